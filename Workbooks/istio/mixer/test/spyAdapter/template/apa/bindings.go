@@ -0,0 +1,56 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sampleapa
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// outPrefix prefixes references to fields of the adapter output in attribute bindings.
+const outPrefix = "$out."
+
+// Validate checks that every attribute binding has a non-empty attribute name and
+// references one of knownOutFields using the $out.<field> notation. All problems
+// are reported together.
+func (m *InstanceParam) Validate(knownOutFields map[string]bool) error {
+	attrs := make([]string, 0, len(m.AttributeBindings))
+	for attr := range m.AttributeBindings {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+
+	var result *multierror.Error
+	for _, attr := range attrs {
+		expr := m.AttributeBindings[attr]
+		if strings.TrimSpace(attr) == "" {
+			result = multierror.Append(result, fmt.Errorf("attribute name cannot be empty for binding '%s'", expr))
+		}
+		field := strings.TrimSpace(expr)
+		if !strings.HasPrefix(field, outPrefix) {
+			result = multierror.Append(result,
+				fmt.Errorf("binding for attribute '%s' must reference an output field as %s<field>, got '%s'", attr, outPrefix, expr))
+			continue
+		}
+		field = strings.TrimPrefix(field, outPrefix)
+		if !knownOutFields[field] {
+			result = multierror.Append(result, fmt.Errorf("binding for attribute '%s' references unknown output field '%s'", attr, field))
+		}
+	}
+	return result.ErrorOrNil()
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sampleapa
+
+import (
+	"strings"
+	"testing"
+
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+func TestInstanceParamValidate(t *testing.T) {
+	known := map[string]bool{
+		"int64Primitive":  true,
+		"stringPrimitive": true,
+		"stringMap":       true,
+	}
+
+	cases := []struct {
+		name     string
+		bindings map[string]string
+		wantErrs []string
+	}{
+		{
+			name: "no bindings",
+		},
+		{
+			name: "valid bindings",
+			bindings: map[string]string{
+				"source.name": "$out.stringPrimitive",
+				"source.port": " $out.int64Primitive ",
+				"source.tags": "$out.stringMap",
+			},
+		},
+		{
+			name:     "unknown field",
+			bindings: map[string]string{"source.name": "$out.stringPrimitve"},
+			wantErrs: []string{"binding for attribute 'source.name' references unknown output field 'stringPrimitve'"},
+		},
+		{
+			name:     "missing $out prefix",
+			bindings: map[string]string{"source.name": "stringPrimitive"},
+			wantErrs: []string{"binding for attribute 'source.name' must reference an output field as $out.<field>, got 'stringPrimitive'"},
+		},
+		{
+			name:     "empty attribute name",
+			bindings: map[string]string{"": "$out.stringPrimitive"},
+			wantErrs: []string{"attribute name cannot be empty for binding '$out.stringPrimitive'"},
+		},
+		{
+			name: "multiple problems",
+			bindings: map[string]string{
+				"":            "$out.bogus",
+				"source.name": "$out.stringPrimitive",
+				"source.port": "$out.",
+			},
+			wantErrs: []string{
+				"attribute name cannot be empty for binding '$out.bogus'",
+				"binding for attribute '' references unknown output field 'bogus'",
+				"binding for attribute 'source.port' references unknown output field ''",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		param := &InstanceParam{AttributeBindings: c.bindings}
+		err := param.Validate(known)
+		if len(c.wantErrs) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", c.name, err)
+			}
+			continue
+		}
+		merr, ok := err.(*multierror.Error)
+		if !ok {
+			t.Errorf("%s: got error %v, want a *multierror.Error", c.name, err)
+			continue
+		}
+		if len(merr.Errors) != len(c.wantErrs) {
+			t.Errorf("%s: got %d errors (%v), want %d", c.name, len(merr.Errors), err, len(c.wantErrs))
+			continue
+		}
+		for i, want := range c.wantErrs {
+			if got := merr.Errors[i].Error(); !strings.Contains(got, want) {
+				t.Errorf("%s: error %d is %q, want %q", c.name, i, got, want)
+			}
+		}
+	}
+}
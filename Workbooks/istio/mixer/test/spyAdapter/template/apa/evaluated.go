@@ -0,0 +1,99 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sampleapa
+
+import (
+	"fmt"
+)
+
+// EvaluatedInstance holds the typed values of an InstanceParam whose
+// expressions have been evaluated.
+type EvaluatedInstance struct {
+	Int64Primitive int64
+
+	BoolPrimitive bool
+
+	DoublePrimitive float64
+
+	StringPrimitive string
+}
+
+// BuildEvaluatedInstance evaluates each primitive expression of param with
+// eval and coerces the result to the type declared by the template.
+func BuildEvaluatedInstance(param *InstanceParam, eval func(string) (interface{}, error)) (*EvaluatedInstance, error) {
+	out := &EvaluatedInstance{}
+
+	v, err := evalField(eval, "Int64Primitive", param.Int64Primitive)
+	if err != nil {
+		return nil, err
+	}
+	switch t := v.(type) {
+	case int64:
+		out.Int64Primitive = t
+	case int:
+		out.Int64Primitive = int64(t)
+	case int32:
+		out.Int64Primitive = int64(t)
+	default:
+		return nil, coercionError("Int64Primitive", param.Int64Primitive, v, "int64")
+	}
+
+	if v, err = evalField(eval, "BoolPrimitive", param.BoolPrimitive); err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, coercionError("BoolPrimitive", param.BoolPrimitive, v, "bool")
+	}
+	out.BoolPrimitive = b
+
+	if v, err = evalField(eval, "DoublePrimitive", param.DoublePrimitive); err != nil {
+		return nil, err
+	}
+	switch t := v.(type) {
+	case float64:
+		out.DoublePrimitive = t
+	case float32:
+		out.DoublePrimitive = float64(t)
+	default:
+		return nil, coercionError("DoublePrimitive", param.DoublePrimitive, v, "float64")
+	}
+
+	if v, err = evalField(eval, "StringPrimitive", param.StringPrimitive); err != nil {
+		return nil, err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return nil, coercionError("StringPrimitive", param.StringPrimitive, v, "string")
+	}
+	out.StringPrimitive = s
+
+	return out, nil
+}
+
+func evalField(eval func(string) (interface{}, error), field, expr string) (interface{}, error) {
+	if expr == "" {
+		return nil, fmt.Errorf("expression for field '%s' cannot be empty", field)
+	}
+	v, err := eval(expr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate expression '%s' for field '%s': %v", expr, field, err)
+	}
+	return v, nil
+}
+
+func coercionError(field, expr string, v interface{}, want string) error {
+	return fmt.Errorf("expression '%s' for field '%s' evaluated to %v of type %T, want %s", expr, field, v, v, want)
+}
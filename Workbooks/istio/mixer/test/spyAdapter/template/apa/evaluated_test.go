@@ -0,0 +1,128 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sampleapa
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testValues = map[string]interface{}{
+	"int":       int64(42),
+	"plainInt":  7,
+	"bool":      true,
+	"double":    3.5,
+	"float":     float32(1.5),
+	"string":    "hello",
+	"wrongType": "not a number",
+	"nilValue":  nil,
+}
+
+func testEval(expr string) (interface{}, error) {
+	v, ok := testValues[expr]
+	if !ok {
+		return nil, errors.New("unknown attribute")
+	}
+	return v, nil
+}
+
+func TestBuildEvaluatedInstance(t *testing.T) {
+	cases := []struct {
+		name    string
+		param   *InstanceParam
+		want    *EvaluatedInstance
+		wantErr string
+	}{
+		{
+			name: "all primitives",
+			param: &InstanceParam{
+				Int64Primitive:  "int",
+				BoolPrimitive:   "bool",
+				DoublePrimitive: "double",
+				StringPrimitive: "string",
+			},
+			want: &EvaluatedInstance{
+				Int64Primitive:  42,
+				BoolPrimitive:   true,
+				DoublePrimitive: 3.5,
+				StringPrimitive: "hello",
+			},
+		},
+		{
+			name: "narrower numeric types",
+			param: &InstanceParam{
+				Int64Primitive:  "plainInt",
+				BoolPrimitive:   "bool",
+				DoublePrimitive: "float",
+				StringPrimitive: "string",
+			},
+			want: &EvaluatedInstance{
+				Int64Primitive:  7,
+				BoolPrimitive:   true,
+				DoublePrimitive: 1.5,
+				StringPrimitive: "hello",
+			},
+		},
+		{
+			name:    "bad int64",
+			param:   &InstanceParam{Int64Primitive: "wrongType", BoolPrimitive: "bool", DoublePrimitive: "double", StringPrimitive: "string"},
+			wantErr: "field 'Int64Primitive' evaluated to not a number of type string, want int64",
+		},
+		{
+			name:    "bad bool",
+			param:   &InstanceParam{Int64Primitive: "int", BoolPrimitive: "string", DoublePrimitive: "double", StringPrimitive: "string"},
+			wantErr: "field 'BoolPrimitive' evaluated to hello of type string, want bool",
+		},
+		{
+			name:    "bad double",
+			param:   &InstanceParam{Int64Primitive: "int", BoolPrimitive: "bool", DoublePrimitive: "int", StringPrimitive: "string"},
+			wantErr: "field 'DoublePrimitive' evaluated to 42 of type int64, want float64",
+		},
+		{
+			name:    "bad string",
+			param:   &InstanceParam{Int64Primitive: "int", BoolPrimitive: "bool", DoublePrimitive: "double", StringPrimitive: "nilValue"},
+			wantErr: "field 'StringPrimitive' evaluated to <nil> of type <nil>, want string",
+		},
+		{
+			name:    "bad expression",
+			param:   &InstanceParam{Int64Primitive: "int", BoolPrimitive: "bool", DoublePrimitive: "missing", StringPrimitive: "string"},
+			wantErr: "failed to evaluate expression 'missing' for field 'DoublePrimitive': unknown attribute",
+		},
+		{
+			name:    "empty expression",
+			param:   &InstanceParam{Int64Primitive: "int", BoolPrimitive: "bool", DoublePrimitive: "double"},
+			wantErr: "expression for field 'StringPrimitive' cannot be empty",
+		},
+	}
+
+	for _, c := range cases {
+		got, err := BuildEvaluatedInstance(c.param, testEval)
+		if c.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("%s: got error %v, want error containing %q", c.name, err, c.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %#v, want %#v", c.name, got, c.want)
+		}
+	}
+}
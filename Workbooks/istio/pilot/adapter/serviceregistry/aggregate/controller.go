@@ -49,18 +49,28 @@ func (c *Controller) AddRegistry(registry Registry) {
 	c.registries = append(c.registries, registry)
 }
 
-// Services lists services from all platforms
+// Services lists services from all platforms. Errors are only returned
+// if no service could be listed.
 func (c *Controller) Services() ([]*model.Service, error) {
 	services := make([]*model.Service, 0)
 	var errs error
 	for _, r := range c.registries {
+		// a registry may return the services it could list along with an error
 		svcs, err := r.Services()
 		if err != nil {
 			errs = multierror.Append(errs, err)
-		} else {
-			services = append(services, svcs...)
 		}
+		services = append(services, svcs...)
 	}
+
+	// a failing registry, or service within one, must not hide the services found elsewhere
+	if len(services) > 0 {
+		if errs != nil {
+			log.Warnf("Services() found services but encountered an error: %v", errs)
+		}
+		return services, nil
+	}
+
 	return services, errs
 }
 
@@ -119,12 +129,12 @@ func (c *Controller) HostInstances(addrs map[string]*model.Node) ([]*model.Servi
 	out := make([]*model.ServiceInstance, 0)
 	var errs error
 	for _, r := range c.registries {
+		// a registry may return the instances it could list along with an error
 		instances, err := r.HostInstances(addrs)
 		if err != nil {
 			errs = multierror.Append(errs, err)
-		} else {
-			out = append(out, instances...)
 		}
+		out = append(out, instances...)
 	}
 
 	if len(out) > 0 {
@@ -78,9 +78,42 @@ func TestServicesError(t *testing.T) {
 	discovery1.ServicesError = errors.New("mock Services() error")
 
 	// List Services from aggregate controller
-	_, err := aggregateCtl.Services()
-	if err == nil {
-		t.Fatal("Aggregate controller should return error if one discovery client experience error")
+	services, err := aggregateCtl.Services()
+	if err != nil {
+		t.Fatalf("Aggregate controller should not return error if services are found: %v", err)
+	}
+	if len(services) != 1 || services[0].Hostname != mock.WorldService.Hostname {
+		t.Fatalf("Services() returned %v, want the services of the other client", services)
+	}
+
+	discovery2.ServicesError = errors.New("mock Services() error")
+	if _, err = aggregateCtl.Services(); err == nil {
+		t.Fatal("Aggregate controller should return error if every discovery client experiences error")
+	}
+}
+
+// partialDiscovery lists its services along with an error, like a registry
+// failing to retrieve only some of its services
+type partialDiscovery struct {
+	*mock.ServiceDiscovery
+}
+
+func (sd partialDiscovery) Services() ([]*model.Service, error) {
+	services, _ := sd.ServiceDiscovery.Services()
+	return services, errors.New("mock Services() error of another service")
+}
+
+func TestServicesPartialError(t *testing.T) {
+	aggregateCtl := buildMockController()
+	aggregateCtl.registries[0].ServiceDiscovery = partialDiscovery{discovery1}
+	discovery2.ServicesError = errors.New("mock Services() error")
+
+	services, err := aggregateCtl.Services()
+	if err != nil {
+		t.Fatalf("Aggregate controller should not return error if services are found: %v", err)
+	}
+	if len(services) != 1 || services[0].Hostname != mock.HelloService.Hostname {
+		t.Fatalf("Services() returned %v, want the services listed by the failing client", services)
 	}
 }
 
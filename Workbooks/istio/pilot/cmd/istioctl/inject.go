@@ -19,11 +19,14 @@ import (
 	"fmt"
 	"io"
 	"os"
+
+	"github.com/ghodss/yaml"
 	// TODO(nmittler): Remove this
 	_ "github.com/golang/glog"
 	"github.com/spf13/cobra"
 	"k8s.io/api/core/v1"
 
+	meshconfig "istio.io/api/mesh/v1alpha1"
 	"istio.io/istio/pilot/platform/kube"
 	"istio.io/istio/pilot/platform/kube/inject"
 	"istio.io/istio/pkg/log"
@@ -31,16 +34,18 @@ import (
 )
 
 var (
-	hub               string
-	tag               string
-	sidecarProxyUID   int64
-	verbosity         int
-	versionStr        string // override build version
-	enableCoreDump    bool
-	meshConfigMapName string
-	imagePullPolicy   string
-	includeIPRanges   string
-	debugMode         bool
+	hub                 string
+	tag                 string
+	sidecarProxyUID     int64
+	verbosity           int
+	versionStr          string // override build version
+	enableCoreDump      bool
+	meshConfigMapName   string
+	meshConfigFile      string
+	imagePullPolicy     string
+	includeIPRanges     string
+	debugMode           bool
+	preStopDrainSeconds int
 
 	inFilename  string
 	outFilename string
@@ -132,32 +137,40 @@ kubectl get deployment -o yaml | istioctl kube-inject -f - | kubectl apply -f -
 				versionStr = version.Info.String()
 			}
 
-			_, client, err := kube.CreateInterface(kubeconfig)
-			if err != nil {
-				return err
-			}
+			var meshConfig *meshconfig.MeshConfig
+			if meshConfigFile != "" {
+				if meshConfig, err = inject.LoadMeshConfigFromFile(meshConfigFile); err != nil {
+					return fmt.Errorf("could not read valid mesh config from file %q: %v", meshConfigFile, err)
+				}
+			} else {
+				_, client, errClient := kube.CreateInterface(kubeconfig)
+				if errClient != nil {
+					return errClient
+				}
 
-			_, meshConfig, err := inject.GetMeshConfig(client, istioNamespace, meshConfigMapName)
-			if err != nil {
-				return fmt.Errorf("could not read valid configmap %q from namespace  %q: %v - "+
-					"Re-run kube-inject with `-i <istioSystemNamespace> and ensure valid MeshConfig exists",
-					meshConfigMapName, istioNamespace, err)
+				_, meshConfig, err = inject.GetMeshConfig(client, istioNamespace, meshConfigMapName, inject.RetryPolicy{})
+				if err != nil {
+					return fmt.Errorf("could not read valid configmap %q from namespace  %q: %v - "+
+						"Re-run kube-inject with `-i <istioSystemNamespace> and ensure valid MeshConfig exists",
+						meshConfigMapName, istioNamespace, err)
+				}
 			}
 
 			config := &inject.Config{
 				Policy:            inject.DefaultInjectionPolicy,
 				IncludeNamespaces: []string{v1.NamespaceAll},
 				Params: inject.Params{
-					InitImage:       inject.InitImageName(hub, tag, debugMode),
-					ProxyImage:      inject.ProxyImageName(hub, tag, debugMode),
-					Verbosity:       verbosity,
-					SidecarProxyUID: sidecarProxyUID,
-					Version:         versionStr,
-					EnableCoreDump:  enableCoreDump,
-					Mesh:            meshConfig,
-					ImagePullPolicy: imagePullPolicy,
-					IncludeIPRanges: includeIPRanges,
-					DebugMode:       debugMode,
+					InitImage:           inject.InitImageName(hub, tag, debugMode),
+					ProxyImage:          inject.ProxyImageName(hub, tag, debugMode),
+					Verbosity:           verbosity,
+					SidecarProxyUID:     sidecarProxyUID,
+					Version:             versionStr,
+					EnableCoreDump:      enableCoreDump,
+					Mesh:                meshConfig,
+					ImagePullPolicy:     imagePullPolicy,
+					IncludeIPRanges:     includeIPRanges,
+					DebugMode:           debugMode,
+					PreStopDrainSeconds: &preStopDrainSeconds,
 				},
 			}
 			return inject.IntoResourceFile(config, reader, writer)
@@ -165,8 +178,69 @@ kubectl get deployment -o yaml | istioctl kube-inject -f - | kubectl apply -f -
 	}
 )
 
+var (
+	injectConfigMapName string
+
+	injectConfigMapCmd = &cobra.Command{
+		Use:   "configmap",
+		Short: "Generate the default sidecar initializer ConfigMap",
+		Long: `
+Generate the ConfigMap read by the sidecar initializer, holding the
+default injection configuration for the given hub, tag and version.
+Edit the generated configuration before applying it to customize the
+injection policy.
+`,
+		Example: `
+# Install the default sidecar initializer configuration.
+istioctl kube-inject configmap -i istio-system | kubectl apply -f -
+`,
+		RunE: func(_ *cobra.Command, _ []string) (err error) {
+			if versionStr == "" {
+				versionStr = version.Info.String()
+			}
+
+			config := inject.DefaultConfig(versionStr, hub, tag)
+			configMap, err := inject.InitializerConfigMap(config, istioNamespace, injectConfigMapName)
+			if err != nil {
+				return err
+			}
+			out, err := yaml.Marshal(configMap)
+			if err != nil {
+				return err
+			}
+
+			var writer io.Writer
+			if outFilename == "" {
+				writer = os.Stdout
+			} else {
+				var file *os.File
+				if file, err = os.Create(outFilename); err != nil {
+					return err
+				}
+				writer = file
+				defer func() {
+					if errClose := file.Close(); errClose != nil {
+						log.Errorf("Error: close file from %s, %s", outFilename, errClose)
+
+						// don't overwrite the previous error
+						if err == nil {
+							err = errClose
+						}
+					}
+				}()
+			}
+			_, err = writer.Write(out)
+			return err
+		},
+	}
+)
+
 func init() {
 	rootCmd.AddCommand(injectCmd)
+	injectCmd.AddCommand(injectConfigMapCmd)
+
+	injectConfigMapCmd.Flags().StringVar(&injectConfigMapName, "name", inject.DefaultInitializerConfigMapName,
+		"Name of the generated sidecar initializer ConfigMap")
 
 	injectCmd.PersistentFlags().StringVar(&hub, "hub", version.Info.DockerHub, "Docker hub")
 	injectCmd.PersistentFlags().StringVar(&tag, "tag", version.Info.Version, "Docker tag")
@@ -183,6 +257,8 @@ func init() {
 		"", "Override version info injected into resource")
 	injectCmd.PersistentFlags().StringVar(&meshConfigMapName, "meshConfigMapName", "istio",
 		fmt.Sprintf("ConfigMap name for Istio mesh configuration, key should be %q", inject.ConfigMapKey))
+	injectCmd.PersistentFlags().StringVar(&meshConfigFile, "meshConfigFile", "",
+		"Mesh configuration filename, used instead of the ConfigMap to inject without access to a cluster")
 
 	// Default --coreDump=true for pre-alpha development. Core dump
 	// settings (i.e. sysctl kernel.*) affect all pods in a node and
@@ -198,4 +274,6 @@ func init() {
 		"Comma separated list of IP ranges in CIDR form. If set, only redirect outbound "+
 			"traffic to Envoy for IP ranges. Otherwise all outbound traffic is redirected")
 	injectCmd.PersistentFlags().BoolVar(&debugMode, "debug", false, "Use debug images and settings for the sidecar")
+	injectCmd.PersistentFlags().IntVar(&preStopDrainSeconds, "preStopDrainSeconds", inject.DefaultPreStopDrainSeconds,
+		"Seconds the Envoy sidecar drains in-flight requests before it is stopped, 0 to disable")
 }
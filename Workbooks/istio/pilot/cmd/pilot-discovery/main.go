@@ -108,6 +108,18 @@ func init() {
 		"Consul Config file for discovery")
 	discoveryCmd.PersistentFlags().StringVar(&serverArgs.Service.Consul.ServerURL, "consulserverURL", "",
 		"URL for the Consul server")
+	discoveryCmd.PersistentFlags().StringVar(&serverArgs.Service.Consul.ServiceAccountsFile, "consulServiceAccounts", "",
+		"YAML file mapping Consul service names to SPIFFE identities, re-read on SIGHUP")
+	discoveryCmd.PersistentFlags().DurationVar(&serverArgs.Service.Consul.MinPollInterval, "consulMinPollInterval",
+		time.Second, "Shortest interval between polls of the Consul catalog in adaptive mode")
+	discoveryCmd.PersistentFlags().DurationVar(&serverArgs.Service.Consul.MaxPollInterval, "consulMaxPollInterval", 0,
+		"Longest interval between polls of the Consul catalog. If set, the interval adapts to the rate of "+
+			"catalog changes instead of being fixed")
+	discoveryCmd.PersistentFlags().BoolVar(&serverArgs.Service.Consul.PassingInstancesOnly, "consulPassingOnly", false,
+		"Only route to Consul service instances whose health checks are all passing")
+	discoveryCmd.PersistentFlags().StringVar(&serverArgs.Service.Consul.TagSeparator, "consulTagSeparator", "",
+		"Separator of the keys and values of Consul service tags converted into labels, e.g. \"=\" for version=v2. "+
+			"Bare tags become labels with an empty value. If unset, tags of the form key|value are converted")
 	discoveryCmd.PersistentFlags().StringVar(&serverArgs.Service.Eureka.ServerURL, "eurekaserverURL", "",
 		"URL for the Eureka server")
 
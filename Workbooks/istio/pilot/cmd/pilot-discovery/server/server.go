@@ -102,6 +102,17 @@ type ConfigArgs struct {
 type ConsulArgs struct {
 	Config    string
 	ServerURL string
+	// ServiceAccountsFile maps service names to SPIFFE identities (optional)
+	ServiceAccountsFile string
+	// MinPollInterval and MaxPollInterval bound the adaptive polling of the
+	// catalog. Consul is polled at a fixed interval unless MaxPollInterval is set.
+	MinPollInterval time.Duration
+	MaxPollInterval time.Duration
+	// PassingInstancesOnly excludes instances with a failing Consul health check
+	PassingInstancesOnly bool
+	// TagSeparator splits service tags into label keys and values, e.g. "="
+	// for "version=v2". Tags of the form "key|value" are parsed if unset.
+	TagSeparator string
 }
 
 // EurekaArgs provides configuration for the Eureka service registry
@@ -428,8 +439,22 @@ func (s *Server) initServiceControllers(args *PilotArgs) error {
 			}
 		case ConsulRegistry:
 			log.Infof("Consul url: %v", args.Service.Consul.ServerURL)
+			var opts []consul.ControllerOption
+			if args.Service.Consul.ServiceAccountsFile != "" {
+				opts = append(opts, consul.WithServiceAccountsFile(args.Service.Consul.ServiceAccountsFile))
+			}
+			if args.Service.Consul.MaxPollInterval > 0 {
+				opts = append(opts, consul.WithAdaptiveInterval(
+					args.Service.Consul.MinPollInterval, args.Service.Consul.MaxPollInterval))
+			}
+			if args.Service.Consul.PassingInstancesOnly {
+				opts = append(opts, consul.WithPassingInstancesOnly())
+			}
+			if args.Service.Consul.TagSeparator != "" {
+				opts = append(opts, consul.WithTagParser(consul.SeparatorTagParser(args.Service.Consul.TagSeparator)))
+			}
 			conctl, conerr := consul.NewController(
-				args.Service.Consul.ServerURL, 2*time.Second)
+				args.Service.Consul.ServerURL, 2*time.Second, opts...)
 			if conerr != nil {
 				return fmt.Errorf("failed to create Consul controller: %v", conerr)
 			}
@@ -15,10 +15,15 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
 	"os"
 	// TODO(nmittler): Remove this
 	_ "github.com/golang/glog"
 	multierror "github.com/hashicorp/go-multierror"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/cobra"
 	"k8s.io/api/core/v1"
 
@@ -35,9 +40,12 @@ var (
 		meshconfig     string
 		injectConfig   string
 		namespace      string
+		monitoringPort int
+		configRetry    inject.RetryPolicy
 		loggingOptions *log.Options
 	}{
 		loggingOptions: log.NewOptions(),
+		configRetry:    inject.DefaultRetryPolicy,
 	}
 
 	rootCmd = &cobra.Command{
@@ -54,7 +62,7 @@ var (
 
 			log.Infof("version %s", version.Info.String())
 
-			config, err := inject.GetInitializerConfig(client, flags.namespace, flags.injectConfig)
+			config, err := inject.GetInitializerConfig(client, flags.namespace, flags.injectConfig, flags.configRetry)
 			if err != nil {
 				return multierror.Prefix(err, "failed to read initializer configuration")
 			}
@@ -69,11 +77,17 @@ var (
 				return multierror.Prefix(err, "failed to create initializer")
 			}
 
-			stop := make(chan struct{})
+			if err = startMonitor(flags.monitoringPort); err != nil {
+				return multierror.Prefix(err, "failed to start monitoring server")
+			}
 
-			go initializer.Run(stop)
+			ctx, cancel := context.WithCancel(context.Background())
+			initializer.Run(ctx)
 
+			stop := make(chan struct{})
 			cmd.WaitSignal(stop)
+			cancel()
+			initializer.Wait()
 			return nil
 		},
 	}
@@ -84,10 +98,19 @@ func init() {
 		"Use a Kubernetes configuration file instead of in-cluster configuration")
 	rootCmd.PersistentFlags().StringVar(&flags.meshconfig, "meshconfig", "/etc/istio/config/mesh",
 		"File name for Istio mesh configuration")
-	rootCmd.PersistentFlags().StringVar(&flags.injectConfig, "injectConfig", "istio-inject",
+	rootCmd.PersistentFlags().StringVar(&flags.injectConfig, "injectConfig", inject.DefaultInitializerConfigMapName,
 		"Name of initializer configuration ConfigMap")
 	rootCmd.PersistentFlags().StringVar(&flags.namespace, "namespace", v1.NamespaceDefault, // TODO istio-system?
 		"Namespace of initializer configuration ConfigMap")
+	rootCmd.PersistentFlags().DurationVar(&flags.configRetry.InitialInterval, "configRetryInitialInterval",
+		inject.DefaultRetryPolicy.InitialInterval, "Initial interval between attempts to fetch the configuration ConfigMap")
+	rootCmd.PersistentFlags().DurationVar(&flags.configRetry.MaxInterval, "configRetryMaxInterval",
+		inject.DefaultRetryPolicy.MaxInterval, "Maximum interval between attempts to fetch the configuration ConfigMap")
+	rootCmd.PersistentFlags().DurationVar(&flags.configRetry.MaxElapsedTime, "configRetryMaxElapsedTime",
+		inject.DefaultRetryPolicy.MaxElapsedTime, "Time after which fetching the configuration ConfigMap is no longer retried")
+
+	rootCmd.PersistentFlags().IntVar(&flags.monitoringPort, "monitoringPort", 9093,
+		"HTTP port to serve prometheus metrics")
 
 	// Attach the Istio logging options to the command.
 	flags.loggingOptions.AttachCobraFlags(rootCmd)
@@ -95,6 +118,23 @@ func init() {
 	cmd.AddFlags(rootCmd)
 }
 
+// startMonitor serves the initializer metrics on port.
+func startMonitor(port int) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("unable to listen on socket: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Errorf("Monitoring server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
 func main() {
 	// Needed to avoid "logging before flag.Parse" error with glog.
 	cmd.SupressGlogWarnings()
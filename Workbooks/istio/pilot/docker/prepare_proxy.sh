@@ -12,12 +12,14 @@ usage() {
   echo '  -u: Specify the UID of the user for which the redirection is not'
   echo '      applied. Typically, this is the UID of the proxy container'
   echo '  -i: Comma separated list of IP ranges in CIDR form to redirect to envoy (optional)'
+  echo '  -x: Comma separated list of inbound ports to be excluded from redirection to envoy (optional)'
   echo ''
 }
 
 IP_RANGES_INCLUDE=""
+INBOUND_PORTS_EXCLUDE=""
 
-while getopts ":p:u:e:i:h" opt; do
+while getopts ":p:u:e:i:x:h" opt; do
   case ${opt} in
     p)
       ENVOY_PORT=${OPTARG}
@@ -28,6 +30,9 @@ while getopts ":p:u:e:i:h" opt; do
     i)
       IP_RANGES_INCLUDE=${OPTARG}
       ;;
+    x)
+      INBOUND_PORTS_EXCLUDE=${OPTARG}
+      ;;
     h)
       usage
       exit 0
@@ -51,7 +56,13 @@ fi
 iptables -t nat -N ISTIO_REDIRECT                                             -m comment --comment "istio/redirect-common-chain"
 iptables -t nat -A ISTIO_REDIRECT -p tcp -j REDIRECT --to-port ${ENVOY_PORT}  -m comment --comment "istio/redirect-to-envoy-port"
 
-# Redirect all inbound traffic to Envoy.
+# Skip redirection of inbound traffic to the ports in INBOUND_PORTS_EXCLUDE.
+IFS=,
+for port in ${INBOUND_PORTS_EXCLUDE}; do
+    iptables -t nat -A PREROUTING -p tcp --dport ${port} -j RETURN            -m comment --comment "istio/bypass-inbound-port-${port}"
+done
+
+# Redirect all other inbound traffic to Envoy.
 iptables -t nat -A PREROUTING -j ISTIO_REDIRECT                               -m comment --comment "istio/install-istio-prerouting"
 
 # Create a new chain for selectively redirecting outbound packets to
@@ -79,7 +90,6 @@ iptables -t nat -A ISTIO_OUTPUT -d 127.0.0.1/32 -j RETURN                     -m
 # All outbound traffic will be redirected to Envoy by default. If
 # IP_RANGES_INCLUDE is non-empty, only traffic bound for the
 # destinations specified in this list will be captured.
-IFS=,
 if [ "${IP_RANGES_INCLUDE}" != "" ]; then
     for cidr in ${IP_RANGES_INCLUDE}; do
         iptables -t nat -A ISTIO_OUTPUT -d ${cidr} -j ISTIO_REDIRECT          -m comment --comment "istio/redirect-ip-range-${cidr}"
@@ -15,34 +15,116 @@
 package consul
 
 import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
 	"time"
+
 	// TODO(nmittler): Remove this
 	_ "github.com/golang/glog"
 	"github.com/hashicorp/consul/api"
+	multierror "github.com/hashicorp/go-multierror"
 
 	"istio.io/istio/pilot/model"
 	"istio.io/istio/pkg/log"
 )
 
+// catalogAPI is the part of the Consul catalog API used by the controller,
+// implemented by *api.Catalog.
+type catalogAPI interface {
+	Services(q *api.QueryOptions) (map[string][]string, *api.QueryMeta, error)
+	Service(service, tag string, q *api.QueryOptions) ([]*api.CatalogService, *api.QueryMeta, error)
+}
+
+// healthAPI is the part of the Consul health API used by the controller,
+// implemented by *api.Health.
+type healthAPI interface {
+	Service(service, tag string, passingOnly bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error)
+}
+
 // Controller communicates with Consul and monitors for changes
 type Controller struct {
-	client  *api.Client
-	monitor Monitor
+	client          *api.Client
+	catalog         catalogAPI
+	health          healthAPI
+	monitor         Monitor
+	serviceAccounts *serviceAccountsFile
+	// passingOnly excludes instances with a failing Consul health check
+	passingOnly bool
+	// tagParser converts the service tags of instances into labels
+	tagParser TagParser
 }
 
 // NewController creates a new Consul controller
-func NewController(addr string, interval time.Duration) (*Controller, error) {
+func NewController(addr string, interval time.Duration, opts ...ControllerOption) (*Controller, error) {
 	conf := api.DefaultConfig()
 	conf.Address = addr
 
 	client, err := api.NewClient(conf)
+	if err != nil {
+		return nil, err
+	}
+	c := NewControllerWithClient(client, interval)
+	for _, opt := range opts {
+		if err = opt(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// NewControllerWithClient creates a new Consul controller using the given
+// client, e.g. configured with a token or TLS settings.
+func NewControllerWithClient(client *api.Client, interval time.Duration) *Controller {
 	return &Controller{
-		monitor: NewConsulMonitor(client, interval),
-		client:  client,
-	}, err
+		monitor:   NewConsulMonitor(client, interval),
+		client:    client,
+		catalog:   client.Catalog(),
+		health:    client.Health(),
+		tagParser: PipeTagParser,
+	}
+}
+
+// WithAdaptiveInterval makes the controller poll Consul between min and max,
+// polling faster while the catalog changes and backing off while it is quiet,
+// instead of at the fixed interval given to NewController.
+func WithAdaptiveInterval(min, max time.Duration) ControllerOption {
+	return func(c *Controller) error {
+		if min <= 0 || max < min {
+			return fmt.Errorf("invalid adaptive polling interval bounds %v-%v", min, max)
+		}
+		c.monitor = NewAdaptiveConsulMonitor(c.client, min, max)
+		return nil
+	}
+}
+
+// WithPassingInstancesOnly makes the controller list only the instances whose
+// Consul health checks are all passing, using the health API instead of the
+// catalog API.
+func WithPassingInstancesOnly() ControllerOption {
+	return func(c *Controller) error {
+		c.passingOnly = true
+		return nil
+	}
+}
+
+// WithTagParser makes the controller convert the service tags of instances
+// into labels with the given parser instead of PipeTagParser.
+func WithTagParser(parse TagParser) ControllerOption {
+	return func(c *Controller) error {
+		if parse == nil {
+			return fmt.Errorf("no tag parser")
+		}
+		c.tagParser = parse
+		return nil
+	}
 }
 
-// Services list declarations of all services in the system
+// Services list declarations of all services in the system. The services
+// whose catalog could not be retrieved are skipped, and their errors are
+// returned along with the other services.
 func (c *Controller) Services() ([]*model.Service, error) {
 	data, err := c.getServices()
 	if err != nil {
@@ -50,15 +132,17 @@ func (c *Controller) Services() ([]*model.Service, error) {
 	}
 
 	services := make([]*model.Service, 0, len(data))
+	var errs error
 	for name := range data {
 		endpoints, err := c.getCatalogService(name, nil)
 		if err != nil {
-			return nil, err
+			errs = multierror.Append(errs, fmt.Errorf("service %s: %v", name, err))
+			continue
 		}
 		services = append(services, convertService(endpoints))
 	}
 
-	return services, nil
+	return services, errs
 }
 
 // GetService retrieves a service by host name if it exists
@@ -78,8 +162,43 @@ func (c *Controller) GetService(hostname string) (*model.Service, error) {
 	return convertService(endpoints), nil
 }
 
+// ServiceMetadata returns the service metadata and the sorted union of the
+// tags of the endpoints of a service, e.g. to build custom routing. The
+// Consul API pinned in Gopkg.lock (v1.0.2) has no ServiceMeta, so the metadata
+// is always empty. Node metadata is not returned in its place, as it is
+// shared by every service on a node.
+func (c *Controller) ServiceMetadata(hostname string) (map[string]string, []string, error) {
+	name, err := parseHostname(hostname)
+	if err != nil {
+		log.Infof("parseHostname(%s) => error %v", hostname, err)
+		return nil, nil, err
+	}
+
+	endpoints, err := c.getCatalogService(name, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(endpoints) == 0 {
+		return nil, nil, fmt.Errorf("service %s not found", hostname)
+	}
+
+	tagSet := make(map[string]bool)
+	for _, endpoint := range endpoints {
+		for _, tag := range endpoint.ServiceTags {
+			tagSet[tag] = true
+		}
+	}
+
+	tags := make([]string, 0, len(tagSet))
+	for tag := range tagSet {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return map[string]string{}, tags, nil
+}
+
 func (c *Controller) getServices() (map[string][]string, error) {
-	data, _, err := c.client.Catalog().Services(nil)
+	data, _, err := c.catalog.Services(nil)
 	if err != nil {
 		log.Warnf("Could not retrieve services from consul: %v", err)
 		return nil, err
@@ -89,7 +208,11 @@ func (c *Controller) getServices() (map[string][]string, error) {
 }
 
 func (c *Controller) getCatalogService(name string, q *api.QueryOptions) ([]*api.CatalogService, error) {
-	endpoints, _, err := c.client.Catalog().Service(name, "", q)
+	if c.passingOnly {
+		return c.getPassingService(name, q)
+	}
+
+	endpoints, _, err := c.catalog.Service(name, "", q)
 	if err != nil {
 		log.Warnf("Could not retrieve service catalogue from consul: %v", err)
 		return nil, err
@@ -98,6 +221,34 @@ func (c *Controller) getCatalogService(name string, q *api.QueryOptions) ([]*api
 	return endpoints, nil
 }
 
+// getPassingService returns the endpoints of a service whose health checks are
+// all passing, in the form returned by the catalog API.
+func (c *Controller) getPassingService(name string, q *api.QueryOptions) ([]*api.CatalogService, error) {
+	entries, _, err := c.health.Service(name, "", false, q)
+	if err != nil {
+		log.Warnf("Could not retrieve service health from consul: %v", err)
+		return nil, err
+	}
+
+	endpoints := make([]*api.CatalogService, 0, len(entries))
+	for _, entry := range entries {
+		if isPassing(entry.Checks) {
+			endpoints = append(endpoints, convertServiceEntry(entry))
+		}
+	}
+	return endpoints, nil
+}
+
+// isPassing returns true if none of the checks is in a warning or critical state
+func isPassing(checks api.HealthChecks) bool {
+	for _, check := range checks {
+		if check.Status != api.HealthPassing {
+			return false
+		}
+	}
+	return true
+}
+
 // ManagementPorts retries set of health check ports by instance IP.
 // This does not apply to Consul service registry, as Consul does not
 // manage the service instances. In future, when we integrate Nomad, we
@@ -129,7 +280,7 @@ func (c *Controller) Instances(hostname string, ports []string,
 
 	instances := []*model.ServiceInstance{}
 	for _, endpoint := range endpoints {
-		instance := convertInstance(endpoint)
+		instance := convertInstance(endpoint, c.tagParser)
 		if labels.HasSubsetOf(instance.Labels) && portMatch(instance, portMap) {
 			instances = append(instances, instance)
 		}
@@ -153,28 +304,72 @@ func portMatch(instance *model.ServiceInstance, portMap map[string]bool) bool {
 
 // HostInstances lists service instances for a given set of IPv4 addresses.
 func (c *Controller) HostInstances(addrs map[string]*model.Node) ([]*model.ServiceInstance, error) {
+	return c.HostInstancesWithLabels(addrs, nil)
+}
+
+// HostInstancesWithLabels lists service instances for a given set of IPv4
+// addresses that match any of the supplied labels. All instances match an
+// empty label collection. Like Services, it skips the services whose
+// catalog could not be retrieved.
+func (c *Controller) HostInstancesWithLabels(addrs map[string]*model.Node,
+	labels model.LabelsCollection) ([]*model.ServiceInstance, error) {
 	data, err := c.getServices()
 	if err != nil {
 		return nil, err
 	}
 	out := make([]*model.ServiceInstance, 0)
+	var errs error
 	for svcName := range data {
 		endpoints, err := c.getCatalogService(svcName, nil)
 		if err != nil {
-			return nil, err
+			errs = multierror.Append(errs, fmt.Errorf("service %s: %v", svcName, err))
+			continue
 		}
 		for _, endpoint := range endpoints {
-			if addrs[endpoint.ServiceAddress] != nil {
-				out = append(out, convertInstance(endpoint))
+			if addrs[endpoint.ServiceAddress] == nil {
+				continue
+			}
+			instance := convertInstance(endpoint, c.tagParser)
+			if labels.HasSubsetOf(instance.Labels) {
+				out = append(out, instance)
 			}
 		}
 	}
 
-	return out, nil
+	return out, errs
+}
+
+// AllInstances lists the instances of every service in the catalog. Like
+// Services, it skips the services whose catalog could not be retrieved.
+func (c *Controller) AllInstances() ([]*model.ServiceInstance, error) {
+	data, err := c.getServices()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*model.ServiceInstance, 0)
+	var errs error
+	for svcName := range data {
+		endpoints, err := c.getCatalogService(svcName, nil)
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("service %s: %v", svcName, err))
+			continue
+		}
+		for _, endpoint := range endpoints {
+			out = append(out, convertInstance(endpoint, c.tagParser))
+		}
+	}
+
+	return out, errs
 }
 
 // Run all controllers until a signal is received
 func (c *Controller) Run(stop <-chan struct{}) {
+	if c.serviceAccounts != nil {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go c.serviceAccounts.watch(hup, stop)
+	}
 	c.monitor.Start(stop)
 }
 
@@ -190,13 +385,46 @@ func (c *Controller) AppendServiceHandler(f func(*model.Service, model.Event)) e
 // AppendInstanceHandler implements a service catalog operation
 func (c *Controller) AppendInstanceHandler(f func(*model.ServiceInstance, model.Event)) error {
 	c.monitor.AppendInstanceHandler(func(instance *api.CatalogService, event model.Event) error {
-		f(convertInstance(instance), event)
+		f(convertInstance(instance, c.tagParser), event)
+		return nil
+	})
+	return nil
+}
+
+// AppendInstanceDeltaHandler notifies about changes to the service instances
+// with all instances before and after each change, so that the handler can
+// tell which instances were added, updated or removed.
+func (c *Controller) AppendInstanceDeltaHandler(f func(previous, current []*model.ServiceInstance)) error {
+	c.monitor.AppendInstanceDeltaHandler(func(previous, current []*api.CatalogService) error {
+		f(convertInstances(previous, c.tagParser), convertInstances(current, c.tagParser))
 		return nil
 	})
 	return nil
 }
 
-// GetIstioServiceAccounts implements model.ServiceAccounts operation TODO
+func convertInstances(instances []*api.CatalogService, parse TagParser) []*model.ServiceInstance {
+	out := make([]*model.ServiceInstance, 0, len(instances))
+	for _, instance := range instances {
+		out = append(out, convertInstance(instance, parse))
+	}
+	return out
+}
+
+// GetIstioServiceAccounts implements model.ServiceAccounts operation.
+// Identities are taken from the service accounts file, if one is configured.
 func (c *Controller) GetIstioServiceAccounts(hostname string, ports []string) []string {
+	name, err := parseHostname(hostname)
+	if err != nil {
+		log.Infof("parseHostname(%s) => error %v", hostname, err)
+		return nil
+	}
+
+	if c.serviceAccounts != nil {
+		if identity, ok := c.serviceAccounts.lookup(name); ok {
+			return []string{identity}
+		}
+	}
+
+	// TODO: derive identities from service tags
 	return nil
 }
@@ -19,6 +19,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path"
+	"reflect"
+	"sort"
 	"sync"
 	"testing"
 	"time"
@@ -81,7 +84,12 @@ type mockServer struct {
 	Services    map[string][]string
 	Productpage []*api.CatalogService
 	Reviews     []*api.CatalogService
-	Lock        sync.Mutex
+	// Checks holds the health check status of instances by ID, passing if absent
+	Checks map[string]string
+	// Failing holds the names of the services whose catalog and health
+	// requests fail
+	Failing map[string]bool
+	Lock    sync.Mutex
 }
 
 func newServer() *mockServer {
@@ -89,6 +97,8 @@ func newServer() *mockServer {
 		Productpage: make([]*api.CatalogService, len(productpage)),
 		Reviews:     make([]*api.CatalogService, len(reviews)),
 		Services:    make(map[string][]string),
+		Checks:      make(map[string]string),
+		Failing:     make(map[string]bool),
 	}
 
 	copy(m.Reviews, reviews)
@@ -98,6 +108,14 @@ func newServer() *mockServer {
 	}
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock.Lock()
+		failing := m.Failing[path.Base(r.URL.Path)]
+		m.Lock.Unlock()
+		if failing {
+			http.Error(w, "service unavailable", http.StatusInternalServerError)
+			return
+		}
+
 		if r.URL.Path == "/v1/catalog/services" {
 			m.Lock.Lock()
 			data, _ := json.Marshal(&m.Services)
@@ -116,6 +134,18 @@ func newServer() *mockServer {
 			m.Lock.Unlock()
 			w.Header().Set("Content-Type", "application/json")
 			fmt.Fprintln(w, string(data))
+		} else if r.URL.Path == "/v1/health/service/reviews" {
+			m.Lock.Lock()
+			data, _ := json.Marshal(m.serviceEntries(m.Reviews))
+			m.Lock.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintln(w, string(data))
+		} else if r.URL.Path == "/v1/health/service/productpage" {
+			m.Lock.Lock()
+			data, _ := json.Marshal(m.serviceEntries(m.Productpage))
+			m.Lock.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintln(w, string(data))
 		} else {
 			data, _ := json.Marshal(&[]*api.CatalogService{})
 			w.Header().Set("Content-Type", "application/json")
@@ -127,6 +157,38 @@ func newServer() *mockServer {
 	return &m
 }
 
+// serviceEntries returns the health API entries of the given instances
+func (m *mockServer) serviceEntries(instances []*api.CatalogService) []*api.ServiceEntry {
+	entries := make([]*api.ServiceEntry, 0, len(instances))
+	for _, instance := range instances {
+		status, ok := m.Checks[instance.ID]
+		if !ok {
+			status = api.HealthPassing
+		}
+		entries = append(entries, &api.ServiceEntry{
+			Node: &api.Node{
+				ID:         instance.ID,
+				Node:       instance.Node,
+				Address:    instance.Address,
+				Datacenter: instance.Datacenter,
+				Meta:       instance.NodeMeta,
+			},
+			Service: &api.AgentService{
+				ID:      instance.ServiceID,
+				Service: instance.ServiceName,
+				Tags:    instance.ServiceTags,
+				Port:    instance.ServicePort,
+				Address: instance.ServiceAddress,
+			},
+			Checks: api.HealthChecks{
+				{Node: instance.Node, CheckID: "serfHealth", Status: api.HealthPassing},
+				{Node: instance.Node, CheckID: "service:" + instance.ID, Status: status},
+			},
+		})
+	}
+	return entries
+}
+
 func TestInstances(t *testing.T) {
 	ts := newServer()
 	defer ts.Server.Close()
@@ -300,6 +362,76 @@ func TestGetServiceNoInstances(t *testing.T) {
 	}
 }
 
+func TestServiceMetadata(t *testing.T) {
+	catalog := &fakeCatalog{
+		instances: map[string][]*api.CatalogService{
+			"productpage": productpage,
+			"reviews":     reviews,
+			"ratings": {
+				{
+					ID:          "555-555-555",
+					ServiceName: "ratings",
+					ServiceTags: []string{"version|v1", "canary"},
+				},
+				{
+					ID:          "666-666-666",
+					ServiceName: "ratings",
+					ServiceTags: []string{"version|v2", "canary"},
+				},
+			},
+		},
+		errs: map[string]error{"details": fmt.Errorf("unavailable")},
+	}
+	controller := newFakeController(t, catalog, nil)
+
+	cases := map[string]struct {
+		hostname  string
+		wantTags  []string
+		wantError bool
+	}{
+		"Merged endpoints": {
+			hostname: serviceHostname("ratings"),
+			wantTags: []string{"canary", "version|v1", "version|v2"},
+		},
+		"Single tag per endpoint": {
+			hostname: serviceHostname("reviews"),
+			wantTags: []string{"version|v1", "version|v2", "version|v3"},
+		},
+		"Not found": {
+			hostname:  serviceHostname("unknown"),
+			wantError: true,
+		},
+		"Catalog error": {
+			hostname:  serviceHostname("details"),
+			wantError: true,
+		},
+		"Bad hostname": {
+			hostname:  "",
+			wantError: true,
+		},
+	}
+
+	for id, c := range cases {
+		meta, tags, err := controller.ServiceMetadata(c.hostname)
+		if c.wantError {
+			if err == nil {
+				t.Errorf("%s: ServiceMetadata() returned %v and %v, want an error", id, meta, tags)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: ServiceMetadata() returned an error: %v", id, err)
+			continue
+		}
+		if len(meta) != 0 {
+			t.Errorf("%s: ServiceMetadata() => metadata %v, want none", id, meta)
+		}
+		if !reflect.DeepEqual(tags, c.wantTags) {
+			t.Errorf("%s: ServiceMetadata() => tags %v, want %v", id, tags, c.wantTags)
+		}
+	}
+}
+
 func TestServices(t *testing.T) {
 	ts := newServer()
 	defer ts.Server.Close()
@@ -349,6 +481,51 @@ func TestServicesError(t *testing.T) {
 	}
 }
 
+func TestPartialCatalogError(t *testing.T) {
+	for _, passingOnly := range []bool{false, true} {
+		ts := newServer()
+		ts.Lock.Lock()
+		ts.Failing["reviews"] = true
+		ts.Lock.Unlock()
+
+		var opts []ControllerOption
+		if passingOnly {
+			opts = append(opts, WithPassingInstancesOnly())
+		}
+		controller, err := NewController(ts.Server.URL, 3*time.Second, opts...)
+		if err != nil {
+			t.Errorf("could not create Consul Controller: %v", err)
+		}
+
+		services, err := controller.Services()
+		if err == nil {
+			t.Errorf("passingOnly=%t: Services() should return the error of the failing service", passingOnly)
+		}
+		if len(services) != 1 || services[0].Hostname != serviceHostname("productpage") {
+			t.Errorf("passingOnly=%t: Services() returned %v, want only productpage", passingOnly, services)
+		}
+
+		var svcNode model.Node
+		addrs := map[string]*model.Node{"172.19.0.6": &svcNode, "172.19.0.11": &svcNode}
+		instances, err := controller.HostInstances(addrs)
+		if err == nil {
+			t.Errorf("passingOnly=%t: HostInstances() should return the error of the failing service", passingOnly)
+		}
+		if len(instances) != 1 || instances[0].Endpoint.Address != "172.19.0.11" {
+			t.Errorf("passingOnly=%t: HostInstances() returned %d instances, want only 172.19.0.11", passingOnly, len(instances))
+		}
+
+		instances, err = controller.AllInstances()
+		if err == nil {
+			t.Errorf("passingOnly=%t: AllInstances() should return the error of the failing service", passingOnly)
+		}
+		if len(instances) != 1 || instances[0].Service.Hostname != serviceHostname("productpage") {
+			t.Errorf("passingOnly=%t: AllInstances() returned %d instances, want only productpage", passingOnly, len(instances))
+		}
+		ts.Server.Close()
+	}
+}
+
 func TestHostInstances(t *testing.T) {
 	ts := newServer()
 	defer ts.Server.Close()
@@ -372,6 +549,44 @@ func TestHostInstances(t *testing.T) {
 	}
 }
 
+func TestHostInstancesWithLabels(t *testing.T) {
+	ts := newServer()
+	defer ts.Server.Close()
+	controller, err := NewController(ts.Server.URL, 3*time.Second)
+	if err != nil {
+		t.Errorf("could not create Consul Controller: %v", err)
+	}
+
+	var svcNode model.Node
+	addrs := map[string]*model.Node{"172.19.0.6": &svcNode, "172.19.0.7": &svcNode, "172.19.0.11": &svcNode}
+	cases := map[string]struct {
+		labels model.LabelsCollection
+		want   int
+	}{
+		"No labels":        {want: 3},
+		"Empty collection": {labels: model.LabelsCollection{}, want: 3},
+		"Single label":     {labels: model.LabelsCollection{{"version": "v2"}}, want: 1},
+		"Any of labels":    {labels: model.LabelsCollection{{"version": "v2"}, {"version": "v1"}}, want: 3},
+		"Not on host":      {labels: model.LabelsCollection{{"version": "v3"}}, want: 0},
+	}
+
+	for id, c := range cases {
+		instances, err := controller.HostInstancesWithLabels(addrs, c.labels)
+		if err != nil {
+			t.Errorf("%s: client encountered error during HostInstancesWithLabels(): %v", id, err)
+			continue
+		}
+		if len(instances) != c.want {
+			t.Errorf("%s: HostInstancesWithLabels() returned wrong # of instances => %d, want %d", id, len(instances), c.want)
+		}
+		for _, inst := range instances {
+			if !c.labels.HasSubsetOf(inst.Labels) {
+				t.Errorf("%s: HostInstancesWithLabels() returned instance with labels %v", id, inst.Labels)
+			}
+		}
+	}
+}
+
 func TestHostInstancesError(t *testing.T) {
 	ts := newServer()
 	controller, err := NewController(ts.Server.URL, 3*time.Second)
@@ -390,3 +605,320 @@ func TestHostInstancesError(t *testing.T) {
 		t.Errorf("HostInstances() returned wrong # of instances: %q, want 0", len(instances))
 	}
 }
+
+func TestAllInstances(t *testing.T) {
+	ts := newServer()
+	defer ts.Server.Close()
+	ts.Lock.Lock()
+	ts.Productpage = append(ts.Productpage, &api.CatalogService{
+		Node:           "istio",
+		Address:        "172.19.0.5",
+		ID:             "555-555-555",
+		ServiceName:    "productpage",
+		ServiceTags:    []string{"version|v1"},
+		ServiceAddress: "172.19.0.12",
+		ServicePort:    9080,
+	})
+	ts.Lock.Unlock()
+
+	controller, err := NewController(ts.Server.URL, 3*time.Second)
+	if err != nil {
+		t.Errorf("could not create Consul Controller: %v", err)
+	}
+
+	instances, err := controller.AllInstances()
+	if err != nil {
+		t.Errorf("client encountered error during AllInstances(): %v", err)
+	}
+
+	want := map[string]string{
+		"172.19.0.11": serviceHostname("productpage"),
+		"172.19.0.12": serviceHostname("productpage"),
+		"172.19.0.6":  serviceHostname("reviews"),
+		"172.19.0.7":  serviceHostname("reviews"),
+		"172.19.0.8":  serviceHostname("reviews"),
+	}
+	if len(instances) != len(want) {
+		t.Errorf("AllInstances() returned wrong # of instances => %d, want %d", len(instances), len(want))
+	}
+	for _, inst := range instances {
+		if hostname, ok := want[inst.Endpoint.Address]; !ok || hostname != inst.Service.Hostname {
+			t.Errorf("AllInstances() returned unexpected instance => %s of %q", inst.Endpoint.Address, inst.Service.Hostname)
+		}
+		delete(want, inst.Endpoint.Address)
+	}
+}
+
+func TestAllInstancesError(t *testing.T) {
+	ts := newServer()
+	controller, err := NewController(ts.Server.URL, 3*time.Second)
+	if err != nil {
+		ts.Server.Close()
+		t.Errorf("could not create Consul Controller: %v", err)
+	}
+
+	ts.Server.Close()
+	instances, err := controller.AllInstances()
+	if err == nil {
+		t.Error("AllInstances() should return error when client experiences connection problem")
+	}
+	if len(instances) != 0 {
+		t.Errorf("AllInstances() returned wrong # of instances: %q, want 0", len(instances))
+	}
+}
+
+func TestWithTagParser(t *testing.T) {
+	if _, err := NewController("127.0.0.1:0", 3*time.Second, WithTagParser(nil)); err == nil {
+		t.Error("NewController() accepted a nil tag parser")
+	}
+
+	ts := newServer()
+	defer ts.Server.Close()
+	ts.Lock.Lock()
+	ts.Productpage[0].ServiceTags = []string{"version=v2", "canary"}
+	ts.Lock.Unlock()
+	controller, err := NewController(ts.Server.URL, 3*time.Second, WithTagParser(SeparatorTagParser("=")))
+	if err != nil {
+		t.Fatalf("could not create Consul Controller: %v", err)
+	}
+
+	instances, err := controller.Instances(serviceHostname("productpage"), nil,
+		model.LabelsCollection{{"version": "v2", "canary": ""}})
+	if err != nil {
+		t.Fatalf("client encountered error during Instances(): %v", err)
+	}
+	if len(instances) != 1 {
+		t.Errorf("Instances() returned wrong # of instances => %d, want 1", len(instances))
+	}
+}
+
+func TestWithAdaptiveInterval(t *testing.T) {
+	cases := map[string]struct {
+		min, max time.Duration
+		valid    bool
+	}{
+		"valid":           {min: time.Second, max: 10 * time.Second, valid: true},
+		"equal bounds":    {min: time.Second, max: time.Second, valid: true},
+		"zero min":        {max: 10 * time.Second},
+		"max below min":   {min: 10 * time.Second, max: time.Second},
+		"negative bounds": {min: -time.Second, max: -time.Second},
+	}
+	for id, c := range cases {
+		controller, err := NewController("127.0.0.1:0", 3*time.Second, WithAdaptiveInterval(c.min, c.max))
+		if !c.valid {
+			if err == nil {
+				t.Errorf("%s: expected error for bounds %v-%v", id, c.min, c.max)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+		if m := controller.monitor.(*consulMonitor); m.minPeriod != c.min || m.maxPeriod != c.max {
+			t.Errorf("%s: monitor bounds %v-%v, want %v-%v", id, m.minPeriod, m.maxPeriod, c.min, c.max)
+		}
+	}
+}
+
+func TestInstancesPassingOnly(t *testing.T) {
+	ts := newServer()
+	defer ts.Server.Close()
+	ts.Checks["333-333-333"] = api.HealthWarning
+	ts.Checks["444-444-444"] = api.HealthCritical
+
+	cases := map[string]struct {
+		opts []ControllerOption
+		want []string
+	}{
+		"all instances": {
+			want: []string{"172.19.0.6", "172.19.0.7", "172.19.0.8"},
+		},
+		"passing only": {
+			opts: []ControllerOption{WithPassingInstancesOnly()},
+			want: []string{"172.19.0.6"},
+		},
+	}
+	for id, c := range cases {
+		controller, err := NewController(ts.Server.URL, 3*time.Second, c.opts...)
+		if err != nil {
+			t.Fatalf("could not create Consul Controller: %v", err)
+		}
+
+		instances, err := controller.Instances(serviceHostname("reviews"), []string{}, model.LabelsCollection{})
+		if err != nil {
+			t.Errorf("%s: Instances() encountered unexpected error: %v", id, err)
+			continue
+		}
+		var got []string
+		for _, inst := range instances {
+			got = append(got, inst.Endpoint.Address)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: Instances() => %v, want %v", id, got, c.want)
+		}
+	}
+}
+
+func TestInstancesPassingOnlyConversion(t *testing.T) {
+	ts := newServer()
+	defer ts.Server.Close()
+
+	controller, err := NewController(ts.Server.URL, 3*time.Second, WithPassingInstancesOnly())
+	if err != nil {
+		t.Fatalf("could not create Consul Controller: %v", err)
+	}
+	instances, err := controller.Instances(serviceHostname("reviews"), []string{}, model.LabelsCollection{
+		{"version": "v3"},
+	})
+	if err != nil {
+		t.Fatalf("Instances() encountered unexpected error: %v", err)
+	}
+	if len(instances) != 1 {
+		t.Fatalf("Instances() returned %d instances, want 1", len(instances))
+	}
+	inst := instances[0]
+	if inst.Endpoint.Port != 9080 || inst.Endpoint.ServicePort.Protocol != model.ProtocolTCP {
+		t.Errorf("Instances() => endpoint %v, want port 9080 with protocol %s", inst.Endpoint, model.ProtocolTCP)
+	}
+	if inst.Service.Hostname != serviceHostname("reviews") {
+		t.Errorf("Instances() => hostname %q, want %q", inst.Service.Hostname, serviceHostname("reviews"))
+	}
+}
+
+// fakeCatalog serves the instances of services from memory, failing the
+// requests for the services in errs.
+type fakeCatalog struct {
+	instances map[string][]*api.CatalogService
+	errs      map[string]error
+}
+
+func (f *fakeCatalog) Services(*api.QueryOptions) (map[string][]string, *api.QueryMeta, error) {
+	out := make(map[string][]string, len(f.instances))
+	for name, instances := range f.instances {
+		for _, instance := range instances {
+			out[name] = append(out[name], instance.ServiceTags...)
+		}
+	}
+	return out, nil, nil
+}
+
+func (f *fakeCatalog) Service(service, _ string, _ *api.QueryOptions) ([]*api.CatalogService, *api.QueryMeta, error) {
+	if err := f.errs[service]; err != nil {
+		return nil, nil, err
+	}
+	return f.instances[service], nil, nil
+}
+
+// fakeHealth serves the health of the instances of a fakeCatalog, whose
+// checks are passing unless listed in critical by instance ID.
+type fakeHealth struct {
+	catalog  *fakeCatalog
+	critical map[string]bool
+}
+
+func (f *fakeHealth) Service(service, tag string, _ bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	instances, _, err := f.catalog.Service(service, tag, q)
+	if err != nil {
+		return nil, nil, err
+	}
+	entries := make([]*api.ServiceEntry, 0, len(instances))
+	for _, instance := range instances {
+		status := api.HealthPassing
+		if f.critical[instance.ID] {
+			status = api.HealthCritical
+		}
+		entries = append(entries, &api.ServiceEntry{
+			Node: &api.Node{ID: instance.ID, Node: instance.Node, Address: instance.Address, Meta: instance.NodeMeta},
+			Service: &api.AgentService{
+				Service: instance.ServiceName,
+				Tags:    instance.ServiceTags,
+				Port:    instance.ServicePort,
+				Address: instance.ServiceAddress,
+			},
+			Checks: api.HealthChecks{{Node: instance.Node, CheckID: "service:" + instance.ID, Status: status}},
+		})
+	}
+	return entries, nil, nil
+}
+
+func newFakeController(t *testing.T, catalog *fakeCatalog, critical map[string]bool) *Controller {
+	client, err := api.NewClient(api.DefaultConfig())
+	if err != nil {
+		t.Fatalf("could not create Consul client: %v", err)
+	}
+	c := NewControllerWithClient(client, 3*time.Second)
+	c.catalog = catalog
+	c.health = &fakeHealth{catalog: catalog, critical: critical}
+	return c
+}
+
+func TestFakeCatalog(t *testing.T) {
+	catalog := &fakeCatalog{
+		instances: map[string][]*api.CatalogService{
+			"productpage": productpage,
+			"reviews":     reviews,
+			"ratings": {{
+				Node:        "istio",
+				Address:     "172.19.0.5",
+				ID:          "555-555-555",
+				ServiceName: "ratings",
+				ServiceTags: []string{"version|v1"},
+				ServicePort: 9080,
+				NodeMeta:    map[string]string{protocolTagName: "grpc", externalTagName: "ratings.example.com"},
+			}},
+		},
+		errs: map[string]error{},
+	}
+	controller := newFakeController(t, catalog, map[string]bool{"333-333-333": true})
+
+	services, err := controller.Services()
+	if err != nil {
+		t.Fatalf("Services() returned an error: %v", err)
+	}
+	byHostname := make(map[string]*model.Service)
+	for _, svc := range services {
+		byHostname[svc.Hostname] = svc
+	}
+	ratings := byHostname[serviceHostname("ratings")]
+	if len(services) != 3 || ratings == nil {
+		t.Fatalf("Services() returned %v, want productpage, reviews and ratings", services)
+	}
+	if ratings.ExternalName != "ratings.example.com" || len(ratings.Ports) != 1 || ratings.Ports[0].Protocol != model.ProtocolGRPC {
+		t.Errorf("Services() converted ratings into %v, want an external GRPC service", ratings)
+	}
+
+	instances, err := controller.Instances(serviceHostname("ratings"), nil, nil)
+	if err != nil {
+		t.Fatalf("Instances() returned an error: %v", err)
+	}
+	if len(instances) != 1 || instances[0].Endpoint.Address != "172.19.0.5" {
+		t.Errorf("Instances() returned %v, want ratings at the node address 172.19.0.5", instances)
+	}
+
+	var node model.Node
+	instances, err = controller.HostInstancesWithLabels(map[string]*model.Node{"172.19.0.7": &node, "172.19.0.8": &node},
+		model.LabelsCollection{{"version": "v2"}})
+	if err != nil {
+		t.Fatalf("HostInstancesWithLabels() returned an error: %v", err)
+	}
+	if len(instances) != 1 || instances[0].Labels["version"] != "v2" {
+		t.Errorf("HostInstancesWithLabels() returned %v, want reviews v2", instances)
+	}
+
+	controller.passingOnly = true
+	instances, err = controller.Instances(serviceHostname("reviews"), nil, nil)
+	if err != nil {
+		t.Fatalf("Instances() returned an error: %v", err)
+	}
+	if len(instances) != 2 {
+		t.Errorf("Instances() with passing instances only returned %d instances, want 2", len(instances))
+	}
+
+	catalog.errs["reviews"] = fmt.Errorf("unavailable")
+	services, err = controller.Services()
+	if err == nil || len(services) != 2 {
+		t.Errorf("Services() returned %d services and error %v, want 2 services and an error", len(services), err)
+	}
+}
@@ -16,6 +16,7 @@ package consul
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 	// TODO(nmittler): Remove this
 	_ "github.com/golang/glog"
@@ -30,16 +31,47 @@ const (
 	externalTagName = "external"
 )
 
-func convertLabels(labels []string) model.Labels {
+// TagParser parses a Consul service tag into the key and value of an
+// instance label. Tags for which it returns an error are ignored.
+type TagParser func(tag string) (key, value string, err error)
+
+// PipeTagParser parses tags of the form "key|value". It is the default.
+func PipeTagParser(tag string) (string, string, error) {
+	vals := strings.Split(tag, "|")
+	// Labels not of form "key|value" are ignored to avoid possible collisions
+	if len(vals) < 2 {
+		return "", "", fmt.Errorf("it is not of form key|value")
+	}
+	return vals[0], vals[1], nil
+}
+
+// SeparatorTagParser returns a parser of tags of the form "key<sep>value",
+// e.g. "version=v2" with "=". Bare tags become labels with an empty value,
+// so that instances can be selected by the presence of a tag.
+func SeparatorTagParser(sep string) TagParser {
+	return func(tag string) (string, string, error) {
+		vals := strings.Split(tag, sep)
+		switch {
+		case vals[0] == "":
+			return "", "", fmt.Errorf("it has an empty key")
+		case len(vals) > 2:
+			return "", "", fmt.Errorf("it has more than one %q separator", sep)
+		case len(vals) == 1:
+			return vals[0], "", nil
+		}
+		return vals[0], vals[1], nil
+	}
+}
+
+func convertLabels(labels []string, parse TagParser) model.Labels {
 	out := make(model.Labels, len(labels))
 	for _, tag := range labels {
-		vals := strings.Split(tag, "|")
-		// Labels not of form "key|value" are ignored to avoid possible collisions
-		if len(vals) > 1 {
-			out[vals[0]] = vals[1]
-		} else {
-			log.Warnf("Tag %v ignored since it is not of form key|value", tag)
+		key, value, err := parse(tag)
+		if err != nil {
+			log.Warnf("Tag %v ignored since %v", tag, err)
+			continue
 		}
+		out[key] = value
 	}
 	return out
 }
@@ -94,8 +126,8 @@ func convertService(endpoints []*api.CatalogService) *model.Service {
 	return out
 }
 
-func convertInstance(instance *api.CatalogService) *model.ServiceInstance {
-	labels := convertLabels(instance.ServiceTags)
+func convertInstance(instance *api.CatalogService, parse TagParser) *model.ServiceInstance {
+	labels := convertLabels(instance.ServiceTags, parse)
 	port := convertPort(instance.ServicePort, instance.NodeMeta[protocolTagName])
 
 	addr := instance.ServiceAddress
@@ -121,22 +153,77 @@ func convertInstance(instance *api.CatalogService) *model.ServiceInstance {
 	}
 }
 
+// convertServiceEntry converts an entry of the health API into the form
+// returned by the catalog API
+func convertServiceEntry(entry *api.ServiceEntry) *api.CatalogService {
+	return &api.CatalogService{
+		ID:              entry.Node.ID,
+		Node:            entry.Node.Node,
+		Address:         entry.Node.Address,
+		Datacenter:      entry.Node.Datacenter,
+		TaggedAddresses: entry.Node.TaggedAddresses,
+		NodeMeta:        entry.Node.Meta,
+		ServiceID:       entry.Service.ID,
+		ServiceName:     entry.Service.Service,
+		ServiceAddress:  entry.Service.Address,
+		ServiceTags:     entry.Service.Tags,
+		ServicePort:     entry.Service.Port,
+	}
+}
+
+// serviceHostnameSuffix is appended to consul service names to form their hostnames
+const serviceHostnameSuffix = ".service.consul"
+
+// serviceNameLabel matches a single dot-separated label of a consul service name
+var serviceNameLabel = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+// HostnameError is returned for hostnames that do not name a consul service
+type HostnameError struct {
+	Hostname string
+	Reason   string
+}
+
+func (e *HostnameError) Error() string {
+	return fmt.Sprintf("malformed service hostname %q: %s", e.Hostname, e.Reason)
+}
+
 // serviceHostname produces FQDN for a consul service
 func serviceHostname(name string) string {
 	// TODO include datacenter in Hostname?
 	// consul DNS uses "redis.service.us-east-1.consul" -> "[<optional_tag>].<svc>.service.[<optional_datacenter>].consul"
-	return fmt.Sprintf("%s.service.consul", name)
+	return name + serviceHostnameSuffix
 }
 
-// parseHostname extracts service name from the service hostname
-func parseHostname(hostname string) (name string, err error) {
-	parts := strings.Split(hostname, ".")
-	if len(parts) < 1 || parts[0] == "" {
-		err = fmt.Errorf("missing service name from the service hostname %q", hostname)
-		return
+// parseHostname extracts service name from the service hostname. It is the
+// inverse of serviceHostname, and preserves dots and case in the service
+// name. A bare service name without dots is also accepted.
+func parseHostname(hostname string) (string, error) {
+	name := hostname
+	if n := len(hostname) - len(serviceHostnameSuffix); n >= 0 &&
+		strings.EqualFold(hostname[n:], serviceHostnameSuffix) {
+		name = hostname[:n]
+	} else if strings.Contains(hostname, ".") {
+		return "", &HostnameError{Hostname: hostname, Reason: "missing " + serviceHostnameSuffix + " suffix"}
+	}
+
+	if name == "" {
+		return "", &HostnameError{Hostname: hostname, Reason: "missing service name"}
+	}
+	if !validServiceName(name) {
+		return "", &HostnameError{Hostname: hostname, Reason: fmt.Sprintf("invalid service name %q", name)}
+	}
+	return name, nil
+}
+
+// validServiceName returns whether each dot-separated label of a consul
+// service name is valid
+func validServiceName(name string) bool {
+	for _, label := range strings.Split(name, ".") {
+		if !serviceNameLabel.MatchString(label) {
+			return false
+		}
 	}
-	name = parts[0]
-	return
+	return true
 }
 
 func convertProtocol(name string) model.Protocol {
@@ -16,6 +16,7 @@ package consul
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/hashicorp/consul/api"
@@ -59,17 +60,62 @@ func TestConvertProtocol(t *testing.T) {
 }
 
 func TestConvertLabels(t *testing.T) {
-	out := convertLabels(goodLabels)
+	out := convertLabels(goodLabels, PipeTagParser)
 	if len(out) != len(goodLabels) {
 		t.Errorf("convertLabels(%q) => length %v, want %v", goodLabels, len(out), len(goodLabels))
 	}
 
-	out = convertLabels(badLabels)
+	out = convertLabels(badLabels, PipeTagParser)
 	if len(out) == len(badLabels) {
 		t.Errorf("convertLabels(%q) => length %v, want %v", badLabels, len(out), len(badLabels)-1)
 	}
 }
 
+func TestConvertLabelsSeparator(t *testing.T) {
+	tags := []string{
+		"version=v2",
+		"env=prod",
+		"canary",
+		"url=http://host?a=b",
+		"=orphan",
+		"key|value",
+	}
+	want := model.Labels{
+		"version":   "v2",
+		"env":       "prod",
+		"canary":    "",
+		"key|value": "",
+	}
+	if out := convertLabels(tags, SeparatorTagParser("=")); !reflect.DeepEqual(out, want) {
+		t.Errorf("convertLabels(%q) => %v, want %v", tags, out, want)
+	}
+
+	cases := map[string]struct {
+		key, value string
+		valid      bool
+	}{
+		"version=v2": {key: "version", value: "v2", valid: true},
+		"version=":   {key: "version", value: "", valid: true},
+		"canary":     {key: "canary", value: "", valid: true},
+		"=v2":        {},
+		"":           {},
+		"a=b=c":      {},
+	}
+	parse := SeparatorTagParser("=")
+	for tag, c := range cases {
+		key, value, err := parse(tag)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("SeparatorTagParser(%q) => %q, %q, want an error", tag, key, value)
+			}
+			continue
+		}
+		if err != nil || key != c.key || value != c.value {
+			t.Errorf("SeparatorTagParser(%q) => %q, %q, %v, want %q, %q", tag, key, value, err, c.key, c.value)
+		}
+	}
+}
+
 func TestConvertInstance(t *testing.T) {
 	ip := "172.19.0.11"
 	port := 9080
@@ -95,7 +141,7 @@ func TestConvertInstance(t *testing.T) {
 		NodeMeta:       map[string]string{protocolTagName: protocol},
 	}
 
-	out := convertInstance(&consulServiceInst)
+	out := convertInstance(&consulServiceInst, PipeTagParser)
 
 	if out.Endpoint.ServicePort.Protocol != model.ProtocolUDP {
 		t.Errorf("convertInstance() => %v, want %v", out.Endpoint.ServicePort.Protocol, model.ProtocolUDP)
@@ -155,6 +201,53 @@ func TestServiceHostname(t *testing.T) {
 	}
 }
 
+func TestParseHostname(t *testing.T) {
+	cases := []struct {
+		hostname string
+		want     string
+		wantErr  bool
+	}{
+		{hostname: "productpage.service.consul", want: "productpage"},
+		{hostname: "productpage", want: "productpage"},
+		{hostname: "reviews-v2.service.consul", want: "reviews-v2"},
+		{hostname: "api.v1.orders.service.consul", want: "api.v1.orders"},
+		{hostname: "MixedCase.Service.Consul", want: "MixedCase"},
+		{hostname: "", wantErr: true},
+		{hostname: ".service.consul", wantErr: true},
+		{hostname: "productpage.default.svc", wantErr: true},
+		{hostname: "api..orders.service.consul", wantErr: true},
+		{hostname: ".orders.service.consul", wantErr: true},
+		{hostname: "bad name.service.consul", wantErr: true},
+	}
+
+	for _, c := range cases {
+		out, err := parseHostname(c.hostname)
+		if c.wantErr {
+			if _, ok := err.(*HostnameError); !ok {
+				t.Errorf("parseHostname(%q) => error %v, want a *HostnameError", c.hostname, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseHostname(%q) => unexpected error %v", c.hostname, err)
+		} else if out != c.want {
+			t.Errorf("parseHostname(%q) => %q, want %q", c.hostname, out, c.want)
+		}
+	}
+}
+
+func TestHostnameRoundTrip(t *testing.T) {
+	for _, name := range []string{"productpage", "reviews-v2", "api.v1.orders", "MixedCase", "my_svc"} {
+		svc := convertService([]*api.CatalogService{{ServiceName: name, ServicePort: 9080}})
+		out, err := parseHostname(svc.Hostname)
+		if err != nil {
+			t.Errorf("parseHostname(%q) => unexpected error %v", svc.Hostname, err)
+		} else if out != name {
+			t.Errorf("parseHostname(convertService(%q).Hostname) => %q, want %q", name, out, name)
+		}
+	}
+}
+
 func TestConvertService(t *testing.T) {
 	name := "productpage"
 	consulServiceInsts := []*api.CatalogService{
@@ -34,11 +34,16 @@ type Monitor interface {
 	Start(<-chan struct{})
 	AppendServiceHandler(ServiceHandler)
 	AppendInstanceHandler(InstanceHandler)
+	AppendInstanceDeltaHandler(InstanceDeltaHandler)
 }
 
 // InstanceHandler processes service instance change events
 type InstanceHandler func(instance *api.CatalogService, event model.Event) error
 
+// InstanceDeltaHandler processes a change of the service instances, given all
+// instances before and after the change
+type InstanceDeltaHandler func(previous, current []*api.CatalogService) error
+
 // ServiceHandler processes service change events
 type ServiceHandler func(instances []*api.CatalogService, event model.Event) error
 
@@ -46,9 +51,12 @@ type consulMonitor struct {
 	discovery            *api.Client
 	instanceCachedRecord consulServiceInstances
 	serviceCachedRecord  consulServices
-	instanceHandlers     []InstanceHandler
+	instanceHandlers     []InstanceDeltaHandler
 	serviceHandlers      []ServiceHandler
 	period               time.Duration
+
+	// The bounds of the polling period in adaptive mode, zero otherwise.
+	minPeriod, maxPeriod time.Duration
 }
 
 // NewConsulMonitor polls for changes in Consul Services and CatalogServices
@@ -58,34 +66,64 @@ func NewConsulMonitor(client *api.Client, period time.Duration) Monitor {
 		period:               period,
 		instanceCachedRecord: make(consulServiceInstances, 0),
 		serviceCachedRecord:  make(consulServices),
-		instanceHandlers:     make([]InstanceHandler, 0),
+		instanceHandlers:     make([]InstanceDeltaHandler, 0),
 		serviceHandlers:      make([]ServiceHandler, 0),
 	}
 }
 
+// NewAdaptiveConsulMonitor polls for changes in Consul Services and
+// CatalogServices like NewConsulMonitor, but drops the polling period to
+// minPeriod after a change is seen and doubles it, up to maxPeriod, after
+// every poll that sees no change.
+func NewAdaptiveConsulMonitor(client *api.Client, minPeriod, maxPeriod time.Duration) Monitor {
+	m := NewConsulMonitor(client, minPeriod).(*consulMonitor)
+	m.minPeriod = minPeriod
+	m.maxPeriod = maxPeriod
+	return m
+}
+
 func (m *consulMonitor) Start(stop <-chan struct{}) {
 	m.run(stop)
 }
 
 func (m *consulMonitor) run(stop <-chan struct{}) {
-	ticker := time.NewTicker(m.period)
+	timer := time.NewTimer(m.period)
 	for {
 		select {
 		case <-stop:
-			ticker.Stop()
+			timer.Stop()
 			return
-		case <-ticker.C:
-			m.updateServiceRecord()
-			m.updateInstanceRecord()
+		case <-timer.C:
+			serviceChanged := m.updateServiceRecord()
+			instanceChanged := m.updateInstanceRecord()
+			m.period = m.nextPeriod(serviceChanged || instanceChanged)
+			timer.Reset(m.period)
 		}
 	}
 }
 
-func (m *consulMonitor) updateServiceRecord() {
+// nextPeriod returns the polling period following a poll. The period only
+// changes in adaptive mode.
+func (m *consulMonitor) nextPeriod(changed bool) time.Duration {
+	if m.maxPeriod == 0 {
+		return m.period
+	}
+	if changed {
+		return m.minPeriod
+	}
+	if next := 2 * m.period; next < m.maxPeriod {
+		return next
+	}
+	return m.maxPeriod
+}
+
+// updateServiceRecord notifies the service handlers of a change in services
+// and returns whether there was one.
+func (m *consulMonitor) updateServiceRecord() bool {
 	svcs, _, err := m.discovery.Catalog().Services(nil)
 	if err != nil {
 		log.Warnf("Could not fetch services: %v", err)
-		return
+		return false
 	}
 	newRecord := consulServices(svcs)
 	if !reflect.DeepEqual(newRecord, m.serviceCachedRecord) {
@@ -104,14 +142,18 @@ func (m *consulMonitor) updateServiceRecord() {
 			}(f)
 		}
 		m.serviceCachedRecord = newRecord
+		return true
 	}
+	return false
 }
 
-func (m *consulMonitor) updateInstanceRecord() {
+// updateInstanceRecord notifies the instance handlers of a change in service
+// instances and returns whether there was one.
+func (m *consulMonitor) updateInstanceRecord() bool {
 	svcs, _, err := m.discovery.Catalog().Services(nil)
 	if err != nil {
 		log.Warnf("Could not fetch instances: %v", err)
-		return
+		return false
 	}
 	for _, tags := range svcs {
 		sort.Strings(tags)
@@ -130,22 +172,18 @@ func (m *consulMonitor) updateInstanceRecord() {
 	newRecord := consulServiceInstances(instances)
 	sort.Sort(newRecord)
 	if !reflect.DeepEqual(newRecord, m.instanceCachedRecord) {
-		// This is only a work-around solution currently
-		// Since Handler functions generally act as a refresher
-		// regardless of the input, thus passing in meaningless
-		// input should make functionalities work
-		// TODO
-		obj := &api.CatalogService{}
-		var event model.Event
+		previous := m.instanceCachedRecord
 		for _, f := range m.instanceHandlers {
-			go func(handler InstanceHandler) {
-				if err := handler(obj, event); err != nil {
+			go func(handler InstanceDeltaHandler) {
+				if err := handler(previous, newRecord); err != nil {
 					log.Warnf("Error executing instance handler function: %v", err)
 				}
 			}(f)
 		}
 		m.instanceCachedRecord = newRecord
+		return true
 	}
+	return false
 }
 
 func (m *consulMonitor) AppendServiceHandler(h ServiceHandler) {
@@ -153,6 +191,19 @@ func (m *consulMonitor) AppendServiceHandler(h ServiceHandler) {
 }
 
 func (m *consulMonitor) AppendInstanceHandler(h InstanceHandler) {
+	// This is only a work-around solution currently
+	// Since Handler functions generally act as a refresher
+	// regardless of the input, thus passing in meaningless
+	// input should make functionalities work
+	// TODO
+	obj := &api.CatalogService{}
+	var event model.Event
+	m.AppendInstanceDeltaHandler(func(_, _ []*api.CatalogService) error {
+		return h(obj, event)
+	})
+}
+
+func (m *consulMonitor) AppendInstanceDeltaHandler(h InstanceDeltaHandler) {
 	m.instanceHandlers = append(m.instanceHandlers, h)
 }
 
@@ -15,6 +15,8 @@
 package consul
 
 import (
+	"reflect"
+	"sort"
 	"sync"
 	"testing"
 	"time"
@@ -121,3 +123,149 @@ func TestController(t *testing.T) {
 		t.Errorf("got %d notifications from controller, want %d", i, 2)
 	}
 }
+
+func TestAdaptivePeriod(t *testing.T) {
+	m := NewAdaptiveConsulMonitor(nil, time.Second, 8*time.Second).(*consulMonitor)
+
+	// changed reports whether each poll sees a change, want is the period after it
+	polls := []struct {
+		changed bool
+		want    time.Duration
+	}{
+		// quiet period
+		{false, 2 * time.Second},
+		{false, 4 * time.Second},
+		{false, 8 * time.Second},
+		{false, 8 * time.Second},
+		// change burst
+		{true, time.Second},
+		{true, time.Second},
+		{true, time.Second},
+		// quiet again
+		{false, 2 * time.Second},
+		{false, 4 * time.Second},
+		// a single change
+		{true, time.Second},
+		{false, 2 * time.Second},
+	}
+	for i, poll := range polls {
+		m.period = m.nextPeriod(poll.changed)
+		if m.period != poll.want {
+			t.Errorf("poll %d (changed %t): period %v, want %v", i, poll.changed, m.period, poll.want)
+		}
+	}
+}
+
+func TestFixedPeriod(t *testing.T) {
+	m := NewConsulMonitor(nil, 2*time.Second).(*consulMonitor)
+	for _, changed := range []bool{false, false, true, true, false} {
+		if got := m.nextPeriod(changed); got != 2*time.Second {
+			t.Errorf("nextPeriod(%t) => %v, want %v", changed, got, 2*time.Second)
+		}
+	}
+}
+
+func instanceIDs(instances []*api.CatalogService) []string {
+	ids := make([]string, 0, len(instances))
+	for _, instance := range instances {
+		ids = append(ids, instance.ID+"@"+instance.ServiceAddress)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestInstanceDeltaHandler(t *testing.T) {
+	ts := newServer()
+	defer ts.Server.Close()
+	conf := api.DefaultConfig()
+	conf.Address = ts.Server.URL
+	cl, err := api.NewClient(conf)
+	if err != nil {
+		t.Fatalf("could not create Consul client: %v", err)
+	}
+
+	type delta struct{ previous, current []string }
+	deltas := make(chan delta, 1)
+	m := NewConsulMonitor(cl, resync).(*consulMonitor)
+	m.AppendInstanceDeltaHandler(func(previous, current []*api.CatalogService) error {
+		deltas <- delta{instanceIDs(previous), instanceIDs(current)}
+		return nil
+	})
+	oldEvents := make(chan struct{}, 10)
+	m.AppendInstanceHandler(func(*api.CatalogService, model.Event) error {
+		oldEvents <- struct{}{}
+		return nil
+	})
+
+	all := []string{
+		"111-111-111@172.19.0.11", "222-222-222@172.19.0.6", "333-333-333@172.19.0.7", "444-444-444@172.19.0.8"}
+	steps := []struct {
+		name   string
+		update func()
+		want   delta
+	}{
+		{
+			name:   "initial add",
+			update: func() {},
+			want:   delta{previous: []string{}, current: all},
+		},
+		{
+			name: "add",
+			update: func() {
+				ts.Reviews = append(ts.Reviews, &api.CatalogService{
+					ID:             "555-555-555",
+					ServiceName:    "reviews",
+					ServiceAddress: "172.19.0.9",
+					ServicePort:    9080,
+				})
+			},
+			want: delta{previous: all, current: append(append([]string{}, all...), "555-555-555@172.19.0.9")},
+		},
+		{
+			name: "update",
+			update: func() {
+				updated := *ts.Reviews[3]
+				updated.ServiceAddress = "172.19.0.10"
+				ts.Reviews[3] = &updated
+			},
+			want: delta{
+				previous: append(append([]string{}, all...), "555-555-555@172.19.0.9"),
+				current:  append(append([]string{}, all...), "555-555-555@172.19.0.10"),
+			},
+		},
+		{
+			name: "delete",
+			update: func() {
+				ts.Reviews = ts.Reviews[:3]
+			},
+			want: delta{previous: append(append([]string{}, all...), "555-555-555@172.19.0.10"), current: all},
+		},
+	}
+	for _, step := range steps {
+		ts.Lock.Lock()
+		step.update()
+		ts.Lock.Unlock()
+
+		if !m.updateInstanceRecord() {
+			t.Fatalf("%s: no change detected", step.name)
+		}
+		select {
+		case got := <-deltas:
+			if !reflect.DeepEqual(got, step.want) {
+				t.Errorf("%s: got previous %v, current %v; want previous %v, current %v",
+					step.name, got.previous, got.current, step.want.previous, step.want.current)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: delta handler not called", step.name)
+		}
+		select {
+		case <-oldEvents:
+		case <-time.After(time.Second):
+			t.Errorf("%s: instance handler not called", step.name)
+		}
+	}
+
+	if m.updateInstanceRecord() {
+		t.Error("change detected without a catalog update")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ghodss/yaml"
+
+	"istio.io/istio/pkg/log"
+)
+
+const spiffePrefix = "spiffe://"
+
+// ControllerOption configures optional behavior of a Controller
+type ControllerOption func(*Controller) error
+
+// WithServiceAccountsFile maps Consul service names to SPIFFE identities using
+// a YAML file, for example:
+//
+//	productpage: spiffe://cluster.local/ns/default/sa/bookinfo-productpage
+//
+// The file is loaded when the controller is created and re-read on SIGHUP
+// while the controller is running.
+func WithServiceAccountsFile(path string) ControllerOption {
+	return func(c *Controller) error {
+		c.serviceAccounts = &serviceAccountsFile{path: path}
+		return c.serviceAccounts.load()
+	}
+}
+
+// serviceAccountsFile holds the identities last loaded from a mapping file
+type serviceAccountsFile struct {
+	path string
+
+	mutex      sync.RWMutex
+	identities map[string]string
+}
+
+// load reads and validates the mapping file. The previous mapping is kept if
+// the file cannot be read or is malformed.
+func (f *serviceAccountsFile) load() error {
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	identities := make(map[string]string)
+	if err = yaml.Unmarshal(data, &identities); err != nil {
+		return fmt.Errorf("cannot parse service accounts file %s: %v", f.path, err)
+	}
+	for name, identity := range identities {
+		if !validServiceName(name) {
+			return fmt.Errorf("invalid service name %q in %s", name, f.path)
+		}
+		if !strings.HasPrefix(identity, spiffePrefix) || len(identity) == len(spiffePrefix) {
+			return fmt.Errorf("invalid identity %q for service %s in %s: must be a %s URI",
+				identity, name, f.path, spiffePrefix)
+		}
+	}
+
+	f.mutex.Lock()
+	f.identities = identities
+	f.mutex.Unlock()
+	return nil
+}
+
+// lookup returns the identity mapped to a service name
+func (f *serviceAccountsFile) lookup(name string) (string, bool) {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	identity, ok := f.identities[name]
+	return identity, ok
+}
+
+// watch reloads the mapping file on every signal received until stop is closed
+func (f *serviceAccountsFile) watch(signals <-chan os.Signal, stop <-chan struct{}) {
+	for {
+		select {
+		case <-signals:
+			if err := f.load(); err != nil {
+				log.Warnf("Keeping previous service accounts: %v", err)
+			} else {
+				log.Infof("Reloaded service accounts from %s", f.path)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
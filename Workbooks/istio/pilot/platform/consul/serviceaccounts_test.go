@@ -0,0 +1,147 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consul
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const (
+	productpageIdentity = "spiffe://cluster.local/ns/default/sa/bookinfo-productpage"
+	reviewsIdentity     = "spiffe://cluster.local/ns/default/sa/bookinfo-reviews"
+	detailsIdentity     = "spiffe://cluster.local/ns/default/sa/bookinfo-details"
+)
+
+func writeServiceAccountsFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "consul_service_accounts")
+	if err != nil {
+		t.Fatalf("TempFile() failed: %v", err)
+	}
+	_ = f.Close()
+	if err = ioutil.WriteFile(f.Name(), []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile() failed: %v", err)
+	}
+	return f.Name()
+}
+
+func TestGetIstioServiceAccountsFromFile(t *testing.T) {
+	ts := newServer()
+	defer ts.Server.Close()
+
+	path := writeServiceAccountsFile(t, "productpage: "+productpageIdentity+"\n"+
+		"bookinfo.details: "+detailsIdentity+"\n")
+	defer func() { _ = os.Remove(path) }()
+	controller, err := NewController(ts.Server.URL, 3*time.Second, WithServiceAccountsFile(path))
+	if err != nil {
+		t.Fatalf("could not create Consul Controller: %v", err)
+	}
+
+	cases := []struct {
+		hostname string
+		want     []string
+	}{
+		{hostname: serviceHostname("productpage"), want: []string{productpageIdentity}},
+		{hostname: serviceHostname("bookinfo.details"), want: []string{detailsIdentity}},
+		{hostname: serviceHostname("reviews")},
+		{hostname: "productpage.default.svc.cluster.local"},
+	}
+	for _, c := range cases {
+		if got := controller.GetIstioServiceAccounts(c.hostname, nil); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("GetIstioServiceAccounts(%s) => %v, want %v", c.hostname, got, c.want)
+		}
+	}
+}
+
+func TestGetIstioServiceAccountsWithoutFile(t *testing.T) {
+	ts := newServer()
+	defer ts.Server.Close()
+
+	controller, err := NewController(ts.Server.URL, 3*time.Second)
+	if err != nil {
+		t.Fatalf("could not create Consul Controller: %v", err)
+	}
+	if got := controller.GetIstioServiceAccounts(serviceHostname("productpage"), nil); got != nil {
+		t.Errorf("GetIstioServiceAccounts() => %v, want none", got)
+	}
+}
+
+func TestServiceAccountsFileInvalid(t *testing.T) {
+	cases := map[string]string{
+		"malformed":        "productpage: [" + productpageIdentity,
+		"not a map":        "- " + productpageIdentity,
+		"not spiffe":       "productpage: bookinfo-productpage",
+		"empty identity":   "productpage: spiffe://",
+		"invalid hostname": "product page: " + productpageIdentity,
+		"empty label":      "product..page: " + productpageIdentity,
+	}
+	for id, content := range cases {
+		path := writeServiceAccountsFile(t, content)
+		if _, err := NewController("127.0.0.1:0", 3*time.Second, WithServiceAccountsFile(path)); err == nil {
+			t.Errorf("%s: expected error loading %q", id, content)
+		}
+		_ = os.Remove(path)
+	}
+
+	if _, err := NewController("127.0.0.1:0", 3*time.Second, WithServiceAccountsFile("/does/not/exist")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestServiceAccountsFileReload(t *testing.T) {
+	path := writeServiceAccountsFile(t, "productpage: "+productpageIdentity+"\n")
+	defer func() { _ = os.Remove(path) }()
+
+	f := &serviceAccountsFile{path: path}
+	if err := f.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	signals := make(chan os.Signal)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		f.watch(signals, stop)
+		close(done)
+	}()
+
+	reload := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// unbuffered, so the previous reload has finished once this is received
+		signals <- os.Interrupt
+		signals <- os.Interrupt
+	}
+
+	reload("reviews: " + reviewsIdentity + "\n")
+	if _, ok := f.lookup("productpage"); ok {
+		t.Error("productpage is still mapped after reload")
+	}
+	if got, _ := f.lookup("reviews"); got != reviewsIdentity {
+		t.Errorf("lookup(reviews) => %q, want %q", got, reviewsIdentity)
+	}
+
+	reload("reviews: [")
+	if got, _ := f.lookup("reviews"); got != reviewsIdentity {
+		t.Errorf("malformed reload replaced mapping: lookup(reviews) => %q, want %q", got, reviewsIdentity)
+	}
+
+	close(stop)
+	<-done
+}
@@ -21,20 +21,28 @@ package inject
 
 import (
 	"fmt"
+	"io/ioutil"
 
 	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 
 	meshconfig "istio.io/api/mesh/v1alpha1"
 	"istio.io/istio/pilot/model"
+	"istio.io/istio/pkg/meshconfigmap"
 )
 
-// GetMeshConfig fetches the ProxyMesh configuration from Kubernetes ConfigMap.
+// RetryPolicy controls how fetching a ConfigMap is retried.
+type RetryPolicy = meshconfigmap.RetryPolicy
+
+// DefaultRetryPolicy retries for up to a minute.
+var DefaultRetryPolicy = meshconfigmap.DefaultRetryPolicy
+
+// GetMeshConfig fetches the ProxyMesh configuration from Kubernetes ConfigMap,
+// retrying failed requests per the policy.
 func GetMeshConfig(kube kubernetes.Interface, namespace,
-	name string) (*v1.ConfigMap, *meshconfig.MeshConfig, error) {
+	name string, retry RetryPolicy) (*v1.ConfigMap, *meshconfig.MeshConfig, error) {
 
-	config, err := kube.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	config, err := meshconfigmap.Get(kube, namespace, name, retry)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -52,3 +60,14 @@ func GetMeshConfig(kube kubernetes.Interface, namespace,
 	}
 	return config, mesh, nil
 }
+
+// LoadMeshConfigFromFile reads the ProxyMesh configuration from a YAML file,
+// applying the same defaults as GetMeshConfig. It allows injecting sidecars
+// without access to a cluster.
+func LoadMeshConfigFromFile(path string) (*meshconfig.MeshConfig, error) {
+	yaml, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return model.ApplyMeshConfigDefaults(string(yaml))
+}
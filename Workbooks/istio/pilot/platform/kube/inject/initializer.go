@@ -15,16 +15,25 @@
 package inject
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	// TODO(nmittler): Remove this
 	_ "github.com/golang/glog"
+	"go.uber.org/zap"
+	appsv1 "k8s.io/api/apps/v1"
 	appsv1beta1 "k8s.io/api/apps/v1beta1"
 	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/api/batch/v2alpha1"
 	"k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
@@ -34,10 +43,13 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 
 	"istio.io/istio/pilot/platform/kube"
 	"istio.io/istio/pkg/log"
@@ -58,49 +70,128 @@ var ignoredNamespaces = []string{
 type Initializer struct {
 	clientset   kubernetes.Interface
 	controllers []cache.Controller
+	kinds       []injectKind
 	config      *Config
+	recorder    record.EventRecorder
+	pending     pendingObjects
+	failed      failedObjects
+
+	// running tracks the controller goroutines started by Run
+	running sync.WaitGroup
+}
+
+// injectKind is a kind of object the sidecar can be injected into
+type injectKind struct {
+	groupVersion schema.GroupVersion
+	obj          runtime.Object
+	resource     string
+	apiPath      string
+	list         func(cl kubernetes.Interface, options metav1.ListOptions) (runtime.Object, error)
 }
 
 var (
-	kinds = []struct {
-		groupVersion schema.GroupVersion
-		obj          runtime.Object
-		resource     string
-		apiPath      string
-	}{
-		{v1.SchemeGroupVersion, &v1.ReplicationController{}, "replicationcontrollers", "/api"},
-
-		{v1beta1.SchemeGroupVersion, &v1beta1.Deployment{}, "deployments", "/apis"},
-		{v1beta1.SchemeGroupVersion, &v1beta1.DaemonSet{}, "daemonsets", "/apis"},
-		{v1beta1.SchemeGroupVersion, &v1beta1.ReplicaSet{}, "replicasets", "/apis"},
-
-		{batchv1.SchemeGroupVersion, &batchv1.Job{}, "jobs", "/apis"},
-		{v2alpha1.SchemeGroupVersion, &v2alpha1.CronJob{}, "cronjobs", "/apis"},
+	// kinds lists the stable group version of a kind before the
+	// deprecated ones, see servedKinds.
+	kinds = []injectKind{
+		{v1.SchemeGroupVersion, &v1.ReplicationController{}, "replicationcontrollers", "/api",
+			func(cl kubernetes.Interface, options metav1.ListOptions) (runtime.Object, error) {
+				return cl.CoreV1().ReplicationControllers(v1.NamespaceAll).List(options)
+			}},
+
+		{appsv1.SchemeGroupVersion, &appsv1.Deployment{}, "deployments", "/apis",
+			func(cl kubernetes.Interface, options metav1.ListOptions) (runtime.Object, error) {
+				return cl.AppsV1().Deployments(v1.NamespaceAll).List(options)
+			}},
+		{appsv1.SchemeGroupVersion, &appsv1.DaemonSet{}, "daemonsets", "/apis",
+			func(cl kubernetes.Interface, options metav1.ListOptions) (runtime.Object, error) {
+				return cl.AppsV1().DaemonSets(v1.NamespaceAll).List(options)
+			}},
+		{appsv1.SchemeGroupVersion, &appsv1.ReplicaSet{}, "replicasets", "/apis",
+			func(cl kubernetes.Interface, options metav1.ListOptions) (runtime.Object, error) {
+				return cl.AppsV1().ReplicaSets(v1.NamespaceAll).List(options)
+			}},
+		{appsv1.SchemeGroupVersion, &appsv1.StatefulSet{}, "statefulsets", "/apis",
+			func(cl kubernetes.Interface, options metav1.ListOptions) (runtime.Object, error) {
+				return cl.AppsV1().StatefulSets(v1.NamespaceAll).List(options)
+			}},
+
+		{v1beta1.SchemeGroupVersion, &v1beta1.Deployment{}, "deployments", "/apis",
+			func(cl kubernetes.Interface, options metav1.ListOptions) (runtime.Object, error) {
+				return cl.ExtensionsV1beta1().Deployments(v1.NamespaceAll).List(options)
+			}},
+		{v1beta1.SchemeGroupVersion, &v1beta1.DaemonSet{}, "daemonsets", "/apis",
+			func(cl kubernetes.Interface, options metav1.ListOptions) (runtime.Object, error) {
+				return cl.ExtensionsV1beta1().DaemonSets(v1.NamespaceAll).List(options)
+			}},
+		{v1beta1.SchemeGroupVersion, &v1beta1.ReplicaSet{}, "replicasets", "/apis",
+			func(cl kubernetes.Interface, options metav1.ListOptions) (runtime.Object, error) {
+				return cl.ExtensionsV1beta1().ReplicaSets(v1.NamespaceAll).List(options)
+			}},
+
+		{batchv1.SchemeGroupVersion, &batchv1.Job{}, "jobs", "/apis",
+			func(cl kubernetes.Interface, options metav1.ListOptions) (runtime.Object, error) {
+				return cl.BatchV1().Jobs(v1.NamespaceAll).List(options)
+			}},
+		{v2alpha1.SchemeGroupVersion, &v2alpha1.CronJob{}, "cronjobs", "/apis",
+			func(cl kubernetes.Interface, options metav1.ListOptions) (runtime.Object, error) {
+				return cl.BatchV2alpha1().CronJobs(v1.NamespaceAll).List(options)
+			}},
 		// TODO JobTemplate requires different reflection logic to populate the PodTemplateSpec
 
-		{appsv1beta1.SchemeGroupVersion, &appsv1beta1.StatefulSet{}, "statefulsets", "/apis"},
+		{appsv1beta1.SchemeGroupVersion, &appsv1beta1.StatefulSet{}, "statefulsets", "/apis",
+			func(cl kubernetes.Interface, options metav1.ListOptions) (runtime.Object, error) {
+				return cl.AppsV1beta1().StatefulSets(v1.NamespaceAll).List(options)
+			}},
 	}
 	injectScheme = runtime.NewScheme()
 )
 
+// Events recorded on initialized objects
+const (
+	eventComponent      = "istio-sidecar-initializer"
+	eventReasonInjected = "SidecarInjected"
+	eventReasonFailed   = "SidecarInjectionFailed"
+	eventReasonObserved = "SidecarInjectionObserved"
+)
+
 type patcherFunc func(namespace, name string, patchBytes []byte, obj runtime.Object) error
 
 func init() {
+	unversioned := make(map[string]bool)
 	for _, kind := range kinds {
 		injectScheme.AddKnownTypes(kind.groupVersion, kind.obj)
-		injectScheme.AddUnversionedTypes(kind.groupVersion, kind.obj)
+		// unversioned kind names must be unique, so only the first
+		// group version of a kind is registered as unversioned
+		name := reflect.TypeOf(kind.obj).Elem().Name()
+		if !unversioned[name] {
+			injectScheme.AddUnversionedTypes(kind.groupVersion, kind.obj)
+			unversioned[name] = true
+		}
 	}
 }
 
 // NewInitializer creates a new instance of the Istio sidecar initializer.
 func NewInitializer(restConfig *rest.Config, config *Config, cl kubernetes.Interface) (*Initializer, error) {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: cl.CoreV1().Events(v1.NamespaceAll)})
+
+	served, err := servedKinds(cl.Discovery())
+	if err != nil {
+		return nil, err
+	}
+
 	i := &Initializer{
 		clientset: cl,
 		config:    config,
+		recorder:  broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: eventComponent}),
 	}
 
-	for k := range kinds {
-		kind := kinds[k]
+	for k := range served {
+		kind := served[k]
+		if !config.kindEnabled(objectKind(kind.obj)) {
+			continue
+		}
+		i.kinds = append(i.kinds, kind)
 
 		// Create RESTClient for the specific GroupVersion and APIPath.
 		kindConfig := *restConfig
@@ -148,13 +239,23 @@ func NewInitializer(restConfig *rest.Config, config *Config, cl kubernetes.Inter
 				Into(obj)
 		}
 
+		kindName := objectKind(kind.obj)
 		_, controller := cache.NewInformer(watchlist, kind.obj, DefaultResyncPeriod,
 			cache.ResourceEventHandlerFuncs{
 				AddFunc: func(obj interface{}) {
-					if err := i.initialize(obj.(runtime.Object), patcher); err != nil {
-						log.Error(err.Error())
+					i.pending.update(config.InitializerName, kindName, obj, false)
+					i.initializeObject(obj.(runtime.Object), patcher)
+				},
+				UpdateFunc: func(_, obj interface{}) {
+					i.pending.update(config.InitializerName, kindName, obj, false)
+					if i.failed.contains(obj) {
+						i.initializeObject(obj.(runtime.Object), patcher)
 					}
 				},
+				DeleteFunc: func(obj interface{}) {
+					i.pending.update(config.InitializerName, kindName, obj, true)
+					i.failed.remove(obj)
+				},
 			},
 		)
 		i.controllers = append(i.controllers, controller)
@@ -162,7 +263,28 @@ func NewInitializer(restConfig *rest.Config, config *Config, cl kubernetes.Inter
 	return i, nil
 }
 
-func (i *Initializer) initialize(in runtime.Object, patcher patcherFunc) error {
+// initializeObject initializes the object unless the injection already
+// failed for its resourceVersion, so that an object failing permanently is
+// reported once rather than on every event.
+func (i *Initializer) initializeObject(in runtime.Object, patcher patcherFunc) {
+	obj, err := meta.Accessor(in)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	if i.failed.failedVersion(obj) {
+		return
+	}
+	if err := i.initialize(in, patcher); err != nil {
+		i.failed.add(obj)
+		log.Warnf("Failed to initialize %s/%s at resourceVersion %s, backing off until it changes: %v",
+			obj.GetNamespace(), obj.GetName(), obj.GetResourceVersion(), err)
+		return
+	}
+	i.failed.remove(in)
+}
+
+func (i *Initializer) initialize(in runtime.Object, patcher patcherFunc) (err error) {
 	obj, err := meta.Accessor(in)
 	if err != nil {
 		return err
@@ -174,11 +296,13 @@ func (i *Initializer) initialize(in runtime.Object, patcher patcherFunc) error {
 	}
 	gvk := gvks[0]
 
-	log.Infof("ObjectMeta initializer info %v %v/%v policy:%q status:%q %v",
-		gvk, obj.GetNamespace(), obj.GetName(),
-		obj.GetAnnotations()[istioSidecarAnnotationPolicyKey],
-		obj.GetAnnotations()[istioSidecarAnnotationStatusKey],
-		obj.GetInitializers())
+	log.Info(fmt.Sprintf("ObjectMeta initializer info %v %v/%v", gvk, obj.GetNamespace(), obj.GetName()),
+		zap.String("namespace", obj.GetNamespace()),
+		zap.String("name", obj.GetName()),
+		zap.String("kind", gvk.Kind),
+		zap.String("policy", obj.GetAnnotations()[i.config.policyAnnotationKey()]),
+		zap.String("status", obj.GetAnnotations()[i.config.statusAnnotationKey()]),
+		zap.Any("initializers", obj.GetInitializers()))
 
 	if obj.GetInitializers() == nil {
 		return nil
@@ -191,50 +315,121 @@ func (i *Initializer) initialize(in runtime.Object, patcher patcherFunc) error {
 		return nil
 	}
 
-	out, err := intoObject(i.config, in)
+	start := time.Now()
+	result := resultInjected
+	defer func() {
+		if err != nil {
+			result = resultFailed
+		}
+		initializeDuration.WithLabelValues(gvk.Kind).Observe(time.Since(start).Seconds())
+		injectionCounter.WithLabelValues(gvk.Kind, result).Inc()
+	}()
+
+	patchBytes, _, reason, err := injectionPatch(i.config, in)
 	if err != nil {
+		i.recorder.Eventf(in, v1.EventTypeWarning, eventReasonFailed, "Failed to inject istio sidecar: %v", err)
 		return err
 	}
-
-	if obj, err = meta.Accessor(out); err != nil {
+	if i.config.ObserveOnly {
+		result = resultObserved
+		log.Info(fmt.Sprintf("Observe only, not patching %s/%s", obj.GetNamespace(), obj.GetName()),
+			zap.String("namespace", obj.GetNamespace()),
+			zap.String("name", obj.GetName()),
+			zap.String("kind", gvk.Kind),
+			zap.String("reason", reason),
+			zap.String("patch", string(patchBytes)))
+		if reason == "" {
+			i.recorder.Eventf(in, v1.EventTypeNormal, eventReasonObserved,
+				"Would inject istio sidecar (version %s)", i.config.Params.Version)
+		}
+		return nil
+	}
+	rObj, err := injectScheme.New(gvk)
+	if err != nil {
 		return err
 	}
+	if err = patcher(obj.GetNamespace(), obj.GetName(), patchBytes, rObj); err != nil {
+		i.recorder.Eventf(in, v1.EventTypeWarning, eventReasonFailed, "Failed to inject istio sidecar: %v", err)
+		return err
+	}
+	if reason != "" {
+		result = resultSkipped
+	} else {
+		i.recorder.Eventf(in, v1.EventTypeNormal, eventReasonInjected, "Injected istio sidecar (version %s)",
+			i.config.Params.Version)
+	}
+	return nil
+}
+
+// ComputeInjectionPatch returns the strategic merge patch the
+// initializer applies to in, along with the kind of in, e.g. to preview
+// the injection. Besides injecting the sidecar, the patch removes
+// c.InitializerName from the pending initializers of in if it is the
+// first one, unless c.ObserveOnly is set. The patch is empty if in is
+// left unchanged.
+func ComputeInjectionPatch(c *Config, in runtime.Object) ([]byte, schema.GroupVersionKind, error) {
+	patchBytes, gvk, _, err := injectionPatch(c, in)
+	return patchBytes, gvk, err
+}
+
+// injectionPatch is ComputeInjectionPatch, also returning the reason
+// injection was skipped, if any.
+func injectionPatch(c *Config, in runtime.Object) ([]byte, schema.GroupVersionKind, string, error) {
+	gvks, _, err := injectScheme.ObjectKinds(in)
+	if err != nil {
+		return nil, schema.GroupVersionKind{}, "", err
+	}
+	gvk := gvks[0]
+
+	out, reason, err := injectObject(c, in)
+	if err != nil {
+		return nil, gvk, "", err
+	}
+	obj, err := meta.Accessor(out)
+	if err != nil {
+		return nil, gvk, "", err
+	}
 
 	// Remove self from the list of pending Initializers while
-	// preserving ordering.
-	if pending := obj.GetInitializers().Pending; len(pending) == 1 {
-		obj.SetInitializers(nil)
-	} else {
-		obj.GetInitializers().Pending = append(pending[:0], pending[1:]...)
+	// preserving ordering. In observe only mode the initializer stays
+	// pending, so that the patch only describes the injection.
+	if initializers := obj.GetInitializers(); !c.ObserveOnly && initializers != nil &&
+		len(initializers.Pending) > 0 && initializers.Pending[0].Name == c.InitializerName {
+		if pending := initializers.Pending; len(pending) == 1 {
+			obj.SetInitializers(nil)
+		} else {
+			initializers.Pending = append(pending[:0], pending[1:]...)
+		}
 	}
 
 	prevData, err := json.Marshal(in)
 	if err != nil {
-		return err
+		return nil, gvk, "", err
 	}
 	currData, err := json.Marshal(out)
 	if err != nil {
-		return err
+		return nil, gvk, "", err
 	}
 	rObj, err := injectScheme.New(gvk)
 	if err != nil {
-		return err
+		return nil, gvk, "", err
 	}
 	patchBytes, err := strategicpatch.CreateTwoWayMergePatch(prevData, currData, rObj)
 	if err != nil {
-		return err
+		return nil, gvk, "", err
 	}
-	return patcher(obj.GetNamespace(), obj.GetName(), patchBytes, rObj)
+	return patchBytes, gvk, reason, nil
 }
 
-// Run runs the Initializer controller.
-func (i *Initializer) Run(stopCh <-chan struct{}) {
+// Run starts the Initializer controllers, which run until ctx is
+// cancelled. It does not block; see Wait.
+func (i *Initializer) Run(ctx context.Context) {
 	log.Info("Starting Istio sidecar initializer...")
 	log.Infof("Initializer name set to: %s", i.config.InitializerName)
 	log.Infof("Options: %v", spew.Sdump(i.config))
 
-	log.Infof("Supported kinds:")
-	for _, kind := range kinds {
+	log.Infof("Watched kinds:")
+	for _, kind := range i.kinds {
 		if gvks, _, err := injectScheme.ObjectKinds(kind.obj); err != nil {
 			log.Warnf("Could not determine object kind: ", err)
 		} else {
@@ -243,7 +438,153 @@ func (i *Initializer) Run(stopCh <-chan struct{}) {
 		}
 	}
 
+	stopCh := ctx.Done()
 	for _, controller := range i.controllers {
-		go controller.Run(stopCh)
+		i.running.Add(1)
+		go func(controller cache.Controller) {
+			defer i.running.Done()
+			controller.Run(stopCh)
+		}(controller)
+	}
+}
+
+// Wait blocks until all controllers started by Run have returned after
+// its context was cancelled.
+func (i *Initializer) Wait() {
+	i.running.Wait()
+}
+
+// InjectedWorkload identifies a workload whose pod template carries the
+// sidecar status annotation.
+type InjectedWorkload struct {
+	Namespace string
+	Name      string
+	Kind      string
+	// Version is the injected sidecar version recorded in the status
+	// annotation.
+	Version string
+}
+
+// ListInjectedWorkloads returns the workloads of all kinds handled by the
+// initializer, in all namespaces, whose pod template has been injected
+// with the sidecar, as recorded in the status annotation of config.
+func ListInjectedWorkloads(cl kubernetes.Interface, config *Config) ([]InjectedWorkload, error) {
+	served, err := servedKinds(cl.Discovery())
+	if err != nil {
+		return nil, err
+	}
+
+	var workloads []InjectedWorkload
+	for _, kind := range served {
+		list, err := kind.list(cl, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("cannot list %s: %v", kind.resource, err)
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			objectMeta, templateObjectMeta, _ := templateMeta(item)
+			status, ok := templateObjectMeta.Annotations[config.statusAnnotationKey()]
+			if !ok {
+				continue
+			}
+			workloads = append(workloads, InjectedWorkload{
+				Namespace: objectMeta.Namespace,
+				Name:      objectMeta.Name,
+				Kind:      objectKind(kind.obj),
+				Version:   strings.TrimPrefix(status, injectedVersionPrefix),
+			})
+		}
+	}
+	return workloads, nil
+}
+
+// servedKinds returns, for each kind name, the first entry of kinds
+// whose group version and resource are served by the API server. Objects
+// are served under all group versions of their kind, so only one is
+// watched to initialize each object once.
+func servedKinds(cl discovery.ServerResourcesInterface) ([]injectKind, error) {
+	var served []injectKind
+	found := make(map[string]bool)
+	for _, kind := range kinds {
+		name := objectKind(kind.obj)
+		if found[name] {
+			continue
+		}
+		resources, err := cl.ServerResourcesForGroupVersion(kind.groupVersion.String())
+		if errors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("cannot discover %v resources: %v", kind.groupVersion, err)
+		}
+		if resources == nil {
+			continue
+		}
+		for _, resource := range resources.APIResources {
+			if resource.Name == kind.resource {
+				served = append(served, kind)
+				found[name] = true
+				break
+			}
+		}
+	}
+	return served, nil
+}
+
+// failedObjects records the resourceVersion at which the injection of an
+// object failed, keyed by the namespace, name and UID of the object.
+type failedObjects struct {
+	mutex    sync.Mutex
+	versions map[string]string
+}
+
+func failedObjectKey(obj metav1.Object) string {
+	return obj.GetNamespace() + "/" + obj.GetName() + "/" + string(obj.GetUID())
+}
+
+// add records that the injection of obj failed at its resourceVersion.
+func (f *failedObjects) add(obj metav1.Object) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.versions == nil {
+		f.versions = make(map[string]string)
+	}
+	f.versions[failedObjectKey(obj)] = obj.GetResourceVersion()
+}
+
+// contains returns whether the injection of obj failed at any resourceVersion.
+func (f *failedObjects) contains(obj interface{}) bool {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	_, ok := f.versions[failedObjectKey(accessor)]
+	return ok
+}
+
+// failedVersion returns whether the injection of obj failed at its
+// current resourceVersion.
+func (f *failedObjects) failedVersion(obj metav1.Object) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	version, ok := f.versions[failedObjectKey(obj)]
+	return ok && version == obj.GetResourceVersion()
+}
+
+// remove forgets the failures of obj, which may be a deleted object.
+func (f *failedObjects) remove(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
 	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.versions, failedObjectKey(accessor))
 }
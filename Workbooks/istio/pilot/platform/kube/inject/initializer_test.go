@@ -15,23 +15,40 @@
 package inject
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/ghodss/yaml"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	appsv1 "k8s.io/api/apps/v1"
+	appsv1beta1 "k8s.io/api/apps/v1beta1"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/batch/v2alpha1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 
 	"istio.io/istio/pilot/model"
 	"istio.io/istio/pilot/platform/kube"
 	"istio.io/istio/pilot/test/util"
+	"istio.io/istio/pkg/log"
 	"istio.io/istio/tests/k8s"
 )
 
@@ -61,11 +78,169 @@ func TestInitializerRun(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 
-	stop := make(chan struct{})
-	go i.Run(stop)
+	ctx, cancel := context.WithCancel(context.Background())
+	i.Run(ctx)
 
 	time.Sleep(3 * time.Second)
-	close(stop)
+	cancel()
+	i.Wait()
+}
+
+func TestNewInitializerEnabledKinds(t *testing.T) {
+	restConfig := &rest.Config{Host: "localhost"}
+	cases := []struct {
+		name  string
+		kinds []string
+		want  int
+	}{
+		{name: "all kinds", want: 7},
+		{name: "subset", kinds: []string{"Deployment", "StatefulSet"}, want: 2},
+	}
+	for _, c := range cases {
+		i, err := NewInitializer(restConfig, &Config{EnabledKinds: c.kinds}, fakeClientset(allGroupVersions))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := len(i.controllers); got != c.want {
+			t.Errorf("%s: got %d controllers, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+var (
+	legacyGroupVersions = []schema.GroupVersion{
+		v1.SchemeGroupVersion,
+		v1beta1.SchemeGroupVersion,
+		batchv1.SchemeGroupVersion,
+		v2alpha1.SchemeGroupVersion,
+		appsv1beta1.SchemeGroupVersion,
+	}
+	allGroupVersions = append([]schema.GroupVersion{appsv1.SchemeGroupVersion}, legacyGroupVersions...)
+)
+
+// fakeClientset returns a fake clientset holding objects, whose discovery
+// serves the kinds of the given group versions.
+func fakeClientset(groupVersions []schema.GroupVersion, objects ...runtime.Object) *fake.Clientset {
+	cl := fake.NewSimpleClientset(objects...)
+	for _, gv := range groupVersions {
+		resources := &metav1.APIResourceList{GroupVersion: gv.String()}
+		for _, kind := range kinds {
+			if kind.groupVersion == gv {
+				resources.APIResources = append(resources.APIResources,
+					metav1.APIResource{Name: kind.resource, Namespaced: true, Kind: objectKind(kind.obj)})
+			}
+		}
+		cl.Fake.Resources = append(cl.Fake.Resources, resources)
+	}
+	return cl
+}
+
+func TestServedKinds(t *testing.T) {
+	cases := []struct {
+		name          string
+		groupVersions []schema.GroupVersion
+		want          map[string]schema.GroupVersion
+	}{
+		{
+			name:          "legacy",
+			groupVersions: legacyGroupVersions,
+			want: map[string]schema.GroupVersion{
+				"ReplicationController": v1.SchemeGroupVersion,
+				"Deployment":            v1beta1.SchemeGroupVersion,
+				"DaemonSet":             v1beta1.SchemeGroupVersion,
+				"ReplicaSet":            v1beta1.SchemeGroupVersion,
+				"Job":                   batchv1.SchemeGroupVersion,
+				"CronJob":               v2alpha1.SchemeGroupVersion,
+				"StatefulSet":           appsv1beta1.SchemeGroupVersion,
+			},
+		},
+		{
+			name:          "stable preferred",
+			groupVersions: allGroupVersions,
+			want: map[string]schema.GroupVersion{
+				"ReplicationController": v1.SchemeGroupVersion,
+				"Deployment":            appsv1.SchemeGroupVersion,
+				"DaemonSet":             appsv1.SchemeGroupVersion,
+				"ReplicaSet":            appsv1.SchemeGroupVersion,
+				"Job":                   batchv1.SchemeGroupVersion,
+				"CronJob":               v2alpha1.SchemeGroupVersion,
+				"StatefulSet":           appsv1.SchemeGroupVersion,
+			},
+		},
+		{
+			name:          "apps/v1 only",
+			groupVersions: []schema.GroupVersion{v1.SchemeGroupVersion, appsv1.SchemeGroupVersion},
+			want: map[string]schema.GroupVersion{
+				"ReplicationController": v1.SchemeGroupVersion,
+				"Deployment":            appsv1.SchemeGroupVersion,
+				"DaemonSet":             appsv1.SchemeGroupVersion,
+				"ReplicaSet":            appsv1.SchemeGroupVersion,
+				"StatefulSet":           appsv1.SchemeGroupVersion,
+			},
+		},
+	}
+	for _, c := range cases {
+		served, err := servedKinds(fakeClientset(c.groupVersions).Discovery())
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		got := make(map[string]schema.GroupVersion)
+		for _, kind := range served {
+			got[objectKind(kind.obj)] = kind.groupVersion
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: servedKinds() => %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+// blockingController runs until it is stopped
+type blockingController struct {
+	started chan struct{}
+}
+
+func (c *blockingController) Run(stopCh <-chan struct{}) {
+	c.started <- struct{}{}
+	<-stopCh
+}
+
+func (c *blockingController) HasSynced() bool { return true }
+
+func (c *blockingController) LastSyncResourceVersion() string { return "" }
+
+func TestInitializerWait(t *testing.T) {
+	started := make(chan struct{})
+	i := &Initializer{
+		config: &Config{},
+		controllers: []cache.Controller{
+			&blockingController{started},
+			&blockingController{started},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	i.Run(ctx)
+	for range i.controllers {
+		<-started
+	}
+
+	done := make(chan struct{})
+	go func() {
+		i.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Wait() returned before the context was cancelled")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait() did not return after the context was cancelled")
+	}
 }
 
 func TestInitialize(t *testing.T) {
@@ -289,3 +464,555 @@ func TestInitialize(t *testing.T) {
 		}
 	}
 }
+
+func TestComputeInjectionPatch(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := &Config{
+		Policy:            InjectionPolicyEnabled,
+		IncludeNamespaces: []string{v1.NamespaceAll},
+		Params: Params{
+			InitImage:       InitImageName(unitTestHub, unitTestTag, false),
+			ProxyImage:      ProxyImageName(unitTestHub, unitTestTag, false),
+			ImagePullPolicy: "IfNotPresent",
+			Verbosity:       DefaultVerbosity,
+			SidecarProxyUID: DefaultSidecarProxyUID,
+			Version:         "12345678",
+			Mesh:            &mesh,
+		},
+		InitializerName: DefaultInitializerName,
+	}
+
+	raw, err := ioutil.ReadFile("testdata/required.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &v1beta1.Deployment{}
+	if err = yaml.Unmarshal(raw, in); err != nil {
+		t.Fatal(err)
+	}
+
+	patchBytes, gvk, err := ComputeInjectionPatch(config, in)
+	if err != nil {
+		t.Fatalf("ComputeInjectionPatch() returned an error: %v", err)
+	}
+	if gvk.Kind != "Deployment" {
+		t.Errorf("ComputeInjectionPatch() returned kind %q, want Deployment", gvk.Kind)
+	}
+
+	// the patch applied to the input yields the injected object without
+	// the pending initializer
+	want, err := intoObject(config, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.(*v1beta1.Deployment).Initializers = nil
+	wantData, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inData, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchedData, err := strategicpatch.StrategicMergePatch(inData, patchBytes, &v1beta1.Deployment{})
+	if err != nil {
+		t.Fatalf("StrategicMergePatch() returned an error: %v", err)
+	}
+	patched := &v1beta1.Deployment{}
+	if err = json.Unmarshal(patchedData, patched); err != nil {
+		t.Fatal(err)
+	}
+	gotData, err := json.Marshal(patched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(gotData) != string(wantData) {
+		t.Errorf("patched object \n%s, want \n%s", gotData, wantData)
+	}
+}
+
+func TestInitializeRecordsEvents(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := &Config{
+		Policy:            InjectionPolicyEnabled,
+		IncludeNamespaces: []string{v1.NamespaceAll},
+		Params: Params{
+			InitImage:       InitImageName(unitTestHub, unitTestTag, false),
+			ProxyImage:      ProxyImageName(unitTestHub, unitTestTag, false),
+			SidecarProxyUID: DefaultSidecarProxyUID,
+			Version:         "12345678",
+			Mesh:            &mesh,
+		},
+		InitializerName: DefaultInitializerName,
+	}
+
+	raw, err := ioutil.ReadFile("testdata/required.yaml")
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+
+	cases := []struct {
+		name      string
+		patchErr  error
+		wantEvent string
+	}{
+		{
+			name:      "injected",
+			wantEvent: v1.EventTypeNormal + " " + eventReasonInjected + " Injected istio sidecar (version 12345678)",
+		},
+		{
+			name:      "patch failed",
+			patchErr:  errors.New("conflict"),
+			wantEvent: v1.EventTypeWarning + " " + eventReasonFailed + " Failed to inject istio sidecar: conflict",
+		},
+	}
+
+	for _, c := range cases {
+		recorder := record.NewFakeRecorder(10)
+		i := &Initializer{config: config, recorder: recorder}
+
+		obj := &v1beta1.Deployment{}
+		if err = yaml.Unmarshal(raw, obj); err != nil {
+			t.Fatalf("%v: Unmarshal(obj) failed: %v", c.name, err)
+		}
+		patcher := func(string, string, []byte, runtime.Object) error {
+			return c.patchErr
+		}
+
+		if err = i.initialize(obj, patcher); err != c.patchErr {
+			t.Errorf("%v: initialize() returned %v, want %v", c.name, err, c.patchErr)
+		}
+		select {
+		case got := <-recorder.Events:
+			if got != c.wantEvent {
+				t.Errorf("%v: got event %q, want %q", c.name, got, c.wantEvent)
+			}
+		default:
+			t.Errorf("%v: no event recorded", c.name)
+		}
+	}
+}
+
+func TestInitializeObjectFailedOnce(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := &Config{
+		Policy:            InjectionPolicyEnabled,
+		InitializerName:   DefaultInitializerName,
+		IncludeNamespaces: []string{v1.NamespaceAll},
+		Params: Params{
+			InitImage:       InitImageName(unitTestHub, unitTestTag, false),
+			ProxyImage:      ProxyImageName(unitTestHub, unitTestTag, false),
+			SidecarProxyUID: DefaultSidecarProxyUID,
+			Version:         "12345678",
+			Mesh:            &mesh,
+		},
+	}
+	raw, err := ioutil.ReadFile("testdata/required.yaml")
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	obj := &v1beta1.Deployment{}
+	if err = yaml.Unmarshal(raw, obj); err != nil {
+		t.Fatalf("Unmarshal(obj) failed: %v", err)
+	}
+	obj.ResourceVersion = "1"
+
+	i := &Initializer{config: config, recorder: record.NewFakeRecorder(10)}
+	patches := 0
+	patchErr := errors.New("conflict")
+	patcher := func(string, string, []byte, runtime.Object) error {
+		patches++
+		return patchErr
+	}
+	failures := func(entries []map[string]interface{}) int {
+		n := 0
+		for _, e := range entries {
+			if msg, _ := e["msg"].(string); strings.HasPrefix(msg, "Failed to initialize") {
+				n++
+			}
+		}
+		return n
+	}
+
+	entries := captureLogEntries(t, func() {
+		i.initializeObject(obj, patcher)
+		i.initializeObject(obj, patcher)
+	})
+	if got := failures(entries); got != 1 {
+		t.Errorf("same resourceVersion: got %d failure log entries, want 1: %v", got, entries)
+	}
+	if patches != 1 {
+		t.Errorf("same resourceVersion: patched %d times, want 1", patches)
+	}
+
+	obj.ResourceVersion = "2"
+	entries = captureLogEntries(t, func() {
+		i.initializeObject(obj, patcher)
+	})
+	if got := failures(entries); got != 1 {
+		t.Errorf("new resourceVersion: got %d failure log entries, want 1: %v", got, entries)
+	}
+	if patches != 2 {
+		t.Errorf("new resourceVersion: patched %d times, want 2", patches)
+	}
+
+	obj.ResourceVersion = "3"
+	patchErr = nil
+	i.initializeObject(obj, patcher)
+	if i.failed.contains(obj) {
+		t.Errorf("successful retry: object still recorded as failed")
+	}
+}
+
+func TestInitializeObserveOnly(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := &Config{
+		Policy:            InjectionPolicyEnabled,
+		IncludeNamespaces: []string{v1.NamespaceAll},
+		Params: Params{
+			InitImage:       InitImageName(unitTestHub, unitTestTag, false),
+			ProxyImage:      ProxyImageName(unitTestHub, unitTestTag, false),
+			SidecarProxyUID: DefaultSidecarProxyUID,
+			Version:         "12345678",
+			Mesh:            &mesh,
+		},
+		InitializerName: DefaultInitializerName,
+		ObserveOnly:     true,
+	}
+
+	raw, err := ioutil.ReadFile("testdata/required.yaml")
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	obj := &v1beta1.Deployment{}
+	if err = yaml.Unmarshal(raw, obj); err != nil {
+		t.Fatalf("Unmarshal(obj) failed: %v", err)
+	}
+	want := obj.DeepCopy()
+
+	recorder := record.NewFakeRecorder(10)
+	i := &Initializer{config: config, recorder: recorder}
+	patcher := func(namespace, name string, _ []byte, _ runtime.Object) error {
+		t.Errorf("patch issued for %s/%s in observe only mode", namespace, name)
+		return nil
+	}
+	if err = i.initialize(obj, patcher); err != nil {
+		t.Errorf("initialize() returned an error: %v", err)
+	}
+
+	select {
+	case got := <-recorder.Events:
+		wantEvent := v1.EventTypeNormal + " " + eventReasonObserved + " Would inject istio sidecar (version 12345678)"
+		if got != wantEvent {
+			t.Errorf("got event %q, want %q", got, wantEvent)
+		}
+	default:
+		t.Error("no event recorded")
+	}
+	if !reflect.DeepEqual(obj, want) {
+		t.Errorf("initialize() modified the object in observe only mode: got %#v, want %#v", obj, want)
+	}
+}
+
+func TestInitializeLogFields(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := &Config{
+		Policy:            InjectionPolicyDisabled,
+		IncludeNamespaces: []string{v1.NamespaceAll},
+		Params: Params{
+			InitImage:       InitImageName(unitTestHub, unitTestTag, false),
+			ProxyImage:      ProxyImageName(unitTestHub, unitTestTag, false),
+			SidecarProxyUID: DefaultSidecarProxyUID,
+			Version:         "12345678",
+			Mesh:            &mesh,
+		},
+		InitializerName: DefaultInitializerName,
+	}
+
+	raw, err := ioutil.ReadFile("testdata/required.yaml")
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	obj := &v1beta1.Deployment{}
+	if err = yaml.Unmarshal(raw, obj); err != nil {
+		t.Fatalf("Unmarshal(obj) failed: %v", err)
+	}
+
+	entries := captureLogEntries(t, func() {
+		i := &Initializer{config: config, recorder: record.NewFakeRecorder(10)}
+		patcher := func(string, string, []byte, runtime.Object) error { return nil }
+		if err := i.initialize(obj, patcher); err != nil {
+			t.Errorf("initialize() returned an error: %v", err)
+		}
+	})
+
+	want := map[string]map[string]interface{}{
+		"ObjectMeta initializer info": {
+			"namespace": "default",
+			"name":      "hello",
+			"kind":      "Deployment",
+		},
+		"Sidecar injection policy for default/hello": {
+			"namespace": "default",
+			"name":      "hello",
+			"policy":    string(InjectionPolicyDisabled),
+			"inject":    false,
+			"required":  false,
+		},
+		"Skipping default/hello due to policy check": {
+			"namespace": "default",
+			"name":      "hello",
+			"kind":      "Deployment",
+			"reason":    skipReasonPolicy,
+		},
+	}
+	for prefix, fields := range want {
+		var entry map[string]interface{}
+		for _, e := range entries {
+			if msg, _ := e["msg"].(string); strings.HasPrefix(msg, prefix) {
+				entry = e
+				break
+			}
+		}
+		if entry == nil {
+			t.Errorf("no log entry starting with %q in %v", prefix, entries)
+			continue
+		}
+		for key, value := range fields {
+			if got := entry[key]; got != value {
+				t.Errorf("%q: field %s is %v, want %v", prefix, key, got, value)
+			}
+		}
+	}
+}
+
+// captureLogEntries returns the JSON log entries emitted while running f.
+func captureLogEntries(t *testing.T, f func()) []map[string]interface{} {
+	tf, err := ioutil.TempFile("", "inject_log")
+	if err != nil {
+		t.Fatalf("TempFile() failed: %v", err)
+	}
+	_ = tf.Close()
+	defer func() { _ = os.Remove(tf.Name()) }()
+
+	o := log.NewOptions()
+	o.OutputPaths = []string{tf.Name()}
+	o.JSONEncoding = true
+	if err = log.Configure(o); err != nil {
+		t.Fatalf("Configure() failed: %v", err)
+	}
+	defer func() {
+		o := log.NewOptions()
+		_ = o.SetOutputLevel(log.NoneLevel)
+		_ = log.Configure(o)
+	}()
+
+	f()
+	_ = log.Sync()
+
+	content, err := ioutil.ReadFile(tf.Name())
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if line == "" {
+			continue
+		}
+		entry := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Unmarshal(%q) failed: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestInitializeMetrics(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	newConfig := func(policy InjectionPolicy) *Config {
+		return &Config{
+			Policy:            policy,
+			IncludeNamespaces: []string{v1.NamespaceAll},
+			Params: Params{
+				InitImage:       InitImageName(unitTestHub, unitTestTag, false),
+				ProxyImage:      ProxyImageName(unitTestHub, unitTestTag, false),
+				SidecarProxyUID: DefaultSidecarProxyUID,
+				Version:         "12345678",
+				Mesh:            &mesh,
+			},
+			InitializerName: DefaultInitializerName,
+		}
+	}
+
+	raw, err := ioutil.ReadFile("testdata/required.yaml")
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+
+	cases := []struct {
+		name     string
+		policy   InjectionPolicy
+		patchErr error
+		result   string
+	}{
+		{name: "injected", policy: InjectionPolicyEnabled, result: resultInjected},
+		{name: "skipped", policy: InjectionPolicyDisabled, result: resultSkipped},
+		{name: "failed", policy: InjectionPolicyEnabled, patchErr: errors.New("conflict"), result: resultFailed},
+	}
+
+	for _, c := range cases {
+		i := &Initializer{config: newConfig(c.policy), recorder: record.NewFakeRecorder(10)}
+		obj := &v1beta1.Deployment{}
+		if err = yaml.Unmarshal(raw, obj); err != nil {
+			t.Fatalf("%v: Unmarshal(obj) failed: %v", c.name, err)
+		}
+		patcher := func(string, string, []byte, runtime.Object) error {
+			return c.patchErr
+		}
+
+		before := counterValue(t, injectionCounter.WithLabelValues("Deployment", c.result))
+		samples := histogramCount(t, initializeDuration.WithLabelValues("Deployment").(prometheus.Metric))
+		if err = i.initialize(obj, patcher); err != c.patchErr {
+			t.Errorf("%v: initialize() returned %v, want %v", c.name, err, c.patchErr)
+		}
+		if got := counterValue(t, injectionCounter.WithLabelValues("Deployment", c.result)); got != before+1 {
+			t.Errorf("%v: %s injection counter is %v, want %v", c.name, c.result, got, before+1)
+		}
+		if got := histogramCount(t, initializeDuration.WithLabelValues("Deployment").(prometheus.Metric)); got != samples+1 {
+			t.Errorf("%v: initialize duration has %d samples, want %d", c.name, got, samples+1)
+		}
+	}
+}
+
+func TestListInjectedWorkloads(t *testing.T) {
+	injected := map[string]string{istioSidecarAnnotationStatusKey: injectedVersionPrefix + "12345678"}
+	other := map[string]string{"foo": "bar"}
+
+	cl := fakeClientset(legacyGroupVersions,
+		&v1beta1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "injected"},
+			Spec: v1beta1.DeploymentSpec{
+				Template: v1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Annotations: injected}},
+			},
+		},
+		&v1beta1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "plain"},
+			Spec: v1beta1.DeploymentSpec{
+				Template: v1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Annotations: other}},
+			},
+		},
+		&v1.ReplicationController{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "injected"},
+			Spec: v1.ReplicationControllerSpec{
+				Template: &v1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Annotations: injected}},
+			},
+		},
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "foo", Name: "plain"},
+		},
+		&v2alpha1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "bar", Name: "injected"},
+			Spec: v2alpha1.CronJobSpec{
+				JobTemplate: v2alpha1.JobTemplateSpec{ObjectMeta: metav1.ObjectMeta{Annotations: injected}},
+			},
+		},
+		// only the template annotation marks a workload as injected
+		&v1beta1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "bar", Name: "plain", Annotations: injected},
+		},
+	)
+
+	got, err := ListInjectedWorkloads(cl, &Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []InjectedWorkload{
+		{Namespace: "foo", Name: "injected", Kind: "ReplicationController", Version: "12345678"},
+		{Namespace: "default", Name: "injected", Kind: "Deployment", Version: "12345678"},
+		{Namespace: "bar", Name: "injected", Kind: "CronJob", Version: "12345678"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListInjectedWorkloads() => got %v, want %v", got, want)
+	}
+}
+
+func TestListInjectedWorkloadsCustomStatusKey(t *testing.T) {
+	deployment := func(name string, annotations map[string]string) *v1beta1.Deployment {
+		return &v1beta1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec: v1beta1.DeploymentSpec{
+				Template: v1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Annotations: annotations}},
+			},
+		}
+	}
+	cl := fakeClientset(legacyGroupVersions,
+		deployment("custom", map[string]string{"sidecar.example.com/status": injectedVersionPrefix + "12345678"}),
+		deployment("default", map[string]string{istioSidecarAnnotationStatusKey: injectedVersionPrefix + "0.3.0"}),
+	)
+
+	got, err := ListInjectedWorkloads(cl, &Config{StatusAnnotationKey: "sidecar.example.com/status"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []InjectedWorkload{{Namespace: "default", Name: "custom", Kind: "Deployment", Version: "12345678"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListInjectedWorkloads() => got %v, want %v", got, want)
+	}
+}
+
+func TestPendingObjects(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/required.yaml")
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	obj := &v1beta1.Deployment{}
+	if err = yaml.Unmarshal(raw, obj); err != nil {
+		t.Fatalf("Unmarshal(obj) failed: %v", err)
+	}
+	other := obj.DeepCopy()
+	other.Name = "other"
+	initialized := obj.DeepCopy()
+	initialized.Initializers = nil
+
+	var p pendingObjects
+	steps := []struct {
+		name    string
+		obj     interface{}
+		deleted bool
+		want    float64
+	}{
+		{name: "added", obj: obj, want: 1},
+		{name: "resynced", obj: obj, want: 1},
+		{name: "other added", obj: other, want: 2},
+		{name: "initialized", obj: initialized, want: 1},
+		{name: "other deleted", obj: other, deleted: true, want: 0},
+	}
+	for _, s := range steps {
+		p.update(DefaultInitializerName, "PendingTest", s.obj, s.deleted)
+		m := &dto.Metric{}
+		if err := pendingGauge.WithLabelValues("PendingTest").Write(m); err != nil {
+			t.Fatalf("%v: Write() failed: %v", s.name, err)
+		}
+		if got := m.GetGauge().GetValue(); got != s.want {
+			t.Errorf("%v: pending gauge is %v, want %v", s.name, got, s.want)
+		}
+	}
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	if err := c.Write(m); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func histogramCount(t *testing.T, h prometheus.Metric) uint64 {
+	m := &dto.Metric{}
+	if err := h.Write(m); err != nil {
+		t.Fatalf("Write() failed: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
@@ -22,35 +22,82 @@ package inject
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
+	"unicode"
 
 	"github.com/ghodss/yaml"
+	"go.uber.org/zap"
 	// TODO(nmittler): Remove this
 	_ "github.com/golang/glog"
 	"k8s.io/api/batch/v2alpha1"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
 	yamlDecoder "k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/kubernetes"
 
 	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pilot/model"
 	"istio.io/istio/pkg/log"
+	"istio.io/istio/pkg/meshconfigmap"
 	"istio.io/istio/pkg/version"
 )
 
 // per-sidecar policy and status (deployment, job, statefulset, pod, etc)
+// default annotation keys, see Config.PolicyAnnotationKey and Config.StatusAnnotationKey
 const (
 	istioSidecarAnnotationPolicyKey = "sidecar.istio.io/inject"
 	istioSidecarAnnotationStatusKey = "sidecar.istio.io/status"
+
+	// istioSidecarAnnotationInitFirstKey controls whether the istio init
+	// containers run before (default) or after the user init containers.
+	istioSidecarAnnotationInitFirstKey = "sidecar.istio.io/initFirst"
+
+	// istioSidecarAnnotationProxyEnvKey holds a JSON object of extra
+	// environment variables set on the proxy container.
+	istioSidecarAnnotationProxyEnvKey = "sidecar.istio.io/proxyEnv"
+
+	// istioSidecarAnnotationReadinessProbeKey controls whether the proxy
+	// container gets a readiness probe, so that the pod only becomes
+	// ready once the proxy has received its listeners.
+	istioSidecarAnnotationReadinessProbeKey = "sidecar.istio.io/readinessProbe"
+
+	// istioSidecarAnnotationProxyConcurrencyKey overrides the number of
+	// envoy worker threads of the proxy container.
+	istioSidecarAnnotationProxyConcurrencyKey = "sidecar.istio.io/proxyConcurrency"
+
+	// istioSidecarAnnotationDNSNameserversKey and
+	// istioSidecarAnnotationDNSSearchesKey hold comma separated lists of
+	// nameservers and search domains added to the pod DNS config,
+	// overriding Params.DNSNameservers and Params.DNSSearches.
+	istioSidecarAnnotationDNSNameserversKey = "sidecar.istio.io/dnsNameservers"
+	istioSidecarAnnotationDNSSearchesKey    = "sidecar.istio.io/dnsSearches"
+
+	// istioSidecarAnnotationExcludeInboundPortsKey holds a comma separated
+	// list of inbound ports that are not redirected to the proxy,
+	// overriding Params.ExcludeInboundPorts.
+	istioSidecarAnnotationExcludeInboundPortsKey = "traffic.sidecar.istio.io/excludeInboundPorts"
+
+	// istioSidecarAnnotationPreStopDrainSecondsKey overrides the number
+	// of seconds the proxy drains before exiting, see
+	// Params.PreStopDrainSeconds.
+	istioSidecarAnnotationPreStopDrainSecondsKey = "sidecar.istio.io/preStopDrainSeconds"
 )
 
 // InjectionPolicy determines the policy for injecting the
@@ -72,6 +119,12 @@ const (
 	// false.
 	InjectionPolicyEnabled InjectionPolicy = "enabled"
 
+	// InjectionPolicyOff specifies that the initializer will never
+	// inject the sidecar into resources for the namespace(s) being
+	// watched, regardless of the "sidecar.istio.io/inject"
+	// annotation.
+	InjectionPolicyOff InjectionPolicy = "off"
+
 	// DefaultInjectionPolicy is the default injection policy.
 	DefaultInjectionPolicy = InjectionPolicyEnabled
 )
@@ -82,6 +135,11 @@ const (
 	DefaultSidecarProxyUID = int64(1337)
 	DefaultVerbosity       = 2
 	DefaultImagePullPolicy = "IfNotPresent"
+
+	// DefaultPreStopDrainSeconds leaves in-flight requests of typical
+	// services time to complete, well within the default termination
+	// grace period of 30 seconds.
+	DefaultPreStopDrainSeconds = 5
 )
 
 const (
@@ -91,12 +149,27 @@ const (
 	// ProxyContainerName is the name for sidecar proxy container
 	ProxyContainerName = "istio-proxy"
 
+	// names of the other containers and volumes in the sidecar template
+	enableCoreDumpContainerName = "enable-core-dump"
+	envoyVolumeName             = "istio-envoy"
+	certsVolumeName             = "istio-certs"
+
+	// injectedVersionPrefix prefixes the sidecar version in the status annotation
+	injectedVersionPrefix = "injected-version-"
+
+	// readiness probe timing of the proxy container
+	readinessProbeInitialDelaySeconds = 1
+	readinessProbePeriodSeconds       = 2
+
 	// ConfigMapKey should match the expected MeshConfig file name
-	ConfigMapKey = "mesh"
+	ConfigMapKey = meshconfigmap.MeshKey
 
 	// InitializerConfigMapKey is the key into the initailizer ConfigMap data.
 	InitializerConfigMapKey = "config"
 
+	// DefaultInitializerConfigMapName is the name of the initializer ConfigMap.
+	DefaultInitializerConfigMapName = "istio-inject"
+
 	// DefaultResyncPeriod specifies how frequently to retrieve the
 	// full list of watched resources for initialization.
 	DefaultResyncPeriod = 30 * time.Second
@@ -113,6 +186,59 @@ type SidecarConfig struct {
 	Volumes        []v1.Volume    `yaml:"volumes"`
 }
 
+// Merge returns the containers and volumes of base and overlay. An
+// overlay container or volume replaces the one of the same name in
+// base, and the others are appended after those of base.
+func (base SidecarConfig) Merge(overlay SidecarConfig) SidecarConfig {
+	return SidecarConfig{
+		InitContainers: mergeContainers(base.InitContainers, overlay.InitContainers),
+		Containers:     mergeContainers(base.Containers, overlay.Containers),
+		Volumes:        mergeVolumes(base.Volumes, overlay.Volumes),
+	}
+}
+
+func mergeContainers(base, overlay []v1.Container) []v1.Container {
+	if len(base) == 0 && len(overlay) == 0 {
+		return nil
+	}
+	out := make([]v1.Container, 0, len(base)+len(overlay))
+	index := make(map[string]int, len(base))
+	for _, c := range base {
+		index[c.Name] = len(out)
+		out = append(out, c)
+	}
+	for _, c := range overlay {
+		if i, ok := index[c.Name]; ok {
+			out[i] = c
+			continue
+		}
+		index[c.Name] = len(out)
+		out = append(out, c)
+	}
+	return out
+}
+
+func mergeVolumes(base, overlay []v1.Volume) []v1.Volume {
+	if len(base) == 0 && len(overlay) == 0 {
+		return nil
+	}
+	out := make([]v1.Volume, 0, len(base)+len(overlay))
+	index := make(map[string]int, len(base))
+	for _, v := range base {
+		index[v.Name] = len(out)
+		out = append(out, v)
+	}
+	for _, v := range overlay {
+		if i, ok := index[v.Name]; ok {
+			out[i] = v
+			continue
+		}
+		index[v.Name] = len(out)
+		out = append(out, v)
+	}
+	return out
+}
+
 // SidecarTemplate contains configurable settings for the sidecar mesh
 // in a format necessary for proper template interpolation
 type SidecarTemplate struct {
@@ -120,6 +246,9 @@ type SidecarTemplate struct {
 	ServiceCluster string
 	MConfig        *Params
 	AuthPolicy     string
+	Concurrency    int
+	// Comma separated list of inbound ports not redirected to the proxy
+	ExcludeInboundPorts string
 }
 
 // InitImageName returns the fully qualified image name for the istio
@@ -137,6 +266,17 @@ func ProxyImageName(hub string, tag string, debug bool) string {
 	return hub + "/proxy:" + tag
 }
 
+// imageReference resolves an image configured in Params. An image with a
+// repository path or digest, such as "myrepo/istio/proxyv2@sha256:...",
+// is a complete reference and used as is. A bare name such as "proxyv2" is
+// qualified with the docker hub and tag.
+func imageReference(image, hub, tag string) string {
+	if strings.ContainsAny(image, "/@") {
+		return image
+	}
+	return hub + "/" + image + ":" + tag
+}
+
 // Params describes configurable parameters for injecting istio proxy
 // into kubernetes resource.
 type Params struct {
@@ -153,6 +293,34 @@ type Params struct {
 	// redirect outbound traffic to Envoy for these IP
 	// ranges. Otherwise all outbound traffic is redirected to Envoy.
 	IncludeIPRanges string `json:"includeIPRanges"`
+	// Names of the secrets used to pull the proxy and init images
+	// from a private registry.
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+	// Number of envoy worker threads. Zero leaves it to the proxy,
+	// which uses one per core.
+	Concurrency int `json:"concurrency,omitempty"`
+	// Template of additional init containers, containers and volumes,
+	// rendered like the sidecar template and merged into it, e.g. to
+	// add a logging sidecar. Entries replace those of the same name.
+	OverlayTemplate string `json:"overlayTemplate,omitempty"`
+	// Nameservers and search domains added to the DNS config of the
+	// pod, e.g. so that the proxy can resolve hosts of egress rules.
+	// The pod DNS config is left untouched if both are empty.
+	DNSNameservers []string `json:"dnsNameservers,omitempty"`
+	DNSSearches    []string `json:"dnsSearches,omitempty"`
+	// Inbound ports that are not redirected to the proxy, e.g. of
+	// infrastructure agents that must not be meshed.
+	ExcludeInboundPorts []int32 `json:"excludeInboundPorts,omitempty"`
+	// Labels and annotations added to the pod template of injected
+	// objects, e.g. mesh=istio. Keys already set are left unchanged.
+	PodLabels      map[string]string `json:"podLabels,omitempty"`
+	PodAnnotations map[string]string `json:"podAnnotations,omitempty"`
+	// Seconds the proxy keeps serving in-flight requests on pod
+	// termination. A preStop hook fails the envoy health check, so
+	// that no new requests are sent to it, and sleeps this long
+	// before the proxy is stopped. Zero or unset disables the hook;
+	// GetInitializerConfig defaults it to DefaultPreStopDrainSeconds.
+	PreStopDrainSeconds *int `json:"preStopDrainSeconds,omitempty"`
 }
 
 // Config specifies the initializer configuration for sidecar
@@ -168,24 +336,238 @@ type Config struct {
 	// deprecate if InitializerConfiguration becomes namespace aware
 	ExcludeNamespaces []string `json:"excludeNamespaces"`
 
+	// ExcludeSelectors skips injection of objects whose labels match
+	// any of the selectors, e.g. istio-injection=skip
+	ExcludeSelectors []metav1.LabelSelector `json:"excludeSelectors"`
+
 	// Params specifies the parameters of the injected sidcar template
 	Params Params `json:"params"`
 
 	// InitializerName specifies the name of the initializer.
 	InitializerName string `json:"initializerName"`
+
+	// PolicyAnnotationKey is the per-object annotation that overrides
+	// the injection policy. Defaults to "sidecar.istio.io/inject".
+	PolicyAnnotationKey string `json:"policyAnnotationKey"`
+
+	// StatusAnnotationKey is the annotation recording the injected
+	// sidecar version. Defaults to "sidecar.istio.io/status".
+	StatusAnnotationKey string `json:"statusAnnotationKey"`
+
+	// ObserveOnly makes the initializer log and record an event for
+	// the injection it would make instead of patching resources, e.g.
+	// to validate the namespace and policy configuration of a new
+	// cluster. Resources pending the initializer are neither injected
+	// nor initialized in this mode, so they stay uninitialized and
+	// their pods are not created until the initializer is removed from
+	// the InitializerConfiguration or ObserveOnly is turned off.
+	ObserveOnly bool `json:"observeOnly,omitempty"`
+
+	// EnabledKinds restricts injection to the named kinds, e.g.
+	// Deployment. Objects of other kinds are neither watched by the
+	// initializer nor injected into resource files. All supported
+	// kinds are injected if empty.
+	EnabledKinds []string `json:"enabledKinds,omitempty"`
+}
+
+func (c *Config) policyAnnotationKey() string {
+	if c.PolicyAnnotationKey == "" {
+		return istioSidecarAnnotationPolicyKey
+	}
+	return c.PolicyAnnotationKey
+}
+
+func (c *Config) statusAnnotationKey() string {
+	if c.StatusAnnotationKey == "" {
+		return istioSidecarAnnotationStatusKey
+	}
+	return c.StatusAnnotationKey
+}
+
+// kindEnabled returns whether objects of the kind are injected.
+func (c *Config) kindEnabled(kind string) bool {
+	if len(c.EnabledKinds) == 0 {
+		return true
+	}
+	for _, enabled := range c.EnabledKinds {
+		if enabled == kind {
+			return true
+		}
+	}
+	return false
 }
 
-// GetInitializerConfig fetches the initializer configuration from a Kubernetes ConfigMap.
-func GetInitializerConfig(kube kubernetes.Interface, namespace, injectConfigName string) (*Config, error) {
-	var configMap *v1.ConfigMap
-	var err error
-	if errPoll := wait.Poll(500*time.Millisecond, 60*time.Second, func() (bool, error) {
-		if configMap, err = kube.CoreV1().ConfigMaps(namespace).Get(injectConfigName, metav1.GetOptions{}); err != nil {
-			return false, err
+// validate checks the settings of an initializer configuration that
+// cannot be defaulted.
+func (c *Config) validate() error {
+	if c.IncludeNamespaces != nil && c.ExcludeNamespaces != nil {
+		return fmt.Errorf("cannot configure both namespaces and excludeNamespaces")
+	}
+
+	for _, excludeNamespace := range c.ExcludeNamespaces {
+		if excludeNamespace == v1.NamespaceAll {
+			return fmt.Errorf("cannot configure ExcludeNamespaces as NamespaceAll")
+		}
+	}
+
+	for i := range c.ExcludeSelectors {
+		if _, err := metav1.LabelSelectorAsSelector(&c.ExcludeSelectors[i]); err != nil {
+			return fmt.Errorf("invalid excludeSelectors[%d]: %v", i, err)
 		}
-		return true, nil
-	}); errPoll != nil {
-		return nil, errPoll
+	}
+
+	for _, secret := range c.Params.ImagePullSecrets {
+		if secret == "" {
+			return fmt.Errorf("imagePullSecrets cannot contain an empty secret name")
+		}
+	}
+
+	if c.Params.Concurrency < 0 {
+		return fmt.Errorf("concurrency cannot be negative: %d", c.Params.Concurrency)
+	}
+
+	if seconds := c.Params.PreStopDrainSeconds; seconds != nil && *seconds < 0 {
+		return fmt.Errorf("preStopDrainSeconds cannot be negative: %d", *seconds)
+	}
+
+	for _, nameserver := range c.Params.DNSNameservers {
+		if net.ParseIP(nameserver) == nil {
+			return fmt.Errorf("dnsNameservers must be IP addresses: %q", nameserver)
+		}
+	}
+
+	for _, port := range c.Params.ExcludeInboundPorts {
+		if err := validatePort(port); err != nil {
+			return fmt.Errorf("invalid excludeInboundPorts: %v", err)
+		}
+	}
+
+	for _, search := range c.Params.DNSSearches {
+		if search == "" {
+			return fmt.Errorf("dnsSearches cannot contain an empty domain")
+		}
+	}
+
+	for key, value := range c.Params.PodLabels {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid podLabels key %q: %s", key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return fmt.Errorf("invalid podLabels value %q: %s", value, strings.Join(errs, "; "))
+		}
+	}
+
+	for key := range c.Params.PodAnnotations {
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid podAnnotations key %q: %s", key, strings.Join(errs, "; "))
+		}
+	}
+
+	for _, kind := range c.EnabledKinds {
+		if !supportedKind(kind) {
+			return fmt.Errorf("unsupported kind in enabledKinds: %q", kind)
+		}
+	}
+
+	if c.Params.OverlayTemplate != "" {
+		if err := ValidateSidecarTemplate(c.Params.OverlayTemplate); err != nil {
+			return fmt.Errorf("invalid overlayTemplate: %v", err)
+		}
+	}
+	return nil
+}
+
+// ValidateSidecarTemplate checks that a sidecar template parses, executes
+// for a representative pod with the default parameters and mesh config,
+// and renders init containers, containers or volumes.
+func ValidateSidecarTemplate(tmpl string) error {
+	t, err := template.New("sidecar").Parse(tmpl)
+	if err != nil {
+		return err
+	}
+
+	mesh := model.DefaultMeshConfig()
+	p := DefaultConfig(version.Info.Version, version.Info.DockerHub, version.Info.Version).Params
+	p.Mesh = &mesh
+	spec := &v1.PodSpec{Containers: []v1.Container{{
+		Name:  "app",
+		Image: "app",
+		Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 80}},
+	}}}
+	metadata := &metav1.ObjectMeta{Name: "app", Namespace: v1.NamespaceDefault, Labels: map[string]string{"app": "app"}}
+	st, err := resolveSidecarTemplate(&p, spec, metadata)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	if err := t.Execute(&out, &st); err != nil {
+		return err
+	}
+	var sc SidecarConfig
+	if err := yaml.Unmarshal(out.Bytes(), &sc); err != nil {
+		return fmt.Errorf("rendered template is not a sidecar config: %v", err)
+	}
+	if len(sc.InitContainers) == 0 && len(sc.Containers) == 0 && len(sc.Volumes) == 0 {
+		return errors.New("rendered template has no init containers, containers or volumes")
+	}
+	return nil
+}
+
+// DefaultConfig returns the default initializer configuration for the
+// given sidecar version and docker hub and tag.
+func DefaultConfig(version, hub, tag string) *Config {
+	drainSeconds := DefaultPreStopDrainSeconds
+	return &Config{
+		Policy:            DefaultInjectionPolicy,
+		IncludeNamespaces: []string{v1.NamespaceAll},
+		Params: Params{
+			InitImage:           InitImageName(hub, tag, false),
+			ProxyImage:          ProxyImageName(hub, tag, false),
+			Verbosity:           DefaultVerbosity,
+			SidecarProxyUID:     DefaultSidecarProxyUID,
+			Version:             version,
+			ImagePullPolicy:     DefaultImagePullPolicy,
+			PreStopDrainSeconds: &drainSeconds,
+		},
+		InitializerName:     DefaultInitializerName,
+		PolicyAnnotationKey: istioSidecarAnnotationPolicyKey,
+		StatusAnnotationKey: istioSidecarAnnotationStatusKey,
+	}
+}
+
+// InitializerConfigMap returns the ConfigMap holding the initializer
+// configuration, as read by GetInitializerConfig.
+func InitializerConfigMap(c *Config, namespace, name string) (*v1.ConfigMap, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return &v1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Data: map[string]string{
+			InitializerConfigMapKey: string(data),
+		},
+	}, nil
+}
+
+// GetInitializerConfig fetches the initializer configuration from a Kubernetes ConfigMap,
+// retrying failed requests per the policy.
+func GetInitializerConfig(kube kubernetes.Interface, namespace, injectConfigName string,
+	retry RetryPolicy) (*Config, error) {
+	configMap, err := meshconfigmap.Get(kube, namespace, injectConfigName, retry)
+	if err != nil {
+		return nil, err
 	}
 	data, exists := configMap.Data[InitializerConfigMapKey]
 	if !exists {
@@ -196,32 +578,29 @@ func GetInitializerConfig(kube kubernetes.Interface, namespace, injectConfigName
 	if err := yaml.Unmarshal([]byte(data), &c); err != nil {
 		return nil, err
 	}
-
-	if c.IncludeNamespaces != nil && c.ExcludeNamespaces != nil {
-		return nil, fmt.Errorf("cannot configure both namespaces and excludeNamespaces")
+	if err := c.validate(); err != nil {
+		return nil, err
 	}
 
 	if c.IncludeNamespaces == nil {
 		c.IncludeNamespaces = []string{v1.NamespaceAll}
 	}
 
-	for _, excludeNamespace := range c.ExcludeNamespaces {
-		if excludeNamespace == v1.NamespaceAll {
-			return nil, fmt.Errorf("cannot configure ExcludeNamespaces as NamespaceAll")
-		}
-	}
-
 	// apply safe defaults if not specified
 	switch c.Policy {
-	case InjectionPolicyDisabled, InjectionPolicyEnabled:
+	case InjectionPolicyDisabled, InjectionPolicyEnabled, InjectionPolicyOff:
 	default:
 		c.Policy = DefaultInjectionPolicy
 	}
 	if c.Params.InitImage == "" {
 		c.Params.InitImage = InitImageName(version.Info.DockerHub, version.Info.Version, c.Params.DebugMode)
+	} else {
+		c.Params.InitImage = imageReference(c.Params.InitImage, version.Info.DockerHub, version.Info.Version)
 	}
 	if c.Params.ProxyImage == "" {
 		c.Params.ProxyImage = ProxyImageName(version.Info.DockerHub, version.Info.Version, c.Params.DebugMode)
+	} else {
+		c.Params.ProxyImage = imageReference(c.Params.ProxyImage, version.Info.DockerHub, version.Info.Version)
 	}
 	if c.Params.SidecarProxyUID == 0 {
 		c.Params.SidecarProxyUID = DefaultSidecarProxyUID
@@ -229,25 +608,63 @@ func GetInitializerConfig(kube kubernetes.Interface, namespace, injectConfigName
 	if c.Params.ImagePullPolicy == "" {
 		c.Params.ImagePullPolicy = DefaultImagePullPolicy
 	}
+	if c.Params.PreStopDrainSeconds == nil {
+		drainSeconds := DefaultPreStopDrainSeconds
+		c.Params.PreStopDrainSeconds = &drainSeconds
+	}
 	if c.InitializerName == "" {
 		c.InitializerName = DefaultInitializerName
 	}
+	if c.PolicyAnnotationKey == "" {
+		c.PolicyAnnotationKey = istioSidecarAnnotationPolicyKey
+	}
+	if c.StatusAnnotationKey == "" {
+		c.StatusAnnotationKey = istioSidecarAnnotationStatusKey
+	}
 
 	return &c, nil
 }
 
-func injectRequired(include, ignored, excluded []string, namespacePolicy InjectionPolicy, obj metav1.Object) bool {
+// Reasons reported in InjectionSkip
+const (
+	skipReasonIgnoredNamespace     = "ignored namespace"
+	skipReasonExcludedNamespace    = "excluded namespace"
+	skipReasonNamespaceNotIncluded = "namespace not included"
+	skipReasonExcludedLabels       = "excluded by label selector"
+	skipReasonPolicy               = "injection not required by policy"
+	skipReasonAlreadyInjected      = "already injected"
+	skipReasonHostNetwork          = "host networking enabled"
+	skipReasonUnsupportedKind      = "unsupported kind"
+	skipReasonKindNotEnabled       = "kind not enabled"
+)
+
+// InjectionSkip describes an object that was left unchanged by injection and why.
+type InjectionSkip struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Reason    string
+}
+
+func injectRequired(ignored []string, c *Config, obj metav1.Object) bool {
+	return skipReason(ignored, c, obj) == ""
+}
+
+// skipReason returns the reason an object must not be injected, or an empty string if injection is required.
+func skipReason(ignored []string, c *Config, obj metav1.Object) string {
+	include, excluded, namespacePolicy := c.IncludeNamespaces, c.ExcludeNamespaces, c.Policy
+
 	// skip special kubernetes system namespaces
 	for _, namespace := range ignored {
 		if obj.GetNamespace() == namespace {
-			return false
+			return skipReasonIgnoredNamespace
 		}
 	}
 
 	// skip customized exclude namespaces
 	for _, excludeNamespace := range excluded {
 		if obj.GetNamespace() == excludeNamespace {
-			return false
+			return skipReasonExcludedNamespace
 		}
 	}
 
@@ -266,7 +683,19 @@ IncludeNamespaceSearch:
 		// else, keep searching
 	}
 	if !included {
-		return false
+		return skipReasonNamespaceNotIncluded
+	}
+
+	// skip objects matching an exclude selector
+	for i := range c.ExcludeSelectors {
+		selector, err := metav1.LabelSelectorAsSelector(&c.ExcludeSelectors[i])
+		if err != nil {
+			log.Warnf("Ignoring invalid exclude selector %v: %v", c.ExcludeSelectors[i], err)
+			continue
+		}
+		if selector.Matches(labels.Set(obj.GetLabels())) {
+			return skipReasonExcludedLabels
+		}
 	}
 
 	var useDefault bool
@@ -276,7 +705,7 @@ IncludeNamespaceSearch:
 	if annotations == nil {
 		useDefault = true
 	} else {
-		if value, ok := annotations[istioSidecarAnnotationPolicyKey]; !ok {
+		if value, ok := annotations[c.policyAnnotationKey()]; !ok {
 			useDefault = true
 		} else {
 			// http://yaml.org/type/bool.html
@@ -291,6 +720,7 @@ IncludeNamespaceSearch:
 
 	switch namespacePolicy {
 	default: // InjectionPolicyOff
+		// hard off-switch, annotations cannot enable injection
 		required = false
 	case InjectionPolicyDisabled:
 		if useDefault {
@@ -306,33 +736,359 @@ IncludeNamespaceSearch:
 		}
 	}
 
-	status, ok := annotations[istioSidecarAnnotationStatusKey]
+	status, ok := annotations[c.statusAnnotationKey()]
 
-	log.Infof("Sidecar injection policy for %v/%v: namespacePolicy:%v useDefault:%v inject:%v status:%q required:%v",
-		obj.GetNamespace(), obj.GetName(), namespacePolicy, useDefault, inject, status, required)
+	log.Info(fmt.Sprintf("Sidecar injection policy for %v/%v", obj.GetNamespace(), obj.GetName()),
+		zap.String("namespace", obj.GetNamespace()),
+		zap.String("name", obj.GetName()),
+		zap.String("policy", string(namespacePolicy)),
+		zap.Bool("useDefault", useDefault),
+		zap.Bool("inject", inject),
+		zap.String("status", status),
+		zap.Bool("required", required))
 
 	if !required {
+		return skipReasonPolicy
+	}
+
+	// re-inject sidecars older than the configured version
+	if ok && !sidecarOutdated(status, c.Params.Version) {
+		return skipReasonAlreadyInjected
+	}
+	return ""
+}
+
+// sidecarOutdated returns whether a status annotation records a sidecar
+// older than version. Dotted numeric versions (e.g. 0.4.0) are compared
+// numerically; other versions (e.g. build SHAs) cannot be ordered, so
+// any difference is treated as outdated.
+func sidecarOutdated(status, version string) bool {
+	if !strings.HasPrefix(status, injectedVersionPrefix) || version == "" {
+		return false
+	}
+	injected := strings.TrimPrefix(status, injectedVersionPrefix)
+	if injected == version {
 		return false
 	}
+	a, errA := parseVersion(injected)
+	b, errB := parseVersion(version)
+	if errA != nil || errB != nil {
+		return true
+	}
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	return false
+}
+
+func parseVersion(version string) ([]int, error) {
+	var out []int
+	for _, part := range strings.Split(version, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+// removeSidecar removes previously injected containers and volumes from
+// a pod spec so that the sidecar can be re-injected.
+func removeSidecar(spec *v1.PodSpec) {
+	initContainers := spec.InitContainers[:0]
+	for _, c := range spec.InitContainers {
+		if c.Name != InitContainerName && c.Name != enableCoreDumpContainerName {
+			initContainers = append(initContainers, c)
+		}
+	}
+	spec.InitContainers = initContainers
 
-	// TODO - add version check for sidecar upgrade
+	containers := spec.Containers[:0]
+	for _, c := range spec.Containers {
+		if c.Name != ProxyContainerName {
+			containers = append(containers, c)
+		}
+	}
+	spec.Containers = containers
 
-	return !ok
+	volumes := spec.Volumes[:0]
+	for _, v := range spec.Volumes {
+		if v.Name != envoyVolumeName && v.Name != certsVolumeName {
+			volumes = append(volumes, v)
+		}
+	}
+	spec.Volumes = volumes
 }
 
-func injectIntoSpec(p *Params, spec *v1.PodSpec, metadata *metav1.ObjectMeta) {
+// initFirst returns whether the istio init containers should be placed
+// before the user init containers, as set by the initFirst annotation
+// on the object or its pod template.
+//
+// Init containers run sequentially, and the istio-init iptables rules
+// redirect all outbound traffic of the pod to the sidecar proxy, which
+// is not running yet while init containers execute. User init
+// containers that need network access (e.g. to fetch config) must
+// therefore run before istio-init, whereas init containers whose
+// traffic must be captured by the mesh (or that expect the redirect
+// to be in place) must run after it.
+func initFirst(metas ...*metav1.ObjectMeta) bool {
+	return annotationEnabled(istioSidecarAnnotationInitFirstKey, metas...)
+}
 
-	st := SidecarTemplate{spec, p.Mesh.DefaultConfig.ServiceCluster, p, p.Mesh.DefaultConfig.ControlPlaneAuthPolicy.String()}
+// annotationEnabled returns the boolean value of the first annotation
+// key found on metas, defaulting to true.
+func annotationEnabled(key string, metas ...*metav1.ObjectMeta) bool {
+	for _, m := range metas {
+		if value, ok := m.GetAnnotations()[key]; ok {
+			// http://yaml.org/type/bool.html
+			switch strings.ToLower(value) {
+			case "n", "no", "false", "off":
+				return false
+			}
+			return true
+		}
+	}
+	return true
+}
+
+// addReadinessProbe makes the proxy container ready only once envoy
+// binds the virtual listener receiving the iptables redirect, which it
+// does after fetching its listeners from pilot. Without it, pods become
+// ready before the proxy can route their traffic.
+//
+// TODO also add a pod readiness gate on an istio condition once
+// k8s.io/api is updated to 1.11, which introduces PodSpec.ReadinessGates.
+func addReadinessProbe(proxy *v1.Container, mesh *meshconfig.MeshConfig) {
+	if mesh.ProxyListenPort <= 0 {
+		return
+	}
+	proxy.ReadinessProbe = &v1.Probe{
+		Handler: v1.Handler{
+			TCPSocket: &v1.TCPSocketAction{
+				Port: intstr.FromInt(int(mesh.ProxyListenPort)),
+			},
+		},
+		InitialDelaySeconds: readinessProbeInitialDelaySeconds,
+		PeriodSeconds:       readinessProbePeriodSeconds,
+	}
+}
+
+// proxyConcurrency returns the number of envoy worker threads, from the
+// proxyConcurrency annotation if it is valid and the Params otherwise.
+func proxyConcurrency(p *Params, metadata *metav1.ObjectMeta) int {
+	value, ok := metadata.GetAnnotations()[istioSidecarAnnotationProxyConcurrencyKey]
+	if !ok {
+		return p.Concurrency
+	}
+	concurrency, err := strconv.Atoi(value)
+	if err != nil || concurrency < 0 {
+		log.Warnf("Ignoring annotation %s=%q: not a non-negative integer", istioSidecarAnnotationProxyConcurrencyKey, value)
+		return p.Concurrency
+	}
+	return concurrency
+}
+
+// preStopDrainSeconds returns the number of seconds the proxy drains,
+// from the preStopDrainSeconds annotation if it is valid and the Params
+// otherwise.
+func preStopDrainSeconds(p *Params, metadata *metav1.ObjectMeta) int {
+	defaultSeconds := 0
+	if p.PreStopDrainSeconds != nil {
+		defaultSeconds = *p.PreStopDrainSeconds
+	}
+	value, ok := metadata.GetAnnotations()[istioSidecarAnnotationPreStopDrainSecondsKey]
+	if !ok {
+		return defaultSeconds
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		log.Warnf("Ignoring annotation %s=%q: not a non-negative integer", istioSidecarAnnotationPreStopDrainSecondsKey, value)
+		return defaultSeconds
+	}
+	return seconds
+}
+
+// addPreStopHook makes the proxy fail the envoy health check and wait
+// for in-flight requests before kubernetes stops it. A preStop hook set
+// by the template is left unchanged.
+func addPreStopHook(proxy *v1.Container, mesh *meshconfig.MeshConfig, seconds int) {
+	if seconds <= 0 || (proxy.Lifecycle != nil && proxy.Lifecycle.PreStop != nil) {
+		return
+	}
+	drain := fmt.Sprintf("sleep %d", seconds)
+	if mesh.DefaultConfig != nil && mesh.DefaultConfig.ProxyAdminPort > 0 {
+		drain = fmt.Sprintf("curl -s -X POST http://127.0.0.1:%d/healthcheck/fail; %s",
+			mesh.DefaultConfig.ProxyAdminPort, drain)
+	}
+	if proxy.Lifecycle == nil {
+		proxy.Lifecycle = &v1.Lifecycle{}
+	}
+	proxy.Lifecycle.PreStop = &v1.Handler{
+		Exec: &v1.ExecAction{Command: []string{"/bin/sh", "-c", drain}},
+	}
+}
+
+// addProxyEnv appends the environment variables from the proxyEnv
+// annotation to the proxy container. Variables already set by the
+// template are reserved and cannot be overridden.
+func addProxyEnv(proxy *v1.Container, metadata *metav1.ObjectMeta) {
+	value, ok := metadata.GetAnnotations()[istioSidecarAnnotationProxyEnvKey]
+	if !ok {
+		return
+	}
+	env := make(map[string]string)
+	if err := json.Unmarshal([]byte(value), &env); err != nil {
+		log.Warnf("Ignoring annotation %s=%q: %v", istioSidecarAnnotationProxyEnvKey, value, err)
+		return
+	}
+
+	reserved := make(map[string]bool, len(proxy.Env))
+	for _, e := range proxy.Env {
+		reserved[e.Name] = true
+	}
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if reserved[name] {
+			log.Warnf("Ignoring reserved proxy environment variable %s in annotation %s",
+				name, istioSidecarAnnotationProxyEnvKey)
+			continue
+		}
+		proxy.Env = append(proxy.Env, v1.EnvVar{Name: name, Value: env[name]})
+	}
+}
+
+func validatePort(port int32) error {
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("port %d is not in the range 1-65535", port)
+	}
+	return nil
+}
+
+// excludeInboundPorts returns the comma separated inbound ports not
+// redirected to the proxy, from the excludeInboundPorts annotation if
+// it is valid and the Params otherwise.
+func excludeInboundPorts(p *Params, metadata *metav1.ObjectMeta) string {
+	ports := make([]string, 0, len(p.ExcludeInboundPorts))
+	for _, port := range p.ExcludeInboundPorts {
+		ports = append(ports, strconv.Itoa(int(port)))
+	}
+
+	value, ok := metadata.GetAnnotations()[istioSidecarAnnotationExcludeInboundPortsKey]
+	if !ok {
+		return strings.Join(ports, ",")
+	}
+	var annotated []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		port, err := strconv.ParseInt(item, 10, 32)
+		if err == nil {
+			err = validatePort(int32(port))
+		}
+		if err != nil {
+			log.Warnf("Ignoring annotation %s=%q: %v", istioSidecarAnnotationExcludeInboundPortsKey, value, err)
+			return strings.Join(ports, ",")
+		}
+		annotated = append(annotated, strconv.Itoa(int(port)))
+	}
+	return strings.Join(annotated, ",")
+}
+
+// dnsAnnotation returns the comma separated list held by an annotation,
+// or def if the annotation is not set.
+func dnsAnnotation(key string, def []string, metadata *metav1.ObjectMeta) []string {
+	value, ok := metadata.GetAnnotations()[key]
+	if !ok {
+		return def
+	}
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// addDNSConfig adds the configured nameservers and search domains to
+// the pod DNS config, skipping those already present.
+func addDNSConfig(spec *v1.PodSpec, p *Params, metadata *metav1.ObjectMeta) {
+	var nameservers []string
+	for _, nameserver := range dnsAnnotation(istioSidecarAnnotationDNSNameserversKey, p.DNSNameservers, metadata) {
+		if net.ParseIP(nameserver) == nil {
+			log.Warnf("Ignoring nameserver %q in annotation %s: not an IP address",
+				nameserver, istioSidecarAnnotationDNSNameserversKey)
+			continue
+		}
+		nameservers = append(nameservers, nameserver)
+	}
+	searches := dnsAnnotation(istioSidecarAnnotationDNSSearchesKey, p.DNSSearches, metadata)
+	if len(nameservers) == 0 && len(searches) == 0 {
+		return
+	}
+
+	if spec.DNSConfig == nil {
+		spec.DNSConfig = &v1.PodDNSConfig{}
+	}
+	spec.DNSConfig.Nameservers = appendMissing(spec.DNSConfig.Nameservers, nameservers)
+	spec.DNSConfig.Searches = appendMissing(spec.DNSConfig.Searches, searches)
+}
+
+// appendMissing appends the items that are not yet in list.
+func appendMissing(list []string, items []string) []string {
+Items:
+	for _, item := range items {
+		for _, existing := range list {
+			if existing == item {
+				continue Items
+			}
+		}
+		list = append(list, item)
+	}
+	return list
+}
+
+// ResolveSidecarTemplate returns the values the sidecar template is
+// rendered with when injecting into a pod with the given spec and
+// metadata. Neither is modified.
+func ResolveSidecarTemplate(c *Config, spec *v1.PodSpec, metadata *metav1.ObjectMeta) (SidecarTemplate, error) {
+	return resolveSidecarTemplate(&c.Params, spec, metadata)
+}
+
+func resolveSidecarTemplate(p *Params, spec *v1.PodSpec, metadata *metav1.ObjectMeta) (SidecarTemplate, error) {
+	if p.Mesh == nil || p.Mesh.DefaultConfig == nil {
+		return SidecarTemplate{}, errors.New("mesh config has no defaultConfig for the sidecar proxy")
+	}
+
+	st := SidecarTemplate{spec, p.Mesh.DefaultConfig.ServiceCluster, p, p.Mesh.DefaultConfig.ControlPlaneAuthPolicy.String(),
+		proxyConcurrency(p, metadata), excludeInboundPorts(p, metadata)}
 
 	// If 'app' label is available, use it as the default service cluster
 	if val, ok := metadata.GetLabels()["app"]; ok {
 		st.ServiceCluster = val
 	}
+	return st, nil
+}
 
+// renderSidecarConfig executes a sidecar template and parses the result.
+func renderSidecarConfig(t *template.Template, st *SidecarTemplate) SidecarConfig {
 	var tmpl bytes.Buffer
-
-	t := template.Must(template.New("inject").Parse(productionTemplate))
-	if err := t.Execute(&tmpl, &st); err != nil {
+	if err := t.Execute(&tmpl, st); err != nil {
 		log.Errora(err)
 	}
 
@@ -340,112 +1096,507 @@ func injectIntoSpec(p *Params, spec *v1.PodSpec, metadata *metav1.ObjectMeta) {
 	if err := yaml.Unmarshal(tmpl.Bytes(), &sc); err != nil {
 		log.Warnf(err.Error())
 	}
+	return sc
+}
+
+func injectIntoSpec(p *Params, spec *v1.PodSpec, metadata *metav1.ObjectMeta, prependInit bool) error {
+	st, err := resolveSidecarTemplate(p, spec, metadata)
+	if err != nil {
+		return err
+	}
 
-	spec.InitContainers = append(spec.InitContainers, sc.InitContainers...)
+	sc := renderSidecarConfig(template.Must(template.New("inject").Parse(productionTemplate)), &st)
+	if p.OverlayTemplate != "" {
+		if t, err := template.New("overlay").Parse(p.OverlayTemplate); err != nil {
+			log.Errora(err)
+		} else {
+			sc = sc.Merge(renderSidecarConfig(t, &st))
+		}
+	}
+
+	for i := range sc.Containers {
+		if sc.Containers[i].Name == ProxyContainerName {
+			addProxyEnv(&sc.Containers[i], metadata)
+			if annotationEnabled(istioSidecarAnnotationReadinessProbeKey, metadata) {
+				addReadinessProbe(&sc.Containers[i], p.Mesh)
+			}
+			addPreStopHook(&sc.Containers[i], p.Mesh, preStopDrainSeconds(p, metadata))
+		}
+	}
+
+	// Kubernetes rejects pods with duplicate names, so pod entries named
+	// like injected ones are replaced by them.
+	spec.InitContainers = removeContainers(spec.InitContainers, sc.InitContainers, "init container", metadata)
+	spec.Containers = removeContainers(spec.Containers, sc.Containers, "container", metadata)
+	spec.Volumes = removeVolumes(spec.Volumes, sc.Volumes, metadata)
+
+	if prependInit {
+		spec.InitContainers = append(sc.InitContainers, spec.InitContainers...)
+	} else {
+		spec.InitContainers = append(spec.InitContainers, sc.InitContainers...)
+	}
 	spec.Containers = append(spec.Containers, sc.Containers...)
 	spec.Volumes = append(spec.Volumes, sc.Volumes...)
+	addDNSConfig(spec, p, metadata)
+
+ImagePullSecrets:
+	for _, secret := range p.ImagePullSecrets {
+		for _, existing := range spec.ImagePullSecrets {
+			if existing.Name == secret {
+				continue ImagePullSecrets
+			}
+		}
+		spec.ImagePullSecrets = append(spec.ImagePullSecrets, v1.LocalObjectReference{Name: secret})
+	}
+	return nil
+}
+
+// removeContainers returns the containers without those named like one
+// of injected.
+func removeContainers(containers, injected []v1.Container, kind string, metadata *metav1.ObjectMeta) []v1.Container {
+	names := make(map[string]bool, len(injected))
+	for _, c := range injected {
+		names[c.Name] = true
+	}
+	out := make([]v1.Container, 0, len(containers))
+	for _, c := range containers {
+		if names[c.Name] {
+			log.Warnf("Replacing %s %s of %s/%s with the injected one", kind, c.Name, metadata.Namespace, metadata.Name)
+			continue
+		}
+		out = append(out, c)
+	}
+	if len(out) == len(containers) {
+		return containers
+	}
+	return out
+}
+
+// removeVolumes returns the volumes without those named like one of
+// injected.
+func removeVolumes(volumes, injected []v1.Volume, metadata *metav1.ObjectMeta) []v1.Volume {
+	names := make(map[string]bool, len(injected))
+	for _, v := range injected {
+		names[v.Name] = true
+	}
+	out := make([]v1.Volume, 0, len(volumes))
+	for _, v := range volumes {
+		if names[v.Name] {
+			log.Warnf("Replacing volume %s of %s/%s with the injected one", v.Name, metadata.Namespace, metadata.Name)
+			continue
+		}
+		out = append(out, v)
+	}
+	if len(out) == len(volumes) {
+		return volumes
+	}
+	return out
 }
 
 func intoObject(c *Config, in runtime.Object) (interface{}, error) {
+	out, _, err := injectObject(c, in)
+	return out, err
+}
+
+// supportedKind returns whether the kind is one the initializer can inject.
+func supportedKind(kind string) bool {
+	for _, k := range kinds {
+		if objectKind(k.obj) == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// objectKind returns the kind of in, as logged with injection decisions.
+func objectKind(in runtime.Object) string {
+	if gvks, _, err := injectScheme.ObjectKinds(in); err == nil && len(gvks) > 0 {
+		return gvks[0].Kind
+	}
+	return in.GetObjectKind().GroupVersionKind().Kind
+}
+
+// injectObject returns a copy of in with the sidecar injected, or an unchanged copy and the reason
+// injection was skipped.
+func injectObject(c *Config, in runtime.Object) (runtime.Object, string, error) {
 	obj, err := meta.Accessor(in)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	out := in.DeepCopyObject()
 
-	if !injectRequired(c.IncludeNamespaces, ignoredNamespaces, c.ExcludeNamespaces, c.Policy, obj) {
-		log.Infof("Skipping %s/%s due to policy check", obj.GetNamespace(), obj.GetName())
-		return out, nil
+	if reason := skipReason(ignoredNamespaces, c, obj); reason != "" {
+		log.Info(fmt.Sprintf("Skipping %s/%s due to policy check", obj.GetNamespace(), obj.GetName()),
+			zap.String("namespace", obj.GetNamespace()),
+			zap.String("name", obj.GetName()),
+			zap.String("kind", objectKind(in)),
+			zap.String("reason", reason))
+		return out, reason, nil
 	}
 
-	// `in` is a pointer to an Object. Dereference it.
-	outValue := reflect.ValueOf(out).Elem()
+	objectMeta, templateObjectMeta, templatePodSpec := templateMeta(out)
+	reason, err := injectMeta(c, objectMeta, templateObjectMeta, templatePodSpec)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot inject %s/%s: %v", obj.GetNamespace(), obj.GetName(), err)
+	}
+	return out, reason, nil
+}
 
-	var objectMeta *metav1.ObjectMeta
-	var templateObjectMeta *metav1.ObjectMeta
-	var templatePodSpec *v1.PodSpec
+// templateMeta returns the metadata of obj along with the metadata and
+// pod spec of its template. obj must be a pointer to one of the kinds
+// handled by the initializer.
+func templateMeta(obj runtime.Object) (*metav1.ObjectMeta, *metav1.ObjectMeta, *v1.PodSpec) {
 	// CronJobs have JobTemplates in them, instead of Templates, so we
 	// special case them.
-	if job, ok := out.(*v2alpha1.CronJob); ok {
-		objectMeta = &job.ObjectMeta
-		templateObjectMeta = &job.Spec.JobTemplate.ObjectMeta
-		templatePodSpec = &job.Spec.JobTemplate.Spec.Template.Spec
-	} else {
-		templateValue := outValue.FieldByName("Spec").FieldByName("Template")
-		// `Template` is defined as a pointer in some older API
-		// definitions, e.g. ReplicationController
-		if templateValue.Kind() == reflect.Ptr {
-			templateValue = templateValue.Elem()
-		}
-		objectMeta = outValue.FieldByName("ObjectMeta").Addr().Interface().(*metav1.ObjectMeta)
-		templateObjectMeta = templateValue.FieldByName("ObjectMeta").Addr().Interface().(*metav1.ObjectMeta)
-		templatePodSpec = templateValue.FieldByName("Spec").Addr().Interface().(*v1.PodSpec)
+	if job, ok := obj.(*v2alpha1.CronJob); ok {
+		return &job.ObjectMeta, &job.Spec.JobTemplate.ObjectMeta, &job.Spec.JobTemplate.Spec.Template.Spec
 	}
 
+	// `obj` is a pointer to an Object. Dereference it.
+	objValue := reflect.ValueOf(obj).Elem()
+	templateValue := objValue.FieldByName("Spec").FieldByName("Template")
+	// `Template` is defined as a pointer in some older API
+	// definitions, e.g. ReplicationController
+	if templateValue.Kind() == reflect.Ptr {
+		templateValue = templateValue.Elem()
+	}
+	return objValue.FieldByName("ObjectMeta").Addr().Interface().(*metav1.ObjectMeta),
+		templateValue.FieldByName("ObjectMeta").Addr().Interface().(*metav1.ObjectMeta),
+		templateValue.FieldByName("Spec").Addr().Interface().(*v1.PodSpec)
+}
+
+// injectMeta injects the sidecar into spec and records the injected
+// version on objectMeta and its pod templateObjectMeta. It returns the
+// reason injection was skipped, if any.
+func injectMeta(c *Config, objectMeta, templateObjectMeta *metav1.ObjectMeta, spec *v1.PodSpec) (string, error) {
 	// Skip injection when host networking is enabled. The problem is
 	// that the iptable changes are assumed to be within the pod when,
 	// in fact, they are changing the routing at the host level. This
 	// often results in routing failures within a node which can
 	// affect the network provider within the cluster causing
 	// additional pod failures.
-	if templatePodSpec.HostNetwork {
-		return out, nil
+	if spec.HostNetwork {
+		return skipReasonHostNetwork, nil
+	}
+
+	// update rather than duplicate an outdated sidecar
+	if _, ok := objectMeta.Annotations[c.statusAnnotationKey()]; ok {
+		removeSidecar(spec)
 	}
 
 	for _, m := range []*metav1.ObjectMeta{objectMeta, templateObjectMeta} {
 		if m.Annotations == nil {
 			m.Annotations = make(map[string]string)
 		}
-		m.Annotations[istioSidecarAnnotationStatusKey] = "injected-version-" + c.Params.Version
+		m.Annotations[c.statusAnnotationKey()] = injectedVersionPrefix + c.Params.Version
+	}
+	templateObjectMeta.Labels = addMissing(templateObjectMeta.Labels, c.Params.PodLabels)
+	templateObjectMeta.Annotations = addMissing(templateObjectMeta.Annotations, c.Params.PodAnnotations)
+
+	return "", injectIntoSpec(&c.Params, spec, templateObjectMeta, initFirst(objectMeta, templateObjectMeta))
+}
+
+// addMissing adds the entries of from whose keys are not in to, which is
+// allocated if needed.
+func addMissing(to, from map[string]string) map[string]string {
+	for k, v := range from {
+		if to == nil {
+			to = make(map[string]string, len(from))
+		}
+		if _, ok := to[k]; !ok {
+			to[k] = v
+		}
 	}
+	return to
+}
 
-	injectIntoSpec(&c.Params, templatePodSpec, templateObjectMeta)
+// InjectPod returns a copy of pod with the sidecar injected, or an
+// unchanged copy if injection is not required. Unlike IntoResourceFile,
+// it works on a single decoded Pod, e.g. as received by an admission
+// webhook.
+func InjectPod(c *Config, pod *v1.Pod) (*v1.Pod, error) {
+	if pod == nil {
+		return nil, errors.New("no pod to inject")
+	}
+	out := pod.DeepCopy()
 
+	reason := skipReason(ignoredNamespaces, c, out)
+	if reason == "" {
+		var err error
+		if reason, err = injectMeta(c, &out.ObjectMeta, &out.ObjectMeta, &out.Spec); err != nil {
+			return nil, fmt.Errorf("cannot inject pod %s/%s: %v", out.Namespace, out.Name, err)
+		}
+	}
+	if reason != "" {
+		log.Info(fmt.Sprintf("Skipping pod %s/%s", out.Namespace, out.Name),
+			zap.String("namespace", out.Namespace),
+			zap.String("name", out.Name),
+			zap.String("kind", "Pod"),
+			zap.String("reason", reason))
+	}
 	return out, nil
 }
 
-// IntoResourceFile injects the istio proxy into the specified
-// kubernetes YAML file.
-func IntoResourceFile(c *Config, in io.Reader, out io.Writer) error {
+// DocumentError is returned for the document of a kubernetes YAML
+// stream that could not be read or injected.
+type DocumentError struct {
+	// Index is the zero-based position of the document in the stream,
+	// not counting empty documents.
+	Index int
+
+	// Kind, Namespace and Name identify the object of the document,
+	// if it could be parsed that far.
+	Kind      string
+	Namespace string
+	Name      string
+
+	Err error
+}
+
+func (e *DocumentError) Error() string {
+	if e.Kind == "" {
+		return fmt.Sprintf("document %d: %v", e.Index, e.Err)
+	}
+	name := e.Name
+	if e.Namespace != "" {
+		name = e.Namespace + "/" + e.Name
+	}
+	return fmt.Sprintf("document %d (%s %s): %v", e.Index, e.Kind, name, e.Err)
+}
+
+// documentHeader holds the fields identifying the object of a document
+type documentHeader struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        metav1.ObjectMeta `json:"metadata,omitempty"`
+}
+
+// injectDocuments injects the istio proxy into each document of a
+// kubernetes YAML stream. visit is called in order with the raw
+// document and the injected object, which is nil for kinds that
+// cannot be injected or are not enabled, in which case skip is set.
+// Errors are returned as a *DocumentError.
+func injectDocuments(c *Config, in io.Reader, visit func(raw []byte, out runtime.Object, skip *InjectionSkip) error) error {
 	reader := yamlDecoder.NewYAMLReader(bufio.NewReaderSize(in, 4096))
-	for {
+	for index := 0; ; index++ {
 		raw, err := reader.Read()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return err
+			return &DocumentError{Index: index, Err: err}
 		}
 
-		var typeMeta metav1.TypeMeta
-		if err = yaml.Unmarshal(raw, &typeMeta); err != nil {
-			return err
+		var header documentHeader
+		if err = yaml.Unmarshal(raw, &header); err != nil {
+			return &DocumentError{Index: index, Err: err}
 		}
+		if err = injectDocument(c, raw, header.Kind, header.APIVersion, visit); err != nil {
+			return &DocumentError{
+				Index:     index,
+				Kind:      header.Kind,
+				Namespace: header.Metadata.Namespace,
+				Name:      header.Metadata.Name,
+				Err:       err,
+			}
+		}
+	}
+	return nil
+}
 
-		gvk := schema.FromAPIVersionAndKind(typeMeta.APIVersion, typeMeta.Kind)
-		obj, err := injectScheme.New(gvk)
-		var updated []byte
-		if err == nil {
-			if err = yaml.Unmarshal(raw, obj); err != nil {
+// injectDocument injects the istio proxy into a single document of the
+// given kind and calls visit with the result, see injectDocuments.
+func injectDocument(c *Config, raw []byte, kind, apiVersion string,
+	visit func(raw []byte, out runtime.Object, skip *InjectionSkip) error) error {
+	gvk := schema.FromAPIVersionAndKind(apiVersion, kind)
+	obj, err := injectScheme.New(gvk)
+	if err != nil {
+		// unchanged
+		return visit(raw, nil, nil)
+	}
+	if !c.kindEnabled(kind) {
+		// unchanged
+		return visit(raw, nil, &InjectionSkip{Kind: kind, Reason: skipReasonKindNotEnabled})
+	}
+	if err = yaml.Unmarshal(raw, obj); err != nil {
+		return err
+	}
+	out, reason, err := injectObject(c, obj)
+	if err != nil {
+		return err
+	}
+	var skip *InjectionSkip
+	if reason != "" {
+		skip = newInjectionSkip(kind, out, reason)
+	}
+	return visit(raw, out, skip)
+}
+
+func newInjectionSkip(kind string, obj runtime.Object, reason string) *InjectionSkip {
+	skip := &InjectionSkip{Kind: kind, Reason: reason}
+	if accessor, err := meta.Accessor(obj); err == nil {
+		skip.Namespace = accessor.GetNamespace()
+		skip.Name = accessor.GetName()
+	}
+	return skip
+}
+
+// IntoObjects injects the istio proxy into the objects of the
+// specified kubernetes YAML file. It returns all decoded objects, in
+// order, and the objects that were left unchanged with the reason.
+// Kinds that cannot be injected or are not enabled are returned as
+// unstructured objects.
+func IntoObjects(c *Config, in io.Reader) ([]runtime.Object, []InjectionSkip, error) {
+	var objects []runtime.Object
+	var skipped []InjectionSkip
+	err := injectDocuments(c, in, func(raw []byte, out runtime.Object, skip *InjectionSkip) error {
+		if out == nil {
+			u := &unstructured.Unstructured{}
+			if err := yaml.Unmarshal(raw, &u.Object); err != nil {
 				return err
 			}
-			out, err := intoObject(c, obj) // nolint: vetshadow
-			if err != nil {
+			if len(u.Object) == 0 {
+				// empty document
+				return nil
+			}
+			reason := skipReasonUnsupportedKind
+			if skip != nil {
+				reason = skip.Reason
+			}
+			out = u
+			skip = newInjectionSkip(u.GetKind(), u, reason)
+		}
+		objects = append(objects, out)
+		if skip != nil {
+			skipped = append(skipped, *skip)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return objects, skipped, nil
+}
+
+// documentSeparator separates the documents of a YAML stream
+const documentSeparator = "---"
+
+// leadingSeparators consumes the document separator lines at the start of
+// in. It returns them along with a reader for the rest of the input.
+func leadingSeparators(in io.Reader) (string, io.Reader, error) {
+	reader := bufio.NewReader(in)
+	var separators string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", nil, err
+		}
+		if strings.TrimRightFunc(line, unicode.IsSpace) != documentSeparator {
+			return separators, io.MultiReader(strings.NewReader(line), reader), nil
+		}
+		separators += line
+		if err == io.EOF {
+			return separators, reader, nil
+		}
+	}
+}
+
+// IntoResourceFile injects the istio proxy into the specified
+// kubernetes YAML file. Documents are separated by "---" lines, without
+// a trailing separator after the last one. Separators at the start of
+// the file are preserved.
+func IntoResourceFile(c *Config, in io.Reader, out io.Writer) error {
+	separators, in, err := leadingSeparators(in)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprint(out, separators); err != nil {
+		return err
+	}
+
+	first := true
+	return injectDocuments(c, in, func(raw []byte, obj runtime.Object, _ *InjectionSkip) error {
+		updated := raw // unchanged
+		if obj != nil {
+			var err error
+			if updated, err = yaml.Marshal(obj); err != nil {
 				return err
 			}
-			if updated, err = yaml.Marshal(out); err != nil {
+		}
+		if !first {
+			if _, err := fmt.Fprintln(out, documentSeparator); err != nil {
 				return err
 			}
-		} else {
-			updated = raw // unchanged
 		}
-		if _, err = out.Write(updated); err != nil {
-			return err
+		first = false
+		_, err := out.Write(updated)
+		return err
+	})
+}
+
+// IntoKustomizePatch writes the injection of the istio proxy into the
+// specified kubernetes YAML file as a stream of kustomize patches. Each
+// patch is the strategic merge patch the initializer applies to the
+// object, see ComputeInjectionPatch, and is identified by the kind, name
+// and namespace of the object. Objects left unchanged have no patch.
+func IntoKustomizePatch(c *Config, in io.Reader, out io.Writer) error {
+	first := true
+	return injectDocuments(c, in, func(raw []byte, obj runtime.Object, skip *InjectionSkip) error {
+		if obj == nil || skip != nil {
+			// unchanged
+			return nil
 		}
-		if _, err = fmt.Fprint(out, "---\n"); err != nil {
+		patch, err := kustomizePatch(c, raw)
+		if err != nil || patch == nil {
 			return err
 		}
+		if !first {
+			if _, err = fmt.Fprintln(out, documentSeparator); err != nil {
+				return err
+			}
+		}
+		first = false
+		_, err = out.Write(patch)
+		return err
+	})
+}
+
+// kustomizePatch returns the injection patch of the object of a raw
+// document as a kustomize patch, or nil if the object is unchanged.
+func kustomizePatch(c *Config, raw []byte) ([]byte, error) {
+	var header documentHeader
+	if err := yaml.Unmarshal(raw, &header); err != nil {
+		return nil, err
 	}
-	return nil
+	original, err := injectScheme.New(header.GroupVersionKind())
+	if err != nil {
+		return nil, err
+	}
+	if err = yaml.Unmarshal(raw, original); err != nil {
+		return nil, err
+	}
+	patchBytes, gvk, err := ComputeInjectionPatch(c, original)
+	if err != nil {
+		return nil, err
+	}
+
+	patch := map[string]interface{}{}
+	if err = json.Unmarshal(patchBytes, &patch); err != nil {
+		return nil, err
+	}
+	if len(patch) == 0 {
+		return nil, nil
+	}
+	metadata, _ := patch["metadata"].(map[string]interface{})
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadata["name"] = header.Metadata.Name
+	if header.Metadata.Namespace != "" {
+		metadata["namespace"] = header.Metadata.Namespace
+	}
+	patch["metadata"] = metadata
+	patch["apiVersion"] = gvk.GroupVersion().String()
+	patch["kind"] = gvk.Kind
+	return yaml.Marshal(patch)
 }
@@ -16,13 +16,25 @@ package inject
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ghodss/yaml"
+	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 
 	meshconfig "istio.io/api/mesh/v1alpha1"
 	"istio.io/istio/pilot/model"
@@ -45,6 +57,27 @@ func TestImageName(t *testing.T) {
 	}
 }
 
+func TestImageReference(t *testing.T) {
+	cases := []struct {
+		image string
+		want  string
+	}{
+		{image: "proxyv2", want: "docker.io/istio/proxyv2:latest"},
+		{image: "myrepo/istio/proxyv2:0.5.0", want: "myrepo/istio/proxyv2:0.5.0"},
+		{image: "gcr.io/myproject/istio/proxy_init", want: "gcr.io/myproject/istio/proxy_init"},
+		{
+			image: "myrepo/istio/proxyv2@sha256:a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091",
+			want:  "myrepo/istio/proxyv2@sha256:a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f8091",
+		},
+		{image: "proxy@sha256:a2b3c4d5", want: "proxy@sha256:a2b3c4d5"},
+	}
+	for _, c := range cases {
+		if got := imageReference(c.image, "docker.io/istio", "latest"); got != c.want {
+			t.Errorf("imageReference(%q) => %q, want %q", c.image, got, c.want)
+		}
+	}
+}
+
 // Tag name should be kept in sync with value in platform/kube/inject/refresh.sh
 const unitTestTag = "unittest"
 
@@ -117,6 +150,11 @@ func TestIntoResourceFile(t *testing.T) {
 			want:    "testdata/multi-init.yaml.injected",
 			include: []string{v1.NamespaceAll},
 		},
+		{
+			in:      "testdata/multi-init-last.yaml",
+			want:    "testdata/multi-init-last.yaml.injected",
+			include: []string{v1.NamespaceAll},
+		},
 		{
 			in:      "testdata/statefulset.yaml",
 			want:    "testdata/statefulset.yaml.injected",
@@ -223,6 +261,346 @@ func TestIntoResourceFile(t *testing.T) {
 	}
 }
 
+// testConfig returns an injection config enabled in all namespaces with the unit test images
+func testConfig(mesh *meshconfig.MeshConfig) *Config {
+	return &Config{
+		Policy:            InjectionPolicyEnabled,
+		IncludeNamespaces: []string{v1.NamespaceAll},
+		Params: Params{
+			InitImage:       InitImageName(unitTestHub, unitTestTag, false),
+			ProxyImage:      ProxyImageName(unitTestHub, unitTestTag, false),
+			ImagePullPolicy: "IfNotPresent",
+			Verbosity:       DefaultVerbosity,
+			SidecarProxyUID: DefaultSidecarProxyUID,
+			Version:         "12345678",
+			Mesh:            mesh,
+		},
+	}
+}
+
+func TestIntoObjects(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := testConfig(&mesh)
+
+	in, err := os.Open("testdata/skipped-and-injected.yaml")
+	if err != nil {
+		t.Fatalf("Failed to open input: %v", err)
+	}
+	defer func() { _ = in.Close() }()
+
+	objects, skipped, err := IntoObjects(config, in)
+	if err != nil {
+		t.Fatalf("IntoObjects() returned an error: %v", err)
+	}
+	if len(objects) != 4 {
+		t.Fatalf("IntoObjects() returned %d objects, want 4", len(objects))
+	}
+
+	injected, ok := objects[0].(*v1beta1.Deployment)
+	if !ok {
+		t.Fatalf("IntoObjects() returned %T for the first object, want *v1beta1.Deployment", objects[0])
+	}
+	if got := len(injected.Spec.Template.Spec.Containers); got != 2 {
+		t.Errorf("injected deployment has %d containers, want 2", got)
+	}
+
+	want := []InjectionSkip{
+		{Kind: "Deployment", Name: "not-required", Reason: skipReasonPolicy},
+		{Kind: "Deployment", Namespace: "kube-system", Name: "system", Reason: skipReasonIgnoredNamespace},
+		{Kind: "Service", Name: "hello", Reason: skipReasonUnsupportedKind},
+	}
+	if !reflect.DeepEqual(skipped, want) {
+		t.Errorf("IntoObjects() skipped \n%v, want \n%v", skipped, want)
+	}
+}
+
+func TestIntoObjectsAppsV1(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := testConfig(&mesh)
+
+	data, err := ioutil.ReadFile("testdata/hello.yaml")
+	if err != nil {
+		t.Fatalf("Failed to read input: %v", err)
+	}
+	in := strings.Replace(string(data), "apiVersion: extensions/v1beta1", "apiVersion: apps/v1", 1)
+
+	objects, skipped, err := IntoObjects(config, strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("IntoObjects() returned an error: %v", err)
+	}
+	if len(objects) != 1 || len(skipped) != 0 {
+		t.Fatalf("IntoObjects() returned %d objects and skipped %v, want 1 injected object", len(objects), skipped)
+	}
+	injected, ok := objects[0].(*appsv1.Deployment)
+	if !ok {
+		t.Fatalf("IntoObjects() returned %T, want *appsv1.Deployment", objects[0])
+	}
+	if got := len(injected.Spec.Template.Spec.Containers); got != 2 {
+		t.Errorf("injected deployment has %d containers, want 2", got)
+	}
+	if got := injected.Spec.Template.Annotations[istioSidecarAnnotationStatusKey]; got != injectedVersionPrefix+"12345678" {
+		t.Errorf("injected deployment template has status %q, want %q", got, injectedVersionPrefix+"12345678")
+	}
+}
+
+func TestIntoObjectsEnabledKinds(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := testConfig(&mesh)
+	config.EnabledKinds = []string{"Deployment"}
+
+	var in bytes.Buffer
+	for _, file := range []string{"testdata/hello.yaml", "testdata/job.yaml"} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read %q: %v", file, err)
+		}
+		in.Write(data)
+		in.WriteString("---\n")
+	}
+
+	objects, skipped, err := IntoObjects(config, &in)
+	if err != nil {
+		t.Fatalf("IntoObjects() returned an error: %v", err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("IntoObjects() returned %d objects, want 2", len(objects))
+	}
+	if _, ok := objects[0].(*v1beta1.Deployment); !ok {
+		t.Errorf("IntoObjects() returned %T for the deployment, want *v1beta1.Deployment", objects[0])
+	}
+	if _, ok := objects[1].(*unstructured.Unstructured); !ok {
+		t.Errorf("IntoObjects() returned %T for the job, want *unstructured.Unstructured", objects[1])
+	}
+	want := []InjectionSkip{{Kind: "Job", Name: "pi", Reason: skipReasonKindNotEnabled}}
+	if !reflect.DeepEqual(skipped, want) {
+		t.Errorf("IntoObjects() skipped \n%v, want \n%v", skipped, want)
+	}
+}
+
+func TestIntoObjectsPodLabelsAndAnnotations(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := testConfig(&mesh)
+	config.Params.PodLabels = map[string]string{"mesh": "istio", "tier": "mesh"}
+	config.Params.PodAnnotations = map[string]string{"example.com/cost-center": "1234", "owner": "platform"}
+
+	in := `apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: hello
+spec:
+  template:
+    metadata:
+      labels:
+        app: hello
+        tier: backend
+      annotations:
+        owner: hello-team
+    spec:
+      containers:
+      - name: hello
+        image: "fake.docker.io/google-samples/hello-go-gke:1.0"
+`
+	objects, _, err := IntoObjects(config, strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("IntoObjects() returned an error: %v", err)
+	}
+	if len(objects) != 1 {
+		t.Fatalf("IntoObjects() returned %d objects, want 1", len(objects))
+	}
+	template := objects[0].(*v1beta1.Deployment).Spec.Template
+
+	wantLabels := map[string]string{"app": "hello", "tier": "backend", "mesh": "istio"}
+	if !reflect.DeepEqual(template.Labels, wantLabels) {
+		t.Errorf("injected template labels are %v, want %v", template.Labels, wantLabels)
+	}
+	wantAnnotations := map[string]string{
+		"owner":                         "hello-team",
+		"example.com/cost-center":       "1234",
+		istioSidecarAnnotationStatusKey: injectedVersionPrefix + "12345678",
+	}
+	if !reflect.DeepEqual(template.Annotations, wantAnnotations) {
+		t.Errorf("injected template annotations are %v, want %v", template.Annotations, wantAnnotations)
+	}
+}
+
+func TestValidatePodLabelsAndAnnotations(t *testing.T) {
+	cases := []struct {
+		name   string
+		params Params
+		valid  bool
+	}{
+		{name: "valid", params: Params{
+			PodLabels:      map[string]string{"mesh": "istio"},
+			PodAnnotations: map[string]string{"example.com/cost-center": "any value: 1234"},
+		}, valid: true},
+		{name: "invalid label key", params: Params{PodLabels: map[string]string{"mesh=": "istio"}}},
+		{name: "invalid label value", params: Params{PodLabels: map[string]string{"mesh": "is tio"}}},
+		{name: "invalid annotation key", params: Params{PodAnnotations: map[string]string{"": "1234"}}},
+	}
+	for _, c := range cases {
+		config := &Config{Policy: InjectionPolicyEnabled, Params: c.params}
+		if err := config.validate(); (err == nil) != c.valid {
+			t.Errorf("%v: validate() returned %v, want valid %v", c.name, err, c.valid)
+		}
+	}
+}
+
+func TestValidateEnabledKinds(t *testing.T) {
+	config := &Config{Policy: InjectionPolicyEnabled, EnabledKinds: []string{"Deployment", "StatefulSet", "CronJob"}}
+	if err := config.validate(); err != nil {
+		t.Errorf("validate() rejected supported kinds: %v", err)
+	}
+	for _, kind := range []string{"Pod", "deployment", ""} {
+		config := &Config{Policy: InjectionPolicyEnabled, EnabledKinds: []string{"Deployment", kind}}
+		if err := config.validate(); err == nil {
+			t.Errorf("validate() accepted enabled kind %q", kind)
+		}
+	}
+}
+
+func TestIntoResourceFileSeparators(t *testing.T) {
+	svcA := "apiVersion: v1\nkind: Service\nmetadata:\n  name: a\n"
+	svcB := "apiVersion: v1\nkind: Service\nmetadata:\n  name: b\n"
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "single", in: svcA, want: svcA},
+		{name: "single with trailing separator", in: svcA + "---\n", want: svcA},
+		{name: "multi", in: svcA + "---\n" + svcB + "---\n", want: svcA + "---\n" + svcB},
+		{name: "leading separators", in: "---\n---\n" + svcA + "---\n" + svcB, want: "---\n---\n" + svcA + "---\n" + svcB},
+	}
+	mesh := model.DefaultMeshConfig()
+	config := &Config{Policy: InjectionPolicyEnabled, Params: Params{Mesh: &mesh}}
+	for _, c := range cases {
+		var got bytes.Buffer
+		if err := IntoResourceFile(config, strings.NewReader(c.in), &got); err != nil {
+			t.Fatalf("%s: IntoResourceFile() returned an error: %v", c.name, err)
+		}
+		if got.String() != c.want {
+			t.Errorf("%s: IntoResourceFile() => %q, want %q", c.name, got.String(), c.want)
+		}
+	}
+}
+
+func TestIntoResourceFileDocumentError(t *testing.T) {
+	svc := "apiVersion: v1\nkind: Service\nmetadata:\n  name: %s\n---\n"
+	cases := []struct {
+		name  string
+		third string
+		want  DocumentError
+	}{
+		{
+			name:  "malformed yaml",
+			third: "kind: [\n",
+			want:  DocumentError{Index: 2},
+		},
+		{
+			name:  "malformed spec",
+			third: "apiVersion: extensions/v1beta1\nkind: Deployment\nmetadata:\n  name: broken\n  namespace: ns\nspec: foo\n",
+			want:  DocumentError{Index: 2, Kind: "Deployment", Namespace: "ns", Name: "broken"},
+		},
+	}
+	mesh := model.DefaultMeshConfig()
+	config := &Config{Policy: InjectionPolicyEnabled, Params: Params{Mesh: &mesh}}
+	for _, c := range cases {
+		in := fmt.Sprintf(svc, "a") + fmt.Sprintf(svc, "b") + c.third + "---\n" + fmt.Sprintf(svc, "c")
+		err := IntoResourceFile(config, strings.NewReader(in), ioutil.Discard)
+		docErr, ok := err.(*DocumentError)
+		if !ok {
+			t.Errorf("%s: IntoResourceFile() => %v, want a *DocumentError", c.name, err)
+			continue
+		}
+		if docErr.Err == nil {
+			t.Errorf("%s: DocumentError has no cause", c.name)
+		}
+		docErr.Err = nil
+		if !reflect.DeepEqual(*docErr, c.want) {
+			t.Errorf("%s: IntoResourceFile() => %+v, want %+v", c.name, *docErr, c.want)
+		}
+	}
+}
+
+func TestIntoKustomizePatch(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := testConfig(&mesh)
+
+	raw, err := ioutil.ReadFile("testdata/skipped-and-injected.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got bytes.Buffer
+	if err = IntoKustomizePatch(config, bytes.NewReader(raw), &got); err != nil {
+		t.Fatalf("IntoKustomizePatch() returned an error: %v", err)
+	}
+
+	// only the first deployment of the input is injected
+	if strings.Contains(got.String(), documentSeparator) {
+		t.Fatalf("IntoKustomizePatch() returned several patches, want 1:\n%s", got.String())
+	}
+	patch, err := yaml.YAMLToJSON(got.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header documentHeader
+	if err = json.Unmarshal(patch, &header); err != nil {
+		t.Fatal(err)
+	}
+	if header.APIVersion != "extensions/v1beta1" || header.Kind != "Deployment" || header.Metadata.Name != "hello" {
+		t.Errorf("IntoKustomizePatch() patch is for %s %s %s, want extensions/v1beta1 Deployment hello",
+			header.APIVersion, header.Kind, header.Metadata.Name)
+	}
+
+	// the patch applied to the original yields the injected object
+	objects, _, err := IntoObjects(config, bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantData, err := json.Marshal(objects[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := strings.SplitN(string(raw), documentSeparator, 2)[0]
+	inData, err := yaml.YAMLToJSON([]byte(original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchedData, err := strategicpatch.StrategicMergePatch(inData, patch, &v1beta1.Deployment{})
+	if err != nil {
+		t.Fatalf("StrategicMergePatch() returned an error: %v", err)
+	}
+	patched := &v1beta1.Deployment{}
+	if err = json.Unmarshal(patchedData, patched); err != nil {
+		t.Fatal(err)
+	}
+	gotData, err := json.Marshal(patched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(gotData) != string(wantData) {
+		t.Errorf("patched object \n%s, want \n%s", gotData, wantData)
+	}
+}
+
+func TestIntoKustomizePatchUnchanged(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := &Config{Policy: InjectionPolicyDisabled, Params: Params{Mesh: &mesh}}
+	in, err := os.Open("testdata/skipped-and-injected.yaml")
+	if err != nil {
+		t.Fatalf("Failed to open input: %v", err)
+	}
+	defer func() { _ = in.Close() }()
+
+	var got bytes.Buffer
+	if err = IntoKustomizePatch(config, in, &got); err != nil {
+		t.Fatalf("IntoKustomizePatch() returned an error: %v", err)
+	}
+	if got.Len() != 0 {
+		t.Errorf("IntoKustomizePatch() => %q, want no patch", got.String())
+	}
+}
+
 func TestInjectRequired(t *testing.T) {
 	cases := []struct {
 		policy InjectionPolicy
@@ -299,15 +677,238 @@ func TestInjectRequired(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			policy: InjectionPolicyOff,
+			meta: &metav1.ObjectMeta{
+				Name:        "no-policy",
+				Namespace:   "test-namespace",
+				Annotations: map[string]string{},
+			},
+			want: false,
+		},
+		{
+			policy: InjectionPolicyOff,
+			meta: &metav1.ObjectMeta{
+				Name:      "default-policy",
+				Namespace: "test-namespace",
+			},
+			want: false,
+		},
+		{
+			policy: InjectionPolicyOff,
+			meta: &metav1.ObjectMeta{
+				Name:        "force-on-policy",
+				Namespace:   "test-namespace",
+				Annotations: map[string]string{istioSidecarAnnotationPolicyKey: "true"},
+			},
+			want: false,
+		},
+		{
+			policy: InjectionPolicyOff,
+			meta: &metav1.ObjectMeta{
+				Name:        "force-off-policy",
+				Namespace:   "test-namespace",
+				Annotations: map[string]string{istioSidecarAnnotationPolicyKey: "false"},
+			},
+			want: false,
+		},
 	}
 
 	for _, c := range cases {
-		if got := injectRequired([]string{v1.NamespaceAll}, ignoredNamespaces, []string{}, c.policy, c.meta); got != c.want {
+		config := &Config{
+			Policy:            c.policy,
+			IncludeNamespaces: []string{v1.NamespaceAll},
+			ExcludeNamespaces: []string{},
+		}
+		if got := injectRequired(ignoredNamespaces, config, c.meta); got != c.want {
 			t.Errorf("injectRequired(%v, %v) got %v want %v", c.policy, c.meta, got, c.want)
 		}
 	}
 }
 
+func TestInjectRequiredExcludeSelectors(t *testing.T) {
+	config := &Config{
+		Policy:            InjectionPolicyEnabled,
+		IncludeNamespaces: []string{v1.NamespaceAll},
+		ExcludeSelectors: []metav1.LabelSelector{
+			{MatchLabels: map[string]string{"istio-injection": "skip"}},
+			{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"db", "cache"}},
+			}},
+		},
+	}
+
+	cases := []struct {
+		labels map[string]string
+		want   bool
+	}{
+		{labels: nil, want: true},
+		{labels: map[string]string{"app": "hello"}, want: true},
+		{labels: map[string]string{"istio-injection": "enabled"}, want: true},
+		{labels: map[string]string{"tier": "frontend"}, want: true},
+		{labels: map[string]string{"app": "hello", "istio-injection": "skip"}, want: false},
+		{labels: map[string]string{"tier": "cache"}, want: false},
+	}
+
+	for _, c := range cases {
+		meta := &metav1.ObjectMeta{
+			Name:      "labeled",
+			Namespace: "test-namespace",
+			Labels:    c.labels,
+		}
+		if got := injectRequired(ignoredNamespaces, config, meta); got != c.want {
+			t.Errorf("injectRequired(labels %v) got %v want %v", c.labels, got, c.want)
+		}
+	}
+}
+
+func TestInjectCustomAnnotationKeys(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := testConfig(&mesh)
+	config.Policy = InjectionPolicyDisabled
+	config.PolicyAnnotationKey = "sidecar.example.com/inject"
+	config.StatusAnnotationKey = "sidecar.example.com/status"
+
+	meta := &metav1.ObjectMeta{
+		Name:        "istio-annotated",
+		Namespace:   "test-namespace",
+		Annotations: map[string]string{istioSidecarAnnotationPolicyKey: "true"},
+	}
+	if injectRequired(ignoredNamespaces, config, meta) {
+		t.Errorf("injectRequired() honored the default policy annotation key")
+	}
+
+	in := &v1beta1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "custom-annotated",
+			Namespace:   "test-namespace",
+			Annotations: map[string]string{"sidecar.example.com/inject": "true"},
+		},
+	}
+	obj, err := intoObject(config, in)
+	if err != nil {
+		t.Fatalf("intoObject() returned an error: %v", err)
+	}
+	out := obj.(*v1beta1.Deployment)
+	if got := out.Annotations["sidecar.example.com/status"]; got != "injected-version-12345678" {
+		t.Errorf("custom status annotation is %q, want %q", got, "injected-version-12345678")
+	}
+	if _, ok := out.Annotations[istioSidecarAnnotationStatusKey]; ok {
+		t.Errorf("default status annotation set with custom annotation keys")
+	}
+	if len(out.Spec.Template.Spec.Containers) != 1 {
+		t.Errorf("sidecar not injected: got %d containers, want 1", len(out.Spec.Template.Spec.Containers))
+	}
+	if injectRequired(ignoredNamespaces, config, &out.ObjectMeta) {
+		t.Errorf("injectRequired() did not honor the custom status annotation key")
+	}
+}
+
+func TestInjectImagePullSecrets(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	params := &Params{
+		InitImage:        InitImageName(unitTestHub, unitTestTag, false),
+		ProxyImage:       ProxyImageName(unitTestHub, unitTestTag, false),
+		SidecarProxyUID:  DefaultSidecarProxyUID,
+		Mesh:             &mesh,
+		ImagePullSecrets: []string{"istio-registry", "shared-registry"},
+	}
+	spec := &v1.PodSpec{
+		ImagePullSecrets: []v1.LocalObjectReference{{Name: "app-registry"}, {Name: "shared-registry"}},
+	}
+
+	if err := injectIntoSpec(params, spec, &metav1.ObjectMeta{}, true); err != nil {
+		t.Fatalf("injectIntoSpec() returned an error: %v", err)
+	}
+
+	want := []v1.LocalObjectReference{{Name: "app-registry"}, {Name: "shared-registry"}, {Name: "istio-registry"}}
+	if !reflect.DeepEqual(spec.ImagePullSecrets, want) {
+		t.Errorf("injectIntoSpec() set imagePullSecrets %v, want %v", spec.ImagePullSecrets, want)
+	}
+}
+
+func TestSidecarOutdated(t *testing.T) {
+	cases := []struct {
+		status  string
+		version string
+		want    bool
+	}{
+		{status: "injected-version-0.4.0", version: "0.4.0", want: false},
+		{status: "injected-version-0.3.0", version: "0.4.0", want: true},
+		{status: "injected-version-0.4", version: "0.4.1", want: true},
+		{status: "injected-version-0.10.0", version: "0.9.0", want: false},
+		{status: "injected-version-12345678", version: "87654321", want: true},
+		{status: "injected-version-12345678", version: "12345678", want: false},
+		{status: "injected-version-0.3.0", version: "", want: false},
+		{status: "injected", version: "0.4.0", want: false},
+	}
+	for _, c := range cases {
+		if got := sidecarOutdated(c.status, c.version); got != c.want {
+			t.Errorf("sidecarOutdated(%q, %q) = %v, want %v", c.status, c.version, got, c.want)
+		}
+	}
+}
+
+func TestReinjectOutdatedSidecar(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := testConfig(&mesh)
+	config.Params.InitImage = InitImageName(unitTestHub, "0.3.0", false)
+	config.Params.ProxyImage = ProxyImageName(unitTestHub, "0.3.0", false)
+	config.Params.Version = "0.3.0"
+	in := &v1beta1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "test-namespace"},
+		Spec: v1beta1.DeploymentSpec{
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{Containers: []v1.Container{{Name: "hello", Image: "fake.docker.io/google-samples/hello-go-gke:1.0"}}},
+			},
+		},
+	}
+	obj, err := intoObject(config, in)
+	if err != nil {
+		t.Fatalf("intoObject() returned an error: %v", err)
+	}
+	injected := obj.(*v1beta1.Deployment)
+
+	if injectRequired(ignoredNamespaces, config, &injected.ObjectMeta) {
+		t.Errorf("injectRequired() = true for a sidecar at the current version")
+	}
+
+	config.Params.InitImage = InitImageName(unitTestHub, "0.4.0", false)
+	config.Params.ProxyImage = ProxyImageName(unitTestHub, "0.4.0", false)
+	config.Params.Version = "0.4.0"
+	if !injectRequired(ignoredNamespaces, config, &injected.ObjectMeta) {
+		t.Fatalf("injectRequired() = false for an outdated sidecar")
+	}
+
+	obj, err = intoObject(config, injected)
+	if err != nil {
+		t.Fatalf("intoObject() returned an error: %v", err)
+	}
+	out := obj.(*v1beta1.Deployment)
+	if got := out.Annotations[istioSidecarAnnotationStatusKey]; got != "injected-version-0.4.0" {
+		t.Errorf("status annotation is %q, want %q", got, "injected-version-0.4.0")
+	}
+
+	spec := out.Spec.Template.Spec
+	var proxies []v1.Container
+	for _, c := range spec.Containers {
+		if c.Name == ProxyContainerName {
+			proxies = append(proxies, c)
+		}
+	}
+	if len(proxies) != 1 {
+		t.Fatalf("got %d %s containers, want 1", len(proxies), ProxyContainerName)
+	}
+	if proxies[0].Image != config.Params.ProxyImage {
+		t.Errorf("proxy image is %q, want %q", proxies[0].Image, config.Params.ProxyImage)
+	}
+	if len(spec.Containers) != 2 || len(spec.InitContainers) != len(injected.Spec.Template.Spec.InitContainers) ||
+		len(spec.Volumes) != len(injected.Spec.Template.Spec.Volumes) {
+		t.Errorf("re-injection duplicated sidecar resources: %d containers, %d init containers, %d volumes",
+			len(spec.Containers), len(spec.InitContainers), len(spec.Volumes))
+	}
+}
+
 func TestGetMeshConfig(t *testing.T) {
 	_, cl := makeClient(t)
 	t.Parallel()
@@ -380,7 +981,7 @@ func TestGetMeshConfig(t *testing.T) {
 		if err != nil {
 			t.Fatalf("%v: Create failed: %v", c.name, err)
 		}
-		_, got, err := GetMeshConfig(cl, ns, c.queryName)
+		_, got, err := GetMeshConfig(cl, ns, c.queryName, RetryPolicy{})
 		gotErr := err != nil
 		if gotErr != c.wantErr {
 			t.Fatalf("%v: GetMeshConfig returned wrong error value: got %v want %v: err=%v", c.name, gotErr, c.wantErr, err)
@@ -397,24 +998,70 @@ func TestGetMeshConfig(t *testing.T) {
 	}
 }
 
-func TestGetInitializerConfig(t *testing.T) {
-	_, cl := makeClient(t)
-	t.Parallel()
-	ns, err := util.CreateNamespace(cl)
-	if err != nil {
-		t.Fatal(err.Error())
+func TestLoadMeshConfigFromFile(t *testing.T) {
+	defaults := model.DefaultMeshConfig()
+	custom := model.DefaultMeshConfig()
+	custom.IngressClass = "custom"
+
+	cases := []struct {
+		name    string
+		yaml    string
+		want    *meshconfig.MeshConfig
+		wantErr bool
+	}{
+		{name: "empty", yaml: "", want: &defaults},
+		{name: "valid", yaml: "ingressClass: custom\n", want: &custom},
+		{name: "malformed", yaml: "ingressClass: [custom\n", wantErr: true},
 	}
-	defer util.DeleteNamespace(cl, ns)
 
-	goodConfig := Config{
-		Policy:            InjectionPolicyDisabled,
-		InitializerName:   DefaultInitializerName,
-		IncludeNamespaces: []string{v1.NamespaceAll},
-		Params: Params{
-			InitImage:       InitImageName(unitTestHub, unitTestTag, false),
-			ProxyImage:      ProxyImageName(unitTestHub, unitTestTag, false),
-			SidecarProxyUID: 1234,
-			ImagePullPolicy: "Always",
+	for _, c := range cases {
+		tf, err := ioutil.TempFile("", "mesh_config")
+		if err != nil {
+			t.Fatalf("TempFile() failed: %v", err)
+		}
+		defer func() { _ = os.Remove(tf.Name()) }()
+		if _, err = tf.WriteString(c.yaml); err != nil {
+			t.Fatalf("%v: WriteString() failed: %v", c.name, err)
+		}
+		_ = tf.Close()
+
+		got, err := LoadMeshConfigFromFile(tf.Name())
+		if gotErr := err != nil; gotErr != c.wantErr {
+			t.Fatalf("%v: LoadMeshConfigFromFile returned wrong error value: got %v want %v: err=%v",
+				c.name, gotErr, c.wantErr, err)
+		}
+		if !c.wantErr && !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: LoadMeshConfigFromFile returned the wrong result: \ngot  %v \nwant %v", c.name, got, c.want)
+		}
+	}
+
+	if _, err := LoadMeshConfigFromFile("testdata/missing-mesh-config.yaml"); err == nil {
+		t.Errorf("LoadMeshConfigFromFile() accepted a missing file")
+	}
+}
+
+func TestGetInitializerConfig(t *testing.T) {
+	_, cl := makeClient(t)
+	t.Parallel()
+	ns, err := util.CreateNamespace(cl)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer util.DeleteNamespace(cl, ns)
+
+	drainSeconds := DefaultPreStopDrainSeconds
+	goodConfig := Config{
+		Policy:              InjectionPolicyDisabled,
+		InitializerName:     DefaultInitializerName,
+		IncludeNamespaces:   []string{v1.NamespaceAll},
+		PolicyAnnotationKey: "sidecar.example.com/inject",
+		StatusAnnotationKey: "sidecar.example.com/status",
+		Params: Params{
+			InitImage:           InitImageName(unitTestHub, unitTestTag, false),
+			ProxyImage:          ProxyImageName(unitTestHub, unitTestTag, false),
+			SidecarProxyUID:     1234,
+			ImagePullPolicy:     "Always",
+			PreStopDrainSeconds: &drainSeconds,
 		},
 	}
 	goodConfigYAML, err := yaml.Marshal(&goodConfig)
@@ -455,6 +1102,59 @@ func TestGetInitializerConfig(t *testing.T) {
 		t.Fatalf("Failed to create test config data: %v", err)
 	}
 
+	offConfig := Config{
+		Policy:              InjectionPolicyOff,
+		InitializerName:     DefaultInitializerName,
+		IncludeNamespaces:   []string{v1.NamespaceAll},
+		PolicyAnnotationKey: istioSidecarAnnotationPolicyKey,
+		StatusAnnotationKey: istioSidecarAnnotationStatusKey,
+		Params: Params{
+			InitImage:       InitImageName(unitTestHub, unitTestTag, false),
+			ProxyImage:      ProxyImageName(unitTestHub, unitTestTag, false),
+			SidecarProxyUID: 1234,
+			ImagePullPolicy: "Always",
+		},
+	}
+	offConfigYAML, err := yaml.Marshal(&offConfig)
+	if err != nil {
+		t.Fatalf("Failed to create test config data: %v", err)
+	}
+	// the unset preStopDrainSeconds is defaulted
+	offConfig.Params.PreStopDrainSeconds = &drainSeconds
+
+	noDrainSeconds := 0
+	noPreStopHookConfig := goodConfig
+	noPreStopHookConfig.Params.PreStopDrainSeconds = &noDrainSeconds
+	noPreStopHookConfigYAML, err := yaml.Marshal(&noPreStopHookConfig)
+	if err != nil {
+		t.Fatalf("Failed to create test config data: %v", err)
+	}
+
+	customImagesConfig := goodConfig
+	customImagesConfig.Params.InitImage = "proxy_init"
+	customImagesConfig.Params.ProxyImage = "myrepo/istio/proxyv2@sha256:a2b3c4d5e6f708192a3b4c5d6e7f8091"
+	customImagesConfigYAML, err := yaml.Marshal(&customImagesConfig)
+	if err != nil {
+		t.Fatalf("Failed to create test config data: %v", err)
+	}
+	wantCustomImagesConfig := customImagesConfig
+	wantCustomImagesConfig.Params.InitImage = InitImageName(version.Info.DockerHub, version.Info.Version, false)
+
+	badConfigWithInvalidExcludeSelector := Config{
+		Policy:            InjectionPolicyDisabled,
+		InitializerName:   DefaultInitializerName,
+		IncludeNamespaces: []string{v1.NamespaceAll},
+		ExcludeSelectors: []metav1.LabelSelector{
+			{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "tier", Operator: "Near", Values: []string{"db"}},
+			}},
+		},
+	}
+	badConfigWithInvalidExcludeSelectorYAML, err := yaml.Marshal(&badConfigWithInvalidExcludeSelector)
+	if err != nil {
+		t.Fatalf("Failed to create test config data: %v", err)
+	}
+
 	cases := []struct {
 		name      string
 		configMap *v1.ConfigMap
@@ -494,14 +1194,17 @@ func TestGetInitializerConfig(t *testing.T) {
 				},
 			},
 			want: Config{
-				Policy:            DefaultInjectionPolicy,
-				InitializerName:   DefaultInitializerName,
-				IncludeNamespaces: []string{v1.NamespaceAll},
+				Policy:              DefaultInjectionPolicy,
+				InitializerName:     DefaultInitializerName,
+				IncludeNamespaces:   []string{v1.NamespaceAll},
+				PolicyAnnotationKey: istioSidecarAnnotationPolicyKey,
+				StatusAnnotationKey: istioSidecarAnnotationStatusKey,
 				Params: Params{
-					InitImage:       InitImageName(version.Info.DockerHub, version.Info.Version, false),
-					ProxyImage:      ProxyImageName(version.Info.DockerHub, version.Info.Version, false),
-					SidecarProxyUID: DefaultSidecarProxyUID,
-					ImagePullPolicy: DefaultImagePullPolicy,
+					InitImage:           InitImageName(version.Info.DockerHub, version.Info.Version, false),
+					ProxyImage:          ProxyImageName(version.Info.DockerHub, version.Info.Version, false),
+					SidecarProxyUID:     DefaultSidecarProxyUID,
+					ImagePullPolicy:     DefaultImagePullPolicy,
+					PreStopDrainSeconds: &drainSeconds,
 				},
 			},
 		},
@@ -517,6 +1220,42 @@ func TestGetInitializerConfig(t *testing.T) {
 			},
 			want: goodConfig,
 		},
+		{
+			name:      "custom images",
+			queryName: "custom-images",
+			configMap: &v1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"},
+				ObjectMeta: metav1.ObjectMeta{Name: "custom-images"},
+				Data: map[string]string{
+					InitializerConfigMapKey: string(customImagesConfigYAML),
+				},
+			},
+			want: wantCustomImagesConfig,
+		},
+		{
+			name:      "policy off",
+			queryName: "off-config",
+			configMap: &v1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"},
+				ObjectMeta: metav1.ObjectMeta{Name: "off-config"},
+				Data: map[string]string{
+					InitializerConfigMapKey: string(offConfigYAML),
+				},
+			},
+			want: offConfig,
+		},
+		{
+			name:      "preStop hook disabled",
+			queryName: "no-prestop-hook-config",
+			configMap: &v1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"},
+				ObjectMeta: metav1.ObjectMeta{Name: "no-prestop-hook-config"},
+				Data: map[string]string{
+					InitializerConfigMapKey: string(noPreStopHookConfigYAML),
+				},
+			},
+			want: noPreStopHookConfig,
+		},
 		{
 			name:      "bad config with includeNamespaces and excludeNamespaces",
 			queryName: "bad-config-with-include-and-exclude-namespaces",
@@ -541,6 +1280,18 @@ func TestGetInitializerConfig(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name:      "bad config with invalid excludeSelectors",
+			queryName: "bad-config-with-invalid-exclude-selectors",
+			configMap: &v1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"},
+				ObjectMeta: metav1.ObjectMeta{Name: "bad-config-with-invalid-exclude-selectors"},
+				Data: map[string]string{
+					InitializerConfigMapKey: string(badConfigWithInvalidExcludeSelectorYAML),
+				},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, c := range cases {
@@ -548,7 +1299,7 @@ func TestGetInitializerConfig(t *testing.T) {
 		if err != nil {
 			t.Fatalf("%v: Create failed: %v", c.name, err)
 		}
-		got, err := GetInitializerConfig(cl, ns, c.queryName)
+		got, err := GetInitializerConfig(cl, ns, c.queryName, RetryPolicy{})
 		gotErr := err != nil
 		if gotErr != c.wantErr {
 			t.Fatalf("%v: GetMeshConfig returned wrong error value: got %v want %v: err=%v", c.name, gotErr, c.wantErr, err)
@@ -564,3 +1315,826 @@ func TestGetInitializerConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestInitializerConfigMap(t *testing.T) {
+	want := DefaultConfig("12345678", unitTestHub, unitTestTag)
+	configMap, err := InitializerConfigMap(want, "istio-system", DefaultInitializerConfigMapName)
+	if err != nil {
+		t.Fatalf("InitializerConfigMap() returned an error: %v", err)
+	}
+
+	cl := fake.NewSimpleClientset(configMap)
+	got, err := GetInitializerConfig(cl, "istio-system", DefaultInitializerConfigMapName, RetryPolicy{})
+	if err != nil {
+		t.Fatalf("GetInitializerConfig() rejected the generated ConfigMap: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetInitializerConfig() returned the wrong result: \ngot  %v \nwant %v", got, want)
+	}
+
+	bad := DefaultConfig("12345678", unitTestHub, unitTestTag)
+	bad.ExcludeNamespaces = []string{"kube-system"}
+	if _, err := InitializerConfigMap(bad, "istio-system", DefaultInitializerConfigMapName); err == nil {
+		t.Errorf("InitializerConfigMap() accepted both namespaces and excludeNamespaces")
+	}
+
+	bad = DefaultConfig("12345678", unitTestHub, unitTestTag)
+	bad.Params.Concurrency = -1
+	if _, err := InitializerConfigMap(bad, "istio-system", DefaultInitializerConfigMapName); err == nil {
+		t.Errorf("InitializerConfigMap() accepted a negative concurrency")
+	}
+
+	bad = DefaultConfig("12345678", unitTestHub, unitTestTag)
+	negative := -1
+	bad.Params.PreStopDrainSeconds = &negative
+	if _, err := InitializerConfigMap(bad, "istio-system", DefaultInitializerConfigMapName); err == nil {
+		t.Errorf("InitializerConfigMap() accepted a negative preStopDrainSeconds")
+	}
+}
+
+// flakyClientset returns a clientset holding the objects whose first
+// failures Gets of ConfigMaps fail, and the count of those Gets.
+func flakyClientset(failures int, objects ...runtime.Object) (*fake.Clientset, *int) {
+	cl := fake.NewSimpleClientset(objects...)
+	gets := 0
+	cl.PrependReactor("get", "configmaps", func(k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if gets <= failures {
+			return true, nil, fmt.Errorf("connection refused")
+		}
+		return false, nil, nil
+	})
+	return cl, &gets
+}
+
+func TestGetConfigRetry(t *testing.T) {
+	config := DefaultConfig("12345678", unitTestHub, unitTestTag)
+	initializerConfigMap, err := InitializerConfigMap(config, "istio-system", DefaultInitializerConfigMapName)
+	if err != nil {
+		t.Fatalf("InitializerConfigMap() returned an error: %v", err)
+	}
+	meshConfigMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "istio", Namespace: "istio-system"},
+		Data:       map[string]string{ConfigMapKey: ""},
+	}
+
+	retry := RetryPolicy{
+		InitialInterval: time.Millisecond,
+		MaxInterval:     2 * time.Millisecond,
+		MaxElapsedTime:  time.Minute,
+	}
+	cases := []struct {
+		name     string
+		failures int
+		retry    RetryPolicy
+		wantGets int
+		wantErr  bool
+	}{
+		{name: "no failure", retry: retry, wantGets: 1},
+		{name: "transient failures", failures: 3, retry: retry, wantGets: 4},
+		{name: "no retry", failures: 1, retry: RetryPolicy{}, wantGets: 1, wantErr: true},
+		{
+			name:     "failures beyond the elapsed time",
+			failures: 1000,
+			retry: RetryPolicy{
+				InitialInterval: 10 * time.Millisecond,
+				MaxInterval:     time.Second,
+				MaxElapsedTime:  15 * time.Millisecond,
+			},
+			wantGets: 2,
+			wantErr:  true,
+		},
+	}
+
+	for _, c := range cases {
+		cl, gets := flakyClientset(c.failures, initializerConfigMap)
+		_, err := GetInitializerConfig(cl, "istio-system", DefaultInitializerConfigMapName, c.retry)
+		if gotErr := err != nil; gotErr != c.wantErr {
+			t.Errorf("%v: GetInitializerConfig returned wrong error value: got %v want %v: err=%v", c.name, gotErr, c.wantErr, err)
+		}
+		if *gets != c.wantGets {
+			t.Errorf("%v: GetInitializerConfig made %d attempts, want %d", c.name, *gets, c.wantGets)
+		}
+
+		cl, gets = flakyClientset(c.failures, meshConfigMap)
+		_, _, err = GetMeshConfig(cl, "istio-system", "istio", c.retry)
+		if gotErr := err != nil; gotErr != c.wantErr {
+			t.Errorf("%v: GetMeshConfig returned wrong error value: got %v want %v: err=%v", c.name, gotErr, c.wantErr, err)
+		}
+		if *gets != c.wantGets {
+			t.Errorf("%v: GetMeshConfig made %d attempts, want %d", c.name, *gets, c.wantGets)
+		}
+	}
+}
+
+func TestInjectProxyEnv(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := testConfig(&mesh)
+	in := &v1beta1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "test-namespace"},
+		Spec: v1beta1.DeploymentSpec{
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						istioSidecarAnnotationProxyEnvKey: `{"ISTIO_META_TEAM": "payments", "ISTIO_META_TIER": "gold", "POD_NAME": "spoofed"}`,
+					},
+				},
+				Spec: v1.PodSpec{Containers: []v1.Container{{Name: "hello", Image: "fake.docker.io/google-samples/hello-go-gke:1.0"}}},
+			},
+		},
+	}
+	obj, err := intoObject(config, in)
+	if err != nil {
+		t.Fatalf("intoObject() returned an error: %v", err)
+	}
+
+	env := make(map[string][]string)
+	for _, c := range obj.(*v1beta1.Deployment).Spec.Template.Spec.Containers {
+		if c.Name != ProxyContainerName {
+			continue
+		}
+		for _, e := range c.Env {
+			env[e.Name] = append(env[e.Name], e.Value)
+		}
+	}
+
+	for name, want := range map[string]string{"ISTIO_META_TEAM": "payments", "ISTIO_META_TIER": "gold"} {
+		if got := env[name]; len(got) != 1 || got[0] != want {
+			t.Errorf("proxy env %s = %v, want [%s]", name, got, want)
+		}
+	}
+	if got := env["POD_NAME"]; len(got) != 1 || got[0] == "spoofed" {
+		t.Errorf("reserved proxy env POD_NAME = %v, want the template value only", got)
+	}
+}
+
+func TestInjectReadinessProbe(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := testConfig(&mesh)
+
+	cases := map[string]struct {
+		annotations map[string]string
+		wantProbe   bool
+	}{
+		"Default":  {wantProbe: true},
+		"Enabled":  {annotations: map[string]string{istioSidecarAnnotationReadinessProbeKey: "true"}, wantProbe: true},
+		"Disabled": {annotations: map[string]string{istioSidecarAnnotationReadinessProbeKey: "false"}},
+	}
+
+	for id, c := range cases {
+		in := &v1beta1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "test-namespace"},
+			Spec: v1beta1.DeploymentSpec{
+				Template: v1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Annotations: c.annotations},
+					Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "hello", Image: "fake.docker.io/google-samples/hello-go-gke:1.0"}}},
+				},
+			},
+		}
+		obj, err := intoObject(config, in)
+		if err != nil {
+			t.Fatalf("%s: intoObject() returned an error: %v", id, err)
+		}
+
+		for _, container := range obj.(*v1beta1.Deployment).Spec.Template.Spec.Containers {
+			if container.Name != ProxyContainerName {
+				if container.ReadinessProbe != nil {
+					t.Errorf("%s: readiness probe added to container %s", id, container.Name)
+				}
+				continue
+			}
+			probe := container.ReadinessProbe
+			if !c.wantProbe {
+				if probe != nil {
+					t.Errorf("%s: proxy readiness probe = %v, want none", id, probe)
+				}
+				continue
+			}
+			if probe == nil || probe.TCPSocket == nil {
+				t.Errorf("%s: proxy readiness probe = %v, want a TCP probe", id, probe)
+			} else if got := probe.TCPSocket.Port.IntValue(); got != int(mesh.ProxyListenPort) {
+				t.Errorf("%s: proxy readiness probe port = %d, want %d", id, got, mesh.ProxyListenPort)
+			}
+		}
+	}
+}
+
+func TestInjectPod(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := testConfig(&mesh)
+
+	cases := map[string]struct {
+		annotations map[string]string
+		hostNetwork bool
+		wantInject  bool
+	}{
+		"Inject":            {wantInject: true},
+		"Skip by policy":    {annotations: map[string]string{istioSidecarAnnotationPolicyKey: "false"}},
+		"Skip host network": {hostNetwork: true},
+	}
+
+	for id, c := range cases {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "test-namespace", Annotations: c.annotations},
+			Spec: v1.PodSpec{
+				HostNetwork: c.hostNetwork,
+				Containers:  []v1.Container{{Name: "hello", Image: "fake.docker.io/google-samples/hello-go-gke:1.0"}},
+			},
+		}
+		in := pod.DeepCopy()
+
+		out, err := InjectPod(config, pod)
+		if err != nil {
+			t.Fatalf("%s: InjectPod() returned an error: %v", id, err)
+		}
+		if !reflect.DeepEqual(pod, in) {
+			t.Errorf("%s: InjectPod() modified its input", id)
+		}
+
+		if !c.wantInject {
+			if !reflect.DeepEqual(out, in) {
+				t.Errorf("%s: InjectPod() modified a pod that should be skipped: %v", id, out)
+			}
+			continue
+		}
+
+		if got := out.Annotations[istioSidecarAnnotationStatusKey]; got != injectedVersionPrefix+config.Params.Version {
+			t.Errorf("%s: status annotation is %q, want %q", id, got, injectedVersionPrefix+config.Params.Version)
+		}
+		var proxies, inits int
+		for _, container := range out.Spec.Containers {
+			if container.Name == ProxyContainerName {
+				proxies++
+			}
+		}
+		for _, container := range out.Spec.InitContainers {
+			if container.Name == InitContainerName {
+				inits++
+			}
+		}
+		if proxies != 1 || inits != 1 {
+			t.Errorf("%s: got %d %s and %d %s containers, want 1 of each",
+				id, proxies, ProxyContainerName, inits, InitContainerName)
+		}
+	}
+
+	if _, err := InjectPod(config, nil); err == nil {
+		t.Errorf("InjectPod(nil) did not return an error")
+	}
+}
+
+func TestInjectProxyConcurrency(t *testing.T) {
+	cases := map[string]struct {
+		concurrency int
+		annotation  string
+		want        []string
+	}{
+		"Proxy default":       {},
+		"Cluster default":     {concurrency: 2, want: []string{"--concurrency", "2"}},
+		"Annotation override": {concurrency: 2, annotation: "4", want: []string{"--concurrency", "4"}},
+		"Annotation zero":     {concurrency: 2, annotation: "0"},
+		"Invalid annotation":  {concurrency: 2, annotation: "-1", want: []string{"--concurrency", "2"}},
+	}
+
+	for id, c := range cases {
+		mesh := model.DefaultMeshConfig()
+		config := testConfig(&mesh)
+		config.Params.Concurrency = c.concurrency
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "test-namespace"},
+			Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "hello", Image: "fake.docker.io/google-samples/hello-go-gke:1.0"}}},
+		}
+		if c.annotation != "" {
+			pod.Annotations = map[string]string{istioSidecarAnnotationProxyConcurrencyKey: c.annotation}
+		}
+
+		out, err := InjectPod(config, pod)
+		if err != nil {
+			t.Fatalf("%s: InjectPod() returned an error: %v", id, err)
+		}
+		for _, container := range out.Spec.Containers {
+			if container.Name != ProxyContainerName {
+				continue
+			}
+			var got []string
+			for i, arg := range container.Args {
+				if arg == "--concurrency" && i+1 < len(container.Args) {
+					got = append(got, arg, container.Args[i+1])
+				}
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%s: proxy concurrency args are %v, want %v", id, got, c.want)
+			}
+		}
+	}
+}
+
+func TestInjectPreStopHook(t *testing.T) {
+	drain := func(seconds int) []string {
+		return []string{"/bin/sh", "-c",
+			fmt.Sprintf("curl -s -X POST http://127.0.0.1:15000/healthcheck/fail; sleep %d", seconds)}
+	}
+	cases := map[string]struct {
+		seconds    int
+		annotation string
+		want       []string
+	}{
+		"Disabled":            {},
+		"Cluster default":     {seconds: DefaultPreStopDrainSeconds, want: drain(DefaultPreStopDrainSeconds)},
+		"Annotation override": {seconds: DefaultPreStopDrainSeconds, annotation: "20", want: drain(20)},
+		"Annotation zero":     {seconds: DefaultPreStopDrainSeconds, annotation: "0"},
+		"Invalid annotation":  {seconds: 10, annotation: "soon", want: drain(10)},
+	}
+
+	for id, c := range cases {
+		mesh := model.DefaultMeshConfig()
+		seconds := c.seconds
+		config := testConfig(&mesh)
+		config.Params.PreStopDrainSeconds = &seconds
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "test-namespace"},
+			Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "hello", Image: "fake.docker.io/google-samples/hello-go-gke:1.0"}}},
+		}
+		if c.annotation != "" {
+			pod.Annotations = map[string]string{istioSidecarAnnotationPreStopDrainSecondsKey: c.annotation}
+		}
+
+		out, err := InjectPod(config, pod)
+		if err != nil {
+			t.Fatalf("%s: InjectPod() returned an error: %v", id, err)
+		}
+		for _, container := range out.Spec.Containers {
+			var got []string
+			if container.Lifecycle != nil && container.Lifecycle.PreStop != nil && container.Lifecycle.PreStop.Exec != nil {
+				got = container.Lifecycle.PreStop.Exec.Command
+			}
+			if container.Name != ProxyContainerName {
+				if got != nil {
+					t.Errorf("%s: unexpected preStop hook on container %s: %v", id, container.Name, got)
+				}
+				continue
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("%s: proxy preStop hook is %v, want %v", id, got, c.want)
+			}
+		}
+	}
+}
+
+func TestSidecarConfigMerge(t *testing.T) {
+	base := SidecarConfig{
+		InitContainers: []v1.Container{{Name: "istio-init", Image: "init:1"}},
+		Containers:     []v1.Container{{Name: ProxyContainerName, Image: "proxy:1"}},
+		Volumes:        []v1.Volume{{Name: "istio-envoy"}, {Name: "istio-certs"}},
+	}
+
+	cases := map[string]struct {
+		overlay SidecarConfig
+		want    SidecarConfig
+	}{
+		"Empty overlay": {want: base},
+		"Disjoint": {
+			overlay: SidecarConfig{
+				InitContainers: []v1.Container{{Name: "log-init", Image: "log-init:1"}},
+				Containers:     []v1.Container{{Name: "logger", Image: "logger:1"}},
+				Volumes:        []v1.Volume{{Name: "logs"}},
+			},
+			want: SidecarConfig{
+				InitContainers: []v1.Container{{Name: "istio-init", Image: "init:1"}, {Name: "log-init", Image: "log-init:1"}},
+				Containers:     []v1.Container{{Name: ProxyContainerName, Image: "proxy:1"}, {Name: "logger", Image: "logger:1"}},
+				Volumes:        []v1.Volume{{Name: "istio-envoy"}, {Name: "istio-certs"}, {Name: "logs"}},
+			},
+		},
+		"Name collisions": {
+			overlay: SidecarConfig{
+				Containers: []v1.Container{{Name: "logger", Image: "logger:1"}, {Name: ProxyContainerName, Image: "proxy:2"}},
+				Volumes: []v1.Volume{{Name: "istio-envoy", VolumeSource: v1.VolumeSource{
+					EmptyDir: &v1.EmptyDirVolumeSource{Medium: v1.StorageMediumMemory}}}},
+			},
+			want: SidecarConfig{
+				InitContainers: []v1.Container{{Name: "istio-init", Image: "init:1"}},
+				Containers:     []v1.Container{{Name: ProxyContainerName, Image: "proxy:2"}, {Name: "logger", Image: "logger:1"}},
+				Volumes: []v1.Volume{{Name: "istio-envoy", VolumeSource: v1.VolumeSource{
+					EmptyDir: &v1.EmptyDirVolumeSource{Medium: v1.StorageMediumMemory}}}, {Name: "istio-certs"}},
+			},
+		},
+	}
+
+	for id, c := range cases {
+		if got := base.Merge(c.overlay); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: Merge() =>\n%+v\nwant\n%+v", id, got, c.want)
+		}
+	}
+	if base.Containers[0].Image != "proxy:1" {
+		t.Errorf("Merge() modified the base containers: %+v", base.Containers)
+	}
+}
+
+func TestInjectOverlayTemplate(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := testConfig(&mesh)
+	config.Params.OverlayTemplate = `
+containers:
+- name: logger
+  image: fake.docker.io/logger:{{ .MConfig.Version }}
+volumes:
+- name: istio-envoy
+  emptyDir: {}
+- name: logs
+  emptyDir: {}
+`
+	if err := config.validate(); err != nil {
+		t.Fatalf("validate() failed: %v", err)
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "test-namespace"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "hello", Image: "fake.docker.io/google-samples/hello-go-gke:1.0"}}},
+	}
+
+	out, err := InjectPod(config, pod)
+	if err != nil {
+		t.Fatalf("InjectPod() returned an error: %v", err)
+	}
+	var containers []string
+	for _, container := range out.Spec.Containers {
+		containers = append(containers, container.Name+"="+container.Image)
+	}
+	wantContainers := []string{
+		"hello=fake.docker.io/google-samples/hello-go-gke:1.0",
+		ProxyContainerName + "=" + config.Params.ProxyImage,
+		"logger=fake.docker.io/logger:12345678",
+	}
+	if !reflect.DeepEqual(containers, wantContainers) {
+		t.Errorf("injected containers are %v, want %v", containers, wantContainers)
+	}
+	var volumes []string
+	for _, volume := range out.Spec.Volumes {
+		volumes = append(volumes, volume.Name)
+	}
+	if want := []string{"istio-envoy", "istio-certs", "logs"}; !reflect.DeepEqual(volumes, want) {
+		t.Errorf("injected volumes are %v, want %v", volumes, want)
+	}
+	if medium := out.Spec.Volumes[0].EmptyDir.Medium; medium != v1.StorageMediumDefault {
+		t.Errorf("overlay did not replace the istio-envoy volume: medium is %q", medium)
+	}
+
+	config.Params.OverlayTemplate = "containers: {{ .Missing"
+	if err := config.validate(); err == nil {
+		t.Error("validate() accepted an invalid overlay template")
+	}
+}
+
+func TestValidateSidecarTemplate(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		errMsg   string
+	}{
+		{name: "production", template: productionTemplate},
+		{name: "overlay", template: "containers:\n- name: logger\n  image: logger:{{ .MConfig.Version }}\n"},
+		{name: "not parsing", template: "containers: {{ .Missing", errMsg: "unclosed action"},
+		{name: "not executing", template: "containers: {{ .Missing }}", errMsg: "can't evaluate field Missing"},
+		{name: "not unmarshaling", template: "containers: {{ .ServiceCluster }}", errMsg: "rendered template is not a sidecar config"},
+		{name: "empty", template: "# nothing to inject\n", errMsg: "rendered template has no init containers, containers or volumes"},
+	}
+	for _, c := range cases {
+		err := ValidateSidecarTemplate(c.template)
+		if c.errMsg == "" {
+			if err != nil {
+				t.Errorf("%v: ValidateSidecarTemplate() returned an error: %v", c.name, err)
+			}
+		} else if err == nil {
+			t.Errorf("%v: ValidateSidecarTemplate() accepted the template", c.name)
+		} else if !strings.Contains(err.Error(), c.errMsg) {
+			t.Errorf("%v: ValidateSidecarTemplate() returned %q, want it to contain %q", c.name, err, c.errMsg)
+		}
+	}
+}
+
+func TestGetInitializerConfigRejectsBadTemplate(t *testing.T) {
+	config := DefaultConfig("12345678", unitTestHub, unitTestTag)
+	config.Params.OverlayTemplate = "containers: {{ .ServiceCluster }}"
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	cl := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultInitializerConfigMapName, Namespace: "istio-system"},
+		Data:       map[string]string{InitializerConfigMapKey: string(data)},
+	})
+	_, err = GetInitializerConfig(cl, "istio-system", DefaultInitializerConfigMapName, RetryPolicy{})
+	if err == nil || !strings.HasPrefix(err.Error(), "invalid overlayTemplate: rendered template is not a sidecar config") {
+		t.Errorf("GetInitializerConfig() returned %v, want an invalid overlayTemplate error", err)
+	}
+}
+
+func TestResolveSidecarTemplate(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	mesh.DefaultConfig.ServiceCluster = "mesh-cluster"
+	config := &Config{
+		Params: Params{
+			ProxyImage:  ProxyImageName(unitTestHub, unitTestTag, false),
+			Mesh:        &mesh,
+			Concurrency: 2,
+		},
+	}
+
+	cases := map[string]struct {
+		labels map[string]string
+		want   string
+	}{
+		"Mesh default": {want: "mesh-cluster"},
+		"App label":    {labels: map[string]string{"app": "hello", "version": "v1"}, want: "hello"},
+		"Other labels": {labels: map[string]string{"name": "hello"}, want: "mesh-cluster"},
+	}
+
+	for id, c := range cases {
+		spec := &v1.PodSpec{Containers: []v1.Container{{Name: "hello"}}}
+		metadata := &metav1.ObjectMeta{Name: "hello", Labels: c.labels}
+
+		st, err := ResolveSidecarTemplate(config, spec, metadata)
+		if err != nil {
+			t.Fatalf("%s: ResolveSidecarTemplate() returned an error: %v", id, err)
+		}
+		if st.ServiceCluster != c.want {
+			t.Errorf("%s: ServiceCluster is %q, want %q", id, st.ServiceCluster, c.want)
+		}
+		if st.Spec != spec || st.MConfig != &config.Params {
+			t.Errorf("%s: template does not reference the pod spec and injection params", id)
+		}
+		if st.AuthPolicy != mesh.DefaultConfig.ControlPlaneAuthPolicy.String() || st.Concurrency != 2 {
+			t.Errorf("%s: AuthPolicy %q and Concurrency %d, want %q and 2",
+				id, st.AuthPolicy, st.Concurrency, mesh.DefaultConfig.ControlPlaneAuthPolicy.String())
+		}
+		if len(spec.Containers) != 1 || len(spec.InitContainers) != 0 || len(spec.Volumes) != 0 {
+			t.Errorf("%s: ResolveSidecarTemplate() modified the pod spec: %+v", id, spec)
+		}
+	}
+}
+
+func TestInjectNilMeshDefaultConfig(t *testing.T) {
+	config := testConfig(&meshconfig.MeshConfig{})
+	pod := &v1.Pod{
+		TypeMeta:   metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "test-namespace"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "hello", Image: "fake.docker.io/google-samples/hello-go-gke:1.0"}}},
+	}
+
+	if _, err := ResolveSidecarTemplate(config, &pod.Spec, &pod.ObjectMeta); err == nil {
+		t.Error("ResolveSidecarTemplate() succeeded without a mesh defaultConfig")
+	}
+	if _, err := InjectPod(config, pod); err == nil {
+		t.Error("InjectPod() succeeded without a mesh defaultConfig")
+	}
+	if _, err := intoObject(config, pod); err == nil {
+		t.Error("intoObject() succeeded without a mesh defaultConfig")
+	}
+
+	in, err := yaml.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := IntoResourceFile(config, bytes.NewReader(in), &out); err == nil {
+		t.Error("IntoResourceFile() succeeded without a mesh defaultConfig")
+	}
+	if len(pod.Spec.Containers) != 1 || len(pod.Annotations) != 0 {
+		t.Errorf("failed injection modified the pod: %+v", pod)
+	}
+}
+
+func TestInjectDNSConfig(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	cases := []struct {
+		name        string
+		params      Params
+		annotations map[string]string
+		dnsConfig   *v1.PodDNSConfig
+		want        *v1.PodDNSConfig
+	}{
+		{
+			name: "unset",
+		},
+		{
+			name:      "unset with pod DNS config",
+			dnsConfig: &v1.PodDNSConfig{Nameservers: []string{"10.0.0.1"}},
+			want:      &v1.PodDNSConfig{Nameservers: []string{"10.0.0.1"}},
+		},
+		{
+			name:   "params",
+			params: Params{DNSNameservers: []string{"10.0.0.10"}, DNSSearches: []string{"example.com"}},
+			want:   &v1.PodDNSConfig{Nameservers: []string{"10.0.0.10"}, Searches: []string{"example.com"}},
+		},
+		{
+			name:      "params merged with pod DNS config",
+			params:    Params{DNSNameservers: []string{"10.0.0.10"}, DNSSearches: []string{"example.com"}},
+			dnsConfig: &v1.PodDNSConfig{Nameservers: []string{"10.0.0.1", "10.0.0.10"}},
+			want: &v1.PodDNSConfig{
+				Nameservers: []string{"10.0.0.1", "10.0.0.10"},
+				Searches:    []string{"example.com"},
+			},
+		},
+		{
+			name: "annotations",
+			annotations: map[string]string{
+				istioSidecarAnnotationDNSNameserversKey: "10.0.0.20, 10.0.0.21",
+				istioSidecarAnnotationDNSSearchesKey:    "example.org",
+			},
+			want: &v1.PodDNSConfig{Nameservers: []string{"10.0.0.20", "10.0.0.21"}, Searches: []string{"example.org"}},
+		},
+		{
+			name:   "annotations override params",
+			params: Params{DNSNameservers: []string{"10.0.0.10"}, DNSSearches: []string{"example.com"}},
+			annotations: map[string]string{
+				istioSidecarAnnotationDNSNameserversKey: "10.0.0.20,not-an-ip",
+			},
+			want: &v1.PodDNSConfig{Nameservers: []string{"10.0.0.20"}, Searches: []string{"example.com"}},
+		},
+		{
+			name:        "annotations clear params",
+			params:      Params{DNSNameservers: []string{"10.0.0.10"}},
+			annotations: map[string]string{istioSidecarAnnotationDNSNameserversKey: ""},
+		},
+	}
+
+	for _, c := range cases {
+		c.params.InitImage = InitImageName(unitTestHub, unitTestTag, false)
+		c.params.ProxyImage = ProxyImageName(unitTestHub, unitTestTag, false)
+		c.params.SidecarProxyUID = DefaultSidecarProxyUID
+		c.params.Version = "12345678"
+		c.params.Mesh = &mesh
+		config := &Config{
+			Policy:            InjectionPolicyEnabled,
+			IncludeNamespaces: []string{v1.NamespaceAll},
+			Params:            c.params,
+		}
+		if err := config.validate(); err != nil {
+			t.Fatalf("%s: validate() failed: %v", c.name, err)
+		}
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "test-namespace", Annotations: c.annotations},
+			Spec: v1.PodSpec{
+				Containers: []v1.Container{{Name: "hello", Image: "fake.docker.io/google-samples/hello-go-gke:1.0"}},
+				DNSPolicy:  v1.DNSClusterFirst,
+				DNSConfig:  c.dnsConfig,
+			},
+		}
+
+		out, err := InjectPod(config, pod)
+		if err != nil {
+			t.Fatalf("%s: InjectPod() returned an error: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(out.Spec.DNSConfig, c.want) {
+			t.Errorf("%s: injected DNS config is %+v, want %+v", c.name, out.Spec.DNSConfig, c.want)
+		}
+		if out.Spec.DNSPolicy != v1.DNSClusterFirst {
+			t.Errorf("%s: injection changed the DNS policy to %q", c.name, out.Spec.DNSPolicy)
+		}
+	}
+}
+
+func TestValidateDNSConfig(t *testing.T) {
+	cases := map[string]Params{
+		"invalid nameserver": {DNSNameservers: []string{"dns.example.com"}},
+		"empty search":       {DNSSearches: []string{""}},
+	}
+	for name, params := range cases {
+		config := &Config{Policy: InjectionPolicyEnabled, Params: params}
+		if err := config.validate(); err == nil {
+			t.Errorf("%s: validate() accepted %+v", name, params)
+		}
+	}
+}
+
+func TestInjectExcludeInboundPorts(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	cases := []struct {
+		name        string
+		ports       []int32
+		annotations map[string]string
+		want        string
+	}{
+		{name: "unset"},
+		{name: "params", ports: []int32{9100, 9101}, want: "9100,9101"},
+		{
+			name:        "annotation",
+			annotations: map[string]string{istioSidecarAnnotationExcludeInboundPortsKey: "9100, 8080"},
+			want:        "9100,8080",
+		},
+		{
+			name:        "annotation overrides params",
+			ports:       []int32{9100},
+			annotations: map[string]string{istioSidecarAnnotationExcludeInboundPortsKey: "8080"},
+			want:        "8080",
+		},
+		{
+			name:        "annotation clears params",
+			ports:       []int32{9100},
+			annotations: map[string]string{istioSidecarAnnotationExcludeInboundPortsKey: ""},
+		},
+		{
+			name:        "invalid annotation",
+			ports:       []int32{9100},
+			annotations: map[string]string{istioSidecarAnnotationExcludeInboundPortsKey: "8080,70000"},
+			want:        "9100",
+		},
+	}
+
+	for _, c := range cases {
+		config := testConfig(&mesh)
+		config.Params.ExcludeInboundPorts = c.ports
+		if err := config.validate(); err != nil {
+			t.Fatalf("%s: validate() failed: %v", c.name, err)
+		}
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "test-namespace", Annotations: c.annotations},
+			Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "hello", Image: "fake.docker.io/google-samples/hello-go-gke:1.0"}}},
+		}
+
+		out, err := InjectPod(config, pod)
+		if err != nil {
+			t.Fatalf("%s: InjectPod() returned an error: %v", c.name, err)
+		}
+		var args []string
+		for _, container := range out.Spec.InitContainers {
+			if container.Name == InitContainerName {
+				args = container.Args
+			}
+		}
+		got := ""
+		for i, arg := range args {
+			if arg == "-x" && i+1 < len(args) {
+				got = args[i+1]
+			}
+		}
+		if got != c.want {
+			t.Errorf("%s: init container args %v exclude inbound ports %q, want %q", c.name, args, got, c.want)
+		}
+	}
+}
+
+func TestValidateExcludeInboundPorts(t *testing.T) {
+	for _, port := range []int32{-1, 0, 65536} {
+		config := &Config{Policy: InjectionPolicyEnabled, Params: Params{ExcludeInboundPorts: []int32{9100, port}}}
+		if err := config.validate(); err == nil {
+			t.Errorf("validate() accepted excluded inbound port %d", port)
+		}
+	}
+	config := &Config{Policy: InjectionPolicyEnabled, Params: Params{ExcludeInboundPorts: []int32{1, 65535}}}
+	if err := config.validate(); err != nil {
+		t.Errorf("validate() rejected valid excluded inbound ports: %v", err)
+	}
+}
+
+func TestInjectCollidingNames(t *testing.T) {
+	mesh := model.DefaultMeshConfig()
+	config := testConfig(&mesh)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "hello", Namespace: "test-namespace"},
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{Name: InitContainerName, Image: "fake.docker.io/init:1.0"}},
+			Containers: []v1.Container{
+				{Name: "hello", Image: "fake.docker.io/google-samples/hello-go-gke:1.0"},
+				{Name: ProxyContainerName, Image: "fake.docker.io/proxy:1.0"},
+			},
+			Volumes: []v1.Volume{
+				{Name: "data", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+				{Name: certsVolumeName, VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: "/certs"}}},
+			},
+		},
+	}
+
+	out, err := InjectPod(config, pod)
+	if err != nil {
+		t.Fatalf("InjectPod() returned an error: %v", err)
+	}
+
+	var initContainers []string
+	for _, c := range out.Spec.InitContainers {
+		initContainers = append(initContainers, c.Name+"="+c.Image)
+	}
+	if want := []string{InitContainerName + "=" + config.Params.InitImage}; !reflect.DeepEqual(initContainers, want) {
+		t.Errorf("injected init containers are %v, want %v", initContainers, want)
+	}
+	var containers []string
+	for _, c := range out.Spec.Containers {
+		containers = append(containers, c.Name+"="+c.Image)
+	}
+	wantContainers := []string{
+		"hello=fake.docker.io/google-samples/hello-go-gke:1.0",
+		ProxyContainerName + "=" + config.Params.ProxyImage,
+	}
+	if !reflect.DeepEqual(containers, wantContainers) {
+		t.Errorf("injected containers are %v, want %v", containers, wantContainers)
+	}
+	var volumes []string
+	for _, v := range out.Spec.Volumes {
+		volumes = append(volumes, v.Name)
+	}
+	if want := []string{"data", envoyVolumeName, certsVolumeName}; !reflect.DeepEqual(volumes, want) {
+		t.Errorf("injected volumes are %v, want %v", volumes, want)
+	}
+	if certs := out.Spec.Volumes[2]; certs.Secret == nil || certs.HostPath != nil {
+		t.Errorf("the %s volume of the pod was not replaced by the injected one: %+v", certsVolumeName, certs)
+	}
+}
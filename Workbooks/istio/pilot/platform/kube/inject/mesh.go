@@ -28,6 +28,10 @@ initContainers:
   - "-i"
   - {{ printf "%v" .MConfig.IncludeIPRanges }}
   {{ end -}}
+  {{ if ne .ExcludeInboundPorts "" -}}
+  - "-x"
+  - {{ printf "%q" .ExcludeInboundPorts }}
+  {{ end -}}
   {{ if eq .MConfig.ImagePullPolicy "" -}}
   imagePullPolicy: {{ "IfNotPresent" }}
   {{ else -}}
@@ -93,6 +97,10 @@ containers:
   - {{ printf "%v" .MConfig.Mesh.DefaultConfig.ProxyAdminPort }}
   - --controlPlaneAuthPolicy
   - {{ printf "%s"  .AuthPolicy }}
+  {{ if gt .Concurrency 0 -}}
+  - --concurrency
+  - {{ printf "%v" .Concurrency }}
+  {{ end -}}
   env:
   - name: POD_NAME
     valueFrom:
@@ -0,0 +1,107 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inject
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	metricsNamespace  = "pilot"
+	metricsSubsystem  = "sidecar_initializer"
+	metricLabelKind   = "kind"
+	metricLabelResult = "result"
+
+	// results of initializing an object pending the sidecar initializer
+	resultInjected = "injected"
+	resultSkipped  = "skipped"
+	resultFailed   = "failed"
+	resultObserved = "observed"
+)
+
+var (
+	initializeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "initialize_duration_seconds",
+			Help:      "Histogram of the time taken to initialize an object pending the sidecar initializer",
+		}, []string{metricLabelKind})
+	injectionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "injections",
+			Help:      "Counter of objects initialized by the sidecar initializer, by result",
+		}, []string{metricLabelKind, metricLabelResult})
+	pendingGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: metricsSubsystem,
+			Name:      "pending",
+			Help:      "Number of objects waiting for the sidecar initializer",
+		}, []string{metricLabelKind})
+)
+
+func init() {
+	prometheus.MustRegister(initializeDuration)
+	prometheus.MustRegister(injectionCounter)
+	prometheus.MustRegister(pendingGauge)
+}
+
+// pendingObjects tracks the objects of each kind whose next pending
+// initializer is the sidecar initializer.
+type pendingObjects struct {
+	mutex sync.Mutex
+	keys  map[string]map[string]bool
+}
+
+// update records whether obj is pending the named initializer, and
+// updates the pending gauge of its kind.
+func (p *pendingObjects) update(initializerName, kind string, obj interface{}, deleted bool) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return
+	}
+
+	pending := false
+	if !deleted {
+		if accessor, err := meta.Accessor(obj); err == nil {
+			if initializers := accessor.GetInitializers(); initializers != nil &&
+				len(initializers.Pending) > 0 && initializers.Pending[0].Name == initializerName {
+				pending = true
+			}
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.keys == nil {
+		p.keys = make(map[string]map[string]bool)
+	}
+	if p.keys[kind] == nil {
+		p.keys[kind] = make(map[string]bool)
+	}
+	if pending {
+		p.keys[kind][key] = true
+	} else {
+		delete(p.keys[kind], key)
+	}
+	pendingGauge.WithLabelValues(kind).Set(float64(len(p.keys[kind])))
+}
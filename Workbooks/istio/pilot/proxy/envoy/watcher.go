@@ -238,13 +238,17 @@ type envoy struct {
 	extraArgs []string
 }
 
-// NewProxy creates an instance of the proxy control commands
-func NewProxy(config meshconfig.ProxyConfig, node string, logLevel string) proxy.Proxy {
+// NewProxy creates an instance of the proxy control commands. A zero
+// concurrency leaves the number of envoy worker threads to its default.
+func NewProxy(config meshconfig.ProxyConfig, node string, logLevel string, concurrency int) proxy.Proxy {
 	// inject tracing flag for higher levels
 	var args []string
 	if logLevel != "" {
 		args = append(args, "-l", logLevel)
 	}
+	if concurrency > 0 {
+		args = append(args, "--concurrency", fmt.Sprint(concurrency))
+	}
 
 	return envoy{
 		config:    config,
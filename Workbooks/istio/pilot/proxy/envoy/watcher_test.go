@@ -326,8 +326,8 @@ func TestEnvoyArgs(t *testing.T) {
 	config.ServiceCluster = "my-cluster"
 	config.AvailabilityZone = "my-zone"
 
-	test := envoy{config: config, node: "my-node", extraArgs: []string{"-l", "trace"}}
-	testProxy := NewProxy(config, "my-node", "trace")
+	test := envoy{config: config, node: "my-node", extraArgs: []string{"-l", "trace", "--concurrency", "2"}}
+	testProxy := NewProxy(config, "my-node", "trace", 2)
 	if !reflect.DeepEqual(testProxy, test) {
 		t.Errorf("unexpected struct got\n%v\nwant\n%v", testProxy, test)
 	}
@@ -342,6 +342,7 @@ func TestEnvoyArgs(t *testing.T) {
 		"--service-node", "my-node",
 		"--max-obj-name-len", fmt.Sprint(MaxClusterNameLength), // TODO: use MeshConfig.StatNameLength instead
 		"-l", "trace",
+		"--concurrency", "2",
 		"--service-zone", "my-zone",
 	}
 	if !reflect.DeepEqual(got, want) {
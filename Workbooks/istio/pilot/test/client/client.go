@@ -25,6 +25,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -86,6 +87,17 @@ func makeHTTPRequest(client *http.Client) func(int) func() error {
 
 			log.Printf("[%d] StatusCode=%d\n", i, resp.StatusCode)
 
+			keys := make([]string, 0, len(resp.Header))
+			for key := range resp.Header {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				for _, value := range resp.Header[key] {
+					log.Printf("[%d] ResponseHeader=%s:%s\n", i, key, value)
+				}
+			}
+
 			data, err := ioutil.ReadAll(resp.Body)
 			defer func() {
 				if err = resp.Body.Close(); err != nil {
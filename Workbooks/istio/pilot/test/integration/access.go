@@ -55,7 +55,7 @@ func (a *accessLogs) add(app, id, desc string) {
 }
 
 // check logs against a deployment
-func (a *accessLogs) check(infra *infra) error {
+func (a *accessLogs) check(infra *infra, budget int) error {
 	if !infra.checkLogs {
 		log.Info("Log checking is disabled")
 		return nil
@@ -112,5 +112,5 @@ func (a *accessLogs) check(infra *infra) error {
 			}
 		})(app)
 	}
-	return parallel(funcs)
+	return parallel(funcs, budget)
 }
@@ -42,28 +42,42 @@ func (r *authExclusion) run() error {
 func (r *authExclusion) makeRequests() error {
 	// fake-control service doesn't have sidecar, and is excluded from mTLS so
 	// client with sidecar should never use mTLS when talking to it. As the result,
-	// all request will works, as if mesh authentication is NONE.
+	// all request will works, as if mesh authentication is NONE. This is checked over both
+	// HTTP and gRPC (h2c).
 	srcPods := []string{"a", "b", "t"}
 	dst := "fake-control"
 
-	funcs := make(map[string]func() status)
-	for _, src := range srcPods {
-		for _, port := range []string{"", ":80", ":8080"} {
-			for _, domain := range []string{"", "." + r.Namespace} {
-				name := fmt.Sprintf("Request from %s to %s%s%s", src, dst, domain, port)
-				funcs[name] = (func(src, dst, port, domain string) func() status {
-					url := fmt.Sprintf("http://%s%s%s/%s", dst, domain, port, src)
-					return func() status {
-						resp := r.clientRequest(src, url, 1, "")
-						// Request should return successfully (status 200)
-						if len(resp.code) > 0 && resp.code[0] == "200" {
-							return nil
-						}
-						return errAgain
-					}
-				})(src, dst, port, domain)
+	domains := []string{"", "." + r.Namespace}
+
+	funcs := buildRequestMatrix("Request", srcPods, []string{dst}, []string{"", ":80", ":8080"}, domains,
+		func(src, dst, port, domain string) func() status {
+			url := fmt.Sprintf("http://%s%s%s/%s", dst, domain, port, src)
+			return func() status {
+				resp := r.clientRequest(src, url, 1, "")
+				// Request should return successfully (status 200)
+				if len(resp.code) > 0 && resp.code[0] == "200" {
+					return nil
+				}
+				return resp.retry()
+			}
+		})
+
+	// The same holds for gRPC: a sidecar that starts using mTLS towards the excluded service
+	// makes these requests fail
+	grpcFuncs := buildRequestMatrix("GRPC request", srcPods, []string{dst}, []string{":70", ":7070"}, domains,
+		func(src, dst, port, domain string) func() status {
+			url := fmt.Sprintf("grpc://%s%s%s", dst, domain, port)
+			return func() status {
+				resp := r.clientRequest(src, url, 1, "")
+				// The echo server reports its version only if the call succeeded
+				if len(resp.version) > 0 && resp.version[0] == "fake-control" {
+					return nil
+				}
+				return resp.retry()
 			}
-		}
+		})
+	for name, f := range grpcFuncs {
+		funcs[name] = f
 	}
-	return parallel(funcs)
+	return parallel(funcs, budgetFor(r))
 }
@@ -0,0 +1,127 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Workload certificate expiry tests
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/istio/pilot/model"
+	"istio.io/istio/pilot/platform/kube/inject"
+	"istio.io/istio/pilot/test/util"
+	"istio.io/istio/pkg/log"
+)
+
+// certExpiry checks that the workload certificate mounted in every proxy of the apps is valid for
+// longer than threshold, i.e. that the CA issues certificates and rotates them before they expire.
+type certExpiry struct {
+	*infra
+	threshold time.Duration
+}
+
+func (t *certExpiry) String() string {
+	return "cert-expiry"
+}
+
+func (t *certExpiry) setup() error {
+	return nil
+}
+
+func (t *certExpiry) teardown() {}
+
+func (t *certExpiry) run() error {
+	funcs := make(map[string]func() status)
+	for app, pods := range t.apps {
+		for _, name := range pods {
+			pod, err := t.findPod(name)
+			if err != nil {
+				return err
+			}
+			if !hasContainer(pod, inject.ProxyContainerName) {
+				log.Infof("Skipping the certificate of %s (app %s) without proxy", name, app)
+				continue
+			}
+			funcs[fmt.Sprintf("Checking certificate expiry of %s", name)] = t.checkPod(pod)
+		}
+	}
+	return parallel(funcs, budgetFor(t))
+}
+
+// findPod returns the pod with the given name in the app or the istio namespace
+func (t *certExpiry) findPod(name string) (*v1.Pod, error) {
+	pod, err := client.CoreV1().Pods(t.Namespace).Get(name, meta_v1.GetOptions{})
+	if err == nil {
+		return pod, nil
+	}
+	if pod, err = client.CoreV1().Pods(t.IstioNamespace).Get(name, meta_v1.GetOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to find pod %s in namespaces %s and %s: %v",
+			name, t.Namespace, t.IstioNamespace, err)
+	}
+	return pod, nil
+}
+
+func hasContainer(pod *v1.Pod, name string) bool {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPod returns a check of the certificate chain mounted in the proxy of pod. Reading the
+// certificate is retried, since the node agent or CA may not have provisioned it yet, but a
+// certificate close to expiry fails the check right away.
+func (t *certExpiry) checkPod(pod *v1.Pod) func() status {
+	certFile := model.AuthCertsPath + model.CertChainFilename
+	cmd := fmt.Sprintf("kubectl exec %s --kubeconfig %s -n %s -c %s -- cat %s",
+		pod.Name, kubeconfig, pod.Namespace, inject.ProxyContainerName, certFile)
+	return func() status {
+		out, err := util.ShellTimeout(cmd, requestTimeout)
+		if err == util.ErrTimeout {
+			return errTimeout
+		} else if err != nil {
+			log.Infof("Failed to read %s in %s: %v", certFile, pod.Name, err)
+			return errAgain
+		}
+		return checkCertExpiry([]byte(out), time.Now(), t.threshold)
+	}
+}
+
+// checkCertExpiry returns an error if the first certificate of a PEM encoded chain is not valid
+// for at least threshold after now
+func checkCertExpiry(chain []byte, now time.Time, threshold time.Duration) error {
+	block, _ := pem.Decode(chain)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return fmt.Errorf("no PEM encoded certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse the certificate: %v", err)
+	}
+	if remaining := cert.NotAfter.Sub(now); remaining <= 0 {
+		return fmt.Errorf("certificate expired at %v", cert.NotAfter)
+	} else if remaining < threshold {
+		return fmt.Errorf("certificate expires at %v, within %v of %v", cert.NotAfter, threshold, now)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestCheckCertExpiry(t *testing.T) {
+	now := time.Now()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{Organization: []string{"istio.io"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(2 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	cases := []struct {
+		name      string
+		chain     []byte
+		now       time.Time
+		threshold time.Duration
+		wantErr   bool
+	}{
+		{name: "valid", chain: chain, now: now, threshold: time.Hour},
+		{name: "within threshold", chain: chain, now: now, threshold: 3 * time.Hour, wantErr: true},
+		{name: "expired", chain: chain, now: now.Add(3 * time.Hour), wantErr: true},
+		{name: "not PEM", chain: []byte("error: container not found"), now: now, wantErr: true},
+		{name: "not a certificate", chain: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("junk")}),
+			now: now, wantErr: true},
+	}
+	for _, c := range cases {
+		err := checkCertExpiry(c.chain, c.now, c.threshold)
+		if c.wantErr && err == nil {
+			t.Errorf("%s: checkCertExpiry() succeeded, want an error", c.name)
+		} else if !c.wantErr && err != nil {
+			t.Errorf("%s: checkCertExpiry() returned an error: %v", c.name, err)
+		}
+	}
+}
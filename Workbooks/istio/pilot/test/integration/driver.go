@@ -23,8 +23,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"text/template"
 	"time"
 
@@ -32,7 +37,6 @@ import (
 	"github.com/golang/glog"
 	// TODO(nmittler): Remove this
 	_ "github.com/golang/glog"
-	"github.com/golang/sync/errgroup"
 	multierror "github.com/hashicorp/go-multierror"
 	"k8s.io/client-go/kubernetes"
 
@@ -52,17 +56,41 @@ var (
 	verbose  bool
 	count    int
 
+	// number of independent tests to run concurrently
+	parallelTests int
+
+	// default retry budget for tests that do not advertise their own
+	budget int
+
+	// bound on each request exec'd in a client pod
+	requestTimeout time.Duration
+
+	// Check the workload certificates of the proxies, failing if any expires within the threshold
+	certExpiryCheck     bool
+	certExpiryThreshold time.Duration
+
 	// The particular test to run, e.g. "HTTP reachability" or "routing rules"
 	testType string
 
+	// Regular expressions selecting the tests to run and to skip by name
+	runFilter  string
+	skipFilter string
+
 	kubeconfig string
 	client     kubernetes.Interface
+
+	// Namespace of apps deployed by an earlier run to test again
+	reuseNamespace string
+
+	// Only validate the configuration and templates, without touching the cluster
+	validateOnly bool
+
+	// JUnit XML report destination (empty to disable)
+	junitOut string
+	report   junitReport
 )
 
 const (
-	// retry budget
-	budget = 90
-
 	mixerConfigFile     = "/etc/istio/proxy/envoy_mixer.json"
 	mixerConfigAuthFile = "/etc/istio/proxy/envoy_mixer_auth.json"
 
@@ -80,16 +108,31 @@ func init() {
 	flag.StringVar(&params.Registry, "registry", string(platform.KubernetesRegistry), "Pilot registry")
 	flag.BoolVar(&verbose, "verbose", false, "Debug level noise from proxies")
 	flag.BoolVar(&params.checkLogs, "logs", true, "Validate pod logs (expensive in long-running tests)")
+	flag.BoolVar(&params.streamLogs, "stream-logs", false,
+		"Continuously stream pod logs to the error logs directory for the duration of the run")
 
 	flag.StringVar(&kubeconfig, "kubeconfig", os.Getenv("KUBECONFIG"),
 		"kube config file (missing or empty file makes the test use in-cluster kube config instead)")
 	flag.IntVar(&count, "count", 1, "Number of times to run the tests after deploying")
+	flag.IntVar(&parallelTests, "parallel-tests", 1,
+		"Number of independent tests to run concurrently (tests mutating shared config always run serially)")
+	flag.DurationVar(&requestTimeout, "request-timeout", 30*time.Second,
+		"Maximum duration of each client request made by the tests")
+	flag.IntVar(&budget, "budget", 90, "Default number of attempts for each check (tests may override)")
+	flag.BoolVar(&certExpiryCheck, "cert-expiry", false,
+		"Check that the workload certificate of every proxy is not about to expire (slow, execs in each app pod)")
+	flag.DurationVar(&certExpiryThreshold, "cert-expiry-threshold", time.Hour,
+		"Minimum remaining validity of the workload certificates checked with --cert-expiry")
 	flag.StringVar(&authmode, "auth", "both", "Enable / disable auth, or test both.")
 	flag.BoolVar(&params.Mixer, "mixer", true, "Enable / disable mixer.")
+	flag.StringVar(&junitOut, "junit-out", "", "Write a JUnit XML report of the test results to this file")
 	flag.StringVar(&params.errorLogsDir, "errorlogsdir", "", "Store per pod logs as individual files in specific directory instead of writing to stderr.")
 
 	// If specified, only run one test
 	flag.StringVar(&testType, "testtype", "", "Select test to run (default is all tests)")
+	flag.StringVar(&runFilter, "run", "", "Only run tests whose name matches this regular expression")
+	flag.StringVar(&skipFilter, "skip", "",
+		"Skip tests whose name matches this regular expression (takes precedence over --run)")
 
 	// Keep disabled until default no-op initializer is distributed
 	// and running in test clusters.
@@ -105,6 +148,13 @@ func init() {
 	flag.BoolVar(&params.debugImagesAndMode, "debug", true, "Use debug images and mode (false for prod)")
 	flag.BoolVar(&params.SkipCleanup, "skip-cleanup", false, "Debug, skip clean up")
 	flag.BoolVar(&params.SkipCleanupOnFailure, "skip-cleanup-on-failure", false, "Debug, skip clean up on failure")
+	flag.StringVar(&reuseNamespace, "reuse-namespace", "",
+		"Debug, run the tests against the apps already deployed in this namespace, without setup or teardown "+
+			"(Istio components are expected in --ns, which defaults to the same namespace)")
+	flag.BoolVar(&params.Hold, "hold", false,
+		"Debug, keep the infrastructure up after the tests until interrupted, then clean up")
+	flag.BoolVar(&validateOnly, "validate-only", false,
+		"Check the flags and render all testdata templates and test configs, then exit without touching the cluster")
 }
 
 type test interface {
@@ -114,6 +164,83 @@ type test interface {
 	teardown()
 }
 
+// budgeted is implemented by tests whose checks need a retry budget other than the --budget default,
+// e.g. tests that depend on external hosts or on asynchronous trace collection.
+type budgeted interface {
+	retryBudget() int
+}
+
+// repeatBudgeted is implemented by tests whose per-case repeat needs a budget other than
+// defaultRepeatBudget, e.g. when each case waits for a rule to reach external hosts.
+type repeatBudgeted interface {
+	repeatBudget() int
+}
+
+// serial is implemented by tests that mutate shared routing config and therefore must not run
+// concurrently with any other test.
+type serial interface {
+	serial()
+}
+
+// templated is implemented by tests that fill testdata templates, so that --validate-only can render
+// them without deploying anything.
+type templated interface {
+	templates() []testdataTemplate
+}
+
+// registrySpecific is implemented by tests that do not apply to every service registry.
+type registrySpecific interface {
+	// unsupported returns why the test does not apply to the registry, or the empty string if it does.
+	unsupported(registry platform.ServiceRegistry) string
+}
+
+// budgetFor returns the retry budget a test should pass to parallel: the budget advertised by the test
+// if it implements budgeted with a positive value, the --budget flag value otherwise.
+func budgetFor(t interface{}) int {
+	if b, ok := t.(budgeted); ok && b.retryBudget() > 0 {
+		return b.retryBudget()
+	}
+	return budget
+}
+
+// defaultRepeatBudget is the number of times a test case is repeated when the test does not advertise its own
+const defaultRepeatBudget = 3
+
+// repeatBudgetFor returns the budget a test should pass to repeat for each of its cases: the budget advertised
+// by the test if it implements repeatBudgeted with a positive value, defaultRepeatBudget otherwise.
+func repeatBudgetFor(t interface{}) int {
+	if b, ok := t.(repeatBudgeted); ok && b.repeatBudget() > 0 {
+		return b.repeatBudget()
+	}
+	return defaultRepeatBudget
+}
+
+// registrySkipReason returns why a test does not apply to the registry pilot is deployed with, or the
+// empty string if the test should run.
+func registrySkipReason(t interface{}, registry platform.ServiceRegistry) string {
+	if r, ok := t.(registrySpecific); ok {
+		return r.unsupported(registry)
+	}
+	return ""
+}
+
+// registries are the service registries the driver can deploy pilot with
+var registries = []platform.ServiceRegistry{
+	platform.KubernetesRegistry,
+	platform.ConsulRegistry,
+	platform.EurekaRegistry,
+}
+
+// parseRegistry returns the registry named by the --registry flag, ignoring case.
+func parseRegistry(name string) (platform.ServiceRegistry, error) {
+	for _, registry := range registries {
+		if strings.EqualFold(name, string(registry)) {
+			return registry, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported registry %q, want one of %v", name, registries)
+}
+
 func main() {
 	flag.Parse()
 	_ = log.Configure(log.NewOptions())
@@ -123,6 +250,13 @@ func main() {
 		os.Exit(-1)
 	}
 
+	registry, err := parseRegistry(params.Registry)
+	if err != nil {
+		log.Errora(err)
+		os.Exit(-1)
+	}
+	params.Registry = string(registry)
+
 	if verbose {
 		params.Verbosity = 3
 	} else {
@@ -136,6 +270,22 @@ func main() {
 	params.MixerCustomConfigFile = mixerConfigFile
 	params.PilotCustomConfigFile = pilotConfigFile
 
+	if len(reuseNamespace) != 0 {
+		params.Namespace = reuseNamespace
+		if len(params.IstioNamespace) == 0 {
+			params.IstioNamespace = reuseNamespace
+		}
+		params.reuse = true
+	}
+
+	if validateOnly {
+		if err := validateConfig(authmode, params); err != nil {
+			tlogFatal("Invalid configuration", err.Error())
+		}
+		tlog("Valid configuration", fmt.Sprintf("auth: %s, registry: %s", authmode, params.Registry))
+		return
+	}
+
 	if len(params.Namespace) != 0 && authmode == "both" {
 		log.Infof("When namespace(=%s) is specified, auth mode(=%s) must be one of enable or disable.",
 			params.Namespace, authmode)
@@ -146,7 +296,12 @@ func main() {
 		kubeconfig = "pilot/platform/kube/config"
 		glog.Info("Using linked in kube config. Set KUBECONFIG env before running the test.")
 	}
-	var err error
+	filter, err := newTestFilter(testType, runFilter, skipFilter)
+	if err != nil {
+		log.Errora(err)
+		os.Exit(-1)
+	}
+
 	_, client, err = kube.CreateInterface(kubeconfig)
 	if err != nil {
 		log.Errora(err)
@@ -155,11 +310,11 @@ func main() {
 
 	switch authmode {
 	case "enable":
-		runTests(setAuth(params))
+		runTests(filter, setAuth(params))
 	case "disable":
-		runTests(params)
+		runTests(filter, params)
 	case "both":
-		runTests(params, setAuth(params))
+		runTests(filter, params, setAuth(params))
 	default:
 		log.Infof("Invald auth flag: %s. Please choose from: enable/disable/both.", authmode)
 	}
@@ -175,11 +330,18 @@ func setAuth(params infra) infra {
 	return out
 }
 
+// tlogMutex keeps banners from concurrently running tests from interleaving
+var tlogMutex sync.Mutex
+
 func tlog(header, s string) {
+	tlogMutex.Lock()
+	defer tlogMutex.Unlock()
 	log.Infof("\n\n=================== %s =====================\n%s\n\n", header, s)
 }
 
 func tlogError(header, s string) {
+	tlogMutex.Lock()
+	defer tlogMutex.Unlock()
 	log.Errorf("\n\n=================== %s =====================\n%s\n\n", header, s)
 }
 
@@ -188,113 +350,179 @@ func tlogFatal(header, s string) {
 	os.Exit(-1)
 }
 
-func runTests(envs ...infra) {
+func runTests(filter *testFilter, envs ...infra) {
 	var result error
 	for _, istio := range envs {
-		var errs error
-		tlog("Deploying infrastructure", spew.Sdump(istio))
-		if err := istio.setup(); err != nil {
-			result = multierror.Append(result, err)
-			continue
-		}
-		if err := istio.deployApps(); err != nil {
-			result = multierror.Append(result, err)
-			continue
-		}
-
-		nslist := []string{istio.IstioNamespace, istio.Namespace}
-		istio.apps, errs = util.GetAppPods(client, kubeconfig, nslist)
-		if errs != nil {
-			result = multierror.Append(result, errs)
-			break
-		}
+		// each iteration runs in a closure so that the suite is finished on every exit path
+		stop := func() bool {
+			var errs error
+			suite := report.suite(istio.Name)
+			suiteStart := time.Now()
+			defer func() { suite.finish(time.Since(suiteStart)) }()
+
+			// an interrupt while deploying or testing tears the infrastructure down before exiting,
+			// and while holding it ends the hold
+			sigs := make(chan os.Signal, 1)
+			signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(sigs)
+			stopWatch := watchInterrupts(sigs, func(sig os.Signal) {
+				// a second signal during teardown terminates the driver
+				signal.Stop(sigs)
+				tlogError("Received "+sig.String(), istio.Name)
+				istio.cleanup(false)
+				tlogFatal("Interrupted infrastructure tests!", istio.Name)
+			})
+			defer stopWatch()
+			if istio.reuse {
+				tlog("Reusing infrastructure", spew.Sdump(istio))
+				if err := istio.attach(); err != nil {
+					result = multierror.Append(result, err)
+					suite.add("deploy infrastructure", time.Since(suiteStart), err)
+					return false
+				}
+			} else {
+				tlog("Deploying infrastructure", spew.Sdump(istio))
+				if err := istio.setup(); err != nil {
+					result = multierror.Append(result, err)
+					suite.add("deploy infrastructure", time.Since(suiteStart), err)
+					return false
+				}
+				if err := istio.deployApps(); err != nil {
+					result = multierror.Append(result, err)
+					suite.add("deploy infrastructure", time.Since(suiteStart), err)
+					return false
+				}
+			}
 
-		tests := []test{
-			&http{infra: &istio},
-			&grpc{infra: &istio},
-			&tcp{infra: &istio},
-			&headless{infra: &istio},
-			&ingress{infra: &istio},
-			&egressRules{infra: &istio},
-			&routing{infra: &istio},
-			&routingToEgress{infra: &istio},
-			&zipkin{infra: &istio},
-			&authExclusion{infra: &istio},
-		}
+			nslist := []string{istio.IstioNamespace, istio.Namespace}
+			istio.apps, errs = util.GetAppPods(client, kubeconfig, nslist)
+			if errs == nil && istio.reuse {
+				errs = istio.checkApps()
+			}
+			if errs != nil {
+				result = multierror.Append(result, errs)
+				suite.add("deploy infrastructure", time.Since(suiteStart), errs)
+				return true
+			}
 
-		for _, test := range tests {
-			// If the user has specified a test, skip all other tests
-			if len(testType) > 0 && testType != test.String() {
-				continue
+			var streams *logStreams
+			if istio.streamLogs {
+				streams = istio.startLogStreams()
 			}
 
-			for i := 0; i < count; i++ {
-				tlog("Test run", strconv.Itoa(i))
-				if err := test.setup(); err != nil {
-					errs = multierror.Append(errs, multierror.Prefix(err, test.String()))
+			tests := newTests(&istio)
+
+			// Each test retries its checks through parallel using budgetFor(test) attempts, so a test
+			// implementing budgeted is unaffected by --budget. The routing, fault injection and egress
+			// tests also repeat each case repeatBudgetFor(test) times; where a case check calls parallel
+			// the two budgets multiply.
+			var concurrent, serialized []test
+			for _, test := range tests {
+				if !filter.selects(test.String()) {
+					continue
+				}
+				if reason := registrySkipReason(test, platform.ServiceRegistry(istio.Registry)); reason != "" {
+					tlog("Skipping test", fmt.Sprintf("%v with the %s registry: %s", test, istio.Registry, reason))
+					continue
+				}
+				if _, ok := test.(serial); ok || parallelTests <= 1 {
+					serialized = append(serialized, test)
 				} else {
-					tlog("Running test", test.String())
-					if err := test.run(); err != nil {
-						errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("%v run %d", test, i)))
-						tlog("Failed", test.String()+" "+err.Error())
-					} else {
-						tlog("Success!", test.String())
-					}
+					concurrent = append(concurrent, test)
 				}
-				tlog("Tearing down test", test.String())
-				test.teardown()
 			}
-		}
 
-		// spill all logs on error
-		if errs != nil {
-			for _, pod := range util.GetPods(client, istio.Namespace) {
-				var filename, content string
-				if strings.HasPrefix(pod, "istio-pilot") {
-					tlog("Discovery log", pod)
-					filename = "istio-pilot"
-					content = util.FetchLogs(client, pod, istio.IstioNamespace, "discovery")
-				} else if strings.HasPrefix(pod, "istio-mixer") {
-					tlog("Mixer log", pod)
-					filename = "istio-mixer"
-					content = util.FetchLogs(client, pod, istio.IstioNamespace, "mixer")
-				} else if strings.HasPrefix(pod, "istio-ingress") {
-					tlog("Ingress log", pod)
-					filename = "istio-ingress"
-					content = util.FetchLogs(client, pod, istio.IstioNamespace, inject.ProxyContainerName)
-				} else {
-					tlog("Proxy log", pod)
-					filename = pod
-					content = util.FetchLogs(client, pod, istio.Namespace, inject.ProxyContainerName)
+			// Independent tests run first on a pool of --parallel-tests workers, followed by the
+			// tests that mutate shared config one at a time.
+			var mu sync.Mutex
+			queue := make(chan test, len(concurrent))
+			for _, test := range concurrent {
+				queue <- test
+			}
+			close(queue)
+			var wg sync.WaitGroup
+			for w := 0; w < parallelTests && w < len(concurrent); w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for test := range queue {
+						if err := runTest(test, suite); err != nil {
+							mu.Lock()
+							errs = multierror.Append(errs, err)
+							mu.Unlock()
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			for _, test := range serialized {
+				if err := runTest(test, suite); err != nil {
+					errs = multierror.Append(errs, err)
 				}
+			}
 
-				if len(istio.errorLogsDir) > 0 {
-					if err := ioutil.WriteFile(istio.errorLogsDir+"/"+filename+".txt", []byte(content), 0644); err != nil {
-						log.Errorf("Failed to save logs to %s:%s. Dumping on stderr\n", filename, err)
+			// spill all logs on error
+			if errs != nil {
+				for _, pod := range util.GetPods(client, istio.Namespace) {
+					var filename, content string
+					if strings.HasPrefix(pod, "istio-pilot") {
+						tlog("Discovery log", pod)
+						filename = "istio-pilot"
+						content = util.FetchLogs(client, pod, istio.IstioNamespace, "discovery")
+					} else if strings.HasPrefix(pod, "istio-mixer") {
+						tlog("Mixer log", pod)
+						filename = "istio-mixer"
+						content = util.FetchLogs(client, pod, istio.IstioNamespace, "mixer")
+					} else if strings.HasPrefix(pod, "istio-ingress") {
+						tlog("Ingress log", pod)
+						filename = "istio-ingress"
+						content = util.FetchLogs(client, pod, istio.IstioNamespace, inject.ProxyContainerName)
+					} else {
+						tlog("Proxy log", pod)
+						filename = pod
+						content = util.FetchLogs(client, pod, istio.Namespace, inject.ProxyContainerName)
+					}
+
+					if len(istio.errorLogsDir) > 0 {
+						if err := ioutil.WriteFile(istio.errorLogsDir+"/"+filename+".txt", []byte(content), 0644); err != nil {
+							log.Errorf("Failed to save logs to %s:%s. Dumping on stderr\n", filename, err)
+							log.Info(content)
+						}
+					} else {
 						log.Info(content)
 					}
-				} else {
-					log.Info(content)
 				}
 			}
-		}
 
-		cleanup := !istio.SkipCleanup
+			if streams != nil {
+				streams.close()
+			}
 
-		if errs == nil {
-			tlog("Passed all tests!", fmt.Sprintf("tests: %v, count: %d", tests, count))
-		} else {
-			tlogError("Failed tests!", errs.Error())
-			result = multierror.Append(result, multierror.Prefix(errs, istio.Name))
-			if istio.SkipCleanupOnFailure {
-				cleanup = false
+			stopWatch()
+			if istio.Hold {
+				istio.hold(sigs)
 			}
+			// a second signal during teardown terminates the driver
+			signal.Stop(sigs)
+
+			if errs == nil {
+				tlog("Passed all tests!", fmt.Sprintf("tests: %v, count: %d", tests, count))
+			} else {
+				tlogError("Failed tests!", errs.Error())
+				result = multierror.Append(result, multierror.Prefix(errs, istio.Name))
+			}
+			istio.cleanup(errs != nil)
+			return false
+		}()
+		if stop {
+			break
 		}
-		if cleanup {
-			tlog("Tearing down infrastructure", istio.Name)
-			istio.teardown()
-		} else {
-			tlog("Skipping teardown", istio.Name)
+	}
+
+	if len(junitOut) > 0 {
+		if err := report.write(junitOut); err != nil {
+			log.Errorf("Failed to write JUnit report to %s: %v", junitOut, err)
 		}
 	}
 
@@ -305,12 +533,191 @@ func runTests(envs ...infra) {
 	}
 }
 
+// newTests returns all tests against the infrastructure
+func newTests(istio *infra) []test {
+	tests := []test{
+		&http{infra: istio},
+		&grpc{infra: istio},
+		&tcp{infra: istio},
+		&headless{infra: istio},
+		&ingress{infra: istio},
+		&egressRules{infra: istio},
+		&routing{infra: istio},
+		&faultInjection{infra: istio},
+		&routingToEgress{infra: istio},
+		&zipkin{infra: istio},
+		&authExclusion{infra: istio},
+	}
+	if certExpiryCheck {
+		tests = append(tests, &certExpiry{infra: istio, threshold: certExpiryThreshold})
+	}
+	return tests
+}
+
+// hold logs how to reach the deployed apps and blocks until the driver is
+// interrupted, so that the infrastructure can be inspected before teardown.
+func (infra *infra) hold(sigs <-chan os.Signal) {
+	kubectl := fmt.Sprintf("kubectl --kubeconfig %s", kubeconfig)
+	var hints bytes.Buffer
+	fmt.Fprintf(&hints, "Istio namespace: %s\n", infra.IstioNamespace)
+	fmt.Fprintf(&hints, "Apps namespace:  %s\n\n", infra.Namespace)
+
+	apps := make([]string, 0, len(infra.apps))
+	for app := range infra.apps {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	for _, app := range apps {
+		fmt.Fprintf(&hints, "%s: %s\n", app, strings.Join(infra.apps[app], " "))
+	}
+
+	fmt.Fprintf(&hints, "\nList pods:        %s -n %s get pods\n", kubectl, infra.Namespace)
+	fmt.Fprintf(&hints, "Open a shell:     %s -n %s exec -it <pod> -c app -- sh\n", kubectl, infra.Namespace)
+	fmt.Fprintf(&hints, "Proxy logs:       %s -n %s logs <pod> -c %s\n", kubectl, infra.Namespace, inject.ProxyContainerName)
+	fmt.Fprintf(&hints, "Proxy admin:      %s -n %s port-forward <pod> 15000\n", kubectl, infra.Namespace)
+	fmt.Fprintf(&hints, "Pilot logs:       %s -n %s logs -l infra=pilot -c discovery\n", kubectl, infra.IstioNamespace)
+	tlog("Holding infrastructure, press Ctrl-C to tear down", hints.String())
+
+	sig := <-sigs
+	tlog("Received "+sig.String(), infra.Name)
+}
+
+// watchInterrupts calls onInterrupt with the first signal received on sigs until the returned function
+// is called. The returned function waits for a running onInterrupt, and can be called more than once.
+func watchInterrupts(sigs <-chan os.Signal, onInterrupt func(os.Signal)) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case sig := <-sigs:
+			onInterrupt(sig)
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-stopped
+	}
+}
+
+// cleanup tears the infrastructure down unless it is kept with --skip-cleanup, or with
+// --skip-cleanup-on-failure after failed tests. Reused infrastructure belongs to the run that deployed it.
+func (infra *infra) cleanup(failed bool) {
+	if infra.SkipCleanup || infra.reuse || (failed && infra.SkipCleanupOnFailure) {
+		tlog("Skipping teardown", infra.Name)
+		return
+	}
+	tlog("Tearing down infrastructure", infra.Name)
+	infra.teardown()
+}
+
+// runTest runs a test count times, recording each run in the suite
+func runTest(test test, suite *junitTestSuite) error {
+	var errs error
+	for i := 0; i < count; i++ {
+		tlog("Test run", fmt.Sprintf("%v %d", test, i))
+		var testErr error
+		start := time.Now()
+		if err := test.setup(); err != nil {
+			testErr = multierror.Prefix(err, test.String())
+		} else {
+			tlog("Running test", test.String())
+			if err := test.run(); err != nil {
+				testErr = multierror.Prefix(err, fmt.Sprintf("%v run %d", test, i))
+				tlog("Failed", test.String()+" "+err.Error())
+			} else {
+				tlog("Success!", test.String())
+			}
+		}
+		tlog("Tearing down test", test.String())
+		test.teardown()
+		suite.add(test.String(), time.Since(start), testErr)
+		if testErr != nil {
+			errs = multierror.Append(errs, testErr)
+		}
+	}
+	return errs
+}
+
+// testFilter selects tests by name
+type testFilter struct {
+	exact string
+	run   *regexp.Regexp
+	skip  *regexp.Regexp
+}
+
+// newTestFilter compiles the test selection flags. exact is the legacy --testtype exact match; run and
+// skip are regular expressions, either of which may be empty.
+func newTestFilter(exact, run, skip string) (*testFilter, error) {
+	f := &testFilter{exact: exact}
+	var err error
+	if len(run) > 0 {
+		if f.run, err = regexp.Compile(run); err != nil {
+			return nil, fmt.Errorf("invalid --run expression %q: %v", run, err)
+		}
+	}
+	if len(skip) > 0 {
+		if f.skip, err = regexp.Compile(skip); err != nil {
+			return nil, fmt.Errorf("invalid --skip expression %q: %v", skip, err)
+		}
+	}
+	return f, nil
+}
+
+// selects returns whether the named test should run. Skip takes precedence over run and testtype.
+func (f *testFilter) selects(name string) bool {
+	if f.skip != nil && f.skip.MatchString(name) {
+		return false
+	}
+	if len(f.exact) > 0 && f.exact != name {
+		return false
+	}
+	if f.run != nil && !f.run.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+// testdataDir holds the templates, relative to the root of the repository the driver runs from
+var testdataDir = "pilot/test/integration/testdata/"
+
+// templateFuncs are available to the testdata templates
+var templateFuncs = template.FuncMap{
+	"portRange": portRange,
+}
+
+// portRange expands an inclusive port range such as "8000-9000" into its ports
+func portRange(r string) ([]int, error) {
+	bounds := strings.SplitN(r, "-", 2)
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid port range %q: want <from>-<to>", r)
+	}
+	from, err := strconv.Atoi(bounds[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid port range %q: %v", r, err)
+	}
+	to, err := strconv.Atoi(bounds[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid port range %q: %v", r, err)
+	}
+	if from <= 0 || to > 65535 || from > to {
+		return nil, fmt.Errorf("invalid port range %q", r)
+	}
+	ports := make([]int, 0, to-from+1)
+	for port := from; port <= to; port++ {
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
 // fill a file based on a template
 func fill(inFile string, values interface{}) (string, error) {
 	var bytes bytes.Buffer
 	w := bufio.NewWriter(&bytes)
 
-	tmpl, err := template.ParseFiles("pilot/test/integration/testdata/" + inFile)
+	tmpl, err := template.New(inFile).Funcs(templateFuncs).ParseFiles(testdataDir + inFile)
 	if err != nil {
 		return "", err
 	}
@@ -330,44 +737,116 @@ type status error
 
 var (
 	errAgain status = errors.New("try again")
+	// errTimeout is like errAgain, but the check already waited for the request timeout so it is
+	// retried without further delay
+	errTimeout status = errors.New("timed out, try again")
 )
 
-// run in parallel with retries. all funcs must succeed for the function to succeed
-func parallel(fs map[string]func() status) error {
-	g, ctx := errgroup.WithContext(context.Background())
-	repeat := func(name string, f func() status) func() error {
-		return func() error {
-			for n := 0; n < budget; n++ {
-				log.Infof("%s (attempt %d)", name, n)
-				err := f()
-				switch err {
-				case nil:
-					// success
-					return nil
-				case errAgain:
-					// do nothing
-				default:
-					return fmt.Errorf("failed %s at attempt %d: %v", name, n, err)
+// buildRequestMatrix creates a named check for every combination of source pod, destination,
+// port, and domain, to be run with parallel. Checks are named "<kind> from <src> to <dst><domain><port>".
+// check is called once per combination and may return nil to leave the combination out.
+func buildRequestMatrix(kind string, srcs, dsts, ports, domains []string,
+	check func(src, dst, port, domain string) func() status) map[string]func() status {
+	funcs := make(map[string]func() status)
+	for _, src := range srcs {
+		for _, dst := range dsts {
+			for _, port := range ports {
+				for _, domain := range domains {
+					if f := check(src, dst, port, domain); f != nil {
+						funcs[fmt.Sprintf("%s from %s to %s%s%s", kind, src, dst, domain, port)] = f
+					}
 				}
-				select {
-				case <-time.After(time.Second):
-					// try again
-				case <-ctx.Done():
-					return nil
+			}
+		}
+	}
+	return funcs
+}
+
+// parallelRetryDelay is the delay before a check that asked to try again is retried
+var parallelRetryDelay = time.Second
+
+// run in parallel with up to budget retries each. all funcs must succeed for the function to succeed.
+// A check failing with an unexpected error stops the others; otherwise the returned error lists
+// every check that exhausted its budget together with the last status it returned.
+func parallel(fs map[string]func() status, budget int) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mutex sync.Mutex
+	failures := make(map[string]error)
+	fail := func(name string, err error) {
+		mutex.Lock()
+		failures[name] = err
+		mutex.Unlock()
+	}
+
+	repeat := func(name string, f func() status) {
+		var last status
+		for n := 0; n < budget; n++ {
+			log.Infof("%s (attempt %d)", name, n)
+			last = f()
+			switch last {
+			case nil:
+				// success
+				return
+			case errAgain:
+				// do nothing
+			case errTimeout:
+				log.Infof("%s timed out (attempt %d)", name, n)
+				if ctx.Err() != nil {
+					return
 				}
+				continue
+			default:
+				fail(name, fmt.Errorf("failed %s at attempt %d: %v", name, n, last))
+				cancel()
+				return
+			}
+			select {
+			case <-time.After(parallelRetryDelay):
+				// try again
+			case <-ctx.Done():
+				return
 			}
-			return fmt.Errorf("failed all %d attempts for %s", budget, name)
 		}
+		fail(name, fmt.Errorf("failed all %d attempts for %s, last status: %v", budget, name, last))
 	}
+
+	var wg sync.WaitGroup
 	for name, f := range fs {
-		g.Go(repeat(name, f))
+		wg.Add(1)
+		go func(name string, f func() status) {
+			defer wg.Done()
+			repeat(name, f)
+		}(name, f)
+	}
+	wg.Wait()
+
+	names := make([]string, 0, len(failures))
+	for name := range failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs error
+	for _, name := range names {
+		errs = multierror.Append(errs, failures[name])
 	}
-	return g.Wait()
+	return errs
 }
 
+// sleep is replaced in tests to observe the delays between attempts
+var sleep = time.Sleep
+
 // repeat a check up to budget until it does not return an error
 func repeat(f func() error, budget int, delay time.Duration) error {
+	return repeatBackoff(f, budget, delay, delay)
+}
+
+// repeatBackoff repeats a check up to budget until it does not return an error,
+// doubling the delay between attempts from initial up to max
+func repeatBackoff(f func() error, budget int, initial, max time.Duration) error {
 	var errs error
+	delay := initial
 	for i := 0; i < budget; i++ {
 		err := f()
 		if err == nil {
@@ -375,7 +854,13 @@ func repeat(f func() error, budget int, delay time.Duration) error {
 		}
 		errs = multierror.Append(errs, multierror.Prefix(err, fmt.Sprintf("attempt %d", i)))
 		log.Infof("attempt #%d failed with %v", i, err)
-		time.Sleep(delay)
+		if i == budget-1 {
+			break
+		}
+		sleep(delay)
+		if delay *= 2; delay > max {
+			delay = max
+		}
 	}
 	return errs
 }
@@ -0,0 +1,391 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/istio/pilot/platform"
+)
+
+type fixedBudget int
+
+func (b fixedBudget) retryBudget() int {
+	return int(b)
+}
+
+func TestBudgetFor(t *testing.T) {
+	defer func(old int) { budget = old }(budget)
+	budget = 42
+
+	cases := []struct {
+		name string
+		test interface{}
+		want int
+	}{
+		{name: "no override", test: &http{}, want: 42},
+		{name: "override", test: fixedBudget(7), want: 7},
+		{name: "non-positive override", test: fixedBudget(0), want: 42},
+		{name: "zipkin", test: &zipkin{}, want: 180},
+		{name: "egress rules", test: &egressRules{}, want: 180},
+	}
+	for _, c := range cases {
+		if got := budgetFor(c.test); got != c.want {
+			t.Errorf("%s: budgetFor() => %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+type fixedRepeatBudget int
+
+func (b fixedRepeatBudget) repeatBudget() int {
+	return int(b)
+}
+
+func TestRepeatBudgetFor(t *testing.T) {
+	cases := []struct {
+		name string
+		test interface{}
+		want int
+	}{
+		{name: "no override", test: &routing{}, want: defaultRepeatBudget},
+		{name: "retry budget only", test: fixedBudget(7), want: defaultRepeatBudget},
+		{name: "override", test: fixedRepeatBudget(7), want: 7},
+		{name: "non-positive override", test: fixedRepeatBudget(0), want: defaultRepeatBudget},
+		{name: "egress rules", test: &egressRules{}, want: 5},
+	}
+	for _, c := range cases {
+		if got := repeatBudgetFor(c.test); got != c.want {
+			t.Errorf("%s: repeatBudgetFor() => %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRegistrySkipReason(t *testing.T) {
+	tests := []test{&http{}, &grpc{}, &tcp{}, &headless{}, &ingress{}, &routing{}, &zipkin{}}
+	cases := []struct {
+		registry platform.ServiceRegistry
+		skipped  []string
+	}{
+		{registry: platform.KubernetesRegistry},
+		{registry: platform.ConsulRegistry, skipped: []string{"ingress"}},
+		{registry: platform.EurekaRegistry, skipped: []string{"tcp-reachability", "ingress"}},
+	}
+	for _, c := range cases {
+		var skipped []string
+		for _, test := range tests {
+			if reason := registrySkipReason(test, c.registry); reason != "" {
+				skipped = append(skipped, test.String())
+			}
+		}
+		if !reflect.DeepEqual(skipped, c.skipped) {
+			t.Errorf("%s: skipped %v, want %v", c.registry, skipped, c.skipped)
+		}
+	}
+}
+
+func TestParseRegistry(t *testing.T) {
+	cases := map[string]platform.ServiceRegistry{
+		"Kubernetes": platform.KubernetesRegistry,
+		"consul":     platform.ConsulRegistry,
+		"EUREKA":     platform.EurekaRegistry,
+	}
+	for name, want := range cases {
+		if got, err := parseRegistry(name); err != nil || got != want {
+			t.Errorf("parseRegistry(%q) => %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := parseRegistry("zookeeper"); err == nil {
+		t.Error("expected error for unsupported registry")
+	}
+}
+
+func TestTestFilter(t *testing.T) {
+	names := []string{"http-reachability", "tcp-reachability", "routing-rules", "routing-rules-to-egress", "zipkin"}
+	cases := []struct {
+		name             string
+		exact, run, skip string
+		want             []string
+	}{
+		{name: "default", want: names},
+		{name: "testtype", exact: "routing-rules", want: []string{"routing-rules"}},
+		{name: "run", run: "^routing", want: []string{"routing-rules", "routing-rules-to-egress"}},
+		{name: "skip", skip: "zipkin", want: []string{"http-reachability", "tcp-reachability", "routing-rules",
+			"routing-rules-to-egress"}},
+		{name: "skip takes precedence", run: "reachability", skip: "^tcp", want: []string{"http-reachability"}},
+		{name: "skip overrides testtype", exact: "zipkin", skip: "zipkin"},
+	}
+	for _, c := range cases {
+		f, err := newTestFilter(c.exact, c.run, c.skip)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.name, err)
+		}
+		var got []string
+		for _, name := range names {
+			if f.selects(name) {
+				got = append(got, name)
+			}
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: selected %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestTestFilterInvalid(t *testing.T) {
+	if _, err := newTestFilter("", "(", ""); err == nil {
+		t.Error("expected error for invalid --run expression")
+	}
+	if _, err := newTestFilter("", "", "["); err == nil {
+		t.Error("expected error for invalid --skip expression")
+	}
+}
+
+func TestBuildRequestMatrix(t *testing.T) {
+	var calls []string
+	funcs := buildRequestMatrix("Request", []string{"a", "t"}, []string{"b", "t"}, []string{"", ":80"}, []string{"", ".ns"},
+		func(src, dst, port, domain string) func() status {
+			calls = append(calls, src+dst+port+domain)
+			if src == "t" && dst == "t" {
+				return nil
+			}
+			return func() status { return nil }
+		})
+
+	if len(calls) != 16 {
+		t.Errorf("check called %d times, want 16", len(calls))
+	}
+	if len(funcs) != 12 {
+		t.Errorf("got %d checks, want 12", len(funcs))
+	}
+	for _, name := range []string{"Request from a to b", "Request from a to t.ns:80", "Request from t to b.ns"} {
+		if _, ok := funcs[name]; !ok {
+			t.Errorf("missing check %q", name)
+		}
+	}
+	if _, ok := funcs["Request from t to t:80"]; ok {
+		t.Error("excluded combination t->t is present")
+	}
+}
+
+func TestRepeatBackoff(t *testing.T) {
+	defer func(old func(time.Duration)) { sleep = old }(sleep)
+
+	cases := []struct {
+		name        string
+		succeedOn   int
+		budget      int
+		initial     time.Duration
+		max         time.Duration
+		wantCalls   int
+		wantDelays  []time.Duration
+		wantFailure bool
+	}{
+		{name: "first attempt", succeedOn: 1, budget: 5, initial: time.Second, max: 8 * time.Second,
+			wantCalls: 1},
+		{name: "stops on success", succeedOn: 3, budget: 5, initial: time.Second, max: 8 * time.Second,
+			wantCalls: 3, wantDelays: []time.Duration{time.Second, 2 * time.Second}},
+		{name: "capped", budget: 6, initial: time.Second, max: 5 * time.Second,
+			wantCalls: 6, wantFailure: true,
+			wantDelays: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second,
+				5 * time.Second}},
+		{name: "constant", budget: 3, initial: time.Second, max: time.Second,
+			wantCalls: 3, wantFailure: true, wantDelays: []time.Duration{time.Second, time.Second}},
+	}
+	for _, c := range cases {
+		var delays []time.Duration
+		sleep = func(d time.Duration) { delays = append(delays, d) }
+		calls := 0
+		f := func() error {
+			calls++
+			if calls == c.succeedOn {
+				return nil
+			}
+			return errors.New("not yet")
+		}
+		err := repeatBackoff(f, c.budget, c.initial, c.max)
+		if (err != nil) != c.wantFailure {
+			t.Errorf("%s: repeatBackoff() => %v, want failure %t", c.name, err, c.wantFailure)
+		}
+		if calls != c.wantCalls {
+			t.Errorf("%s: got %d attempts, want %d", c.name, calls, c.wantCalls)
+		}
+		if !reflect.DeepEqual(delays, c.wantDelays) {
+			t.Errorf("%s: got delays %v, want %v", c.name, delays, c.wantDelays)
+		}
+	}
+}
+
+func TestParallelReportsExhaustedChecks(t *testing.T) {
+	defer func(old time.Duration) { parallelRetryDelay = old }(parallelRetryDelay)
+	parallelRetryDelay = time.Millisecond
+
+	var mutex sync.Mutex
+	attempts := make(map[string]int)
+	succeedOn := func(name string, n int) func() status {
+		return func() status {
+			mutex.Lock()
+			defer mutex.Unlock()
+			attempts[name]++
+			if n > 0 && attempts[name] >= n {
+				return nil
+			}
+			if name == "timeout" {
+				return errTimeout
+			}
+			return errAgain
+		}
+	}
+	fs := map[string]func() status{
+		"pass":    succeedOn("pass", 1),
+		"flaky":   succeedOn("flaky", 2),
+		"again":   succeedOn("again", 0),
+		"timeout": succeedOn("timeout", 0),
+	}
+
+	err := parallel(fs, 3)
+	merr, ok := err.(*multierror.Error)
+	if !ok {
+		t.Fatalf("parallel() => %v, want a multierror", err)
+	}
+	if len(merr.Errors) != 2 {
+		t.Fatalf("parallel() => %d errors, want 2: %v", len(merr.Errors), err)
+	}
+	for i, want := range []string{"again, last status: try again", "timeout, last status: timed out, try again"} {
+		if got := merr.Errors[i].Error(); !strings.HasSuffix(got, want) {
+			t.Errorf("error %d => %q, want suffix %q", i, got, want)
+		}
+	}
+	for name, want := range map[string]int{"pass": 1, "flaky": 2, "again": 3, "timeout": 3} {
+		if attempts[name] != want {
+			t.Errorf("%s: got %d attempts, want %d", name, attempts[name], want)
+		}
+	}
+}
+
+func TestParallelStopsOnUnexpectedError(t *testing.T) {
+	defer func(old time.Duration) { parallelRetryDelay = old }(parallelRetryDelay)
+	parallelRetryDelay = time.Hour
+
+	fs := map[string]func() status{
+		"broken": func() status { return errors.New("boom") },
+		"again":  func() status { return errAgain },
+	}
+	err := parallel(fs, 3)
+	merr, ok := err.(*multierror.Error)
+	if !ok || len(merr.Errors) != 1 || !strings.Contains(merr.Errors[0].Error(), "boom") {
+		t.Errorf("parallel() => %v, want only the unexpected error", err)
+	}
+}
+
+func TestPortRange(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    []int
+		wantErr bool
+	}{
+		{in: "8000-8002", want: []int{8000, 8001, 8002}},
+		{in: "80-80", want: []int{80}},
+		{in: "8000", wantErr: true},
+		{in: "8002-8000", wantErr: true},
+		{in: "0-10", wantErr: true},
+		{in: "65535-65536", wantErr: true},
+		{in: "a-b", wantErr: true},
+	}
+	for _, c := range cases {
+		got, err := portRange(c.in)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: portRange() => error %v, want error %t", c.in, err, c.wantErr)
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: portRange() => %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestPortRangeFixture(t *testing.T) {
+	const name = "egress-rule-tcp-portquiz-range.yaml.tmpl"
+	tmpl, err := template.New(name).Funcs(templateFuncs).ParseFiles("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err = tmpl.Execute(&out, map[string]string{"cidr": "10.0.0.1/32", "ports": "8000-8002"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`service: "10.0.0.1/32"`, "port: 8000", "port: 8001", "port: 8002"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("rendered fixture is missing %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "port: 8003") {
+		t.Errorf("rendered fixture has a port outside the range:\n%s", out.String())
+	}
+}
+
+func TestAbortTolerance(t *testing.T) {
+	cases := []struct {
+		samples, percent int
+		want             float64
+	}{
+		{samples: 100, percent: 50, want: 20},
+		{samples: 100, percent: 100, want: 0},
+		{samples: 100, percent: 0, want: 0},
+		{samples: 400, percent: 10, want: 24},
+	}
+	for _, c := range cases {
+		if got := abortTolerance(c.samples, c.percent); got != c.want {
+			t.Errorf("abortTolerance(%d, %d) => %v, want %v", c.samples, c.percent, got, c.want)
+		}
+	}
+}
+
+func TestWatchInterrupts(t *testing.T) {
+	sigs := make(chan os.Signal, 1)
+	called := make(chan os.Signal, 1)
+	onInterrupt := func(sig os.Signal) { called <- sig }
+
+	stop := watchInterrupts(sigs, onInterrupt)
+	sigs <- os.Interrupt
+	select {
+	case sig := <-called:
+		if sig != os.Interrupt {
+			t.Errorf("interrupt while watching: onInterrupt called with %v, want %v", sig, os.Interrupt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("interrupt while watching: onInterrupt not called")
+	}
+	stop()
+
+	stop = watchInterrupts(sigs, onInterrupt)
+	stop()
+	stop()
+	sigs <- os.Interrupt
+	select {
+	case sig := <-called:
+		t.Errorf("interrupt after stopping: onInterrupt called with %v", sig)
+	default:
+	}
+}
@@ -18,6 +18,8 @@ package main
 
 import (
 	"fmt"
+	"net"
+	nethttp "net/http"
 	"strings"
 	"time"
 	// TODO(nmittler): Remove this
@@ -27,6 +29,19 @@ import (
 	"istio.io/istio/pkg/log"
 )
 
+// envoyUpstreamServiceTimeHeader is added to responses by Envoy, so its presence shows that the
+// request went through the sidecar
+const envoyUpstreamServiceTimeHeader = "x-envoy-upstream-service-time"
+
+const (
+	// portquizHost accepts tcp connections, and answers http, on every port
+	portquizHost = "portquiz.net"
+
+	// a range of ports allowed by a tcp egress rule
+	portquizRangeFrom = 8080
+	portquizRangeTo   = 8090
+)
+
 type egressRules struct {
 	*infra
 }
@@ -35,17 +50,38 @@ func (t *egressRules) String() string {
 	return "egress-rules"
 }
 
+func (t *egressRules) serial() {}
+
+// external hosts are slower and less reliable than in-mesh services
+func (t *egressRules) retryBudget() int {
+	return 180
+}
+
+// external hosts can take a while to become reachable after a rule is applied
+func (t *egressRules) repeatBudget() int {
+	return 5
+}
+
 func (t *egressRules) setup() error {
 	return nil
 }
 
-// TODO: test negatives
-func (t *egressRules) run() error {
-	cases := []struct {
-		description string
-		config      string
-		check       func() error
-	}{
+// egressCase applies an egress rule and checks the external traffic it allows
+type egressCase struct {
+	description string
+	config      string
+	data        map[string]string
+	check       func() error
+}
+
+// cases returns the egress rule cases, with portquiz the CIDR of portquizHost.
+func (t *egressRules) cases(portquiz string) []egressCase {
+	portquizRange := map[string]string{
+		"cidr":  portquiz,
+		"ports": fmt.Sprintf("%d-%d", portquizRangeFrom, portquizRangeTo),
+	}
+
+	return []egressCase{
 		{
 			description: "allow external traffic to httbin.org",
 			config:      "egress-rule-httpbin.yaml.tmpl",
@@ -53,6 +89,35 @@ func (t *egressRules) run() error {
 				return t.verifyReachable("http://httpbin.org/headers", true)
 			},
 		},
+		{
+			description: "ensure external traffic to httbin.org goes through the sidecar",
+			config:      "egress-rule-httpbin.yaml.tmpl",
+			check: func() error {
+				return t.verifyReachableWithHeaders("http://httpbin.org/headers",
+					map[string]string{envoyUpstreamServiceTimeHeader: ""})
+			},
+		},
+		{
+			description: "prohibit https to httbin.org",
+			config:      "egress-rule-httpbin.yaml.tmpl",
+			check: func() error {
+				return t.verifyReachable("http://httpbin.org:443/headers", false)
+			},
+		},
+		{
+			description: "prohibit tls traffic to httbin.org when allowing http",
+			config:      "egress-rule-httpbin.yaml.tmpl",
+			check: func() error {
+				return t.verifyReachable("https://httpbin.org/headers", false)
+			},
+		},
+		{
+			description: "prohibit www.httbin.org when allowing httbin.org",
+			config:      "egress-rule-httpbin.yaml.tmpl",
+			check: func() error {
+				return t.verifyReachable("http://www.httpbin.org/headers", false)
+			},
+		},
 		{
 			description: "allow external traffic to *.httbin.org",
 			config:      "egress-rule-wildcard-httpbin.yaml.tmpl",
@@ -67,6 +132,13 @@ func (t *egressRules) run() error {
 				return t.verifyReachable("http://httpbin.org/headers", false)
 			},
 		},
+		{
+			description: "prohibit tls traffic to www.httbin.org when allowing http to *.httbin.org",
+			config:      "egress-rule-wildcard-httpbin.yaml.tmpl",
+			check: func() error {
+				return t.verifyReachable("https://www.httpbin.org/headers", false)
+			},
+		},
 		{
 			description: "allow external http2 traffic to nghttp2.org",
 			config:      "egress-rule-nghttp2.yaml.tmpl",
@@ -75,10 +147,17 @@ func (t *egressRules) run() error {
 			},
 		},
 		{
-			description: "prohibit https to httbin.org",
-			config:      "egress-rule-httpbin.yaml.tmpl",
+			description: "prohibit tls traffic to nghttp2.org when allowing http2",
+			config:      "egress-rule-nghttp2.yaml.tmpl",
 			check: func() error {
-				return t.verifyReachable("http://httpbin.org:443/headers", false)
+				return t.verifyReachable("https://nghttp2.org", false)
+			},
+		},
+		{
+			description: "prohibit httbin.org when allowing nghttp2.org",
+			config:      "egress-rule-nghttp2.yaml.tmpl",
+			check: func() error {
+				return t.verifyReachable("http://httpbin.org/headers", false)
 			},
 		},
 		{
@@ -95,22 +174,88 @@ func (t *egressRules) run() error {
 				return t.verifyReachable("https://cnn.com", false)
 			},
 		},
+		{
+			description: "prohibit http traffic to www.wikipedia.org when allowing tcp port 443",
+			config:      "egress-rule-tcp-wikipedia-cidr.yaml.tmpl",
+			check: func() error {
+				return t.verifyReachable("http://www.wikipedia.org", false)
+			},
+		},
+		{
+			description: "allow tcp traffic to the first port of a range",
+			config:      "egress-rule-tcp-portquiz-range.yaml.tmpl",
+			data:        portquizRange,
+			check: func() error {
+				return t.verifyPortReachable(portquizHost, portquizRangeFrom, true)
+			},
+		},
+		{
+			description: "allow tcp traffic to the last port of a range",
+			config:      "egress-rule-tcp-portquiz-range.yaml.tmpl",
+			data:        portquizRange,
+			check: func() error {
+				return t.verifyPortReachable(portquizHost, portquizRangeTo, true)
+			},
+		},
+		{
+			description: "prohibit tcp traffic to the port below a range",
+			config:      "egress-rule-tcp-portquiz-range.yaml.tmpl",
+			data:        portquizRange,
+			check: func() error {
+				return t.verifyPortReachable(portquizHost, portquizRangeFrom-1, false)
+			},
+		},
+		{
+			description: "prohibit tcp traffic to the port above a range",
+			config:      "egress-rule-tcp-portquiz-range.yaml.tmpl",
+			data:        portquizRange,
+			check: func() error {
+				return t.verifyPortReachable(portquizHost, portquizRangeTo+1, false)
+			},
+		},
+		{
+			description: "prohibit tcp traffic to other hosts on a port in a range",
+			config:      "egress-rule-tcp-portquiz-range.yaml.tmpl",
+			data:        portquizRange,
+			check: func() error {
+				return t.verifyPortReachable("httpbin.org", portquizRangeFrom, false)
+			},
+		},
+	}
+}
+
+// templates fills the rules with a documentation CIDR, as the portquiz CIDR is only looked up by run.
+func (t *egressRules) templates() []testdataTemplate {
+	var out []testdataTemplate
+	for _, cs := range t.cases("192.0.2.0/24") {
+		out = append(out, testdataTemplate{name: cs.config, values: cs.data, config: true})
 	}
+	return out
+}
+
+// Each egress rule is checked for the traffic it allows and for the neighbouring traffic it must
+// not allow: other hosts, and other protocols or ports to the same host. This catches over-broad rules.
+func (t *egressRules) run() error {
+	portquiz, err := lookupCIDR(portquizHost)
+	if err != nil {
+		return err
+	}
+
 	var errs error
-	for _, cs := range cases {
+	for _, cs := range t.cases(portquiz) {
 		tlog("Checking egressRules test", cs.description)
-		if err := t.applyConfig(cs.config, nil); err != nil {
+		if err := t.applyConfig(cs.config, cs.data); err != nil {
 			return err
 		}
 
-		if err := repeat(cs.check, 3, time.Second); err != nil {
+		if err := repeatBackoff(cs.check, repeatBudgetFor(t), time.Second, 8*time.Second); err != nil {
 			log.Infof("Failed the test with %v", err)
 			errs = multierror.Append(errs, multierror.Prefix(err, cs.description))
 		} else {
 			log.Info("Success!")
 		}
 
-		if err := t.deleteConfig(cs.config, nil); err != nil {
+		if err := t.deleteConfig(cs.config, cs.data); err != nil {
 			return err
 		}
 	}
@@ -126,10 +271,23 @@ func (t *egressRules) teardown() {
 
 // verifyReachable verifies that the url is reachable
 func (t *egressRules) verifyReachable(url string, shouldBeReachable bool) error {
-	funcs := make(map[string]func() status)
-	for _, src := range []string{"a", "b"} {
-		name := fmt.Sprintf("Request from %s to %s", src, url)
-		funcs[name] = (func(src string) func() status {
+	return t.verify(url, shouldBeReachable, nil)
+}
+
+// verifyPortReachable verifies whether http on a port of the host is reachable
+func (t *egressRules) verifyPortReachable(host string, port int, shouldBeReachable bool) error {
+	return t.verify(fmt.Sprintf("http://%s:%d/", host, port), shouldBeReachable, nil)
+}
+
+// verifyReachableWithHeaders verifies that the url is reachable and that the responses carry the
+// expected headers. An empty expected value matches any value of the header.
+func (t *egressRules) verifyReachableWithHeaders(url string, expectedHeaders map[string]string) error {
+	return t.verify(url, true, expectedHeaders)
+}
+
+func (t *egressRules) verify(url string, shouldBeReachable bool, expectedHeaders map[string]string) error {
+	funcs := buildRequestMatrix("Request", []string{"a", "b"}, []string{url}, []string{""}, []string{""},
+		func(src, _, _, _ string) func() status {
 			trace := fmt.Sprint(time.Now().UnixNano())
 			return func() status {
 				resp := t.clientRequest(src, url, 1, fmt.Sprintf("-key Trace-Id -val %q", trace))
@@ -138,13 +296,55 @@ func (t *egressRules) verifyReachable(url string, shouldBeReachable bool) error
 					return fmt.Errorf("%s is reachable from %s (should be unreachable)", url, src)
 				}
 				if !reachable && shouldBeReachable {
-					return errAgain
+					return resp.retry()
+				}
+				if reachable {
+					if err := matchHeaders(resp, expectedHeaders); err != nil {
+						return fmt.Errorf("request from %s to %s: %v", src, url, err)
+					}
 				}
 
 				return nil
 			}
-		})(src)
+		})
+
+	return parallel(funcs, budgetFor(t))
+}
+
+// matchHeaders checks that a response carries the expected headers
+func matchHeaders(resp response, expectedHeaders map[string]string) error {
+	for name, value := range expectedHeaders {
+		values, exists := resp.headers[nethttp.CanonicalHeaderKey(name)]
+		if !exists {
+			return fmt.Errorf("missing response header %s (traffic bypassed the sidecar?)", name)
+		}
+		if value == "" {
+			continue
+		}
+		found := false
+		for _, v := range values {
+			if v == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("response header %s has values %v, want %q", name, values, value)
+		}
 	}
+	return nil
+}
 
-	return parallel(funcs)
+// lookupCIDR returns a single address CIDR for the first IPv4 address of the host, for tcp egress rules
+func lookupCIDR(host string) (string, error) {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.String() + "/32", nil
+		}
+	}
+	return "", fmt.Errorf("no IPv4 address for %s", host)
 }
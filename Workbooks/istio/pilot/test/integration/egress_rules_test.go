@@ -0,0 +1,45 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestMatchHeaders(t *testing.T) {
+	resp := response{
+		headers: map[string][]string{
+			"X-Envoy-Upstream-Service-Time": {"12"},
+			"Server":                        {"envoy"},
+		},
+	}
+	cases := []struct {
+		name     string
+		expected map[string]string
+		wantErr  bool
+	}{
+		{name: "no expectations"},
+		{name: "present with any value", expected: map[string]string{envoyUpstreamServiceTimeHeader: ""}},
+		{name: "present with value", expected: map[string]string{"server": "envoy"}},
+		{name: "wrong value", expected: map[string]string{"server": "nginx"}, wantErr: true},
+		{name: "missing", expected: map[string]string{"x-envoy-decorator-operation": ""}, wantErr: true},
+	}
+	for _, c := range cases {
+		err := matchHeaders(resp, c.expected)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: matchHeaders() => %v, want error %t", c.name, err, c.wantErr)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Fault injection tests
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// faultHeaderKey and faultHeaderVal select the requests the fault rules apply to,
+	// so that the rules do not disturb other traffic from a to c
+	faultHeaderKey = "fault-injection"
+	faultHeaderVal = "enabled"
+
+	faultSamples      = 100
+	faultAbortPercent = 50
+	faultAbortCode    = 503
+	faultDelay        = 3 * time.Second
+)
+
+type faultInjection struct {
+	*infra
+}
+
+func (t *faultInjection) String() string {
+	return "fault-injection"
+}
+
+func (t *faultInjection) serial() {}
+
+func (t *faultInjection) setup() error {
+	return nil
+}
+
+// faultCase applies a fault rule and checks the faults it injects
+type faultCase struct {
+	description string
+	config      string
+	data        map[string]string
+	check       func() error
+}
+
+// cases returns the fault rule cases, whose delays are checked against the baseline latency.
+func (t *faultInjection) cases(baseline time.Duration) []faultCase {
+	cases := []faultCase{
+		{
+			description: fmt.Sprintf("delaying all requests to c by %v", faultDelay),
+			config:      "rule-fault-delay.yaml.tmpl",
+			data:        map[string]string{"delay": faultDelay.String()},
+			check: func() error {
+				return t.verifyDelay("a", "c", faultSamples, baseline, faultDelay)
+			},
+		},
+		{
+			description: fmt.Sprintf("aborting %d percent of the requests to c with %d", faultAbortPercent, faultAbortCode),
+			config:      "rule-fault-abort.yaml.tmpl",
+			data: map[string]string{
+				"percent": strconv.Itoa(faultAbortPercent),
+				"code":    strconv.Itoa(faultAbortCode),
+			},
+			check: func() error {
+				return t.verifyAbort("a", "c", faultSamples, faultAbortPercent, faultAbortCode)
+			},
+		},
+	}
+	for _, cs := range cases {
+		cs.data["headerKey"] = faultHeaderKey
+		cs.data["headerVal"] = faultHeaderVal
+	}
+	return cases
+}
+
+func (t *faultInjection) templates() []testdataTemplate {
+	var out []testdataTemplate
+	for _, cs := range t.cases(0) {
+		out = append(out, testdataTemplate{name: cs.config, values: cs.data, config: true})
+	}
+	return out
+}
+
+func (t *faultInjection) run() error {
+	// the client sends its requests concurrently, so the delay adds up once to the latency of a batch
+	baseline, err := t.batchLatency("a", "c", faultSamples)
+	if err != nil {
+		return fmt.Errorf("failed to measure the latency without faults: %v", err)
+	}
+	log.Infof("Latency of %d requests without faults: %v", faultSamples, baseline)
+
+	var errs error
+	for _, cs := range t.cases(baseline) {
+		tlog("Checking fault injection test", cs.description)
+		if err := t.applyConfig(cs.config, cs.data); err != nil {
+			return err
+		}
+
+		if err := repeatBackoff(cs.check, repeatBudgetFor(t), time.Second, 8*time.Second); err != nil {
+			log.Infof("Failed the test with %v", err)
+			errs = multierror.Append(errs, multierror.Prefix(err, cs.description))
+		} else {
+			log.Info("Success!")
+		}
+
+		if err := t.deleteConfig(cs.config, cs.data); err != nil {
+			return err
+		}
+	}
+	return errs
+}
+
+func (t *faultInjection) teardown() {
+	log.Info("Cleaning up route rules...")
+	if err := t.deleteAllConfigs(); err != nil {
+		log.Warna(err)
+	}
+}
+
+// faultRequest makes samples requests from src to dst subject to the fault rules, returning the
+// response and the time it took
+func (t *faultInjection) faultRequest(src, dst string, samples int) (response, time.Duration) {
+	url := fmt.Sprintf("http://%s/%s", dst, src)
+	log.Infof("Making %d requests (%s) from %s...\n", samples, url, src)
+
+	start := time.Now()
+	resp := t.clientRequest(src, url, samples, fmt.Sprintf("-key %s -val %s", faultHeaderKey, faultHeaderVal))
+	return resp, time.Since(start)
+}
+
+// batchLatency returns the time samples successful requests from src to dst take
+func (t *faultInjection) batchLatency(src, dst string, samples int) (time.Duration, error) {
+	resp, elapsed := t.faultRequest(src, dst, samples)
+	if got := counts(resp.code)[httpOk]; got != samples {
+		return 0, fmt.Errorf("%d of %d requests succeeded, response codes %v", got, samples, counts(resp.code))
+	}
+	return elapsed, nil
+}
+
+// verifyDelay verifies that the latency of samples requests from src to dst increased by delay
+// over the baseline, give or take a quarter of the delay for the variance of the baseline
+func (t *faultInjection) verifyDelay(src, dst string, samples int, baseline, delay time.Duration) error {
+	elapsed, err := t.batchLatency(src, dst, samples)
+	if err != nil {
+		return err
+	}
+	epsilon := delay / 4
+	if increase := elapsed - baseline; increase < delay-epsilon {
+		return fmt.Errorf("latency increased by %v (from %v to %v), expected at least %v - %v",
+			increase, baseline, elapsed, delay, epsilon)
+	}
+	return nil
+}
+
+// verifyAbort verifies that percent of samples requests from src to dst are aborted with code,
+// within abortTolerance, and that the others succeed
+func (t *faultInjection) verifyAbort(src, dst string, samples, percent, code int) error {
+	resp, _ := t.faultRequest(src, dst, samples)
+	count := counts(resp.code)
+	log.Infof("response code counts %v", count)
+
+	aborted := count[strconv.Itoa(code)]
+	if aborted+count[httpOk] != samples {
+		return fmt.Errorf("expected %d responses with code %d or %s => Got %v", samples, code, httpOk, count)
+	}
+	expected := float64(samples*percent) / 100
+	epsilon := abortTolerance(samples, percent)
+	if math.Abs(float64(aborted)-expected) > epsilon {
+		return fmt.Errorf("expected %v requests (+/-%.1f) to be aborted with %d => Got %v",
+			expected, epsilon, code, aborted)
+	}
+	return nil
+}
+
+// abortTolerance returns how far the number of aborted requests may deviate from samples*percent/100:
+// each request is aborted independently, so the count follows a binomial distribution, and four
+// standard deviations make a correct rule fail the check about once in 16000 attempts.
+func abortTolerance(samples, percent int) float64 {
+	p := float64(percent) / 100
+	return 4 * math.Sqrt(float64(samples)*p*(1-p))
+}
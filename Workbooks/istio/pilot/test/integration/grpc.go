@@ -41,7 +41,7 @@ func (t *grpc) run() error {
 	if err := t.makeRequests(); err != nil {
 		return err
 	}
-	return t.logs.check(t.infra)
+	return t.logs.check(t.infra, budgetFor(t))
 }
 
 func (t *grpc) makeRequests() error {
@@ -101,5 +101,5 @@ func (t *grpc) makeRequests() error {
 			}
 		}
 	}
-	return parallel(funcs)
+	return parallel(funcs, budgetFor(t))
 }
@@ -62,5 +62,5 @@ func (t *headless) run() error {
 			}
 		}
 	}
-	return parallel(funcs)
+	return parallel(funcs, budgetFor(t))
 }
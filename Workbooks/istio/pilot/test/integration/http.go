@@ -43,7 +43,7 @@ func (r *http) run() error {
 	if err := r.makeRequests(); err != nil {
 		return err
 	}
-	return r.logs.check(r.infra)
+	return r.logs.check(r.infra, budgetFor(r))
 }
 
 // makeRequests executes requests in pods and collects request ids per pod to check against access logs
@@ -118,5 +118,5 @@ func (r *http) makeRequests() error {
 			}
 		}
 	}
-	return parallel(funcs)
+	return parallel(funcs, budgetFor(r))
 }
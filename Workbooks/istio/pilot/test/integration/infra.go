@@ -15,7 +15,6 @@
 package main
 
 import (
-	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -23,12 +22,12 @@ import (
 	"encoding/base64"
 	"encoding/pem"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"math/big"
+	"net"
+	nethttp "net/http"
 	"regexp"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
@@ -76,9 +75,19 @@ type infra struct { // nolint: maligned
 	SkipCleanup          bool
 	SkipCleanupOnFailure bool
 
+	// keep the infrastructure up after the tests until interrupted
+	Hold bool
+
+	// run against infrastructure deployed by an earlier run, which is
+	// neither set up nor torn down
+	reuse bool
+
 	// check proxy logs
 	checkLogs bool
 
+	// follow pod logs into errorLogsDir during the run
+	streamLogs bool
+
 	// store error logs in specific directory
 	errorLogsDir string
 
@@ -97,7 +106,7 @@ type infra struct { // nolint: maligned
 	config model.IstioConfigStore
 }
 
-func (infra *infra) setup() error {
+func (infra *infra) setupConfigStore() error {
 	crdclient, crderr := crd.NewClient(kubeconfig, model.IstioConfigTypes, "")
 	if crderr != nil {
 		return crderr
@@ -107,6 +116,27 @@ func (infra *infra) setup() error {
 	}
 
 	infra.config = model.MakeIstioStore(crdclient)
+	return nil
+}
+
+// attach prepares to run the tests against the infrastructure and apps
+// already deployed in the namespaces by an earlier run.
+func (infra *infra) attach() error {
+	if err := infra.setupConfigStore(); err != nil {
+		return err
+	}
+	for _, ns := range []string{infra.IstioNamespace, infra.Namespace} {
+		if _, err := client.CoreV1().Namespaces().Get(ns, meta_v1.GetOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (infra *infra) setup() error {
+	if err := infra.setupConfigStore(); err != nil {
+		return err
+	}
 
 	if infra.Namespace == "" {
 		var err error
@@ -132,28 +162,95 @@ func (infra *infra) setup() error {
 		}
 	}
 
-	deploy := func(name, namespace string) error {
-		if yaml, err := fill(name, infra); err != nil {
-			return err
-		} else if err = infra.kubeApply(yaml, namespace); err != nil {
+	for _, t := range infra.setupTemplates() {
+		if err := t.apply(infra); err != nil {
 			return err
 		}
-		return nil
 	}
-	if err := deploy("rbac-beta.yaml.tmpl", infra.IstioNamespace); err != nil {
-		return err
+	return nil
+}
+
+// setupTemplates returns the templates setup applies, in order
+func (infra *infra) setupTemplates() []testdataTemplate {
+	out := []testdataTemplate{
+		{name: "rbac-beta.yaml.tmpl", values: infra, namespace: infra.IstioNamespace},
+		{name: "config.yaml.tmpl", values: infra, namespace: infra.IstioNamespace, deployed: infra.loadInjectConfig},
+	}
+	if infra.UseInitializer {
+		out = append(out,
+			testdataTemplate{name: "initializer-config.yaml.tmpl", values: infra, namespace: infra.IstioNamespace},
+			// filled with the inject config of the deployed mesh config
+			testdataTemplate{name: "initializer-configmap.yaml.tmpl", values: &infra.InjectConfig, namespace: infra.IstioNamespace},
+			testdataTemplate{name: "initializer.yaml.tmpl", values: infra, namespace: infra.IstioNamespace,
+				// InitializerConfiguration will block *all* deployments and
+				// could possibly lead to timeouts when trying to create other
+				// Istio runtime components. Wait until it's pod is ready
+				// before proceeding with the test setup.
+				deployed: func() error {
+					if _, err := util.GetAppPods(client, kubeconfig, []string{infra.IstioNamespace}); err != nil {
+						return fmt.Errorf("initialized failed to start: %v", err)
+					}
+					return nil
+				}})
+	}
+	if infra.UseAdmissionWebhook {
+		out = append(out, testdataTemplate{name: "pilot-webhook-secret.yaml.tmpl", values: webhookSecretValues(nil, nil, nil),
+			deploy: infra.createAdmissionWebhookSecret})
+	}
+	out = append(out, testdataTemplate{name: "pilot.yaml.tmpl", values: infra, namespace: infra.IstioNamespace})
+	if infra.Mixer {
+		out = append(out, testdataTemplate{name: "mixer.yaml.tmpl", values: infra, namespace: infra.IstioNamespace})
+	}
+	if platform.ServiceRegistry(infra.Registry) == platform.EurekaRegistry {
+		out = append(out, testdataTemplate{name: "eureka.yaml.tmpl", values: infra, namespace: infra.IstioNamespace})
+	}
+	out = append(out,
+		testdataTemplate{name: "ca.yaml.tmpl", values: infra, namespace: infra.IstioNamespace},
+		testdataTemplate{name: "headless.yaml.tmpl", values: infra, namespace: infra.Namespace})
+	if infra.Ingress {
+		out = append(out, testdataTemplate{name: "ingress-proxy.yaml.tmpl", values: infra, namespace: infra.IstioNamespace,
+			deployed: infra.createIngressSecret})
 	}
+	if infra.Zipkin {
+		out = append(out, testdataTemplate{name: "zipkin.yaml", values: infra, namespace: infra.IstioNamespace})
+	}
+	return out
+}
 
-	if err := deploy("config.yaml.tmpl", infra.IstioNamespace); err != nil {
+// loadInjectConfig sets the inject config of the apps from the deployed mesh config
+func (infra *infra) loadInjectConfig() error {
+	_, mesh, err := inject.GetMeshConfig(client, infra.IstioNamespace, "istio", inject.DefaultRetryPolicy)
+	if err != nil {
 		return err
 	}
+	log.Infof("mesh %s", spew.Sdump(mesh))
+	infra.InjectConfig = infra.injectConfig(mesh)
+	return nil
+}
 
-	_, mesh, err := inject.GetMeshConfig(client, infra.IstioNamespace, "istio")
+// createIngressSecret creates the ingress key/cert in a secret
+func (infra *infra) createIngressSecret() error {
+	key, err := ioutil.ReadFile("pilot/docker/certs/cert.key")
 	if err != nil {
 		return err
 	}
+	crt, err := ioutil.ReadFile("pilot/docker/certs/cert.crt")
+	if err != nil {
+		return err
+	}
+	_, err = client.CoreV1().Secrets(infra.IstioNamespace).Create(&v1.Secret{
+		ObjectMeta: meta_v1.ObjectMeta{Name: ingressSecretName},
+		Data: map[string][]byte{
+			"tls.key": key,
+			"tls.crt": crt,
+		},
+	})
+	return err
+}
+
+// injectConfig returns the sidecar injection config of the apps for the mesh config
+func (infra *infra) injectConfig(mesh *meshconfig.MeshConfig) *inject.Config {
 	debugMode := infra.debugImagesAndMode
-	log.Infof("mesh %s", spew.Sdump(mesh))
 
 	// Default to NamespaceAll to mirror kube-inject behavior. Only
 	// use a specific include namespace for the automatic injection.
@@ -162,7 +259,7 @@ func (infra *infra) setup() error {
 		includeNamespaces = []string{infra.Namespace}
 	}
 
-	infra.InjectConfig = &inject.Config{
+	return &inject.Config{
 		Policy:            inject.InjectionPolicyEnabled,
 		IncludeNamespaces: includeNamespaces,
 		Params: inject.Params{
@@ -176,122 +273,75 @@ func (infra *infra) setup() error {
 			DebugMode:       debugMode,
 		},
 	}
+}
 
-	if infra.UseInitializer {
-		if err := deploy("initializer-config.yaml.tmpl", infra.IstioNamespace); err != nil {
-			return err
-		}
-		if yaml, err := fill("initializer-configmap.yaml.tmpl", &infra.InjectConfig); err != nil {
-			return err
-		} else if err = infra.kubeApply(yaml, infra.IstioNamespace); err != nil {
-			return err
-		}
-		if err := deploy("initializer.yaml.tmpl", infra.IstioNamespace); err != nil {
-			return err
-		}
-		// InitializerConfiguration will block *all* deployments and
-		// could possibly lead to timeouts when trying to create other
-		// Istio runtime components. Wait until it's pod is ready
-		// before proceeding with the test setup.
-		if _, err = util.GetAppPods(client, kubeconfig, []string{infra.IstioNamespace}); err != nil {
-			return fmt.Errorf("initialized failed to start: %v", err)
-		}
-	}
-
-	if infra.UseAdmissionWebhook {
-		if err := infra.createAdmissionWebhookSecret(); err != nil {
-			return err
-		}
-	}
+// appServices are the "app" labels of the apps deployed by deployApps
+var appServices = []string{"t", "a", "b", "c", "d", "fake-control"}
 
-	if err := deploy("pilot.yaml.tmpl", infra.IstioNamespace); err != nil {
-		return err
-	}
-	if infra.Mixer {
-		if err := deploy("mixer.yaml.tmpl", infra.IstioNamespace); err != nil {
-			return err
+// checkApps returns an error if any app deployed by deployApps has no pod.
+func (infra *infra) checkApps() error {
+	var missing []string
+	for _, app := range appServices {
+		if len(infra.apps[app]) == 0 {
+			missing = append(missing, app)
 		}
 	}
-	if platform.ServiceRegistry(infra.Registry) == platform.EurekaRegistry {
-		if err := deploy("eureka.yaml.tmpl", infra.IstioNamespace); err != nil {
-			return err
-		}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing pods for apps %v in namespace %s", missing, infra.Namespace)
 	}
+	return nil
+}
 
-	if err := deploy("ca.yaml.tmpl", infra.IstioNamespace); err != nil {
-		return err
-	}
-	if err := deploy("headless.yaml.tmpl", infra.Namespace); err != nil {
-		return err
-	}
-	if infra.Ingress {
-		if err := deploy("ingress-proxy.yaml.tmpl", infra.IstioNamespace); err != nil {
-			return err
-		}
-		// Create ingress key/cert in secret
-		key, err := ioutil.ReadFile("pilot/docker/certs/cert.key")
-		if err != nil {
-			return err
-		}
-		crt, err := ioutil.ReadFile("pilot/docker/certs/cert.crt")
-		if err != nil {
-			return err
-		}
-		_, err = client.CoreV1().Secrets(infra.IstioNamespace).Create(&v1.Secret{
-			ObjectMeta: meta_v1.ObjectMeta{Name: ingressSecretName},
-			Data: map[string][]byte{
-				"tls.key": key,
-				"tls.crt": crt,
-			},
-		})
-		if err != nil {
-			return err
-		}
-	}
-	if infra.Zipkin {
-		if err := deploy("zipkin.yaml", infra.IstioNamespace); err != nil {
+func (infra *infra) deployApps() error {
+	for _, t := range infra.appTemplates() {
+		if err := t.apply(infra); err != nil {
 			return err
 		}
 	}
-
 	return nil
 }
 
-func (infra *infra) deployApps() error {
-	// deploy a healthy mix of apps, with and without proxy
-	if err := infra.deployApp("t", "t", 8080, 80, 9090, 90, 7070, 70, "unversioned", false, false); err != nil {
-		return err
-	}
-	if err := infra.deployApp("a", "a", 8080, 80, 9090, 90, 7070, 70, "v1", true, false); err != nil {
-		return err
-	}
-	if err := infra.deployApp("b", "b", 80, 8080, 90, 9090, 70, 7070, "unversioned", true, false); err != nil {
-		return err
+// appTemplates returns the templates deployApps applies, a healthy mix of apps with and without proxy
+func (infra *infra) appTemplates() []testdataTemplate {
+	app := func(deployment, svcName string, port1, port2, port3, port4, port5, port6 int,
+		version string, injectProxy bool, perServiceAuth bool) testdataTemplate {
+		return testdataTemplate{
+			name: "app.yaml.tmpl",
+			values: infra.appValues(deployment, svcName, port1, port2, port3, port4, port5, port6,
+				version, injectProxy, perServiceAuth),
+			namespace: infra.Namespace,
+			inject:    injectProxy && !infra.UseInitializer,
+		}
 	}
-	if err := infra.deployApp("c-v1", "c", 80, 8080, 90, 9090, 70, 7070, "v1", true, false); err != nil {
-		return err
+	return []testdataTemplate{
+		app("t", "t", 8080, 80, 9090, 90, 7070, 70, "unversioned", false, false),
+		app("a", "a", 8080, 80, 9090, 90, 7070, 70, "v1", true, false),
+		app("b", "b", 80, 8080, 90, 9090, 70, 7070, "unversioned", true, false),
+		app("c-v1", "c", 80, 8080, 90, 9090, 70, 7070, "v1", true, false),
+		app("c-v2", "c", 80, 8080, 90, 9090, 70, 7070, "v2", true, false),
+		app("d", "d", 80, 8080, 90, 9090, 70, 7070, "per-svc-auth", true, true),
+		// Add another service without sidecar to test mTLS blacklisting (as in the e2e test
+		// environment, pilot can see only services in the test namespaces). This service
+		// will be listed in mtlsExcludedServices in the mesh config.
+		app("e", "fake-control", 80, 8080, 90, 9090, 70, 7070, "fake-control", false, false),
 	}
-	if err := infra.deployApp("c-v2", "c", 80, 8080, 90, 9090, 70, 7070, "v2", true, false); err != nil {
-		return err
-	}
-	if err := infra.deployApp("d", "d", 80, 8080, 90, 9090, 70, 7070, "per-svc-auth", true, true); err != nil {
-		return err
-	}
-	// Add another service without sidecar to test mTLS blacklisting (as in the e2e test
-	// environment, pilot can see only services in the test namespaces). This service
-	// will be listed in mtlsExcludedServices in the mesh config.
-	return infra.deployApp("e", "fake-control", 80, 8080, 90, 9090, 70, 7070, "fake-control", false, false)
 }
 
-func (infra *infra) deployApp(deployment, svcName string, port1, port2, port3, port4, port5, port6 int,
-	version string, injectProxy bool, perServiceAuth bool) error {
+// templates returns the templates setup and deployApps apply
+func (infra *infra) templates() []testdataTemplate {
+	return append(infra.setupTemplates(), infra.appTemplates()...)
+}
+
+// appValues returns the values app.yaml.tmpl is filled with to deploy an app
+func (infra *infra) appValues(deployment, svcName string, port1, port2, port3, port4, port5, port6 int,
+	version string, injectProxy bool, perServiceAuth bool) map[string]string {
 	// Eureka does not support management ports
 	healthPort := "true"
 	if platform.ServiceRegistry(infra.Registry) == platform.EurekaRegistry {
 		healthPort = "false"
 	}
 
-	w, err := fill("app.yaml.tmpl", map[string]string{
+	return map[string]string{
 		"Hub":            infra.Hub,
 		"Tag":            infra.Tag,
 		"service":        svcName,
@@ -307,24 +357,7 @@ func (infra *infra) deployApp(deployment, svcName string, port1, port2, port3, p
 		"istioNamespace": infra.IstioNamespace,
 		"injectProxy":    strconv.FormatBool(injectProxy),
 		"healthPort":     healthPort,
-	})
-	if err != nil {
-		return err
-	}
-
-	writer := new(bytes.Buffer)
-
-	if injectProxy && !infra.UseInitializer {
-		if err := inject.IntoResourceFile(infra.InjectConfig, strings.NewReader(w), writer); err != nil {
-			return err
-		}
-	} else {
-		if _, err := io.Copy(writer, strings.NewReader(w)); err != nil {
-			return err
-		}
 	}
-
-	return infra.kubeApply(writer.String(), infra.Namespace)
 }
 
 func (infra *infra) teardown() {
@@ -377,6 +410,21 @@ type response struct {
 	version []string
 	port    []string
 	code    []string
+
+	// response headers keyed by canonical header name
+	headers map[string][]string
+
+	// the request did not complete within --request-timeout
+	timedOut bool
+}
+
+// retry returns the status for a response that did not meet expectations yet: errTimeout if the
+// request timed out, errAgain otherwise
+func (r response) retry() status {
+	if r.timedOut {
+		return errTimeout
+	}
+	return errAgain
 }
 
 const httpOk = "200"
@@ -386,6 +434,7 @@ var (
 	versionRex = regexp.MustCompile("ServiceVersion=(.*)")
 	portRex    = regexp.MustCompile("ServicePort=(.*)")
 	codeRex    = regexp.MustCompile("StatusCode=(.*)")
+	headerRex  = regexp.MustCompile("ResponseHeader=([^:]*):(.*)")
 )
 
 func (infra *infra) clientRequest(app, url string, count int, extra string) response {
@@ -398,9 +447,13 @@ func (infra *infra) clientRequest(app, url string, count int, extra string) resp
 	pod := infra.apps[app][0]
 	cmd := fmt.Sprintf("kubectl exec %s --kubeconfig %s -n %s -c app -- client -url %s -count %d %s",
 		pod, kubeconfig, infra.Namespace, url, count, extra)
-	request, err := util.Shell(cmd)
+	request, err := util.ShellTimeout(cmd, requestTimeout)
 
-	if err != nil {
+	if err == util.ErrTimeout {
+		log.Errorf("client request timed out after %v for %s in %s", requestTimeout, url, app)
+		out.timedOut = true
+		return out
+	} else if err != nil {
 		log.Errorf("client request error %v for %s in %s", err, url, app)
 		return out
 	}
@@ -427,9 +480,33 @@ func (infra *infra) clientRequest(app, url string, count int, extra string) resp
 		out.code = append(out.code, code[1])
 	}
 
+	headers := headerRex.FindAllStringSubmatch(request, -1)
+	if len(headers) > 0 {
+		out.headers = make(map[string][]string)
+	}
+	for _, header := range headers {
+		key := nethttp.CanonicalHeaderKey(header[1])
+		out.headers[key] = append(out.headers[key], header[2])
+	}
+
 	return out
 }
 
+// serviceIPv6 returns the cluster IP of a service in the app namespace if it is an IPv6 address,
+// or an empty string on single-stack IPv4 clusters and for headless services
+func (infra *infra) serviceIPv6(name string) string {
+	svc, err := client.CoreV1().Services(infra.Namespace).Get(name, meta_v1.GetOptions{})
+	if err != nil {
+		log.Warnf("Failed to look up service %s: %v", name, err)
+		return ""
+	}
+	ip := net.ParseIP(svc.Spec.ClusterIP)
+	if ip == nil || ip.To4() != nil {
+		return ""
+	}
+	return ip.String()
+}
+
 func (infra *infra) applyConfig(inFile string, data map[string]string) error {
 	config, err := fill(inFile, data)
 	if err != nil {
@@ -574,17 +651,21 @@ func (infra *infra) createAdmissionWebhookSecret() error {
 	if err != nil {
 		return err
 	}
-	data := map[string]string{
+	yaml, err := fill("pilot-webhook-secret.yaml.tmpl", webhookSecretValues(caCert, serverCert, serverKey))
+	if err != nil {
+		return err
+	}
+	return infra.kubeApply(yaml, infra.IstioNamespace)
+}
+
+// webhookSecretValues returns the values pilot-webhook-secret.yaml.tmpl is filled with
+func webhookSecretValues(caCert, serverCert, serverKey []byte) map[string]string {
+	return map[string]string{
 		"webhookName": "pilot-webhook",
 		"caCert":      base64.StdEncoding.EncodeToString(caCert),
 		"serverCert":  base64.StdEncoding.EncodeToString(serverCert),
 		"serverKey":   base64.StdEncoding.EncodeToString(serverKey),
 	}
-	yaml, err := fill("pilot-webhook-secret.yaml.tmpl", data)
-	if err != nil {
-		return err
-	}
-	return infra.kubeApply(yaml, infra.IstioNamespace)
 }
 
 func (infra *infra) deleteAdmissionWebhookSecret() error {
@@ -38,13 +38,19 @@ func (t *ingress) String() string {
 	return "ingress"
 }
 
+func (t *ingress) serial() {}
+
+func (t *ingress) unsupported(registry platform.ServiceRegistry) string {
+	if registry != platform.KubernetesRegistry {
+		return "ingress resources are only watched with the Kubernetes registry"
+	}
+	return ""
+}
+
 func (t *ingress) setup() error {
 	if !t.Ingress {
 		return nil
 	}
-	if platform.ServiceRegistry(t.Registry) != platform.KubernetesRegistry {
-		return nil
-	}
 	t.logs = makeAccessLogs()
 
 	// parse and send yamls
@@ -58,12 +64,19 @@ func (t *ingress) setup() error {
 	return t.applyConfig("rule-default-route.yaml.tmpl", nil)
 }
 
-func (t *ingress) run() error {
+func (t *ingress) templates() []testdataTemplate {
 	if !t.Ingress {
-		log.Info("skipping test since ingress is missing")
 		return nil
 	}
-	if platform.ServiceRegistry(t.Registry) != platform.KubernetesRegistry {
+	return []testdataTemplate{
+		{name: "ingress.yaml.tmpl", values: t.infra},
+		{name: "rule-default-route.yaml.tmpl", config: true},
+	}
+}
+
+func (t *ingress) run() error {
+	if !t.Ingress {
+		log.Info("skipping test since ingress is missing")
 		return nil
 	}
 
@@ -120,10 +133,10 @@ func (t *ingress) run() error {
 		})(req.dst, req.url, req.host)
 	}
 
-	if err := parallel(funcs); err != nil {
+	if err := parallel(funcs, budgetFor(t)); err != nil {
 		return err
 	}
-	return t.logs.check(t.infra)
+	return t.logs.check(t.infra, budgetFor(t))
 }
 
 // checkRouteRule verifies that version splitting is applied to ingress paths
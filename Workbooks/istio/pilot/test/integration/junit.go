@@ -0,0 +1,112 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// JUnit XML report of the driver runs, consumed by CI
+
+type junitTestSuites struct {
+	XMLName xml.Name          `xml:"testsuites"`
+	Suites  []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+
+	mu sync.Mutex
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// junitReport accumulates test results per infrastructure run
+type junitReport struct {
+	mu     sync.Mutex
+	suites []*junitTestSuite
+}
+
+// suite starts a new test suite, one per infrastructure run
+func (r *junitReport) suite(name string) *junitTestSuite {
+	s := &junitTestSuite{Name: name}
+	r.mu.Lock()
+	r.suites = append(r.suites, s)
+	r.mu.Unlock()
+	return s
+}
+
+// add records the outcome of a single test execution
+func (s *junitTestSuite) add(name string, elapsed time.Duration, err error) {
+	tc := junitTestCase{
+		Name:      name,
+		Classname: s.Name,
+		Time:      seconds(elapsed),
+	}
+	if err != nil {
+		tc.Failure = &junitFailure{
+			Message: fmt.Sprintf("%s failed", name),
+			Body:    err.Error(),
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Cases = append(s.Cases, tc)
+	s.Tests++
+	if err != nil {
+		s.Failures++
+	}
+}
+
+// finish records the total duration of the suite
+func (s *junitTestSuite) finish(elapsed time.Duration) {
+	s.mu.Lock()
+	s.Time = seconds(elapsed)
+	s.mu.Unlock()
+}
+
+// write the report to a file
+func (r *junitReport) write(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out, err := xml.MarshalIndent(junitTestSuites{Suites: r.suites}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append([]byte(xml.Header), out...), 0644)
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
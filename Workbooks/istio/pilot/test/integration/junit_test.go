@@ -0,0 +1,79 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJUnitReportWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "junit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	var r junitReport
+	suite := r.suite("(auth infra)")
+	suite.add("routing", 1500*time.Millisecond, nil)
+	suite.add("egress", 250*time.Millisecond, errors.New("no route to host"))
+	suite.finish(2 * time.Second)
+
+	path := filepath.Join(dir, "report.xml")
+	if err := r.write(path); err != nil {
+		t.Fatalf("write() returned an error: %v", err)
+	}
+	out, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(out), xml.Header) {
+		t.Errorf("the report does not start with the XML header:\n%s", out)
+	}
+
+	var got junitTestSuites
+	if err := xml.Unmarshal(out, &got); err != nil {
+		t.Fatalf("the report is not valid XML: %v\n%s", err, out)
+	}
+	if len(got.Suites) != 1 {
+		t.Fatalf("got %d suites, want 1:\n%s", len(got.Suites), out)
+	}
+	s := got.Suites[0]
+	if s.Name != "(auth infra)" || s.Tests != 2 || s.Failures != 1 || s.Time != "2.000" {
+		t.Errorf("unexpected suite attributes: name %q, tests %d, failures %d, time %q", s.Name, s.Tests, s.Failures, s.Time)
+	}
+	if len(s.Cases) != 2 {
+		t.Fatalf("got %d test cases, want 2:\n%s", len(s.Cases), out)
+	}
+
+	passed := s.Cases[0]
+	if passed.Name != "routing" || passed.Classname != "(auth infra)" || passed.Time != "1.500" || passed.Failure != nil {
+		t.Errorf("unexpected passing test case: %+v", passed)
+	}
+	failed := s.Cases[1]
+	if failed.Name != "egress" || failed.Time != "0.250" || failed.Failure == nil {
+		t.Fatalf("unexpected failing test case: %+v", failed)
+	}
+	if failed.Failure.Message != "egress failed" || failed.Failure.Body != "no route to host" {
+		t.Errorf("unexpected failure: %+v", *failed.Failure)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"istio.io/istio/pilot/platform/kube/inject"
+	"istio.io/istio/pilot/test/util"
+	"istio.io/istio/pkg/log"
+)
+
+// logStreams follows the logs of all pods of a deployment for the duration of a run, so that logs of
+// containers that crash or restart mid-test are not lost
+type logStreams struct {
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// startLogStreams starts following the logs of the istio and app pods into the error logs directory
+func (infra *infra) startLogStreams() *logStreams {
+	s := &logStreams{stop: make(chan struct{})}
+	if len(infra.errorLogsDir) == 0 {
+		log.Warn("Log streaming requires an error logs directory, skipping")
+		return s
+	}
+
+	for _, ns := range []string{infra.IstioNamespace, infra.Namespace} {
+		for _, pod := range util.GetPods(client, ns) {
+			container := logContainer(pod, ns == infra.IstioNamespace)
+			if container == "" {
+				continue
+			}
+			path := filepath.Join(infra.errorLogsDir, fmt.Sprintf("%s.%s.stream.txt", pod, container))
+			f, err := os.Create(path)
+			if err != nil {
+				log.Warnf("Failed to create log stream file %s: %v", path, err)
+				continue
+			}
+
+			s.wg.Add(1)
+			go func(pod, ns, container string) {
+				defer s.wg.Done()
+				defer f.Close() // nolint: errcheck
+				if err := util.StreamLogs(client, pod, ns, container, f, s.stop); err != nil {
+					log.Warnf("Log stream for %s.%s ended: %v", pod, ns, err)
+				}
+			}(pod, ns, container)
+		}
+	}
+	return s
+}
+
+// close stops all streams and waits for them to flush
+func (s *logStreams) close() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+// logContainer returns the container to follow for a pod, or an empty string if it has none of interest
+func logContainer(pod string, control bool) string {
+	switch {
+	case strings.HasPrefix(pod, "istio-pilot"):
+		return "discovery"
+	case strings.HasPrefix(pod, "istio-mixer"):
+		return "mixer"
+	case strings.HasPrefix(pod, "istio-ingress"):
+		return inject.ProxyContainerName
+	case control:
+		return ""
+	default:
+		return inject.ProxyContainerName
+	}
+}
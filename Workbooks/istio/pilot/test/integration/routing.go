@@ -36,17 +36,21 @@ func (t *routing) String() string {
 	return "routing-rules"
 }
 
+func (t *routing) serial() {}
+
 func (t *routing) setup() error {
 	return nil
 }
 
-// TODO: test negatives
-func (t *routing) run() error {
-	cases := []struct {
-		description string
-		config      string
-		check       func() error
-	}{
+// routingCase applies a route rule and checks the traffic it routes
+type routingCase struct {
+	description string
+	config      string
+	check       func() error
+}
+
+func (t *routing) cases() []routingCase {
+	return []routingCase{
 		{
 			// First test default routing
 			description: "routing all traffic to c-v1",
@@ -125,15 +129,26 @@ func (t *routing) run() error {
 			},
 		},
 	}
+}
+
+func (t *routing) templates() []testdataTemplate {
+	var out []testdataTemplate
+	for _, cs := range t.cases() {
+		out = append(out, testdataTemplate{name: cs.config, config: true})
+	}
+	return out
+}
 
+// TODO: test negatives
+func (t *routing) run() error {
 	var errs error
-	for _, cs := range cases {
+	for _, cs := range t.cases() {
 		tlog("Checking routing test", cs.description)
 		if err := t.applyConfig(cs.config, nil); err != nil {
 			return err
 		}
 
-		if err := repeat(cs.check, 3, time.Second); err != nil {
+		if err := repeat(cs.check, repeatBudgetFor(t), time.Second); err != nil {
 			log.Infof("Failed the test with %v", err)
 			errs = multierror.Append(errs, multierror.Prefix(err, cs.description))
 		} else {
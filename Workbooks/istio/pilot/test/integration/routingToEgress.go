@@ -37,18 +37,24 @@ func (t *routingToEgress) String() string {
 	return "routing-rules-to-egress"
 }
 
+func (t *routingToEgress) serial() {}
+
 func (t *routingToEgress) setup() error {
 	return nil
 }
 
-func (t *routingToEgress) run() error {
-	cases := []struct {
-		description   string
-		configEgress  []string
-		configRouting string
-		routingData   map[string]string
-		check         func() error
-	}{
+// egressRoutingCase applies egress rules and a route rule to the external hosts, and checks the
+// traffic it routes
+type egressRoutingCase struct {
+	description   string
+	configEgress  []string
+	configRouting string
+	routingData   map[string]string
+	check         func() error
+}
+
+func (t *routingToEgress) cases() []egressRoutingCase {
+	return []egressRoutingCase{
 		// Fault Injection
 		{
 			description:   "inject a http fault in traffic to httpbin.org",
@@ -198,9 +204,22 @@ func (t *routingToEgress) run() error {
 			},
 		},
 	}
+}
 
+func (t *routingToEgress) templates() []testdataTemplate {
+	var out []testdataTemplate
+	for _, cs := range t.cases() {
+		for _, configEgress := range cs.configEgress {
+			out = append(out, testdataTemplate{name: configEgress, config: true})
+		}
+		out = append(out, testdataTemplate{name: cs.configRouting, values: cs.routingData, config: true})
+	}
+	return out
+}
+
+func (t *routingToEgress) run() error {
 	var errs error
-	for _, cs := range cases {
+	for _, cs := range t.cases() {
 		tlog("Checking routing rule to egress rule test", cs.description)
 		for _, configEgress := range cs.configEgress {
 			if err := t.applyConfig(configEgress, nil); err != nil {
@@ -211,7 +230,7 @@ func (t *routingToEgress) run() error {
 			return err
 		}
 
-		if err := repeat(cs.check, 3, time.Second); err != nil {
+		if err := repeat(cs.check, repeatBudgetFor(t), time.Second); err != nil {
 			log.Infof("Failed the test with %v", err)
 			errs = multierror.Append(errs, multierror.Prefix(err, cs.description))
 		} else {
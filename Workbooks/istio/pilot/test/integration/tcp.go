@@ -29,6 +29,13 @@ func (t *tcp) String() string {
 	return "tcp-reachability"
 }
 
+func (t *tcp) unsupported(registry platform.ServiceRegistry) string {
+	if registry == platform.EurekaRegistry {
+		return "TCP in Eureka is tested by the headless service test"
+	}
+	return ""
+}
+
 func (t *tcp) setup() error {
 	return nil
 }
@@ -37,10 +44,6 @@ func (t *tcp) teardown() {
 }
 
 func (t *tcp) run() error {
-	// TCP in Eureka is tested by the headless service test.
-	if platform.ServiceRegistry(t.Registry) == platform.EurekaRegistry {
-		return nil
-	}
 	// Auth is enabled for d:9090 using per-service policy. We expect request
 	// from non-envoy client ("t") should fail all the time.
 	srcPods := []string{"a", "b", "t"}
@@ -49,37 +52,48 @@ func (t *tcp) run() error {
 		// t is not behind proxy, so it cannot talk in Istio auth.
 		dstPods = append(dstPods, "t")
 	}
-	funcs := make(map[string]func() status)
-	for _, src := range srcPods {
-		for _, dst := range dstPods {
-			if src == "t" && dst == "t" {
-				// this is flaky in minikube
-				continue
-			}
-			for _, port := range []string{":90", ":9090"} {
-				for _, domain := range []string{"", "." + t.Namespace} {
-					name := fmt.Sprintf("TCP connection from %s to %s%s%s", src, dst, domain, port)
-					funcs[name] = (func(src, dst, port, domain string) func() status {
-						url := fmt.Sprintf("http://%s%s%s/%s", dst, domain, port, src)
-						return func() status {
-							resp := t.clientRequest(src, url, 1, "")
-							if src == "t" &&
-								(t.Auth == meshconfig.MeshConfig_MUTUAL_TLS ||
-									(dst == "d" && port == ":9090")) {
-								// t cannot talk to envoy (a or b) when mTLS enabled,
-								// nor with d:9090 (which always has mTLS enabled).
-								if len(resp.code) == 0 || resp.code[0] != httpOk {
-									return nil
-								}
-							} else if len(resp.code) > 0 && resp.code[0] == httpOk {
-								return nil
-							}
-							return errAgain
-						}
-					})(src, dst, port, domain)
+	check := func(src, dst, host, port string) func() status {
+		if src == "t" && dst == "t" {
+			// this is flaky in minikube
+			return nil
+		}
+		url := fmt.Sprintf("http://%s%s/%s", host, port, src)
+		return func() status {
+			resp := t.clientRequest(src, url, 1, "")
+			if src == "t" &&
+				(t.Auth == meshconfig.MeshConfig_MUTUAL_TLS ||
+					(dst == "d" && port == ":9090")) {
+				// t cannot talk to envoy (a or b) when mTLS enabled,
+				// nor with d:9090 (which always has mTLS enabled).
+				if len(resp.code) == 0 || resp.code[0] != httpOk {
+					return nil
 				}
+			} else if len(resp.code) > 0 && resp.code[0] == httpOk {
+				return nil
 			}
+			return resp.retry()
+		}
+	}
+	ports := []string{":90", ":9090"}
+
+	funcs := buildRequestMatrix("TCP connection", srcPods, dstPods, ports, []string{"", "." + t.Namespace},
+		func(src, dst, port, domain string) func() status {
+			return check(src, dst, dst+domain, port)
+		})
+
+	// Dual-stack clusters are also checked with the literal IPv6 address of the service
+	for _, dst := range dstPods {
+		ip := t.serviceIPv6(dst)
+		if ip == "" {
+			continue
+		}
+		ipv6Funcs := buildRequestMatrix("TCP connection", srcPods, []string{"[" + ip + "]"}, ports, []string{""},
+			func(src, host, port, _ string) func() status {
+				return check(src, dst, host, port)
+			})
+		for name, f := range ipv6Funcs {
+			funcs[name] = f
 		}
 	}
-	return parallel(funcs)
+	return parallel(funcs, budgetFor(t))
 }
@@ -0,0 +1,181 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Configuration checks of --validate-only
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	multierror "github.com/hashicorp/go-multierror"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pilot/adapter/config/crd"
+	"istio.io/istio/pilot/model"
+	"istio.io/istio/pilot/platform/kube/inject"
+)
+
+// testdataTemplate is a testdata template and the values it is filled with
+type testdataTemplate struct {
+	name   string
+	values interface{}
+
+	// config templates hold Istio config applied with applyConfig, which must also parse
+	config bool
+
+	// namespace is where the infra applies the template
+	namespace string
+	// inject the sidecar proxy into the filled template before it is applied
+	inject bool
+	// deploy applies the template instead, if its values are only known at deployment
+	deploy func() error
+	// deployed is called once the template is applied
+	deployed func() error
+}
+
+// apply fills the template and applies it to the namespace
+func (t testdataTemplate) apply(infra *infra) error {
+	if t.deploy != nil {
+		if err := t.deploy(); err != nil {
+			return err
+		}
+	} else {
+		yaml, err := fill(t.name, t.values)
+		if err != nil {
+			return err
+		}
+		if t.inject {
+			writer := new(bytes.Buffer)
+			if err := inject.IntoResourceFile(infra.InjectConfig, strings.NewReader(yaml), writer); err != nil {
+				return err
+			}
+			yaml = writer.String()
+		}
+		if err := infra.kubeApply(yaml, t.namespace); err != nil {
+			return err
+		}
+	}
+	if t.deployed != nil {
+		return t.deployed()
+	}
+	return nil
+}
+
+// render fills the template, and parses it if it holds Istio config
+func (t testdataTemplate) render() error {
+	out, err := fill(t.name, t.values)
+	if err != nil {
+		return err
+	}
+	if t.config {
+		if _, _, err := crd.ParseInputs(out); err != nil {
+			return fmt.Errorf("%s: %v", t.name, err)
+		}
+	}
+	return nil
+}
+
+// validate checks that the infra settings are consistent
+func (infra *infra) validate() error {
+	var errs error
+	if infra.Tag == "" {
+		errs = multierror.Append(errs, errors.New("no docker tag specified"))
+	}
+	if _, err := parseRegistry(infra.Registry); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
+	auth := infra.Auth == meshconfig.MeshConfig_MUTUAL_TLS
+	if auth != (infra.MixerCustomConfigFile == mixerConfigAuthFile) ||
+		auth != (infra.PilotCustomConfigFile == pilotConfigAuthFile) {
+		errs = multierror.Append(errs, fmt.Errorf("auth policy %v does not match the proxy config files %s and %s",
+			infra.Auth, infra.MixerCustomConfigFile, infra.PilotCustomConfigFile))
+	}
+
+	if infra.reuse && (infra.Namespace == "" || infra.IstioNamespace == "") {
+		errs = multierror.Append(errs, errors.New("reusing infrastructure requires both the apps and Istio namespaces"))
+	}
+	if infra.UseAdmissionWebhook && infra.AdmissionServiceName == "" {
+		errs = multierror.Append(errs, errors.New("admission webhook enabled without a service name"))
+	}
+	if infra.DebugPort < 0 || infra.DebugPort > 65535 {
+		errs = multierror.Append(errs, fmt.Errorf("debug port %d is not in the range 0-65535", infra.DebugPort))
+	}
+	if infra.errorLogsDir != "" {
+		if info, err := os.Stat(infra.errorLogsDir); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("error logs directory: %v", err))
+		} else if !info.IsDir() {
+			errs = multierror.Append(errs, fmt.Errorf("error logs directory %s is not a directory", infra.errorLogsDir))
+		}
+	}
+	return errs
+}
+
+// validateConfig checks the flags, and renders every template the infrastructure and the tests would
+// fill for the auth mode, without touching the cluster. All problems found are returned.
+func validateConfig(authmode string, params infra) error {
+	var errs error
+	if _, err := newTestFilter(testType, runFilter, skipFilter); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
+	var envs []infra
+	switch authmode {
+	case "enable":
+		envs = []infra{setAuth(params)}
+	case "disable":
+		envs = []infra{params}
+	case "both":
+		if len(params.Namespace) != 0 {
+			errs = multierror.Append(errs, fmt.Errorf("namespace %s is specified, so auth mode must be enable or disable",
+				params.Namespace))
+		}
+		envs = []infra{params, setAuth(params)}
+	default:
+		errs = multierror.Append(errs, fmt.Errorf("invalid auth mode %q, want one of enable, disable or both", authmode))
+		// still check the templates of both modes
+		envs = []infra{params, setAuth(params)}
+	}
+
+	for i := range envs {
+		istio := &envs[i]
+		if err := istio.validate(); err != nil {
+			errs = multierror.Append(errs, multierror.Prefix(err, istio.Name))
+		}
+
+		// setup reads the mesh config deployed with config.yaml.tmpl from the cluster
+		mesh := model.DefaultMeshConfig()
+		istio.InjectConfig = istio.injectConfig(&mesh)
+		templates := istio.templates()
+		for _, test := range newTests(istio) {
+			if t, ok := test.(templated); ok {
+				templates = append(templates, t.templates()...)
+			}
+		}
+		// tests share templates, so report each failure once
+		failed := make(map[string]bool)
+		for _, t := range templates {
+			if err := t.render(); err != nil && !failed[err.Error()] {
+				failed[err.Error()] = true
+				errs = multierror.Append(errs, multierror.Prefix(err, istio.Name))
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,198 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	multierror "github.com/hashicorp/go-multierror"
+
+	meshconfig "istio.io/api/mesh/v1alpha1"
+	"istio.io/istio/pilot/platform"
+)
+
+// validInfra returns infra settings like main, with every component enabled
+func validInfra() infra {
+	return infra{
+		Name:                  "(default infra)",
+		Hub:                   "gcr.io/istio-testing",
+		Tag:                   "test",
+		Registry:              string(platform.KubernetesRegistry),
+		Verbosity:             2,
+		Auth:                  meshconfig.MeshConfig_NONE,
+		MixerCustomConfigFile: mixerConfigFile,
+		PilotCustomConfigFile: pilotConfigFile,
+		Mixer:                 true,
+		Ingress:               true,
+		Zipkin:                true,
+		UseInitializer:        true,
+		UseAdmissionWebhook:   true,
+		AdmissionServiceName:  "istio-pilot",
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	defer func(dir string) { testdataDir = dir }(testdataDir)
+	testdataDir = "testdata/"
+
+	cases := map[string]struct {
+		authmode string
+		modify   func(*infra)
+		want     []string
+	}{
+		"Both auth modes": {
+			authmode: "both",
+		},
+		"Eureka registry": {
+			authmode: "disable",
+			modify:   func(i *infra) { i.Registry = string(platform.EurekaRegistry) },
+		},
+		"Namespace with both auth modes": {
+			authmode: "both",
+			modify:   func(i *infra) { i.Namespace = "apps" },
+			want:     []string{"namespace apps is specified, so auth mode must be enable or disable"},
+		},
+		"Invalid auth mode": {
+			authmode: "sometimes",
+			want:     []string{`invalid auth mode "sometimes"`},
+		},
+		"Inconsistent infra": {
+			authmode: "disable",
+			modify: func(i *infra) {
+				i.Tag = ""
+				i.Registry = "zookeeper"
+				i.PilotCustomConfigFile = pilotConfigAuthFile
+				i.AdmissionServiceName = ""
+				i.DebugPort = -1
+			},
+			want: []string{
+				"no docker tag specified",
+				`unsupported registry "zookeeper"`,
+				"auth policy NONE does not match the proxy config files",
+				"admission webhook enabled without a service name",
+				"debug port -1 is not in the range 0-65535",
+			},
+		},
+	}
+
+	for id, c := range cases {
+		params := validInfra()
+		if c.modify != nil {
+			c.modify(&params)
+		}
+		err := validateConfig(c.authmode, params)
+		if len(c.want) == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", id, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: Succeeded. Error expected", id)
+			continue
+		}
+		if merr, ok := err.(*multierror.Error); !ok || len(merr.Errors) != len(c.want) {
+			t.Errorf("%s: got %v, want %d errors", id, err, len(c.want))
+		}
+		for _, want := range c.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: incorrect error message: %s VS %s", id, err.Error(), want)
+			}
+		}
+	}
+}
+
+func TestValidateConfigMissingTemplates(t *testing.T) {
+	defer func(dir string) { testdataDir = dir }(testdataDir)
+	dir, err := ioutil.TempDir("", "testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	testdataDir = dir + "/"
+
+	params := validInfra()
+	err = validateConfig("disable", params)
+	merr, ok := err.(*multierror.Error)
+	if !ok {
+		t.Fatalf("got %v, want a multierror", err)
+	}
+	// every template fails, but each only once
+	if want := len(templateNames(&params)); len(merr.Errors) != want {
+		t.Errorf("got %d errors, want %d: %v", len(merr.Errors), want, err)
+	}
+}
+
+// templateNames returns the templates the infra and the tests fill
+func templateNames(istio *infra) []string {
+	templates := istio.templates()
+	for _, test := range newTests(istio) {
+		if t, ok := test.(templated); ok {
+			templates = append(templates, t.templates()...)
+		}
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, t := range templates {
+		if !seen[t.name] {
+			seen[t.name] = true
+			names = append(names, t.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// unusedTestdata are testdata files no test fills
+var unusedTestdata = map[string]bool{
+	"egress-rule-google.yaml.tmpl": true,
+}
+
+func TestTemplatesCoverTestdata(t *testing.T) {
+	files, err := filepath.Glob("testdata/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, file := range files {
+		if name := filepath.Base(file); !unusedTestdata[name] {
+			want = append(want, name)
+		}
+	}
+	sort.Strings(want)
+
+	names := make(map[string]bool)
+	for _, params := range []infra{validInfra(), setAuth(validInfra())} {
+		params.Registry = string(platform.EurekaRegistry)
+		for _, name := range templateNames(&params) {
+			names[name] = true
+		}
+	}
+	var got []string
+	for name := range names {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("validated templates %v, want the testdata files %v", got, want)
+	}
+}
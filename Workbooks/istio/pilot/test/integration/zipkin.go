@@ -37,6 +37,11 @@ func (t *zipkin) String() string {
 	return "zipkin"
 }
 
+// traces are reported asynchronously and take a while to show up in the Zipkin API
+func (t *zipkin) retryBudget() int {
+	return 180
+}
+
 func (t *zipkin) setup() error {
 	if !t.Zipkin {
 		return nil
@@ -76,7 +81,7 @@ func (t *zipkin) makeRequests() error {
 			return errAgain
 		}
 	}
-	return parallel(funcs)
+	return parallel(funcs, budgetFor(t))
 }
 
 // verify that the traces were picked up by Zipkin
@@ -105,7 +110,7 @@ func (t *zipkin) verifyTraces() error {
 
 	return parallel(map[string]func() status{
 		"Ensure traces are picked up by Zipkin": f,
-	})
+	}, budgetFor(t))
 }
 
 func (t *zipkin) teardown() {
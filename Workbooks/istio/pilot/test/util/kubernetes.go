@@ -16,6 +16,7 @@ package util
 
 import (
 	"fmt"
+	"io"
 	"testing"
 	"time"
 	// TODO(nmittler): Remove this
@@ -168,6 +169,39 @@ func FetchLogs(cl kubernetes.Interface, name, namespace string, container string
 	return string(raw)
 }
 
+// StreamLogs follows the logs of a container in a pod, copying them to w until the container
+// exits or stop is closed
+func StreamLogs(cl kubernetes.Interface, name, namespace, container string, w io.Writer, stop <-chan struct{}) error {
+	log.Infof("Streaming log for container %s in %s.%s", container, name, namespace)
+	stream, err := cl.CoreV1().Pods(namespace).
+		GetLogs(name, &v1.PodLogOptions{Container: container, Follow: true}).
+		Stream()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			// unblocks the copy below
+			_ = stream.Close()
+		case <-done:
+		}
+	}()
+
+	_, err = io.Copy(w, stream)
+	select {
+	case <-stop:
+		// errors caused by closing the stream are expected
+		return nil
+	default:
+		_ = stream.Close()
+		return err
+	}
+}
+
 // Eventually retries until f() returns true, or it times out in error
 func Eventually(f func() bool, t *testing.T) {
 	interval := 64 * time.Millisecond
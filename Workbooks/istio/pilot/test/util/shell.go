@@ -15,16 +15,22 @@
 package util
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 	// TODO(nmittler): Remove this
 	_ "github.com/golang/glog"
 
 	"istio.io/istio/pkg/log"
 )
 
+// ErrTimeout is returned by ShellTimeout when the command does not complete in time
+var ErrTimeout = errors.New("command timed out")
+
 // Run command and stream output
 func Run(command string) error {
 	log.Info(command)
@@ -65,3 +71,23 @@ func Shell(command string) (string, error) {
 	}
 	return string(bytes), nil
 }
+
+// ShellTimeout is like Shell but kills the command and returns ErrTimeout if it takes longer than timeout
+func ShellTimeout(command string, timeout time.Duration) (string, error) {
+	log.Info(command)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	parts := strings.Split(command, " ")
+	/* #nosec */
+	c := exec.CommandContext(ctx, parts[0], parts[1:]...)
+	bytes, err := c.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Infof("command %q timed out after %v", command, timeout)
+		return "", ErrTimeout
+	}
+	if err != nil {
+		log.Info(string(bytes))
+		return "", fmt.Errorf("command %q failed: %q %v", command, string(bytes), err)
+	}
+	return string(bytes), nil
+}
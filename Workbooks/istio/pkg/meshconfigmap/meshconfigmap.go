@@ -0,0 +1,91 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package meshconfigmap reads the mesh ConfigMap shared by the Istio components.
+package meshconfigmap
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	// MeshKey is the key of the mesh config in the mesh ConfigMap.
+	MeshKey = "mesh"
+
+	// WorkloadCertTTLKey is the key of the TTL of the workload certificates issued by the CA.
+	// The mesh config has no field for it, so it is stored next to it.
+	WorkloadCertTTLKey = "workloadCertTTL"
+
+	// MaxWorkloadCertTTLKey is the key of the max TTL of the workload certificates issued by the CA.
+	MaxWorkloadCertTTLKey = "maxWorkloadCertTTL"
+)
+
+// RetryPolicy controls how fetching a ConfigMap is retried, e.g. while the
+// API server is not available yet at startup. The interval between attempts
+// starts at InitialInterval and doubles up to MaxInterval. No attempt is
+// started once MaxElapsedTime has passed since the first one, so the zero
+// value makes a single attempt.
+type RetryPolicy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	MaxElapsedTime  time.Duration
+}
+
+// DefaultRetryPolicy retries for up to a minute.
+var DefaultRetryPolicy = RetryPolicy{
+	InitialInterval: 500 * time.Millisecond,
+	MaxInterval:     10 * time.Second,
+	MaxElapsedTime:  60 * time.Second,
+}
+
+// Get fetches a ConfigMap, retrying failed requests per the policy.
+func Get(kube kubernetes.Interface, namespace, name string, retry RetryPolicy) (*v1.ConfigMap, error) {
+	start := time.Now()
+	interval := retry.InitialInterval
+	for {
+		config, err := kube.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+		if err == nil {
+			return config, nil
+		}
+		if time.Since(start)+interval > retry.MaxElapsedTime {
+			return nil, err
+		}
+		log.Warnf("Failed to fetch ConfigMap %s/%s, retrying in %v: %v", namespace, name, interval, err)
+		time.Sleep(interval)
+		if interval *= 2; interval > retry.MaxInterval {
+			interval = retry.MaxInterval
+		}
+	}
+}
+
+// CertTTL returns the workload cert TTL under the key of the mesh ConfigMap,
+// WorkloadCertTTLKey or MaxWorkloadCertTTLKey, and whether it is set.
+func CertTTL(configMap *v1.ConfigMap, key string) (time.Duration, bool, error) {
+	data, exists := configMap.Data[key]
+	if !exists {
+		return 0, false, nil
+	}
+	ttl, err := time.ParseDuration(data)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid %q in ConfigMap %s/%s: %v", key, configMap.Namespace, configMap.Name, err)
+	}
+	return ttl, true, nil
+}
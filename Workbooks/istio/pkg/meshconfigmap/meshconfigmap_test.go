@@ -0,0 +1,97 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package meshconfigmap
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGet(t *testing.T) {
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "istio", Namespace: "istio-system"},
+		Data:       map[string]string{MeshKey: ""},
+	}
+	client := fake.NewSimpleClientset(configMap)
+
+	got, err := Get(client, "istio-system", "istio", RetryPolicy{})
+	if err != nil {
+		t.Fatalf("Get() returned an error: %v", err)
+	}
+	if got.Name != "istio" {
+		t.Errorf("Get() returned ConfigMap %s, want istio", got.Name)
+	}
+	if _, err := Get(client, "istio-system", "missing", RetryPolicy{}); err == nil {
+		t.Error("missing ConfigMap: Succeeded. Error expected")
+	}
+}
+
+func TestCertTTL(t *testing.T) {
+	configMap := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "istio", Namespace: "istio-system"},
+		Data: map[string]string{
+			WorkloadCertTTLKey:    "30m",
+			MaxWorkloadCertTTLKey: "a day",
+		},
+	}
+
+	testCases := map[string]struct {
+		configMap   *v1.ConfigMap
+		key         string
+		expected    time.Duration
+		expectedSet bool
+		expectedErr string
+	}{
+		"Set": {
+			configMap:   configMap,
+			key:         WorkloadCertTTLKey,
+			expected:    30 * time.Minute,
+			expectedSet: true,
+		},
+		"Not set": {
+			configMap: &v1.ConfigMap{},
+			key:       WorkloadCertTTLKey,
+		},
+		"Invalid duration": {
+			configMap:   configMap,
+			key:         MaxWorkloadCertTTLKey,
+			expectedErr: `invalid "maxWorkloadCertTTL" in ConfigMap istio-system/istio`,
+		},
+	}
+
+	for id, tc := range testCases {
+		ttl, set, err := CertTTL(tc.configMap, tc.key)
+		if tc.expectedErr != "" {
+			if err == nil {
+				t.Errorf("%s: Succeeded. Error expected", id)
+			} else if !strings.HasPrefix(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: incorrect error message: %s VS %s", id, err.Error(), tc.expectedErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+		if ttl != tc.expected || set != tc.expectedSet {
+			t.Errorf("%s: CertTTL() => %v, %t, want %v, %t", id, ttl, set, tc.expected, tc.expectedSet)
+		}
+	}
+}
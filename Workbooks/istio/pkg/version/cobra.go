@@ -15,16 +15,36 @@
 package version
 
 import (
+	"encoding/json"
+	"fmt"
+
 	"github.com/spf13/cobra"
 )
 
 // CobraCommand is a command used to print version information.
 func CobraCommand() *cobra.Command {
-	return &cobra.Command{
+	var output string
+
+	cmd := &cobra.Command{
 		Use:   "version",
 		Short: "Prints out build version information",
-		Run: func(cmd *cobra.Command, args []string) {
-			cmd.Printf("%s\n", Info)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch output {
+			case "":
+				cmd.Printf("%s\n", Info)
+			case "json":
+				out, err := json.MarshalIndent(Info, "", "  ")
+				if err != nil {
+					return err
+				}
+				cmd.Printf("%s\n", out)
+			default:
+				return fmt.Errorf("--output must be 'json' or empty, got %q", output)
+			}
+			return nil
 		},
 	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Output format, either empty for a single line or 'json'")
+	return cmd
 }
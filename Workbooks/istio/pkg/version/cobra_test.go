@@ -0,0 +1,76 @@
+// Copyright 2018 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package version
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func runCobraCommand(t *testing.T, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	cmd := CobraCommand()
+	cmd.SetOutput(&out)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestCobraCommand(t *testing.T) {
+	out, err := runCobraCommand(t)
+	if err != nil {
+		t.Fatalf("version failed: %v", err)
+	}
+	if want := Info.String() + "\n"; out != want {
+		t.Errorf("got %q; want %q", out, want)
+	}
+}
+
+func TestCobraCommand_JSON(t *testing.T) {
+	out, err := runCobraCommand(t, "--output", "json")
+	if err != nil {
+		t.Fatalf("version --output json failed: %v", err)
+	}
+
+	var got map[string]string
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out, err)
+	}
+	want := map[string]string{
+		"version":        Info.Version,
+		"revision":       Info.GitRevision,
+		"user":           Info.User,
+		"host":           Info.Host,
+		"golang_version": Info.GolangVersion,
+		"hub":            Info.DockerHub,
+		"status":         Info.BuildStatus,
+	}
+	if len(got) != len(want) {
+		t.Errorf("got keys %v; want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("got %s=%q; want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestCobraCommand_InvalidOutput(t *testing.T) {
+	if _, err := runCobraCommand(t, "--output", "yaml"); err == nil {
+		t.Error("version --output yaml succeeded; want error")
+	}
+}
@@ -15,9 +15,11 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"time"
 	// TODO(nmittler): Remove this
 	_ "github.com/golang/glog"
@@ -28,6 +30,7 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 
 	"istio.io/istio/pkg/log"
+	"istio.io/istio/pkg/meshconfigmap"
 	"istio.io/istio/pkg/version"
 	"istio.io/istio/security/pkg/cmd"
 	"istio.io/istio/security/pkg/pki/ca"
@@ -49,6 +52,9 @@ const (
 
 	// The key for the environment variable that specifies the namespace.
 	namespaceKey = "NAMESPACE"
+
+	// How often the self-signed root certificate is checked for rotation.
+	rootRotationCheckInterval = time.Minute
 )
 
 type cliOptions struct {
@@ -57,22 +63,47 @@ type cliOptions struct {
 	signingKeyFile  string
 	rootCertFile    string
 
+	signingSecret string
+
 	namespace string
 
 	istioCaStorageNamespace string
 
 	kubeConfigFile string
 
-	selfSignedCA    bool
-	selfSignedCAOrg string
+	selfSignedCA                 bool
+	selfSignedCAOrg              string
+	selfSignedCARotationFraction float64
 
 	caCertTTL          time.Duration
 	workloadCertTTL    time.Duration
 	maxWorkloadCertTTL time.Duration
 
+	meshConfig string
+
+	workloadCertTTLOverrides       []string
+	parsedWorkloadCertTTLOverrides map[string]time.Duration
+
+	grpcNetwork  string
 	grpcHostname string
 	grpcPort     int
 
+	trustDomain string
+
+	tlsMinVersion   string
+	tlsCipherSuites []string
+	tlsPolicy       grpc.TLSPolicy
+
+	allowedIdentities       []string
+	parsedAllowedIdentities []string
+
+	maxConcurrentStreams uint32
+	maxConnections       int
+
+	selfTest bool
+
+	auditLog string
+
 	loggingOptions *log.Options
 }
 
@@ -96,12 +127,17 @@ func fatalf(template string, args ...interface{}) {
 }
 
 func init() {
-	flags := rootCmd.Flags()
+	// The flags are persistent so that subcommands such as verify-cert load the
+	// CA in the same way as the CA itself.
+	flags := rootCmd.PersistentFlags()
 
 	flags.StringVar(&opts.certChainFile, "cert-chain", "", "Speicifies path to the certificate chain file")
 	flags.StringVar(&opts.signingCertFile, "signing-cert", "", "Specifies path to the CA signing certificate file")
 	flags.StringVar(&opts.signingKeyFile, "signing-key", "", "Specifies path to the CA signing key file")
 	flags.StringVar(&opts.rootCertFile, "root-cert", "", "Specifies path to the root certificate file")
+	flags.StringVar(&opts.signingSecret, "signing-secret", "", "Specifies the name of a secret in the "+
+		"'--istio-ca-storage-namespace' holding ca-cert.pem, ca-key.pem, root-cert.pem and optionally cert-chain.pem. "+
+		"Use instead of '--signing-cert', '--signing-key', '--root-cert' and '--cert-chain'.")
 
 	flags.StringVar(&opts.namespace, "namespace", "",
 		"Select a namespace for the CA to listen to. If unspecified, Istio CA tries to use the ${"+namespaceKey+"} "+
@@ -118,15 +154,51 @@ func init() {
 	flags.StringVar(&opts.selfSignedCAOrg, "self-signed-ca-org", "k8s.cluster.local",
 		fmt.Sprintf("The issuer organization used in self-signed CA certificate (default to %s)",
 			selfSignedCAOrgDefault))
+	flags.Float64Var(&opts.selfSignedCARotationFraction, "self-signed-ca-rotation-fraction", 0,
+		"The fraction of its TTL after which the self-signed CA root certificate is regenerated, in (0, 1). "+
+			"If unspecified, the root certificate is not rotated.")
 
 	flags.DurationVar(&opts.caCertTTL, "ca-cert-ttl", defaultCACertTTL,
 		"The TTL of self-signed CA root certificate")
 	flags.DurationVar(&opts.workloadCertTTL, "workload-cert-ttl", defaultWorkloadCertTTL, "The TTL of issued workload certificates")
 	flags.DurationVar(&opts.maxWorkloadCertTTL, "max-workload-cert-ttl", maxWorkloadCertTTL, "The max TTL of issued workload certificates")
-
+	flags.StringVar(&opts.meshConfig, "mesh-config", "", "Specifies the name of the mesh ConfigMap in "+
+		"'--istio-ca-storage-namespace' from which the '"+meshconfigmap.WorkloadCertTTLKey+"' and '"+
+		meshconfigmap.MaxWorkloadCertTTLKey+"' keys are read, e.g. istio. '--workload-cert-ttl' and '--max-workload-cert-ttl' override them when specified.")
+	flags.StringSliceVar(&opts.workloadCertTTLOverrides, "workload-cert-ttl-overrides", nil,
+		"Comma separated namespace/serviceaccount=duration pairs overriding '--workload-cert-ttl' for the "+
+			"secrets of specific service accounts, e.g. prod/payments=15m. Each must not exceed '--max-workload-cert-ttl'.")
+
+	flags.StringVar(&opts.grpcNetwork, "grpc-network", grpc.NetworkTCP, "Specifies the network for GRPC server, "+
+		"either \"tcp\" or \"unix\". With \"unix\", '--grpc-hostname' is the path of the Unix domain socket.")
 	flags.StringVar(&opts.grpcHostname, "grpc-hostname", "localhost", "Specifies the hostname for GRPC server.")
 	flags.IntVar(&opts.grpcPort, "grpc-port", 0, "Specifies the port number for GRPC server. "+
-		"If unspecified, Istio CA will not server GRPC request.")
+		"If unspecified, Istio CA will not server GRPC request unless '--grpc-network' is \"unix\".")
+
+	flags.StringVar(&opts.trustDomain, "trust-domain", "", "Specifies the SPIFFE trust domain of the identities "+
+		"in workload certificates issued through the GRPC server. If unspecified, the requested identities are used.")
+	flags.StringVar(&opts.tlsMinVersion, "tls-min-version", grpc.DefaultTLSMinVersion,
+		"Specifies the minimum TLS version accepted by the GRPC server. Only \"1.2\" is supported: "+
+			"\"1.3\" is rejected because the CA is built with Go 1.9, whose crypto/tls does not implement TLS 1.3.")
+	flags.StringSliceVar(&opts.tlsCipherSuites, "tls-cipher-suites", grpc.DefaultTLSCipherSuites,
+		"Comma separated TLS 1.2 cipher suites accepted by the GRPC server in order of preference, "+
+			"e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.")
+	flags.StringSliceVar(&opts.allowedIdentities, "allowed-identities", nil,
+		"Comma separated identities, e.g. spiffe://cluster.local/ns/default/sa/foo, or '@' followed by the path of a "+
+			"file listing one identity per line, for which the GRPC server issues certificates. "+
+			"If unspecified, certificates are issued for all identities.")
+	flags.Uint32Var(&opts.maxConcurrentStreams, "max-concurrent-streams", 1000,
+		"Specifies the maximum number of certificate requests handled at once by the GRPC server. "+
+			"Further requests are rejected with RESOURCE_EXHAUSTED. 0 means unlimited.")
+	flags.IntVar(&opts.maxConnections, "max-connections", 10000,
+		"Specifies the maximum number of open connections to the GRPC server. "+
+			"Further connections are closed once accepted. 0 means unlimited.")
+
+	flags.BoolVar(&opts.selfTest, "self-test", true, "Whether Istio CA issues and verifies a throwaway "+
+		"certificate at startup, exiting if it fails, e.g. because of mismatching signing and root certificates.")
+
+	flags.StringVar(&opts.auditLog, "audit-log", "", "Specifies the file to which a JSON line is appended "+
+		"for every certificate issued via GRPC, or \"stderr\". If unspecified, issued certificates are not audited.")
 
 	rootCmd.AddCommand(version.CobraCommand())
 
@@ -142,30 +214,27 @@ func main() {
 }
 
 func runCA() {
-	if err := log.Configure(opts.loggingOptions); err != nil {
-		fatalf("Failed to configure logging (%v)", err)
-	}
-
-	if value, exists := os.LookupEnv(namespaceKey); exists {
-		// When -namespace is not set, try to read the namespace from environment variable.
-		if opts.namespace == "" {
-			opts.namespace = value
-		}
-		// Use environment variable for istioCaStorageNamespace if it exists
-		opts.istioCaStorageNamespace = value
-	}
-
-	verifyCommandLineOptions()
+	setupOptions()
 
 	cs := createClientset()
 	ca := createCA(cs.CoreV1())
+	if opts.selfTest {
+		if err := selfTest(ca, opts.namespace, opts.trustDomain, opts.maxWorkloadCertTTL, time.Now()); err != nil {
+			fatalf("Istio CA self-test failed, check the CA signing material (error: %v)", err)
+		}
+		log.Info("Istio CA self-test passed")
+	}
+	if !opts.selfSignedCA {
+		reloadSigningMaterialOnSIGHUP(ca, cs.CoreV1())
+	}
 	// For workloads in K8s, we apply the configured workload cert TTL.
-	sc := controller.NewSecretController(ca, opts.workloadCertTTL, cs.CoreV1(), opts.namespace)
+	sc := controller.NewSecretController(ca, opts.workloadCertTTL, opts.parsedWorkloadCertTTLOverrides,
+		cs.CoreV1(), opts.namespace)
 
 	stopCh := make(chan struct{})
 	sc.Run(stopCh)
 
-	if opts.grpcPort > 0 {
+	if opts.grpcPort > 0 || opts.grpcNetwork == grpc.NetworkUnix {
 		// start registry if gRPC server is to be started
 		reg := registry.GetIdentityRegistry()
 		ch := make(chan struct{})
@@ -179,7 +248,14 @@ func runCA() {
 		serviceAccountController.Run(ch)
 
 		// The CA API uses cert with the max workload cert TTL.
-		grpcServer := grpc.New(ca, opts.maxWorkloadCertTTL, opts.grpcHostname, opts.grpcPort)
+		grpcServer := grpc.New(ca, opts.maxWorkloadCertTTL, opts.grpcHostname, opts.grpcPort, grpc.ServerOptions{
+			Network:     opts.grpcNetwork,
+			AuditLogger: createAuditLogger(),
+			TrustDomain: opts.trustDomain,
+			TLSPolicy:   opts.tlsPolicy,
+			AllowedIDs:  opts.parsedAllowedIdentities,
+			Limits:      grpc.Limits{MaxConcurrentStreams: opts.maxConcurrentStreams, MaxConnections: opts.maxConnections},
+		})
 		if err := grpcServer.Run(); err != nil {
 			// stop the registry-related controllers
 			ch <- struct{}{}
@@ -192,6 +268,82 @@ func runCA() {
 	select {} // wait forever
 }
 
+// setupOptions configures logging, applies the environment to the command line
+// options and verifies them.
+func setupOptions() {
+	if err := log.Configure(opts.loggingOptions); err != nil {
+		fatalf("Failed to configure logging (%v)", err)
+	}
+
+	if value, exists := os.LookupEnv(namespaceKey); exists {
+		// When -namespace is not set, try to read the namespace from environment variable.
+		if opts.namespace == "" {
+			opts.namespace = value
+		}
+		// Use environment variable for istioCaStorageNamespace if it exists
+		opts.istioCaStorageNamespace = value
+	}
+
+	if opts.meshConfig != "" {
+		loadMeshConfigCertTTLs()
+	}
+
+	verifyCommandLineOptions()
+}
+
+// loadMeshConfigCertTTLs sets the workload cert TTLs not specified on the command line from the
+// mesh ConfigMap.
+func loadMeshConfigCertTTLs() {
+	flags := rootCmd.PersistentFlags()
+	ttls, err := meshConfigCertTTLs(createClientset(), opts.istioCaStorageNamespace, opts.meshConfig,
+		certTTLs{ttl: opts.workloadCertTTL, maxTTL: opts.maxWorkloadCertTTL},
+		flags.Changed("workload-cert-ttl"), flags.Changed("max-workload-cert-ttl"))
+	if err != nil {
+		fatalf("Failed to read the workload cert TTLs from the mesh ConfigMap %s (error: %v)", opts.meshConfig, err)
+	}
+	opts.workloadCertTTL, opts.maxWorkloadCertTTL = ttls.ttl, ttls.maxTTL
+	log.Infof("Using workload cert TTL %v and max workload cert TTL %v", ttls.ttl, ttls.maxTTL)
+}
+
+func createAuditLogger() *grpc.AuditLogger {
+	switch opts.auditLog {
+	case "":
+		return nil
+	case "stderr":
+		return grpc.NewAuditLogger(os.Stderr, nil)
+	}
+	// Chain the new records to those appended by previous runs.
+	lastLine, err := lastAuditLine(opts.auditLog)
+	if err != nil {
+		fatalf("Failed to read audit log %s (error: %v)", opts.auditLog, err)
+	}
+	f, err := os.OpenFile(opts.auditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		fatalf("Failed to open audit log %s (error: %v)", opts.auditLog, err)
+	}
+	return grpc.NewAuditLogger(f, lastLine)
+}
+
+// lastAuditLine returns the last line of the audit log, or nil if it does not exist yet.
+func lastAuditLine(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	var last []byte
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Bytes(); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	return last, scanner.Err()
+}
+
 func createClientset() *kubernetes.Clientset {
 	c := generateConfig()
 	cs, err := kubernetes.NewForConfig(c)
@@ -211,29 +363,72 @@ func createCA(core corev1.SecretsGetter) ca.CertificateAuthority {
 		if err != nil {
 			fatalf("Failed to create a self-signed Istio CA (error: %v)", err)
 		}
+
+		if opts.selfSignedCARotationFraction > 0 {
+			rotator, err := ca.NewRootRotator(istioCA, ca.RootRotatorOptions{
+				CACertTTL:        opts.caCertTTL,
+				RotationFraction: opts.selfSignedCARotationFraction,
+				CheckInterval:    rootRotationCheckInterval,
+				Org:              opts.selfSignedCAOrg,
+				Namespace:        opts.istioCaStorageNamespace,
+			}, core)
+			if err != nil {
+				fatalf("Failed to create the self-signed root rotator (error: %v)", err)
+			}
+			rotator.Run(make(chan struct{}))
+		}
 		return istioCA
 	}
 
-	var certChainBytes []byte
-	if opts.certChainFile != "" {
-		certChainBytes = readFile(opts.certChainFile)
-	}
-	caOpts := &ca.IstioCAOptions{
-		CertChainBytes:   certChainBytes,
-		CertTTL:          opts.workloadCertTTL,
-		MaxCertTTL:       opts.maxWorkloadCertTTL,
-		SigningCertBytes: readFile(opts.signingCertFile),
-		SigningKeyBytes:  readFile(opts.signingKeyFile),
-		RootCertBytes:    readFile(opts.rootCertFile),
+	caOpts, err := loadSigningMaterial(core)
+	if err != nil {
+		fatalf("Failed to load the CA signing material (error: %v)", err)
 	}
 
 	istioCA, err := ca.NewIstioCA(caOpts)
 	if err != nil {
-		log.Errorf("Failed to create an Istio CA (error: %v)", err)
+		fatalf("Failed to create an Istio CA (error: %v)", err)
 	}
 	return istioCA
 }
 
+// loadSigningMaterial reads the signing cert/key, root cert and cert chain from the signing secret
+// or files specified on the command line.
+func loadSigningMaterial(core corev1.SecretsGetter) (*ca.IstioCAOptions, error) {
+	caOpts := &ca.IstioCAOptions{
+		CertTTL:    opts.workloadCertTTL,
+		MaxCertTTL: opts.maxWorkloadCertTTL,
+	}
+	if opts.signingSecret != "" {
+		if err := ca.LoadSigningSecret(core, opts.istioCaStorageNamespace, opts.signingSecret, caOpts); err != nil {
+			return nil, err
+		}
+		return caOpts, nil
+	}
+
+	files := []struct {
+		name  string
+		bytes *[]byte
+	}{
+		{name: opts.certChainFile, bytes: &caOpts.CertChainBytes},
+		{name: opts.signingCertFile, bytes: &caOpts.SigningCertBytes},
+		{name: opts.signingKeyFile, bytes: &caOpts.SigningKeyBytes},
+		{name: opts.rootCertFile, bytes: &caOpts.RootCertBytes},
+	}
+	for _, file := range files {
+		if file.name == "" {
+			// Only the cert chain is optional, see verifyCommandLineOptions.
+			continue
+		}
+		bs, err := ioutil.ReadFile(file.name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %s (error: %v)", file.name, err)
+		}
+		*file.bytes = bs
+	}
+	return caOpts, nil
+}
+
 func generateConfig() *rest.Config {
 	if opts.kubeConfigFile != "" {
 		c, err := clientcmd.BuildConfigFromFlags("", opts.kubeConfigFile)
@@ -251,19 +446,60 @@ func generateConfig() *rest.Config {
 	return c
 }
 
-func readFile(filename string) []byte {
-	bs, err := ioutil.ReadFile(filename)
+func verifyCommandLineOptions() {
+	ttls := certTTLs{ttl: opts.workloadCertTTL, maxTTL: opts.maxWorkloadCertTTL}
+	if err := ttls.validate(); err != nil {
+		fatalf("Invalid '--workload-cert-ttl' or '--max-workload-cert-ttl': %v", err)
+	}
+
+	overrides, err := controller.ParseCertTTLOverrides(opts.workloadCertTTLOverrides, opts.maxWorkloadCertTTL)
 	if err != nil {
-		fatalf("Failed to read file %s (error: %v)", filename, err)
+		fatalf("Invalid '--workload-cert-ttl-overrides': %v", err)
+	}
+	opts.parsedWorkloadCertTTLOverrides = overrides
+
+	if opts.trustDomain != "" {
+		if err := grpc.ValidateTrustDomain(opts.trustDomain); err != nil {
+			fatalf("Invalid trust domain specified via '--trust-domain' (error: %v)", err)
+		}
+	}
+
+	if opts.tlsPolicy, err = grpc.ParseTLSPolicy(opts.tlsMinVersion, opts.tlsCipherSuites); err != nil {
+		fatalf("Invalid GRPC TLS policy specified via '--tls-min-version' and '--tls-cipher-suites' (error: %v)", err)
+	}
+
+	if opts.parsedAllowedIdentities, err = grpc.LoadAllowedIdentities(opts.allowedIdentities); err != nil {
+		fatalf("Invalid '--allowed-identities': %v", err)
+	}
+
+	if opts.maxConnections < 0 {
+		fatalf("Invalid '--max-connections' %d: must not be negative", opts.maxConnections)
+	}
+
+	switch opts.grpcNetwork {
+	case grpc.NetworkTCP:
+	case grpc.NetworkUnix:
+		if err := verifySocketPath(opts.grpcHostname); err != nil {
+			fatalf("Invalid GRPC socket path '%s' specified via '--grpc-hostname' (error: %v)", opts.grpcHostname, err)
+		}
+	default:
+		fatalf("Unsupported GRPC network '%s'. Specify either %q or %q via '--grpc-network'",
+			opts.grpcNetwork, grpc.NetworkTCP, grpc.NetworkUnix)
 	}
-	return bs
-}
 
-func verifyCommandLineOptions() {
 	if opts.selfSignedCA {
 		return
 	}
 
+	if opts.signingSecret != "" {
+		if opts.signingCertFile != "" || opts.signingKeyFile != "" || opts.rootCertFile != "" || opts.certChainFile != "" {
+			fatalf(
+				"Both a signing secret and signing files have been specified. Specify either a secret via " +
+					"'-signing-secret' option or files via '-signing-cert', '-signing-key', '-root-cert' and '-cert-chain' options")
+		}
+		return
+	}
+
 	if opts.signingCertFile == "" {
 		fatalf(
 			"No signing cert has been specified. Either specify a cert file via '-signing-cert' option " +
@@ -282,3 +518,16 @@ func verifyCommandLineOptions() {
 				"or use '-self-signed-ca'")
 	}
 }
+
+// verifySocketPath checks that a Unix domain socket can be created at path.
+func verifySocketPath(path string) error {
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("socket path must be absolute")
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".istio-ca-")
+	if err != nil {
+		return fmt.Errorf("socket directory is not writable (%v)", err)
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
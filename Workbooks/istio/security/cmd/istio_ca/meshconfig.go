@@ -0,0 +1,81 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+
+	"istio.io/istio/pkg/meshconfigmap"
+)
+
+// meshConfigRetry is how the mesh ConfigMap is fetched, e.g. while pilot's configuration is
+// being deployed along with the CA.
+var meshConfigRetry = meshconfigmap.DefaultRetryPolicy
+
+// certTTLs are the TTLs of the issued workload certificates.
+type certTTLs struct {
+	ttl    time.Duration
+	maxTTL time.Duration
+}
+
+// validate returns an error unless both TTLs are positive and the max TTL is at least the TTL.
+func (t certTTLs) validate() error {
+	if t.ttl <= 0 {
+		return fmt.Errorf("workload cert TTL %v must be positive", t.ttl)
+	}
+	if t.maxTTL <= 0 {
+		return fmt.Errorf("max workload cert TTL %v must be positive", t.maxTTL)
+	}
+	if t.maxTTL < t.ttl {
+		return fmt.Errorf("max workload cert TTL %v is shorter than the workload cert TTL %v", t.maxTTL, t.ttl)
+	}
+	return nil
+}
+
+// meshConfigCertTTLs reads the workload cert TTLs from the mesh ConfigMap with the given name, the
+// one pilot reads its mesh config from. The TTLs of flags, overriding the mesh ConfigMap when set
+// on the command line, are returned for keys missing from the ConfigMap.
+func meshConfigCertTTLs(kube kubernetes.Interface, namespace, name string, flags certTTLs,
+	ttlSet, maxTTLSet bool) (certTTLs, error) {
+	configMap, err := meshconfigmap.Get(kube, namespace, name, meshConfigRetry)
+	if err != nil {
+		return certTTLs{}, err
+	}
+
+	ttls := flags
+	for _, field := range []struct {
+		key   string
+		set   bool
+		value *time.Duration
+	}{
+		{key: meshconfigmap.WorkloadCertTTLKey, set: ttlSet, value: &ttls.ttl},
+		{key: meshconfigmap.MaxWorkloadCertTTLKey, set: maxTTLSet, value: &ttls.maxTTL},
+	} {
+		if field.set {
+			continue
+		}
+		ttl, exists, err := meshconfigmap.CertTTL(configMap, field.key)
+		if err != nil {
+			return certTTLs{}, err
+		}
+		if exists {
+			*field.value = ttl
+		}
+	}
+	return ttls, nil
+}
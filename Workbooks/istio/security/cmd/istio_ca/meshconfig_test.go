@@ -0,0 +1,139 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/istio/pkg/meshconfigmap"
+)
+
+func TestMeshConfigCertTTLs(t *testing.T) {
+	meshConfigRetry = meshconfigmap.RetryPolicy{}
+	defer func() { meshConfigRetry = meshconfigmap.DefaultRetryPolicy }()
+
+	meshConfigMap := func(data map[string]string) []runtime.Object {
+		data[meshconfigmap.MeshKey] = ""
+		return []runtime.Object{&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "istio", Namespace: "istio-system"},
+			Data:       data,
+		}}
+	}
+	flags := certTTLs{ttl: time.Hour, maxTTL: 7 * 24 * time.Hour}
+
+	testCases := map[string]struct {
+		objects           []runtime.Object
+		ttlSet, maxTTLSet bool
+		expected          certTTLs
+		expectedErr       string
+	}{
+		"Config sourced": {
+			objects:  meshConfigMap(map[string]string{meshconfigmap.WorkloadCertTTLKey: "30m", meshconfigmap.MaxWorkloadCertTTLKey: "24h"}),
+			expected: certTTLs{ttl: 30 * time.Minute, maxTTL: 24 * time.Hour},
+		},
+		"Missing keys": {
+			objects:  meshConfigMap(map[string]string{}),
+			expected: flags,
+		},
+		"TTL flag override": {
+			objects:  meshConfigMap(map[string]string{meshconfigmap.WorkloadCertTTLKey: "30m", meshconfigmap.MaxWorkloadCertTTLKey: "24h"}),
+			ttlSet:   true,
+			expected: certTTLs{ttl: time.Hour, maxTTL: 24 * time.Hour},
+		},
+		"Both flag overrides": {
+			objects:   meshConfigMap(map[string]string{meshconfigmap.WorkloadCertTTLKey: "30m", meshconfigmap.MaxWorkloadCertTTLKey: "24h"}),
+			ttlSet:    true,
+			maxTTLSet: true,
+			expected:  flags,
+		},
+		"Invalid duration": {
+			objects:     meshConfigMap(map[string]string{meshconfigmap.WorkloadCertTTLKey: "an hour"}),
+			expectedErr: `invalid "workloadCertTTL" in ConfigMap istio-system/istio`,
+		},
+		"Invalid duration overridden": {
+			objects:  meshConfigMap(map[string]string{meshconfigmap.WorkloadCertTTLKey: "an hour"}),
+			ttlSet:   true,
+			expected: flags,
+		},
+		"Missing ConfigMap": {
+			expectedErr: "configmaps \"istio\" not found",
+		},
+	}
+
+	for id, tc := range testCases {
+		client := fake.NewSimpleClientset(tc.objects...)
+		ttls, err := meshConfigCertTTLs(client, "istio-system", "istio", flags, tc.ttlSet, tc.maxTTLSet)
+		if tc.expectedErr != "" {
+			if err == nil {
+				t.Errorf("%s: Succeeded. Error expected", id)
+			} else if !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: incorrect error message: %s VS %s", id, err.Error(), tc.expectedErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+		if ttls != tc.expected {
+			t.Errorf("%s: unexpected TTLs: want %+v, got %+v", id, tc.expected, ttls)
+		}
+	}
+}
+
+func TestCertTTLsValidate(t *testing.T) {
+	testCases := map[string]struct {
+		ttls        certTTLs
+		expectedErr string
+	}{
+		"Valid": {
+			ttls: certTTLs{ttl: time.Hour, maxTTL: time.Hour},
+		},
+		"Zero TTL": {
+			ttls:        certTTLs{maxTTL: time.Hour},
+			expectedErr: "workload cert TTL 0s must be positive",
+		},
+		"Negative max TTL": {
+			ttls:        certTTLs{ttl: time.Hour, maxTTL: -time.Hour},
+			expectedErr: "max workload cert TTL -1h0m0s must be positive",
+		},
+		"Max shorter than TTL": {
+			ttls:        certTTLs{ttl: time.Hour, maxTTL: time.Minute},
+			expectedErr: "max workload cert TTL 1m0s is shorter than the workload cert TTL 1h0m0s",
+		},
+	}
+
+	for id, tc := range testCases {
+		err := tc.ttls.validate()
+		if tc.expectedErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", id, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: Succeeded. Error expected", id)
+		} else if err.Error() != tc.expectedErr {
+			t.Errorf("%s: incorrect error message: %s VS %s", id, err.Error(), tc.expectedErr)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+var (
+	rotateRootKeyFile    string
+	rotateSigningCertTTL time.Duration
+	rotateSigningCertOrg string
+
+	rotateSigningKeyCmd = &cobra.Command{
+		Use:   "rotate-signing-key",
+		Short: "Generate a new signing key/cert and store it where the CA loads its signing material from",
+		Long: "Generates a new signing key/cert, issued by the root certificate if its key is specified via " +
+			"'--root-key' or self-signed otherwise, checks that it chains to the root, and writes it to the " +
+			"signing secret or files of the CA. A running Istio CA starts issuing certificates with it on SIGHUP.",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			setupOptions()
+			if opts.selfSignedCA {
+				return fmt.Errorf("the self-signed CA rotates its root itself, see '--self-signed-ca-rotation-fraction'")
+			}
+
+			// The Kubernetes API is only needed when the CA is stored in a secret.
+			var core corev1.SecretsGetter
+			if opts.signingSecret != "" {
+				core = createClientset().CoreV1()
+			}
+			current, err := loadSigningMaterial(core)
+			if err != nil {
+				return err
+			}
+			var rootKey []byte
+			if rotateRootKeyFile != "" {
+				if rootKey, err = ioutil.ReadFile(rotateRootKeyFile); err != nil {
+					return err
+				}
+			}
+
+			material, err := rotateSigningKey(current.RootCertBytes, rootKey, rotateSigningCertOrg, time.Now(),
+				rotateSigningCertTTL)
+			if err != nil {
+				return err
+			}
+			if opts.signingSecret != "" {
+				err = ca.WriteSigningSecret(core, opts.istioCaStorageNamespace, opts.signingSecret, material)
+			} else {
+				err = writeSigningFiles(material, opts.certChainFile, opts.signingCertFile, opts.signingKeyFile,
+					opts.rootCertFile)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Println("Wrote the new signing material, send SIGHUP to Istio CA to start issuing certificates with it")
+			return nil
+		},
+	}
+)
+
+func init() {
+	rotateSigningKeyCmd.Flags().StringVar(&rotateRootKeyFile, "root-key", "", "Specifies path to the key of "+
+		"the root certificate, which issues the new signing certificate. If unspecified, the new signing "+
+		"certificate is a new root, trusted along with the current root certificate.")
+	rotateSigningKeyCmd.Flags().DurationVar(&rotateSigningCertTTL, "signing-cert-ttl", defaultCACertTTL,
+		"The TTL of the new signing certificate, capped to the expiry of the root certificate")
+	rotateSigningKeyCmd.Flags().StringVar(&rotateSigningCertOrg, "signing-cert-org", selfSignedCAOrgDefault,
+		"The organization of the new signing certificate")
+
+	rootCmd.AddCommand(rotateSigningKeyCmd)
+}
+
+// rotateSigningKey generates new signing material, see ca.GenSigningMaterial, and returns an error
+// unless it chains to the root.
+func rotateSigningKey(rootCert, rootKey []byte, org string, now time.Time, ttl time.Duration) (*ca.IstioCAOptions, error) {
+	material, err := ca.GenSigningMaterial(rootCert, rootKey, org, now, ttl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the signing material (error: %v)", err)
+	}
+	if _, err := ca.NewIstioCA(material); err != nil {
+		return nil, fmt.Errorf("the new signing cert does not chain to the root cert (error: %v)", err)
+	}
+	return material, nil
+}
+
+// writeSigningFiles replaces the signing material files. A cert chain file is required when the
+// signing cert is not a root.
+func writeSigningFiles(material *ca.IstioCAOptions, certChainFile, signingCertFile, signingKeyFile,
+	rootCertFile string) error {
+	if certChainFile == "" && len(material.CertChainBytes) > 0 {
+		return fmt.Errorf("'--cert-chain' must be specified to store the cert chain of the new signing cert")
+	}
+
+	files := []struct {
+		name  string
+		bytes []byte
+		perm  os.FileMode
+	}{
+		{name: rootCertFile, bytes: material.RootCertBytes, perm: 0644},
+		{name: certChainFile, bytes: material.CertChainBytes, perm: 0644},
+		{name: signingCertFile, bytes: material.SigningCertBytes, perm: 0644},
+		{name: signingKeyFile, bytes: material.SigningKeyBytes, perm: 0600},
+	}
+	for _, file := range files {
+		if file.name == "" {
+			continue
+		}
+		if err := replaceFile(file.name, file.bytes, file.perm); err != nil {
+			return fmt.Errorf("failed to write file %s (error: %v)", file.name, err)
+		}
+	}
+	return nil
+}
+
+// replaceFile atomically replaces the content of the named file, so that a reload never reads a
+// partially written file.
+func replaceFile(name string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(name), ".istio-ca-")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// reloadSigningMaterialOnSIGHUP makes the CA issue certificates with the signing material of its
+// secret or files, e.g. as written by rotate-signing-key, whenever the process receives SIGHUP.
+func reloadSigningMaterialOnSIGHUP(authority ca.CertificateAuthority, core corev1.SecretsGetter) {
+	istioCA, ok := authority.(*ca.IstioCA)
+	if !ok {
+		return
+	}
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			if err := reloadSigningMaterial(istioCA, core); err != nil {
+				log.Errorf("Failed to reload the CA signing material, keeping the current one (error: %v)", err)
+				continue
+			}
+			log.Info("Reloaded the CA signing material")
+		}
+	}()
+}
+
+func reloadSigningMaterial(istioCA *ca.IstioCA, core corev1.SecretsGetter) error {
+	material, err := loadSigningMaterial(core)
+	if err != nil {
+		return err
+	}
+	return istioCA.UpdateSigningMaterial(material)
+}
@@ -0,0 +1,126 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+func TestRotateSigningKey(t *testing.T) {
+	now := time.Now()
+	rootCert, rootKey := genRoot(now)
+	_, foreignKey := genRoot(now)
+
+	testCases := map[string]struct {
+		rootKey []byte
+		errMsg  string
+	}{
+		"Signed by the root": {
+			rootKey: rootKey,
+		},
+		"New root": {},
+		"Mismatching root key": {
+			rootKey: foreignKey,
+			errMsg:  "the new signing cert does not chain to the root cert",
+		},
+	}
+
+	for id, tc := range testCases {
+		material, err := rotateSigningKey(rootCert, tc.rootKey, "istio.io", now, time.Minute)
+		if len(tc.errMsg) > 0 {
+			if err == nil {
+				t.Errorf("%s: Succeeded. Error expected", id)
+			} else if !strings.HasPrefix(err.Error(), tc.errMsg) {
+				t.Errorf("%s: incorrect error message: %s VS %s", id, err.Error(), tc.errMsg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+
+		// Workload certs issued before the rotation still verify against the new roots.
+		workloadCert := genWorkloadCert(t, rootCert, rootKey, now.Add(-time.Minute), now.Add(time.Minute))
+		if err := verifyCert(ioutil.Discard, workloadCert, material.RootCertBytes, now); err != nil {
+			t.Errorf("%s: a cert issued before the rotation does not verify: %v", id, err)
+		}
+	}
+}
+
+func TestWriteSigningFiles(t *testing.T) {
+	now := time.Now()
+	rootCert, rootKey := genRoot(now)
+	dir, err := ioutil.TempDir("", "istio-ca-rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	material, err := rotateSigningKey(rootCert, rootKey, "istio.io", now, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certChainFile := filepath.Join(dir, "cert-chain.pem")
+	signingCertFile := filepath.Join(dir, "ca-cert.pem")
+	signingKeyFile := filepath.Join(dir, "ca-key.pem")
+	rootCertFile := filepath.Join(dir, "root-cert.pem")
+
+	err = writeSigningFiles(material, "", signingCertFile, signingKeyFile, rootCertFile)
+	if errMsg := "'--cert-chain' must be specified to store the cert chain of the new signing cert"; err == nil {
+		t.Error("No cert chain file: Succeeded. Error expected")
+	} else if err.Error() != errMsg {
+		t.Errorf("No cert chain file: incorrect error message: %s VS %s", err.Error(), errMsg)
+	}
+
+	if err := writeSigningFiles(material, certChainFile, signingCertFile, signingKeyFile, rootCertFile); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loaded := &ca.IstioCAOptions{CertTTL: time.Hour, MaxCertTTL: time.Hour}
+	for _, file := range []struct {
+		name     string
+		expected []byte
+		actual   *[]byte
+	}{
+		{name: certChainFile, expected: material.CertChainBytes, actual: &loaded.CertChainBytes},
+		{name: signingCertFile, expected: material.SigningCertBytes, actual: &loaded.SigningCertBytes},
+		{name: signingKeyFile, expected: material.SigningKeyBytes, actual: &loaded.SigningKeyBytes},
+		{name: rootCertFile, expected: material.RootCertBytes, actual: &loaded.RootCertBytes},
+	} {
+		if *file.actual, err = ioutil.ReadFile(file.name); err != nil {
+			t.Errorf("failed to read %s: %v", file.name, err)
+		} else if !bytes.Equal(*file.actual, file.expected) {
+			t.Errorf("%s does not contain the new signing material", file.name)
+		}
+	}
+	if info, err := os.Stat(signingKeyFile); err != nil {
+		t.Error(err)
+	} else if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("unexpected signing key file mode: want %v, got %v", os.FileMode(0600), perm)
+	}
+
+	// The files load as the signing material of a CA.
+	if _, err := ca.NewIstioCA(loaded); err != nil {
+		t.Errorf("failed to create a CA from the signing files: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+const (
+	// selfTestServiceAccount is the service account of the throwaway
+	// certificate issued by the startup self-test.
+	selfTestServiceAccount = "istio-ca-self-test"
+
+	// selfTestCertTTL is the TTL of the throwaway certificate, unless the
+	// max workload cert TTL is shorter.
+	selfTestCertTTL = 10 * time.Minute
+)
+
+// selfTest issues a certificate for a throwaway CSR the way the GRPC server
+// does, and returns an error unless it chains to the root certificate of the
+// CA at the given time. With a trust domain, the identity of the certificate
+// is set by the CA instead of being taken from the CSR.
+func selfTest(istioCA ca.CertificateAuthority, namespace, trustDomain string, maxTTL time.Duration, now time.Time) error {
+	domain := trustDomain
+	if domain == "" {
+		domain = "cluster.local"
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+	id := fmt.Sprintf("spiffe://%s/ns/%s/sa/%s", domain, namespace, selfTestServiceAccount)
+
+	csrPEM, _, err := ca.GenCSR(ca.CertOptions{
+		Host:       id,
+		Org:        "istio.io",
+		RSAKeySize: 2048,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to generate a CSR (error: %v)", err)
+	}
+
+	ttl := selfTestCertTTL
+	if maxTTL < ttl {
+		ttl = maxTTL
+	}
+	var cert []byte
+	if trustDomain != "" {
+		cert, err = istioCA.SignWithIDs(csrPEM, ttl, []string{id})
+	} else {
+		cert, err = istioCA.Sign(csrPEM, ttl)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to sign a certificate for %s (error: %v)", id, err)
+	}
+
+	if err := verifyCert(ioutil.Discard, cert, istioCA.GetRootCertificate(), now); err != nil {
+		return fmt.Errorf("the issued certificate does not verify: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+// foreignRootCA is a CA whose root certificate does not match its signing
+// certificate.
+type foreignRootCA struct {
+	*ca.IstioCA
+	root []byte
+}
+
+func (c *foreignRootCA) GetRootCertificate() []byte {
+	return c.root
+}
+
+func newTestCA(t *testing.T, now time.Time, maxCertTTL time.Duration) *ca.IstioCA {
+	rootCert, rootKey := genRoot(now)
+	istioCA, err := ca.NewIstioCA(&ca.IstioCAOptions{
+		CertTTL:          time.Hour,
+		MaxCertTTL:       maxCertTTL,
+		SigningCertBytes: rootCert,
+		SigningKeyBytes:  rootKey,
+		RootCertBytes:    rootCert,
+	})
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+	return istioCA
+}
+
+func TestSelfTest(t *testing.T) {
+	now := time.Now()
+	istioCA := newTestCA(t, now, time.Hour)
+	foreignRoot, _ := genRoot(now)
+
+	testCases := map[string]struct {
+		ca          ca.CertificateAuthority
+		trustDomain string
+		maxTTL      time.Duration
+		now         time.Time
+		errMsg      string
+	}{
+		"Valid CA": {
+			ca:     istioCA,
+			maxTTL: time.Hour,
+			now:    now,
+		},
+		"Valid CA with trust domain": {
+			ca:          istioCA,
+			trustDomain: "example.com",
+			maxTTL:      time.Hour,
+			now:         now,
+		},
+		"Foreign root": {
+			ca:     &foreignRootCA{IstioCA: istioCA, root: foreignRoot},
+			maxTTL: time.Hour,
+			now:    now,
+			errMsg: "the issued certificate does not verify: the certificate is not issued by this CA",
+		},
+		"Expired certificate": {
+			ca:     istioCA,
+			maxTTL: time.Hour,
+			now:    now.Add(2 * time.Hour),
+			errMsg: "the issued certificate does not verify: the certificate expired at ",
+		},
+		"Signing failure": {
+			ca:     newTestCA(t, now, time.Minute),
+			maxTTL: time.Hour,
+			now:    now,
+			errMsg: "failed to sign a certificate for spiffe://cluster.local/ns/istio-system/sa/istio-ca-self-test",
+		},
+	}
+
+	for id, tc := range testCases {
+		err := selfTest(tc.ca, "istio-system", tc.trustDomain, tc.maxTTL, tc.now)
+		if tc.errMsg == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", id, err)
+			}
+		} else if err == nil {
+			t.Errorf("%s: Succeeded. Error expected", id)
+		} else if !strings.HasPrefix(err.Error(), tc.errMsg) {
+			t.Errorf("%s: incorrect error message: %s VS %s", id, err.Error(), tc.errMsg)
+		}
+	}
+}
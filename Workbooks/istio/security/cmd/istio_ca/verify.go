@@ -0,0 +1,139 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/istio/security/pkg/pki"
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+var (
+	verifyCertFile string
+
+	verifyCertCmd = &cobra.Command{
+		Use:   "verify-cert",
+		Short: "Verify that a workload certificate is issued by this CA and is currently valid",
+		RunE: func(_ *cobra.Command, _ []string) error {
+			certPEM, err := ioutil.ReadFile(verifyCertFile)
+			if err != nil {
+				return err
+			}
+			rootCertPEM, err := loadRootCert()
+			if err != nil {
+				return err
+			}
+			return verifyCert(os.Stdout, certPEM, rootCertPEM, time.Now())
+		},
+	}
+)
+
+func init() {
+	verifyCertCmd.Flags().StringVar(&verifyCertFile, "cert", "",
+		"Specifies path to the PEM-encoded certificate to verify, optionally followed by its intermediate certificates")
+
+	rootCmd.AddCommand(verifyCertCmd)
+}
+
+// loadRootCert reads the root certificate of the CA configured by the command
+// line options. It never creates or updates the CA secret, so verifying a
+// certificate cannot mint a new self-signed root.
+func loadRootCert() ([]byte, error) {
+	if value, exists := os.LookupEnv(namespaceKey); exists {
+		opts.istioCaStorageNamespace = value
+	}
+
+	switch {
+	case opts.selfSignedCA:
+		return ca.ReadSelfSignedRootCert(createClientset().CoreV1(), opts.istioCaStorageNamespace)
+	case opts.signingSecret != "":
+		return ca.ReadRootCert(createClientset().CoreV1(), opts.istioCaStorageNamespace, opts.signingSecret)
+	case opts.rootCertFile != "":
+		return ioutil.ReadFile(opts.rootCertFile)
+	default:
+		return nil, fmt.Errorf("no root certificate configured, specify --self-signed-ca, --signing-secret or --root-cert")
+	}
+}
+
+// verifyCert writes the identities and validity period of the first
+// certificate in certPEM to w, and returns an error unless it chains to
+// rootCertPEM and is valid at the given time. Any further certificates in
+// certPEM are used as intermediates.
+func verifyCert(w io.Writer, certPEM []byte, rootCertPEM []byte, now time.Time) error {
+	var certs []*x509.Certificate
+	for rest := certPEM; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("failed to parse the certificate (error: %v)", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return fmt.Errorf("no PEM-encoded certificate found")
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(rootCertPEM) {
+		return fmt.Errorf("the CA has no valid root certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	cert := certs[0]
+	sans := "none"
+	if ids, err := pki.ExtractIDs(cert.Extensions); err == nil {
+		sans = strings.Join(ids, ", ")
+	}
+	fmt.Fprintf(w, "Subject alternative names: %s\n", sans)
+	fmt.Fprintf(w, "Not before: %s\n", cert.NotBefore.UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "Not after: %s\n", cert.NotAfter.UTC().Format(time.RFC3339))
+
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	switch {
+	case now.After(cert.NotAfter):
+		err = fmt.Errorf("the certificate expired at %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	case now.Before(cert.NotBefore):
+		err = fmt.Errorf("the certificate is not valid before %s", cert.NotBefore.UTC().Format(time.RFC3339))
+	case err != nil:
+		err = fmt.Errorf("the certificate is not issued by this CA (error: %v)", err)
+	}
+
+	fmt.Fprintf(w, "Valid: %t\n", err == nil)
+	return err
+}
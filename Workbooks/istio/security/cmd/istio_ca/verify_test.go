@@ -0,0 +1,125 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/istio/security/pkg/pki"
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+const workloadID = "spiffe://cluster.local/ns/default/sa/bookinfo-productpage"
+
+// genRoot generates a self-signed root certificate and its private key.
+func genRoot(now time.Time) ([]byte, []byte) {
+	return ca.GenCert(ca.CertOptions{
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		Org:          "istio.io",
+		IsCA:         true,
+		IsSelfSigned: true,
+		RSAKeySize:   2048,
+	})
+}
+
+// genWorkloadCert generates a workload certificate signed by the given root.
+func genWorkloadCert(t *testing.T, rootCert, rootKey []byte, notBefore, notAfter time.Time) []byte {
+	signerCert, err := pki.ParsePemEncodedCertificate(rootCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signerKey, err := pki.ParsePemEncodedKey(rootKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := ca.GenCert(ca.CertOptions{
+		Host:       workloadID,
+		NotBefore:  notBefore,
+		NotAfter:   notAfter,
+		SignerCert: signerCert,
+		SignerPriv: signerKey,
+		IsClient:   true,
+		IsServer:   true,
+		RSAKeySize: 2048,
+	})
+	return cert
+}
+
+func TestVerifyCert(t *testing.T) {
+	now := time.Now()
+	rootCert, rootKey := genRoot(now)
+	foreignCert, foreignKey := genRoot(now)
+
+	testCases := map[string]struct {
+		cert   []byte
+		errMsg string
+		valid  bool
+	}{
+		"Valid cert": {
+			cert:  genWorkloadCert(t, rootCert, rootKey, now.Add(-time.Minute), now.Add(time.Minute)),
+			valid: true,
+		},
+		"Expired cert": {
+			cert:   genWorkloadCert(t, rootCert, rootKey, now.Add(-time.Hour), now.Add(-time.Minute)),
+			errMsg: "the certificate expired at ",
+		},
+		"Foreign cert": {
+			cert:   genWorkloadCert(t, foreignCert, foreignKey, now.Add(-time.Minute), now.Add(time.Minute)),
+			errMsg: "the certificate is not issued by this CA",
+		},
+	}
+
+	for id, tc := range testCases {
+		var out bytes.Buffer
+		err := verifyCert(&out, tc.cert, rootCert, now)
+		if tc.valid {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", id, err)
+			}
+		} else if err == nil {
+			t.Errorf("%s: Succeeded. Error expected", id)
+		} else if !strings.HasPrefix(err.Error(), tc.errMsg) {
+			t.Errorf("%s: incorrect error message: %s VS %s", id, err.Error(), tc.errMsg)
+		}
+
+		for _, want := range []string{
+			"Subject alternative names: " + workloadID + "\n",
+			"Not before: ",
+			"Not after: ",
+			fmt.Sprintf("Valid: %t\n", tc.valid),
+		} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("%s: output %q does not contain %q", id, out.String(), want)
+			}
+		}
+	}
+}
+
+func TestVerifyCertWithoutCert(t *testing.T) {
+	rootCert, _ := genRoot(time.Now())
+	var out bytes.Buffer
+	err := verifyCert(&out, []byte("not a certificate"), rootCert, time.Now())
+	if err == nil {
+		t.Fatal("Succeeded. Error expected")
+	}
+	if errMsg := "no PEM-encoded certificate found"; err.Error() != errMsg {
+		t.Errorf("incorrect error message: %s VS %s", err.Error(), errMsg)
+	}
+}
@@ -16,6 +16,8 @@ package main
 
 import (
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	// TODO(nmittler): Remove this
@@ -53,6 +55,21 @@ func init() {
 		"ca-address", "istio-ca:8060", "Istio CA address")
 	flags.StringVar(&naConfig.Env, "env", "onprem", "Node Environment : onprem | gcp | aws")
 
+	flags.StringVar(&naConfig.BootstrapCertFile, "bootstrap-cert", "",
+		"Pre-provisioned cert presented to the CA until the first CSR is approved (optional)")
+	flags.StringVar(&naConfig.BootstrapKeyFile, "bootstrap-key", "", "Private key of the bootstrap cert")
+	flags.BoolVar(&naConfig.RequireSecureCAConnection, "require-secure-ca-connection", naConfig.RequireSecureCAConnection,
+		"Refuse to send CSRs to the CA over a connection without transport security")
+
+	flags.StringVar(&naConfig.CertOutputDir, "cert-output-dir", naConfig.CertOutputDir,
+		"Directory the workload key, cert chain and root cert are written to, created if missing")
+	flags.StringVar(&naConfig.KeyFileName, "key-file-name", naConfig.KeyFileName,
+		"Name of the workload private key file in the cert output directory")
+	flags.StringVar(&naConfig.CertChainFileName, "cert-chain-file-name", naConfig.CertChainFileName,
+		"Name of the workload cert chain file in the cert output directory")
+	flags.StringVar(&naConfig.RootCertFileName, "root-cert-file-name", naConfig.RootCertFileName,
+		"Name of the root cert file in the cert output directory")
+
 	flags.StringVar(&naConfig.PlatformConfig.OnPremConfig.CertChainFile, "onprem-cert-chain",
 		"/etc/certs/cert-chain.pem", "Node Agent identity cert file in on premise environment")
 	flags.StringVar(&naConfig.PlatformConfig.OnPremConfig.KeyFile,
@@ -84,12 +101,26 @@ func runNodeAgent() {
 		log.Errora(err)
 		os.Exit(-1)
 	}
+	if err := naConfig.Validate(); err != nil {
+		log.Errora(err)
+		os.Exit(-1)
+	}
 	nodeAgent, err := na.NewNodeAgent(naConfig)
 	if err != nil {
 		log.Errora(err)
 		os.Exit(-1)
 	}
 
+	// SIGUSR1 forces an immediate cert renewal, e.g. to re-key during an incident
+	renew := make(chan os.Signal, 1)
+	signal.Notify(renew, syscall.SIGUSR1)
+	go func() {
+		for range renew {
+			log.Infof("Received SIGUSR1, renewing cert")
+			nodeAgent.Renew()
+		}
+	}()
+
 	log.Infof("Starting Node Agent")
 	if err := nodeAgent.Start(); err != nil {
 		log.Errorf("Node agent terminated with error: %v.", err)
@@ -0,0 +1,108 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package na
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"istio.io/istio/security/pkg/pki"
+	"istio.io/istio/security/pkg/platform"
+)
+
+// getBootstrapCredentials loads the bootstrap cert and key of the config into
+// transport credentials that present them to the CA. It returns nil if no
+// bootstrap cert is configured.
+func getBootstrapCredentials(cfg *Config) (credentials.TransportCredentials, error) {
+	if cfg.BootstrapCertFile == "" && cfg.BootstrapKeyFile == "" {
+		return nil, nil
+	}
+	if cfg.BootstrapCertFile == "" || cfg.BootstrapKeyFile == "" {
+		return nil, fmt.Errorf("bootstrap cert and key files must be set together")
+	}
+
+	certificate, err := tls.LoadX509KeyPair(cfg.BootstrapCertFile, cfg.BootstrapKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load bootstrap key pair: %s", err)
+	}
+	config := tls.Config{
+		Certificates: []tls.Certificate{certificate},
+	}
+
+	// Verify the CA with the root cert of the platform, or the system roots if none is set.
+	if rootCACertFile := cfg.rootCACertFile(); rootCACertFile != "" {
+		bs, err := ioutil.ReadFile(rootCACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA cert: %s", err)
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(bs) {
+			return nil, fmt.Errorf("failed to append certificates")
+		}
+		config.RootCAs = certPool
+	}
+
+	return credentials.NewTLS(&config), nil
+}
+
+// bootstrapClient is a platform client whose connections to the CA present the
+// bootstrap cert instead of the credentials of the platform, which may not be
+// provisioned yet, e.g. the cert of an on premise node.
+type bootstrapClient struct {
+	platform.Client
+	creds    credentials.TransportCredentials
+	certFile string
+}
+
+// GetDialOptions returns the bootstrap transport credentials, without loading
+// those of the platform.
+func (c *bootstrapClient) GetDialOptions() ([]grpc.DialOption, error) {
+	return []grpc.DialOption{grpc.WithTransportCredentials(c.creds)}, nil
+}
+
+// GetServiceIdentity gets the service identity from the SAN field of the
+// bootstrap cert.
+func (c *bootstrapClient) GetServiceIdentity() (string, error) {
+	certBytes, err := ioutil.ReadFile(c.certFile)
+	if err != nil {
+		return "", err
+	}
+	cert, err := pki.ParsePemEncodedCertificate(certBytes)
+	if err != nil {
+		return "", err
+	}
+	serviceIDs, err := pki.ExtractIDs(cert.Extensions)
+	if err != nil {
+		return "", err
+	}
+	if len(serviceIDs) != 1 {
+		return "", fmt.Errorf("bootstrap cert has %v SAN fields, should be 1", len(serviceIDs))
+	}
+	return serviceIDs[0], nil
+}
+
+// GetAgentCredential passes the bootstrap cert to the CA to authenticate.
+func (c *bootstrapClient) GetAgentCredential() ([]byte, error) {
+	certBytes, err := ioutil.ReadFile(c.certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bootstrap cert file: %s", c.certFile)
+	}
+	return certBytes, nil
+}
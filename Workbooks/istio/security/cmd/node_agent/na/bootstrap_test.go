@@ -0,0 +1,209 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package na
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"istio.io/istio/security/pkg/platform"
+	mockpc "istio.io/istio/security/pkg/platform/mock"
+)
+
+func TestGetBootstrapCredentials(t *testing.T) {
+	testCases := map[string]struct {
+		certFile    string
+		keyFile     string
+		rootFile    string
+		expectCreds bool
+		expectedErr string
+	}{
+		"no bootstrap cert": {},
+		"bootstrap cert": {
+			certFile:    "testdata/bootstrap-cert.pem",
+			keyFile:     "testdata/bootstrap-key.pem",
+			rootFile:    "testdata/root-cert.pem",
+			expectCreds: true,
+		},
+		"bootstrap cert with system roots": {
+			certFile:    "testdata/bootstrap-cert.pem",
+			keyFile:     "testdata/bootstrap-key.pem",
+			expectCreds: true,
+		},
+		"missing key": {
+			certFile:    "testdata/bootstrap-cert.pem",
+			expectedErr: "bootstrap cert and key files must be set together",
+		},
+		"missing cert": {
+			keyFile:     "testdata/bootstrap-key.pem",
+			expectedErr: "bootstrap cert and key files must be set together",
+		},
+		"cert not found": {
+			certFile:    "testdata/bootstrap-cert-not-exist.pem",
+			keyFile:     "testdata/bootstrap-key.pem",
+			expectedErr: "cannot load bootstrap key pair",
+		},
+		"key does not match cert": {
+			certFile:    "testdata/bootstrap-cert.pem",
+			keyFile:     "testdata/root-cert.pem",
+			expectedErr: "cannot load bootstrap key pair",
+		},
+		"root cert not found": {
+			certFile:    "testdata/bootstrap-cert.pem",
+			keyFile:     "testdata/bootstrap-key.pem",
+			rootFile:    "testdata/root-cert-not-exist.pem",
+			expectedErr: "failed to read CA cert",
+		},
+		"malformed root cert": {
+			certFile:    "testdata/bootstrap-cert.pem",
+			keyFile:     "testdata/bootstrap-key.pem",
+			rootFile:    "testdata/bootstrap-key.pem",
+			expectedErr: "failed to append certificates",
+		},
+	}
+
+	for id, c := range testCases {
+		cfg := NewConfig()
+		cfg.Env = "onprem"
+		cfg.BootstrapCertFile = c.certFile
+		cfg.BootstrapKeyFile = c.keyFile
+		cfg.PlatformConfig.OnPremConfig.RootCACertFile = c.rootFile
+
+		creds, err := getBootstrapCredentials(cfg)
+		if len(c.expectedErr) > 0 {
+			if err == nil {
+				t.Errorf("%s: Succeeded. Error expected: %s", id, c.expectedErr)
+			} else if !strings.HasPrefix(err.Error(), c.expectedErr) {
+				t.Errorf("%s: incorrect error message: %s VS %s", id, err.Error(), c.expectedErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Unexpected Error: %v", id, err)
+		}
+		if (creds != nil) != c.expectCreds {
+			t.Errorf("%s: got credentials %v, expected credentials: %t", id, creds, c.expectCreds)
+		}
+	}
+}
+
+func TestNewNodeAgentBootstrap(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "na_bootstrap")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+
+	cfg := newTestConfig("onprem", tmp)
+	if agent, err := NewNodeAgent(cfg); err != nil {
+		t.Errorf("Unexpected Error: %v", err)
+	} else if _, ok := agent.(*nodeAgentInternal).pc.(*bootstrapClient); ok {
+		t.Error("Unexpected bootstrap client without a bootstrap cert")
+	}
+
+	cfg.BootstrapCertFile = "testdata/bootstrap-cert.pem"
+	cfg.BootstrapKeyFile = "testdata/bootstrap-key.pem"
+	cfg.PlatformConfig.OnPremConfig.RootCACertFile = "testdata/root-cert.pem"
+	if agent, err := NewNodeAgent(cfg); err != nil {
+		t.Errorf("Unexpected Error: %v", err)
+	} else if _, ok := agent.(*nodeAgentInternal).pc.(*bootstrapClient); !ok {
+		t.Error("Expected a bootstrap client with a bootstrap cert")
+	}
+
+	cfg.BootstrapKeyFile = "testdata/root-cert.pem"
+	if _, err := NewNodeAgent(cfg); err == nil {
+		t.Error("Succeeded with a malformed bootstrap key. Error expected")
+	}
+}
+
+func TestBootstrapClientDialOptions(t *testing.T) {
+	cfg := NewConfig()
+	cfg.Env = "onprem"
+	cfg.BootstrapCertFile = "testdata/bootstrap-cert.pem"
+	cfg.BootstrapKeyFile = "testdata/bootstrap-key.pem"
+	creds, err := getBootstrapCredentials(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %v", err)
+	}
+
+	var pc platform.Client = mockpc.FakeClient{ProperPlatform: true}
+	bc := &bootstrapClient{Client: pc, creds: creds, certFile: cfg.BootstrapCertFile}
+	options, err := bc.GetDialOptions()
+	if err != nil {
+		t.Fatalf("Unexpected Error: %v", err)
+	}
+	if len(options) != 1 {
+		t.Errorf("Expected only the bootstrap transport credentials, got %d dial options", len(options))
+	}
+	if !bc.IsProperPlatform() {
+		t.Error("bootstrap client does not delegate to the platform client")
+	}
+
+	// The dial options of the platform are not needed.
+	bc.Client = mockpc.FakeClient{DialOptionErr: "no dial options"}
+	if _, err := bc.GetDialOptions(); err != nil {
+		t.Errorf("Unexpected Error: %v", err)
+	}
+}
+
+func TestBootstrapOnPremWithoutPlatformCert(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "na_bootstrap")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+
+	// The node has no cert yet, only the bootstrap cert.
+	cfg := newTestConfig("onprem", tmp)
+	cfg.PlatformConfig.OnPremConfig.CertChainFile = filepath.Join(tmp, "cert-chain-not-exist.pem")
+	cfg.PlatformConfig.OnPremConfig.KeyFile = filepath.Join(tmp, "key-not-exist.pem")
+	cfg.PlatformConfig.OnPremConfig.RootCACertFile = "testdata/root-cert.pem"
+	cfg.BootstrapCertFile = "testdata/bootstrap-cert.pem"
+	cfg.BootstrapKeyFile = "testdata/bootstrap-key.pem"
+
+	agent, err := NewNodeAgent(cfg)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %v", err)
+	}
+	bc, ok := agent.(*nodeAgentInternal).pc.(*bootstrapClient)
+	if !ok {
+		t.Fatal("Expected a bootstrap client with a bootstrap cert")
+	}
+	if _, err := bc.Client.GetDialOptions(); err == nil {
+		t.Fatal("The platform dial options unexpectedly succeeded without the platform cert")
+	}
+
+	if _, err := bc.GetDialOptions(); err != nil {
+		t.Errorf("GetDialOptions() failed without the platform cert: %v", err)
+	}
+	if identity, err := bc.GetServiceIdentity(); err != nil {
+		t.Errorf("GetServiceIdentity() failed without the platform cert: %v", err)
+	} else if identity != "cloud.google.com" {
+		t.Errorf("Expected the identity of the bootstrap cert, got %q", identity)
+	}
+	want, err := ioutil.ReadFile(cfg.BootstrapCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred, err := bc.GetAgentCredential(); err != nil {
+		t.Errorf("GetAgentCredential() failed without the platform cert: %v", err)
+	} else if !bytes.Equal(cred, want) {
+		t.Error("Expected the bootstrap cert as the agent credential")
+	}
+}
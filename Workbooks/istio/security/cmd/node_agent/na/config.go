@@ -15,6 +15,11 @@
 package na
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"istio.io/istio/pkg/log"
@@ -28,6 +33,16 @@ const (
 	defaultCSRMaxRetries = 5
 	// defaultCSRGracePeriodPercentage is the default value of Config.CSRGracePeriodPercentage.
 	defaultCSRGracePeriodPercentage = 50
+	// defaultCertOutputDir is the default value of Config.CertOutputDir.
+	defaultCertOutputDir = "/etc/certs"
+	// defaultKeyFileName is the default value of Config.KeyFileName.
+	defaultKeyFileName = "key.pem"
+	// defaultCertChainFileName is the default value of Config.CertChainFileName.
+	defaultCertChainFileName = "cert-chain.pem"
+	// defaultRootCertFileName is the default value of Config.RootCertFileName.
+	defaultRootCertFileName = "root-cert.pem"
+	// certOutputDirPermission is the permission of a created Config.CertOutputDir.
+	certOutputDirPermission = 0700
 )
 
 // Config is Node agent configuration.
@@ -59,8 +74,44 @@ type Config struct {
 	// The Configuration for talking to the platform metadata server.
 	PlatformConfig platform.ClientConfig
 
+	// BootstrapCertFile and BootstrapKeyFile are a pre-provisioned cert and key
+	// presented to the CA until the first CSR is approved. Both are optional.
+	BootstrapCertFile string
+	BootstrapKeyFile  string
+
 	// LoggingOptions is the options for Istio logging.
 	LoggingOptions *log.Options
+
+	// CertOutputDir is the directory the workload key, cert chain and root cert are written to.
+	CertOutputDir string
+
+	// KeyFileName is the name of the workload private key file in CertOutputDir.
+	KeyFileName string
+
+	// CertChainFileName is the name of the workload cert chain file in CertOutputDir.
+	CertChainFileName string
+
+	// RootCertFileName is the name of the root cert file in CertOutputDir.
+	RootCertFileName string
+
+	// RequireSecureCAConnection makes the node agent refuse to send CSRs to the
+	// CA over a connection without transport security, instead of falling back
+	// to an insecure channel.
+	RequireSecureCAConnection bool
+}
+
+// rootCACertFile returns the root cert of the CA configured for the environment.
+func (c *Config) rootCACertFile() string {
+	switch c.Env {
+	case "onprem":
+		return c.PlatformConfig.OnPremConfig.RootCACertFile
+	case "gcp":
+		return c.PlatformConfig.GcpConfig.RootCACertFile
+	case "aws":
+		return c.PlatformConfig.AwsConfig.RootCACertFile
+	default:
+		return ""
+	}
 }
 
 // NewConfig creates a new Config instance with default values.
@@ -71,5 +122,58 @@ func NewConfig() *Config {
 		CSRGracePeriodPercentage:  defaultCSRGracePeriodPercentage,
 		PlatformConfig:            platform.ClientConfig{},
 		LoggingOptions:            log.NewOptions(),
+		CertOutputDir:             defaultCertOutputDir,
+		KeyFileName:               defaultKeyFileName,
+		CertChainFileName:         defaultCertChainFileName,
+		RootCertFileName:          defaultRootCertFileName,
+		RequireSecureCAConnection: true,
+	}
+}
+
+// Validate checks that the cert renewal settings are usable.
+func (c *Config) Validate() error {
+	if c.CSRGracePeriodPercentage <= 0 || c.CSRGracePeriodPercentage >= 100 {
+		return fmt.Errorf("CSR grace period percentage must be between 0 and 100 (exclusive), got %d",
+			c.CSRGracePeriodPercentage)
+	}
+	if c.CSRMaxRetries < 0 {
+		return fmt.Errorf("CSR max retries must not be negative, got %d", c.CSRMaxRetries)
+	}
+	if c.CSRInitialRetrialInterval <= 0 {
+		return fmt.Errorf("CSR initial retrial interval must be positive, got %v", c.CSRInitialRetrialInterval)
+	}
+	if c.WorkloadCertTTL <= 0 {
+		return fmt.Errorf("workload cert TTL must be positive, got %v", c.WorkloadCertTTL)
+	}
+	return nil
+}
+
+// outputFile returns the path of a file in the cert output directory.
+func (c *Config) outputFile(name string) string {
+	return filepath.Join(c.CertOutputDir, name)
+}
+
+// prepareCertOutputDir creates the cert output directory if it is missing,
+// and checks that the workload certs can be written to it.
+func (c *Config) prepareCertOutputDir() error {
+	if c.CertOutputDir == "" {
+		return fmt.Errorf("cert output directory is empty")
+	}
+	for _, name := range []string{c.KeyFileName, c.CertChainFileName, c.RootCertFileName} {
+		if name == "" || strings.ContainsRune(name, filepath.Separator) {
+			return fmt.Errorf("invalid cert output file name %q", name)
+		}
+	}
+
+	if err := os.MkdirAll(c.CertOutputDir, certOutputDirPermission); err != nil {
+		return fmt.Errorf("failed to create cert output directory: %s", err)
+	}
+	f, err := ioutil.TempFile(c.CertOutputDir, ".write-check")
+	if err != nil {
+		return fmt.Errorf("cert output directory %s is not writable: %s", c.CertOutputDir, err)
+	}
+	if err = f.Close(); err != nil {
+		return err
 	}
+	return os.Remove(f.Name())
 }
@@ -15,7 +15,12 @@
 package na
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestInitializeConfig(t *testing.T) {
@@ -33,4 +38,185 @@ func TestInitializeConfig(t *testing.T) {
 		t.Errorf("Unexpected config.CSRGracePeriodPercentage: %v", config.CSRGracePeriodPercentage)
 	}
 
+	if config.BootstrapCertFile != "" || config.BootstrapKeyFile != "" {
+		t.Errorf("Unexpected bootstrap cert %q and key %q", config.BootstrapCertFile, config.BootstrapKeyFile)
+	}
+
+	if config.CertOutputDir != defaultCertOutputDir {
+		t.Errorf("Unexpected config.CertOutputDir: %v", config.CertOutputDir)
+	}
+
+	if config.outputFile(config.KeyFileName) != "/etc/certs/key.pem" ||
+		config.outputFile(config.CertChainFileName) != "/etc/certs/cert-chain.pem" ||
+		config.outputFile(config.RootCertFileName) != "/etc/certs/root-cert.pem" {
+		t.Errorf("Unexpected cert output files: %v, %v, %v", config.KeyFileName, config.CertChainFileName,
+			config.RootCertFileName)
+	}
+
+	if !config.RequireSecureCAConnection {
+		t.Error("Expected a secure CA connection to be required by default")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	testCases := map[string]struct {
+		modify      func(*Config)
+		expectedErr string
+	}{
+		"defaults": {},
+		"grace period 1%": {
+			modify: func(c *Config) { c.CSRGracePeriodPercentage = 1 },
+		},
+		"grace period 99%": {
+			modify: func(c *Config) { c.CSRGracePeriodPercentage = 99 },
+		},
+		"grace period 0%": {
+			modify:      func(c *Config) { c.CSRGracePeriodPercentage = 0 },
+			expectedErr: "CSR grace period percentage must be between 0 and 100 (exclusive), got 0",
+		},
+		"grace period 100%": {
+			modify:      func(c *Config) { c.CSRGracePeriodPercentage = 100 },
+			expectedErr: "CSR grace period percentage must be between 0 and 100 (exclusive), got 100",
+		},
+		"grace period 150%": {
+			modify:      func(c *Config) { c.CSRGracePeriodPercentage = 150 },
+			expectedErr: "CSR grace period percentage must be between 0 and 100 (exclusive), got 150",
+		},
+		"no retries": {
+			modify: func(c *Config) { c.CSRMaxRetries = 0 },
+		},
+		"negative retries": {
+			modify:      func(c *Config) { c.CSRMaxRetries = -1 },
+			expectedErr: "CSR max retries must not be negative, got -1",
+		},
+		"retrial interval 1ns": {
+			modify: func(c *Config) { c.CSRInitialRetrialInterval = time.Nanosecond },
+		},
+		"zero retrial interval": {
+			modify:      func(c *Config) { c.CSRInitialRetrialInterval = 0 },
+			expectedErr: "CSR initial retrial interval must be positive, got 0s",
+		},
+		"negative retrial interval": {
+			modify:      func(c *Config) { c.CSRInitialRetrialInterval = -time.Second },
+			expectedErr: "CSR initial retrial interval must be positive, got -1s",
+		},
+		"workload cert TTL 1ns": {
+			modify: func(c *Config) { c.WorkloadCertTTL = time.Nanosecond },
+		},
+		"zero workload cert TTL": {
+			modify:      func(c *Config) { c.WorkloadCertTTL = 0 },
+			expectedErr: "workload cert TTL must be positive, got 0s",
+		},
+	}
+
+	for id, c := range testCases {
+		config := NewConfig()
+		config.WorkloadCertTTL = time.Hour
+		if c.modify != nil {
+			c.modify(config)
+		}
+
+		err := config.Validate()
+		if len(c.expectedErr) > 0 {
+			if err == nil {
+				t.Errorf("%s: Succeeded. Error expected: %v", id, c.expectedErr)
+			} else if err.Error() != c.expectedErr {
+				t.Errorf("%s: incorrect error message: %s VS %s", id, err.Error(), c.expectedErr)
+			}
+		} else if err != nil {
+			t.Errorf("%s: Unexpected Error: %v", id, err)
+		}
+	}
+}
+
+func TestPrepareCertOutputDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "na_cert_output")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+
+	readOnly := filepath.Join(tmp, "read-only")
+	if err = os.Mkdir(readOnly, 0500); err != nil {
+		t.Fatalf("Mkdir() failed: %v", err)
+	}
+
+	testCases := map[string]struct {
+		dir         string
+		keyFileName string
+		expectedErr string
+	}{
+		"existing directory": {
+			dir:         tmp,
+			keyFileName: defaultKeyFileName,
+		},
+		"missing directory": {
+			dir:         filepath.Join(tmp, "custom", "certs"),
+			keyFileName: "workload-key.pem",
+		},
+		"empty directory": {
+			keyFileName: defaultKeyFileName,
+			expectedErr: "cert output directory is empty",
+		},
+		"file name with directory": {
+			dir:         tmp,
+			keyFileName: "keys/key.pem",
+			expectedErr: `invalid cert output file name "keys/key.pem"`,
+		},
+		"empty file name": {
+			dir:         tmp,
+			expectedErr: `invalid cert output file name ""`,
+		},
+		"directory is a file": {
+			dir:         "testdata/root-cert.pem",
+			keyFileName: defaultKeyFileName,
+			expectedErr: "failed to create cert output directory",
+		},
+		"read-only directory": {
+			dir:         readOnly,
+			keyFileName: defaultKeyFileName,
+			expectedErr: "cert output directory " + readOnly + " is not writable",
+		},
+	}
+
+	for id, c := range testCases {
+		if id == "read-only directory" && os.Geteuid() == 0 {
+			// root can write to any directory
+			continue
+		}
+		config := NewConfig()
+		config.CertOutputDir = c.dir
+		config.KeyFileName = c.keyFileName
+
+		err := config.prepareCertOutputDir()
+		if len(c.expectedErr) > 0 {
+			if err == nil {
+				t.Errorf("%s: Succeeded. Error expected: %v", id, c.expectedErr)
+			} else if !strings.HasPrefix(err.Error(), c.expectedErr) {
+				t.Errorf("%s: incorrect error message: %s VS %s", id, err.Error(), c.expectedErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Unexpected Error: %v", id, err)
+			continue
+		}
+		files, err := ioutil.ReadDir(c.dir)
+		if err != nil {
+			t.Errorf("%s: ReadDir() failed: %v", id, err)
+		}
+		for _, f := range files {
+			if strings.HasPrefix(f.Name(), ".write-check") {
+				t.Errorf("%s: write check file %s was left behind", id, f.Name())
+			}
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(tmp, "custom"))
+	if err != nil {
+		t.Fatalf("Stat() failed: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm&^certOutputDirPermission != 0 {
+		t.Errorf("Created cert output directory with permission %o, want at most %o", perm, certOutputDirPermission)
+	}
 }
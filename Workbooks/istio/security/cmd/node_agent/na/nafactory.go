@@ -16,7 +16,9 @@ package na
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	// TODO(nmittler): Remove this
 	_ "github.com/golang/glog"
 
@@ -29,6 +31,9 @@ import (
 // various platform specific node agents.
 type NodeAgent interface {
 	Start() error
+	// Renew makes a running node agent send a CSR immediately, regardless of
+	// the grace period of its current cert.
+	Renew()
 }
 
 // NewNodeAgent is constructor for Node agent based on the provided Environment variable.
@@ -39,6 +44,7 @@ func NewNodeAgent(cfg *Config) (NodeAgent, error) {
 	na := &nodeAgentInternal{
 		config:   cfg,
 		certUtil: CertUtilImpl{},
+		renew:    make(chan struct{}, 1),
 	}
 
 	if pc, err := platform.NewClient(cfg.Env, cfg.PlatformConfig, cfg.IstioCAAddress); err == nil {
@@ -47,16 +53,36 @@ func NewNodeAgent(cfg *Config) (NodeAgent, error) {
 		return nil, err
 	}
 
+	if creds, err := getBootstrapCredentials(cfg); err != nil {
+		return nil, err
+	} else if creds != nil {
+		na.pc = &bootstrapClient{Client: na.pc, creds: creds, certFile: cfg.BootstrapCertFile}
+	}
+
 	cAClient := &cAGrpcClientImpl{}
 	na.cAClient = cAClient
 
-	// TODO: Specify files for service identity cert/key instead of node agent files.
-	secretServer, err := workload.NewSecretServer(
-		workload.NewSecretFileServerConfig(cfg.PlatformConfig.OnPremConfig.CertChainFile, cfg.PlatformConfig.OnPremConfig.KeyFile))
+	if err := cfg.prepareCertOutputDir(); err != nil {
+		return nil, err
+	}
+	secretServer, err := workload.NewSecretServer(workload.NewSecretFileServerConfig(
+		cfg.outputFile(cfg.CertChainFileName), cfg.outputFile(cfg.KeyFileName), cfg.outputFile(cfg.RootCertFileName)))
 	if err != nil {
 		log.Errorf("Workload IO creation error: %v", err)
 		os.Exit(-1)
 	}
 	na.secretServer = secretServer
+
+	// Publish the root cert the CA is verified with, unless it is already in place.
+	if root := cfg.rootCACertFile(); root != "" &&
+		filepath.Clean(root) != filepath.Clean(cfg.outputFile(cfg.RootCertFileName)) {
+		content, err := ioutil.ReadFile(root)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read root cert: %s", err)
+		}
+		if err = secretServer.SetRootCert(content); err != nil {
+			return nil, err
+		}
+	}
 	return na, nil
 }
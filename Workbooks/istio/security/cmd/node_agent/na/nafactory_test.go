@@ -15,10 +15,27 @@
 package na
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
+// newTestConfig returns a config for env that writes certs to dir.
+func newTestConfig(env, dir string) *Config {
+	config := NewConfig()
+	config.Env = env
+	config.CertOutputDir = dir
+	return config
+}
+
 func TestNewNodeAgent(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "na_factory")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
 
 	testCases := map[string]struct {
 		config      *Config
@@ -29,27 +46,25 @@ func TestNewNodeAgent(t *testing.T) {
 			expectedErr: "nil configuration passed",
 		},
 		"onprem env test": {
-			config: &Config{
-				Env: "onprem",
-			},
+			config:      newTestConfig("onprem", tmp),
 			expectedErr: "",
 		},
 		"gcp env test": {
-			config: &Config{
-				Env: "gcp",
-			},
+			config:      newTestConfig("gcp", tmp),
 			expectedErr: "",
 		},
 		"Unsupported env test": {
-			config: &Config{
-				Env: "somethig else",
-			},
+			config:      newTestConfig("somethig else", tmp),
 			expectedErr: "invalid env somethig else specified",
 		},
+		"No cert output directory test": {
+			config:      newTestConfig("onprem", ""),
+			expectedErr: "cert output directory is empty",
+		},
 	}
 
 	for id, c := range testCases {
-		_, err := NewNodeAgent(c.config)
+		_, err = NewNodeAgent(c.config)
 
 		if len(c.expectedErr) > 0 {
 			if err == nil {
@@ -64,3 +79,55 @@ func TestNewNodeAgent(t *testing.T) {
 
 	}
 }
+
+func TestNewNodeAgentCertOutput(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "na_factory")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+
+	config := newTestConfig("gcp", filepath.Join(tmp, "workload"))
+	config.KeyFileName = "workload-key.pem"
+	config.CertChainFileName = "workload-cert.pem"
+	config.RootCertFileName = "workload-root.pem"
+	config.PlatformConfig.GcpConfig.RootCACertFile = "testdata/root-cert.pem"
+
+	agent, err := NewNodeAgent(config)
+	if err != nil {
+		t.Fatalf("Unexpected Error: %v", err)
+	}
+
+	root, err := ioutil.ReadFile(filepath.Join(tmp, "workload", "workload-root.pem"))
+	if err != nil {
+		t.Fatalf("Root cert was not written to the cert output directory: %v", err)
+	}
+	want, err := ioutil.ReadFile("testdata/root-cert.pem")
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+	if !bytes.Equal(root, want) {
+		t.Errorf("Unexpected root cert content: %s", root)
+	}
+
+	secretServer := agent.(*nodeAgentInternal).secretServer
+	if err = secretServer.SetServiceIdentityCert([]byte("cert")); err != nil {
+		t.Fatalf("SetServiceIdentityCert() failed: %v", err)
+	}
+	if err = secretServer.SetServiceIdentityPrivateKey([]byte("key")); err != nil {
+		t.Fatalf("SetServiceIdentityPrivateKey() failed: %v", err)
+	}
+	for name, content := range map[string]string{"workload-cert.pem": "cert", "workload-key.pem": "key"} {
+		got, err := ioutil.ReadFile(filepath.Join(tmp, "workload", name))
+		if err != nil {
+			t.Errorf("%s was not written: %v", name, err)
+		} else if string(got) != content {
+			t.Errorf("Unexpected %s content: %s", name, got)
+		}
+	}
+
+	config.PlatformConfig.GcpConfig.RootCACertFile = "testdata/root-cert-not-exist.pem"
+	if _, err = NewNodeAgent(config); err == nil {
+		t.Error("Succeeded with a missing root cert. Error expected")
+	}
+}
@@ -35,6 +35,18 @@ type CAGrpcClient interface {
 	SendCSR(*pb.Request, platform.Client, *Config) (*pb.Response, error)
 }
 
+// requireTransportSecurity is a per-RPC credential without metadata. Dialing
+// with it fails unless transport credentials are set as well.
+type requireTransportSecurity struct{}
+
+func (requireTransportSecurity) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return nil, nil
+}
+
+func (requireTransportSecurity) RequireTransportSecurity() bool {
+	return true
+}
+
 // cAGrpcClientImpl is an implementation of GRPC client to talk to CA.
 type cAGrpcClientImpl struct {
 }
@@ -48,6 +60,9 @@ func (c *cAGrpcClientImpl) SendCSR(req *pb.Request, pc platform.Client, cfg *Con
 	if err != nil {
 		return nil, err
 	}
+	if cfg.RequireSecureCAConnection {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(requireTransportSecurity{}))
+	}
 	conn, err := grpc.Dial(cfg.IstioCAAddress, dialOptions...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to dial %s: %s", cfg.IstioCAAddress, err)
@@ -75,6 +90,17 @@ type nodeAgentInternal struct {
 	identity     string
 	secretServer workload.SecretServer
 	certUtil     CertUtil
+	// renew interrupts the wait for the grace period of the current cert
+	renew chan struct{}
+}
+
+// Renew makes the node agent send a CSR immediately. Triggers received
+// while a renewal is already pending are coalesced.
+func (na *nodeAgentInternal) Renew() {
+	select {
+	case na.renew <- struct{}{}:
+	default:
+	}
 }
 
 // Start starts the node Agent.
@@ -120,10 +146,19 @@ func (na *nodeAgentInternal) Start() error {
 					return writeErr
 				}
 				log.Infof("CSR is approved successfully. Will renew cert in %s", waitTime.String())
+				if bc, ok := na.pc.(*bootstrapClient); ok {
+					// renewals are authenticated by the platform
+					na.pc = bc.Client
+				}
 				retries = 0
 				retrialInterval = na.config.CSRInitialRetrialInterval
 				timer := time.NewTimer(waitTime)
-				<-timer.C
+				select {
+				case <-timer.C:
+				case <-na.renew:
+					timer.Stop()
+					log.Infof("Renewing cert ahead of schedule")
+				}
 				success = true
 			}
 		} else {
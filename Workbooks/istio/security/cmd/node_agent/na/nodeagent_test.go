@@ -204,7 +204,7 @@ func TestStartWithArgs(t *testing.T) {
 				ServiceIdentityPrivateKeyFile: "key_file",
 			},
 		)
-		na := nodeAgentInternal{c.config, c.pc, c.cAClient, "service1", fakeWorkloadIO, c.certUtil}
+		na := nodeAgentInternal{c.config, c.pc, c.cAClient, "service1", fakeWorkloadIO, c.certUtil, nil}
 		err := na.Start()
 		if err.Error() != c.expectedErr {
 			t.Errorf("Test case [%s]: incorrect error message: %s VS (expected) %s", id, err.Error(), c.expectedErr)
@@ -293,6 +293,20 @@ func TestSendCSRAgainstLocalInstance(t *testing.T) {
 			expectedErr: fmt.Sprintf("failed to dial %s: grpc: no transport security set "+
 				"(use grpc.WithInsecure() explicitly or set credentials)", lis.Addr().String()),
 		},
+		"Insecure option with secure connection required": {
+			config: &Config{
+				IstioCAAddress:            lis.Addr().String(),
+				RSAKeySize:                512,
+				RequireSecureCAConnection: true,
+			},
+			pc: mockpc.FakeClient{[]grpc.DialOption{
+				grpc.WithInsecure(),
+			}, "", "service1", "", []byte{}, "", true},
+			res:      defaultServerResponse,
+			cAClient: &cAGrpcClientImpl{},
+			expectedErr: fmt.Sprintf("failed to dial %s: grpc: the credentials require transport level security "+
+				"(use grpc.WithTransportCredentials() to set)", lis.Addr().String()),
+		},
 		"Error from GetDialOptions": {
 			config: &Config{
 				IstioCAAddress: lis.Addr().String(),
@@ -334,7 +348,7 @@ func TestSendCSRAgainstLocalInstance(t *testing.T) {
 			},
 		)
 
-		na := nodeAgentInternal{c.config, c.pc, c.cAClient, "service1", fakeWorkloadIO, c.certUtil}
+		na := nodeAgentInternal{c.config, c.pc, c.cAClient, "service1", fakeWorkloadIO, c.certUtil, nil}
 
 		serv.SetResponseAndError(&c.res, c.resErr)
 
@@ -353,3 +367,81 @@ func TestSendCSRAgainstLocalInstance(t *testing.T) {
 		}
 	}
 }
+
+// notifyingCAClient approves CSRs with the queued responses, and notifies
+// each CSR sent.
+type notifyingCAClient struct {
+	sent      chan struct{}
+	responses chan *pb.Response
+}
+
+func (c *notifyingCAClient) SendCSR(req *pb.Request, pc platform.Client, cfg *Config) (*pb.Response, error) {
+	c.sent <- struct{}{}
+	return <-c.responses, nil
+}
+
+func TestRenew(t *testing.T) {
+	config := Config{
+		ServiceIdentityOrg:        "Google Inc.",
+		RSAKeySize:                512,
+		Env:                       "onprem",
+		CSRInitialRetrialInterval: time.Millisecond,
+		CSRMaxRetries:             0,
+		CSRGracePeriodPercentage:  50,
+		LoggingOptions:            log.NewOptions(),
+	}
+	caClient := &notifyingCAClient{
+		sent:      make(chan struct{}, 1),
+		responses: make(chan *pb.Response, 2),
+	}
+	for i := 0; i < 2; i++ {
+		caClient.responses <- &pb.Response{IsApproved: true, SignedCertChain: []byte(`TESTCERT`)}
+	}
+	fakeWorkloadIO, _ := workload.NewSecretServer(
+		workload.Config{
+			Mode:                          workload.SecretFile,
+			FileUtil:                      mockutil.FakeFileUtil{WriteContent: make(map[string][]byte)},
+			ServiceIdentityCertFile:       "cert_file",
+			ServiceIdentityPrivateKeyFile: "key_file",
+		},
+	)
+	// the cert would not be renewed before the test times out
+	na := nodeAgentInternal{&config, mockpc.FakeClient{nil, "", "service1", "", []byte{}, "", true}, caClient,
+		"service1", fakeWorkloadIO, FakeCertUtil{time.Hour, nil}, make(chan struct{}, 1)}
+
+	done := make(chan error)
+	go func() {
+		done <- na.Start()
+	}()
+
+	waitForCSR := func(description string) {
+		select {
+		case <-caClient.sent:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("No CSR was sent %s", description)
+		}
+	}
+
+	waitForCSR("at startup")
+	select {
+	case <-caClient.sent:
+		t.Fatal("CSR was sent before the cert needed renewal")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	na.Renew()
+	waitForCSR("after the renewal was triggered")
+
+	// the next CSR is not approved, which stops the node agent without retries
+	close(caClient.responses)
+	na.Renew()
+	waitForCSR("after the second renewal was triggered")
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Start() returned without error")
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("Node agent did not stop after the CSR was rejected")
+	}
+}
@@ -42,6 +42,8 @@ const (
 	integrationTestNamespacePrefix = "istio-ca-integration-"
 	// Specifies how long we wait before a secret becomes existent.
 	secretWaitTime = 20 * time.Second
+	// Specifies how long we wait for a LoadBalancer service to get an external IP.
+	serviceWaitTime = 300 * time.Second
 
 	// Certificates validation retry
 	certValidateRetry      = 10
@@ -136,7 +138,7 @@ func initializeIntegrationTest(cmd *cobra.Command, args []string) error {
 	opts.namespace = namespace
 
 	// Create Role
-	err = utils.CreateIstioCARole(opts.clientset, opts.namespace)
+	err = utils.CreateIstioCARole(opts.clientset, opts.namespace, "")
 	if err != nil {
 		_ = utils.DeleteTestNamespace(opts.clientset, opts.namespace)
 		return fmt.Errorf("failed to create a role (error: %v)", err)
@@ -279,7 +281,7 @@ func runCAForNodeAgentTests() error {
 
 	// Create the istio-ca service for NodeAgent pod
 	_, err = utils.CreateService(opts.clientset, opts.namespace, "istio-ca", 8060,
-		v1.ServiceTypeClusterIP, caPod)
+		v1.ServiceTypeClusterIP, serviceWaitTime, caPod)
 	if err != nil {
 		return fmt.Errorf("failed to deploy Istio CA (error: %v)", err)
 	}
@@ -293,20 +295,14 @@ func runCAForNodeAgentTests() error {
 	}
 
 	// Create the service for NodeAgent pod
-	naService, err := utils.CreateService(opts.clientset, opts.namespace, "node-agent", 8080,
-		v1.ServiceTypeLoadBalancer, naPod)
+	naService, err := utils.CreateServiceWithResult(opts.clientset, opts.namespace, "node-agent",
+		[]v1.ServicePort{{Port: 8080}}, "", v1.ServiceTypeLoadBalancer, serviceWaitTime, naPod)
 	if err != nil {
 		return fmt.Errorf("failed to deploy Istio CA (error: %v)", err)
 	}
 
 	// Test certificates of NodeAgent were updated and valid
-	_, err = opts.clientset.CoreV1().Services(opts.namespace).Get("node-agent", metav1.GetOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to get the external IP adddress of node-agent service: %v", err)
-	}
-
-	err = waitForNodeAgentCertificateUpdate(fmt.Sprintf("http://%v:%v",
-		naService.Status.LoadBalancer.Ingress[0].IP, 8080))
+	err = waitForNodeAgentCertificateUpdate(fmt.Sprintf("http://%v:%v", naService.ExternalIP, 8080))
 	if err != nil {
 		return fmt.Errorf("failed to check certificate update node-agent (err: %v)", err)
 	}
@@ -15,24 +15,49 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 	// TODO(nmittler): Remove this
 	_ "github.com/golang/glog"
+	multierror "github.com/hashicorp/go-multierror"
 	"k8s.io/api/core/v1"
 	rbac "k8s.io/api/rbac/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth" // to avoid 'No Auth Provider found for name "gcp"'
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
 
 	"istio.io/istio/pkg/log"
 )
 
 var (
 	immediate int64
+
+	// ErrTimeout is returned by WaitForCondition when the condition is not met in time.
+	ErrTimeout = errors.New("timed out waiting for condition")
+
+	// ServicePollInterval is how often a LoadBalancer service is fetched while waiting for its
+	// external IP, in case the watch misses the update.
+	ServicePollInterval = 10 * time.Second
+
+	// PortForwardReadyTimeout is how long PortForward waits for the forward to be ready.
+	PortForwardReadyTimeout = 30 * time.Second
 )
 
 // CreateClientset creates a new Clientset for the given kubeconfig.
@@ -77,9 +102,67 @@ func DeleteTestNamespace(clientset kubernetes.Interface, namespace string) error
 	return nil
 }
 
-// CreateService creates a service object and returns a pointer pointing to this object on success.
+// DeleteTestNamespacesByPrefix deletes all namespaces whose names start with prefix, e.g. those
+// leaked by earlier runs of CreateTestNamespace. Deletion continues past failures and all errors
+// are returned together.
+func DeleteTestNamespacesByPrefix(clientset kubernetes.Interface, prefix string) error {
+	if prefix == "" {
+		return errors.New("namespace prefix cannot be empty")
+	}
+	namespaces, err := clientset.CoreV1().Namespaces().List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces (error: %v)", err)
+	}
+
+	var result *multierror.Error
+	for _, ns := range namespaces.Items {
+		if !strings.HasPrefix(ns.GetName(), prefix) {
+			continue
+		}
+		if err := DeleteTestNamespace(clientset, ns.GetName()); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+// CreateService creates a single-port service object and returns a pointer pointing to this object on success.
+// LoadBalancer services are waited on for up to timeout until they get an external IP.
 func CreateService(clientset kubernetes.Interface, namespace string, name string, port int32,
-	serviceType v1.ServiceType, pod *v1.Pod) (*v1.Service, error) {
+	serviceType v1.ServiceType, timeout time.Duration, pod *v1.Pod) (*v1.Service, error) {
+	return CreateServiceWithPorts(clientset, namespace, name, []v1.ServicePort{{Port: port}}, "", serviceType, timeout, pod)
+}
+
+// CreateServiceWithPorts creates a service object exposing the given ports and returns a pointer pointing
+// to this object on success. Setting clusterIP to v1.ClusterIPNone creates a headless service.
+// LoadBalancer services are waited on for up to timeout until they get an external IP.
+func CreateServiceWithPorts(clientset kubernetes.Interface, namespace string, name string, ports []v1.ServicePort,
+	clusterIP string, serviceType v1.ServiceType, timeout time.Duration, pod *v1.Pod) (*v1.Service, error) {
+	result, err := CreateServiceWithResult(clientset, namespace, name, ports, clusterIP, serviceType, timeout, pod)
+	if err != nil {
+		return nil, err
+	}
+	return result.Service, nil
+}
+
+// ServiceResult describes a service created by CreateServiceWithResult.
+type ServiceResult struct {
+	// Service is the created service.
+	Service *v1.Service
+
+	// ExternalIP and ExternalHostname are the first LoadBalancer ingress of the service.
+	// Both are empty for services which are not waited on.
+	ExternalIP       string
+	ExternalHostname string
+
+	// Waited is how long it took the LoadBalancer to get an external IP.
+	Waited time.Duration
+}
+
+// CreateServiceWithResult is CreateServiceWithPorts, but also returns the external address of
+// LoadBalancer services and the time waited for it.
+func CreateServiceWithResult(clientset kubernetes.Interface, namespace string, name string, ports []v1.ServicePort,
+	clusterIP string, serviceType v1.ServiceType, timeout time.Duration, pod *v1.Pod) (*ServiceResult, error) {
 	uuid := string(uuid.NewUUID())
 	_, err := clientset.CoreV1().Services(namespace).Create(&v1.Service{
 		ObjectMeta: metav1.ObjectMeta{
@@ -89,13 +172,10 @@ func CreateService(clientset kubernetes.Interface, namespace string, name string
 			Name: name,
 		},
 		Spec: v1.ServiceSpec{
-			Type:     serviceType,
-			Selector: pod.Labels,
-			Ports: []v1.ServicePort{
-				{
-					Port: port,
-				},
-			},
+			Type:      serviceType,
+			ClusterIP: clusterIP,
+			Selector:  pod.Labels,
+			Ports:     ports,
 		},
 	})
 
@@ -103,14 +183,24 @@ func CreateService(clientset kubernetes.Interface, namespace string, name string
 		return nil, err
 	}
 
-	if serviceType == v1.ServiceTypeLoadBalancer {
-		err = waitForServiceExternalIPAddress(clientset, namespace, uuid, 300*time.Second)
+	result := &ServiceResult{}
+	if serviceType == v1.ServiceTypeLoadBalancer && clusterIP != v1.ClusterIPNone {
+		startTime := time.Now()
+		svc, err := waitForServiceExternalIPAddress(clientset, namespace, name, uuid, timeout, ServicePollInterval)
 		if err != nil {
 			return nil, err
 		}
+		result.Waited = time.Since(startTime)
+		ingress := svc.Status.LoadBalancer.Ingress[0]
+		result.ExternalIP = ingress.IP
+		result.ExternalHostname = ingress.Hostname
 	}
 
-	return clientset.CoreV1().Services(namespace).Get(name, metav1.GetOptions{})
+	result.Service, err = clientset.CoreV1().Services(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 // DeleteService deletes a service.
@@ -118,15 +208,41 @@ func DeleteService(clientset kubernetes.Interface, namespace string, name string
 	return clientset.CoreV1().Services(namespace).Delete(name, &metav1.DeleteOptions{GracePeriodSeconds: &immediate})
 }
 
+// PodOptions customizes a pod created by CreatePodWithOptions or CreatePodWithContainers.
+type PodOptions struct {
+	// Command and Args override the entrypoint of the container. Args is only used with Command.
+	// They are ignored by CreatePodWithContainers, which takes the containers as they are.
+	Command []string
+	Args    []string
+
+	// Labels are added to the pod. The "uuid" and "pod-group" labels are always set and cannot
+	// be overridden.
+	Labels map[string]string
+
+	// Timeout is how long to wait for the pod to be running. Defaults to DefaultPodWaitTime.
+	Timeout time.Duration
+
+	NodeSelector map[string]string
+	Tolerations  []v1.Toleration
+}
+
+// DefaultPodWaitTime is how long CreatePodWithOptions and CreatePodWithContainers wait for a pod to be running by default.
+const DefaultPodWaitTime = 60 * time.Second
+
 // CreatePod creates a pod object and returns a pointer pointing to this object on success.
 func CreatePod(clientset kubernetes.Interface, namespace string, image string, name string) (*v1.Pod, error) {
-	return CreatePodWithCommand(clientset, namespace, image, name, []string{}, []string{})
+	return CreatePodWithOptions(clientset, namespace, image, name, PodOptions{})
 }
 
 // CreatePodWithCommand creates a pod object with specific command and arguments and returns a pointer pointing to this object on success.
 func CreatePodWithCommand(clientset kubernetes.Interface, namespace string, image string, name string, command []string, args []string) (*v1.Pod, error) {
-	podUUID := string(uuid.NewUUID())
+	return CreatePodWithOptions(clientset, namespace, image, name, PodOptions{Command: command, Args: args})
+}
 
+// CreatePodWithOptions creates a pod object customized by opts, waits for it to be running, and returns
+// a pointer pointing to this object on success.
+func CreatePodWithOptions(clientset kubernetes.Interface, namespace string, image string, name string,
+	opts PodOptions) (*v1.Pod, error) {
 	env := []v1.EnvVar{
 		{
 			Name: "NAMESPACE",
@@ -139,30 +255,48 @@ func CreatePodWithCommand(clientset kubernetes.Interface, namespace string, imag
 		},
 	}
 
+	container := v1.Container{
+		Env:   env,
+		Name:  fmt.Sprintf("%v-pod-container", name),
+		Image: image,
+	}
+	if len(opts.Command) > 0 {
+		container.Command = opts.Command
+		if len(opts.Args) > 0 {
+			container.Args = opts.Args
+		}
+	}
+
+	return CreatePodWithContainers(clientset, namespace, name, []v1.Container{container}, opts)
+}
+
+// CreatePodWithContainers creates a pod object running the given containers, customized by opts,
+// waits for it to be running, and returns a pointer pointing to this object on success.
+func CreatePodWithContainers(clientset kubernetes.Interface, namespace string, name string,
+	containers []v1.Container, opts PodOptions) (*v1.Pod, error) {
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("pod %v has no container", name)
+	}
+	podUUID := string(uuid.NewUUID())
+
 	spec := v1.PodSpec{
-		Containers: []v1.Container{
-			{
-				Env:   env,
-				Name:  fmt.Sprintf("%v-pod-container", name),
-				Image: image,
-			},
-		},
+		Containers:   containers,
+		NodeSelector: opts.NodeSelector,
+		Tolerations:  opts.Tolerations,
 	}
 
-	if len(command) > 0 {
-		spec.Containers[0].Command = command
-		if len(args) > 0 {
-			spec.Containers[0].Args = args
-		}
+	podLabels := make(map[string]string, len(opts.Labels)+2)
+	for k, v := range opts.Labels {
+		podLabels[k] = v
 	}
+	// waitForPodRunning selects the pod by its uuid label
+	podLabels["uuid"] = podUUID
+	podLabels["pod-group"] = fmt.Sprintf("%v-pod-group", name)
 
 	pod := &v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
-			Labels: map[string]string{
-				"uuid":      podUUID,
-				"pod-group": fmt.Sprintf("%v-pod-group", name),
-			},
-			Name: name,
+			Labels: podLabels,
+			Name:   name,
 		},
 		Spec: spec,
 	}
@@ -172,7 +306,11 @@ func CreatePodWithCommand(clientset kubernetes.Interface, namespace string, imag
 		return nil, err
 	}
 
-	if err := waitForPodRunning(clientset, namespace, podUUID, 60*time.Second); err != nil {
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultPodWaitTime
+	}
+	if err := waitForPodRunning(clientset, namespace, podUUID, timeout); err != nil {
 		return nil, err
 	}
 
@@ -189,8 +327,137 @@ func DeletePod(clientset kubernetes.Interface, namespace string, name string) er
 	return clientset.CoreV1().Pods(namespace).Delete(name, &metav1.DeleteOptions{GracePeriodSeconds: &immediate})
 }
 
-// CreateIstioCARole creates a role object named "istio-ca-role".
-func CreateIstioCARole(clientset kubernetes.Interface, namespace string) error {
+// PortForward forwards localPort on localhost to remotePort of a running pod, e.g. to scrape the
+// admin stats of its proxy from the test process. It returns once the forward accepts connections,
+// along with a function that stops the forward and waits for the local port to be released.
+func PortForward(clientset kubernetes.Interface, restConfig *rest.Config, namespace string, pod string,
+	localPort int, remotePort int) (func(), error) {
+	p, err := clientset.CoreV1().Pods(namespace).Get(pod, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod %v/%v (error: %v)", namespace, pod, err)
+	}
+	if p.Status.Phase != v1.PodRunning {
+		return nil, fmt.Errorf("pod %v/%v is in %v phase, not running", namespace, pod, p.Status.Phase)
+	}
+
+	hostURL, apiPath, err := rest.DefaultServerURL(restConfig.Host, "/api", schema.GroupVersion{Version: "v1"},
+		rest.IsConfigTransportTLS(*restConfig))
+	if err != nil {
+		return nil, fmt.Errorf("invalid API server address %q (error: %v)", restConfig.Host, err)
+	}
+	hostURL.Path = path.Join(apiPath, "namespaces", namespace, "pods", pod, "portforward")
+
+	transport, upgrader, err := spdy.RoundTripperFor(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the SPDY transport (error: %v)", err)
+	}
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, hostURL)
+
+	stopCh := make(chan struct{})
+	readyCh := make(chan struct{})
+	ports := []string{fmt.Sprintf("%d:%d", localPort, remotePort)}
+	forwarder, err := portforward.New(dialer, ports, stopCh, readyCh, ioutil.Discard, ioutil.Discard)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up the port forward (error: %v)", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- forwarder.ForwardPorts()
+	}()
+
+	select {
+	case <-readyCh:
+	case err = <-errCh:
+		return nil, fmt.Errorf("failed to forward port %v to %v/%v:%v (error: %v)",
+			localPort, namespace, pod, remotePort, err)
+	case <-time.After(PortForwardReadyTimeout):
+		close(stopCh)
+		return nil, fmt.Errorf("port forward to %v/%v:%v is not ready within %v",
+			namespace, pod, remotePort, PortForwardReadyTimeout)
+	}
+	log.Infof("Forwarding localhost:%v to %v/%v:%v", localPort, namespace, pod, remotePort)
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			close(stopCh)
+			if err := <-errCh; err != nil {
+				log.Warnf("Port forward to %v/%v:%v failed (error: %v)", namespace, pod, remotePort, err)
+			}
+		})
+	}
+	return stop, nil
+}
+
+// istioCARoleRules are the permissions granted to Istio CA by "istio-ca-role".
+var istioCARoleRules = []rbac.PolicyRule{
+	{
+		Verbs:     []string{"create", "get", "watch", "list", "update"},
+		APIGroups: []string{"core", ""},
+		Resources: []string{"secrets"},
+	},
+	{
+		Verbs:     []string{"get", "watch", "list"},
+		APIGroups: []string{"core", ""},
+		Resources: []string{"serviceaccounts"},
+	},
+}
+
+// scopedIstioCARoleRules limits get and update of secrets to the given names. RBAC cannot scope
+// create, list and watch by name, so those are still granted on all secrets of the namespace.
+func scopedIstioCARoleRules(secretNames []string) []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			Verbs:     []string{"create", "watch", "list"},
+			APIGroups: []string{"core", ""},
+			Resources: []string{"secrets"},
+		},
+		{
+			Verbs:         []string{"get", "update"},
+			APIGroups:     []string{"core", ""},
+			Resources:     []string{"secrets"},
+			ResourceNames: secretNames,
+		},
+		{
+			Verbs:     []string{"get", "watch", "list"},
+			APIGroups: []string{"core", ""},
+			Resources: []string{"serviceaccounts"},
+		},
+	}
+}
+
+// istioCARoleRulesFor returns the rules of "istio-ca-role". Without a secretNamePrefix the CA may
+// write any secret in the namespace. Otherwise RBAC has no prefix match, so writes are limited to
+// the prefixed names of the service accounts that exist in the namespace at this point.
+func istioCARoleRulesFor(clientset kubernetes.Interface, namespace string, secretNamePrefix string) ([]rbac.PolicyRule, error) {
+	if secretNamePrefix == "" {
+		return istioCARoleRules, nil
+	}
+	serviceAccounts, err := clientset.CoreV1().ServiceAccounts(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service accounts (error: %v)", err)
+	}
+	// An empty resourceNames would grant access to all secrets.
+	if len(serviceAccounts.Items) == 0 {
+		return nil, fmt.Errorf("no service accounts in namespace %v to scope the secrets to", namespace)
+	}
+	var secretNames []string
+	for _, sa := range serviceAccounts.Items {
+		secretNames = append(secretNames, secretNamePrefix+sa.Name)
+	}
+	sort.Strings(secretNames)
+	return scopedIstioCARoleRules(secretNames), nil
+}
+
+// CreateIstioCARole creates a role object named "istio-ca-role". If the role already exists,
+// its rules are updated in place when they differ from the expected ones. A non-empty
+// secretNamePrefix restricts secret writes to the secrets of the current service accounts.
+func CreateIstioCARole(clientset kubernetes.Interface, namespace string, secretNamePrefix string) error {
+	rules, err := istioCARoleRulesFor(clientset, namespace, secretNamePrefix)
+	if err != nil {
+		return err
+	}
 	role := rbac.Role{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: "rbac.authorization.k8s.io/v1beta1",
@@ -199,26 +466,34 @@ func CreateIstioCARole(clientset kubernetes.Interface, namespace string) error {
 			Name:      "istio-ca-role",
 			Namespace: namespace,
 		},
-		Rules: []rbac.PolicyRule{
-			{
-				Verbs:     []string{"create", "get", "watch", "list", "update"},
-				APIGroups: []string{"core", ""},
-				Resources: []string{"secrets"},
-			},
-			{
-				Verbs:     []string{"get", "watch", "list"},
-				APIGroups: []string{"core", ""},
-				Resources: []string{"serviceaccounts"},
-			},
-		},
+		Rules: rules,
+	}
+	roles := clientset.RbacV1beta1().Roles(namespace)
+	_, err = roles.Create(&role)
+	if err == nil {
+		return nil
 	}
-	if _, err := clientset.RbacV1beta1().Roles(namespace).Create(&role); err != nil {
+	if !apierrors.IsAlreadyExists(err) {
 		return fmt.Errorf("failed to create role (error: %v)", err)
 	}
+
+	existing, err := roles.Get(role.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get existing role (error: %v)", err)
+	}
+	if reflect.DeepEqual(existing.Rules, rules) {
+		return nil
+	}
+	log.Infof("Reconciling rules of role %v/%v", namespace, role.Name)
+	existing.Rules = rules
+	if _, err := roles.Update(existing); err != nil {
+		return fmt.Errorf("failed to update role (error: %v)", err)
+	}
 	return nil
 }
 
-// CreateIstioCARoleBinding binds role "istio-ca-role" to default service account.
+// CreateIstioCARoleBinding binds role "istio-ca-role" to default service account. An existing
+// binding is left as is.
 func CreateIstioCARoleBinding(clientset kubernetes.Interface, namespace string) error {
 	rolebinding := rbac.RoleBinding{
 		TypeMeta: metav1.TypeMeta{
@@ -242,86 +517,147 @@ func CreateIstioCARoleBinding(clientset kubernetes.Interface, namespace string)
 		},
 	}
 	_, err := clientset.RbacV1beta1().RoleBindings(namespace).Create(&rolebinding)
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
 	return err
 }
 
-func waitForServiceExternalIPAddress(clientset kubernetes.Interface, namespace string, uuid string,
-	timeToWait time.Duration) error {
+// WaitForCondition reads events from the given watch until cond reports true, cond returns an
+// error, or the timeout expires. The watch is stopped before returning.
+func WaitForCondition(w watch.Interface, timeout time.Duration, cond func(watch.Event) (bool, error)) error {
+	defer w.Stop()
+	events := w.ResultChan()
+
+	startTime := time.Now()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return fmt.Errorf("watch closed before condition was met")
+			}
+			done, err := cond(event)
+			if err != nil {
+				return err
+			}
+			if done {
+				return nil
+			}
+		case <-time.After(timeout - time.Since(startTime)):
+			return ErrTimeout
+		}
+	}
+}
+
+// waitForServiceExternalIPAddress watches the service for an external IP, and also fetches it every
+// pollInterval in case the watch misses the update or is closed. It returns the service once it has one.
+func waitForServiceExternalIPAddress(clientset kubernetes.Interface, namespace string, name string, uuid string,
+	timeToWait time.Duration, pollInterval time.Duration) (*v1.Service, error) {
 	selectors := labels.Set{"uuid": uuid}.AsSelectorPreValidated()
 	listOptions := metav1.ListOptions{
 		LabelSelector: selectors.String(),
 	}
 
-	watch, err := clientset.CoreV1().Services(namespace).Watch(listOptions)
+	w, err := clientset.CoreV1().Services(namespace).Watch(listOptions)
 	if err != nil {
-		return fmt.Errorf("failed to set up a watch for service (error: %v)", err)
+		return nil, fmt.Errorf("failed to set up a watch for service (error: %v)", err)
 	}
-	events := watch.ResultChan()
+	defer w.Stop()
+	events := w.ResultChan()
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
 
 	startTime := time.Now()
+	timeout := time.After(timeToWait)
 	for {
 		select {
-		case event := <-events:
-			svc := event.Object.(*v1.Service)
-			if len(svc.Status.LoadBalancer.Ingress) > 0 {
-				log.Infof("LoadBalancer for %v/%v is ready. IP: %v", namespace, svc.GetName(),
-					svc.Status.LoadBalancer.Ingress[0].IP)
-				return nil
+		case event, ok := <-events:
+			if !ok {
+				// keep polling until the timeout
+				events = nil
+				continue
+			}
+			if svc, ok := event.Object.(*v1.Service); ok && loadBalancerReady(svc) {
+				return svc, nil
 			}
-		case <-time.After(timeToWait - time.Since(startTime)):
-			return fmt.Errorf("pod is not in running phase within %v", timeToWait)
+		case <-ticker.C:
+			svc, err := clientset.CoreV1().Services(namespace).Get(name, metav1.GetOptions{})
+			if err != nil {
+				log.Warnf("Failed to get service %v/%v (error: %v)", namespace, name, err)
+				continue
+			}
+			if loadBalancerReady(svc) {
+				return svc, nil
+			}
+		case <-timeout:
+			return nil, fmt.Errorf("LoadBalancer for %v/%v has no external IP after %v", namespace, name,
+				time.Since(startTime))
 		}
 	}
 }
 
+func loadBalancerReady(svc *v1.Service) bool {
+	if len(svc.Status.LoadBalancer.Ingress) == 0 {
+		return false
+	}
+	log.Infof("LoadBalancer for %v/%v is ready. IP: %v", svc.GetNamespace(), svc.GetName(),
+		svc.Status.LoadBalancer.Ingress[0].IP)
+	return true
+}
+
 func waitForPodRunning(clientset kubernetes.Interface, namespace string, uuid string,
 	timeToWait time.Duration) error {
 	selectors := labels.Set{"uuid": uuid}.AsSelectorPreValidated()
 	listOptions := metav1.ListOptions{
 		LabelSelector: selectors.String(),
 	}
-	watch, err := clientset.CoreV1().Pods(namespace).Watch(listOptions)
+	w, err := clientset.CoreV1().Pods(namespace).Watch(listOptions)
 	if err != nil {
 		return fmt.Errorf("failed to set up a watch for pod (error: %v)", err)
 	}
-	events := watch.ResultChan()
 
-	startTime := time.Now()
-	for {
-		select {
-		case event := <-events:
-			pod := event.Object.(*v1.Pod)
-			if pod.Status.Phase == v1.PodRunning {
-				log.Infof("Pod %v/%v is in Running phase", namespace, pod.GetName())
-				return nil
-			}
-		case <-time.After(timeToWait - time.Since(startTime)):
-			return fmt.Errorf("pod is not in running phase within %v", timeToWait)
+	err = WaitForCondition(w, timeToWait, func(event watch.Event) (bool, error) {
+		pod, ok := event.Object.(*v1.Pod)
+		if !ok {
+			return false, nil
+		}
+		if pod.Status.Phase == v1.PodRunning {
+			log.Infof("Pod %v/%v is in Running phase", namespace, pod.GetName())
+			return true, nil
 		}
+		return false, nil
+	})
+	if err == ErrTimeout {
+		return fmt.Errorf("pod is not in running phase within %v", timeToWait)
 	}
+	return err
 }
 
 // WaitForSecretExist takes name of a secret and watches the secret. Returns the requested secret
 // if it exists, or error on timeouts.
 func WaitForSecretExist(clientset kubernetes.Interface, namespace string, secretName string,
 	timeToWait time.Duration) (*v1.Secret, error) {
-	watch, err := clientset.CoreV1().Secrets(namespace).Watch(metav1.ListOptions{})
+	w, err := clientset.CoreV1().Secrets(namespace).Watch(metav1.ListOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to set up watch for secret (error: %v)", err)
 	}
-	events := watch.ResultChan()
 
-	startTime := time.Now()
-	for {
-		select {
-		case event := <-events:
-			secret := event.Object.(*v1.Secret)
-			if secret.GetName() == secretName {
-				return secret, nil
-			}
-		case <-time.After(timeToWait - time.Since(startTime)):
-			return nil, fmt.Errorf("secret %v/%v did not become existent within %v",
-				namespace, secretName, timeToWait)
+	var secret *v1.Secret
+	err = WaitForCondition(w, timeToWait, func(event watch.Event) (bool, error) {
+		s, ok := event.Object.(*v1.Secret)
+		if !ok || s.GetName() != secretName {
+			return false, nil
 		}
+		secret = s
+		return true, nil
+	})
+	if err == ErrTimeout {
+		return nil, fmt.Errorf("secret %v/%v did not become existent within %v",
+			namespace, secretName, timeToWait)
+	}
+	if err != nil {
+		return nil, err
 	}
+	return secret, nil
 }
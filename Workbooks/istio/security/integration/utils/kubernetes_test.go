@@ -0,0 +1,636 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	"k8s.io/api/core/v1"
+	rbac "k8s.io/api/rbac/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestWaitForCondition(t *testing.T) {
+	condErr := errors.New("condition failed")
+	testCases := map[string]struct {
+		events      []string
+		cond        func(watch.Event) (bool, error)
+		expectedErr error
+	}{
+		"Match": {
+			events: []string{"foo", "bar"},
+			cond: func(e watch.Event) (bool, error) {
+				return e.Object.(*v1.Pod).GetName() == "bar", nil
+			},
+		},
+		"Error": {
+			events: []string{"foo"},
+			cond: func(watch.Event) (bool, error) {
+				return false, condErr
+			},
+			expectedErr: condErr,
+		},
+		"Timeout": {
+			events: []string{"foo", "bar"},
+			cond: func(watch.Event) (bool, error) {
+				return false, nil
+			},
+			expectedErr: ErrTimeout,
+		},
+	}
+
+	for id, tc := range testCases {
+		w := watch.NewFakeWithChanSize(len(tc.events), false)
+		for _, name := range tc.events {
+			w.Add(&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}})
+		}
+
+		err := WaitForCondition(w, 100*time.Millisecond, tc.cond)
+		if err != tc.expectedErr {
+			t.Errorf("%s: unexpected error: want %v, got %v", id, tc.expectedErr, err)
+		}
+		if !w.IsStopped() {
+			t.Errorf("%s: watch is not stopped", id)
+		}
+	}
+}
+
+func TestCreateServiceWithPorts(t *testing.T) {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "foo"}}}
+	testCases := map[string]struct {
+		ports     []v1.ServicePort
+		clusterIP string
+	}{
+		"Headless": {
+			ports:     []v1.ServicePort{{Name: "tcp", Port: 9090}},
+			clusterIP: v1.ClusterIPNone,
+		},
+		"Two ports": {
+			ports: []v1.ServicePort{{Name: "http", Port: 80}, {Name: "grpc", Port: 7070}},
+		},
+	}
+
+	for id, tc := range testCases {
+		clientset := fake.NewSimpleClientset()
+		svc, err := CreateServiceWithPorts(clientset, "test-ns", "foo", tc.ports, tc.clusterIP, v1.ServiceTypeClusterIP, time.Second, pod)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+		if svc.Spec.ClusterIP != tc.clusterIP {
+			t.Errorf("%s: unexpected cluster IP: want %q, got %q", id, tc.clusterIP, svc.Spec.ClusterIP)
+		}
+		if !reflect.DeepEqual(svc.Spec.Ports, tc.ports) {
+			t.Errorf("%s: unexpected ports: want %v, got %v", id, tc.ports, svc.Spec.Ports)
+		}
+		if !reflect.DeepEqual(svc.Spec.Selector, pod.Labels) {
+			t.Errorf("%s: unexpected selector: want %v, got %v", id, pod.Labels, svc.Spec.Selector)
+		}
+	}
+}
+
+func TestCreateServiceLoadBalancerPolling(t *testing.T) {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "foo"}}}
+	defer func(interval time.Duration) { ServicePollInterval = interval }(ServicePollInterval)
+	ServicePollInterval = 10 * time.Millisecond
+
+	testCases := map[string]struct {
+		ipDelay     time.Duration
+		expectedErr string
+	}{
+		"IP assigned after delay": {
+			ipDelay: 50 * time.Millisecond,
+		},
+		"Timeout": {
+			expectedErr: "LoadBalancer for test-ns/foo has no external IP after",
+		},
+	}
+
+	for id, tc := range testCases {
+		clientset := fake.NewSimpleClientset()
+		// The watch never delivers events, so the IP can only be found by polling.
+		w := watch.NewFake()
+		clientset.PrependWatchReactor("services", func(k8stesting.Action) (bool, watch.Interface, error) {
+			return true, w, nil
+		})
+
+		if tc.ipDelay > 0 {
+			go func() {
+				time.Sleep(tc.ipDelay)
+				svc, err := clientset.CoreV1().Services("test-ns").Get("foo", metav1.GetOptions{})
+				if err != nil {
+					t.Errorf("%s: failed to get service: %v", id, err)
+					return
+				}
+				svc.Status.LoadBalancer.Ingress = []v1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+				if _, err := clientset.CoreV1().Services("test-ns").UpdateStatus(svc); err != nil {
+					t.Errorf("%s: failed to update service status: %v", id, err)
+				}
+			}()
+		}
+
+		svc, err := CreateService(clientset, "test-ns", "foo", 80, v1.ServiceTypeLoadBalancer, time.Second/2, pod)
+		if tc.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: unexpected error: want %q, got %v", id, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+		if ingress := svc.Status.LoadBalancer.Ingress; len(ingress) != 1 || ingress[0].IP != "10.0.0.1" {
+			t.Errorf("%s: unexpected LoadBalancer ingress: %v", id, ingress)
+		}
+		if !w.IsStopped() {
+			t.Errorf("%s: watch is not stopped", id)
+		}
+	}
+}
+
+func TestCreateServiceWithResult(t *testing.T) {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "foo"}}}
+	defer func(interval time.Duration) { ServicePollInterval = interval }(ServicePollInterval)
+	ServicePollInterval = 10 * time.Millisecond
+
+	testCases := map[string]struct {
+		serviceType      v1.ServiceType
+		ingress          []v1.LoadBalancerIngress
+		expectedIP       string
+		expectedHostname string
+	}{
+		"LoadBalancer with IP": {
+			serviceType: v1.ServiceTypeLoadBalancer,
+			ingress:     []v1.LoadBalancerIngress{{IP: "10.0.0.1"}},
+			expectedIP:  "10.0.0.1",
+		},
+		"LoadBalancer with hostname": {
+			serviceType:      v1.ServiceTypeLoadBalancer,
+			ingress:          []v1.LoadBalancerIngress{{Hostname: "foo.example.com"}},
+			expectedHostname: "foo.example.com",
+		},
+		"ClusterIP": {
+			serviceType: v1.ServiceTypeClusterIP,
+		},
+	}
+
+	for id, tc := range testCases {
+		clientset := fake.NewSimpleClientset()
+		w := watch.NewFake()
+		clientset.PrependWatchReactor("services", func(k8stesting.Action) (bool, watch.Interface, error) {
+			return true, w, nil
+		})
+
+		if len(tc.ingress) > 0 {
+			go func(ingress []v1.LoadBalancerIngress) {
+				time.Sleep(20 * time.Millisecond)
+				svc, err := clientset.CoreV1().Services("test-ns").Get("foo", metav1.GetOptions{})
+				if err != nil {
+					t.Errorf("%s: failed to get service: %v", id, err)
+					return
+				}
+				svc.Status.LoadBalancer.Ingress = ingress
+				if _, err := clientset.CoreV1().Services("test-ns").UpdateStatus(svc); err != nil {
+					t.Errorf("%s: failed to update service status: %v", id, err)
+				}
+			}(tc.ingress)
+		}
+
+		result, err := CreateServiceWithResult(clientset, "test-ns", "foo", []v1.ServicePort{{Port: 80}}, "",
+			tc.serviceType, time.Second, pod)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+		if result.Service == nil || result.Service.Name != "foo" {
+			t.Errorf("%s: unexpected service: %v", id, result.Service)
+		}
+		if result.ExternalIP != tc.expectedIP {
+			t.Errorf("%s: unexpected external IP: want %q, got %q", id, tc.expectedIP, result.ExternalIP)
+		}
+		if result.ExternalHostname != tc.expectedHostname {
+			t.Errorf("%s: unexpected external hostname: want %q, got %q", id, tc.expectedHostname, result.ExternalHostname)
+		}
+		if len(tc.ingress) > 0 && result.Waited <= 0 {
+			t.Errorf("%s: expected a positive wait time, got %v", id, result.Waited)
+		}
+		if len(tc.ingress) == 0 && result.Waited != 0 {
+			t.Errorf("%s: unexpected wait time: %v", id, result.Waited)
+		}
+	}
+}
+
+func TestDeleteTestNamespacesByPrefix(t *testing.T) {
+	var objects []runtime.Object
+	for _, name := range []string{"istio-ca-integration-abc", "istio-ca-integration-def", "default", "kube-system", "istio-system"} {
+		objects = append(objects, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+
+	testCases := map[string]struct {
+		prefix    string
+		deleteErr error
+		remaining []string
+		expectErr bool
+	}{
+		"Prefixed namespaces": {
+			prefix:    "istio-ca-integration-",
+			remaining: []string{"default", "istio-system", "kube-system"},
+		},
+		"No matches": {
+			prefix:    "no-such-prefix-",
+			remaining: []string{"default", "istio-ca-integration-abc", "istio-ca-integration-def", "istio-system", "kube-system"},
+		},
+		"Empty prefix": {
+			remaining: []string{"default", "istio-ca-integration-abc", "istio-ca-integration-def", "istio-system", "kube-system"},
+			expectErr: true,
+		},
+		"Delete errors": {
+			prefix:    "istio-ca-integration-",
+			deleteErr: errors.New("forbidden"),
+			remaining: []string{"default", "istio-ca-integration-abc", "istio-ca-integration-def", "istio-system", "kube-system"},
+			expectErr: true,
+		},
+	}
+
+	for id, tc := range testCases {
+		clientset := fake.NewSimpleClientset(objects...)
+		if tc.deleteErr != nil {
+			clientset.PrependReactor("delete", "namespaces", func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, tc.deleteErr
+			})
+		}
+
+		err := DeleteTestNamespacesByPrefix(clientset, tc.prefix)
+		if tc.expectErr != (err != nil) {
+			t.Errorf("%s: unexpected error: %v", id, err)
+		}
+		if merr, ok := err.(*multierror.Error); ok && len(merr.Errors) != 2 {
+			t.Errorf("%s: want 2 aggregated errors, got %v", id, merr)
+		}
+
+		list, err := clientset.CoreV1().Namespaces().List(metav1.ListOptions{})
+		if err != nil {
+			t.Fatalf("%s: failed to list namespaces: %v", id, err)
+		}
+		var remaining []string
+		for _, ns := range list.Items {
+			remaining = append(remaining, ns.GetName())
+		}
+		sort.Strings(remaining)
+		if !reflect.DeepEqual(remaining, tc.remaining) {
+			t.Errorf("%s: unexpected remaining namespaces: want %v, got %v", id, tc.remaining, remaining)
+		}
+	}
+}
+
+func TestCreateIstioCARole(t *testing.T) {
+	driftedRole := &rbac.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: "istio-ca-role", Namespace: "test-ns"},
+		Rules: []rbac.PolicyRule{
+			{
+				Verbs:     []string{"get"},
+				APIGroups: []string{""},
+				Resources: []string{"secrets"},
+			},
+		},
+	}
+	currentRole := &rbac.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: "istio-ca-role", Namespace: "test-ns"},
+		Rules:      istioCARoleRules,
+	}
+
+	testCases := map[string]struct {
+		existing      []runtime.Object
+		expectUpdated bool
+	}{
+		"Create new": {},
+		"Already exists": {
+			existing: []runtime.Object{currentRole},
+		},
+		"Reconcile drift": {
+			existing:      []runtime.Object{driftedRole},
+			expectUpdated: true,
+		},
+	}
+
+	for id, tc := range testCases {
+		clientset := fake.NewSimpleClientset(tc.existing...)
+		if err := CreateIstioCARole(clientset, "test-ns", ""); err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+
+		role, err := clientset.RbacV1beta1().Roles("test-ns").Get("istio-ca-role", metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("%s: failed to get role: %v", id, err)
+			continue
+		}
+		if !reflect.DeepEqual(role.Rules, istioCARoleRules) {
+			t.Errorf("%s: unexpected rules: want %v, got %v", id, istioCARoleRules, role.Rules)
+		}
+
+		updated := false
+		for _, action := range clientset.Actions() {
+			if action.GetVerb() == "update" && action.GetResource().Resource == "roles" {
+				updated = true
+			}
+		}
+		if updated != tc.expectUpdated {
+			t.Errorf("%s: unexpected role update: want %v, got %v", id, tc.expectUpdated, updated)
+		}
+	}
+}
+
+func TestCreateIstioCARoleScoped(t *testing.T) {
+	serviceAccount := func(name string) runtime.Object {
+		return &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test-ns"}}
+	}
+
+	testCases := map[string]struct {
+		existing      []runtime.Object
+		prefix        string
+		expectedRules []rbac.PolicyRule
+		expectedErr   string
+	}{
+		"No prefix": {
+			existing:      []runtime.Object{serviceAccount("default")},
+			expectedRules: istioCARoleRules,
+		},
+		"Prefix": {
+			existing: []runtime.Object{serviceAccount("foo"), serviceAccount("default")},
+			prefix:   "istio.",
+			expectedRules: []rbac.PolicyRule{
+				{
+					Verbs:     []string{"create", "watch", "list"},
+					APIGroups: []string{"core", ""},
+					Resources: []string{"secrets"},
+				},
+				{
+					Verbs:         []string{"get", "update"},
+					APIGroups:     []string{"core", ""},
+					Resources:     []string{"secrets"},
+					ResourceNames: []string{"istio.default", "istio.foo"},
+				},
+				{
+					Verbs:     []string{"get", "watch", "list"},
+					APIGroups: []string{"core", ""},
+					Resources: []string{"serviceaccounts"},
+				},
+			},
+		},
+		"Prefix without service accounts": {
+			prefix:      "istio.",
+			expectedErr: "no service accounts in namespace test-ns",
+		},
+	}
+
+	for id, tc := range testCases {
+		clientset := fake.NewSimpleClientset(tc.existing...)
+		err := CreateIstioCARole(clientset, "test-ns", tc.prefix)
+		if tc.expectedErr != "" {
+			if err == nil {
+				t.Errorf("%s: Succeeded. Error expected: %v", id, tc.expectedErr)
+			} else if !strings.HasPrefix(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: incorrect error message: %s VS %s", id, err.Error(), tc.expectedErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+
+		role, err := clientset.RbacV1beta1().Roles("test-ns").Get("istio-ca-role", metav1.GetOptions{})
+		if err != nil {
+			t.Errorf("%s: failed to get role: %v", id, err)
+			continue
+		}
+		if !reflect.DeepEqual(role.Rules, tc.expectedRules) {
+			t.Errorf("%s: unexpected rules: want %v, got %v", id, tc.expectedRules, role.Rules)
+		}
+	}
+}
+
+func TestCreateIstioCARoleBinding(t *testing.T) {
+	existing := &rbac.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "istio-ca-role-binding", Namespace: "test-ns"},
+	}
+
+	testCases := map[string]struct {
+		existing []runtime.Object
+	}{
+		"Create new": {},
+		"Already exists": {
+			existing: []runtime.Object{existing},
+		},
+	}
+
+	for id, tc := range testCases {
+		clientset := fake.NewSimpleClientset(tc.existing...)
+		if err := CreateIstioCARoleBinding(clientset, "test-ns"); err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+		if _, err := clientset.RbacV1beta1().RoleBindings("test-ns").Get("istio-ca-role-binding", metav1.GetOptions{}); err != nil {
+			t.Errorf("%s: failed to get role binding: %v", id, err)
+		}
+	}
+}
+
+// podWatchReactor makes the pod watch report a running pod after delay, or never if running is false.
+func podWatchReactor(running bool, delay time.Duration) k8stesting.WatchReactionFunc {
+	return func(k8stesting.Action) (bool, watch.Interface, error) {
+		w := watch.NewFake()
+		if !running {
+			return true, w, nil
+		}
+		go func() {
+			time.Sleep(delay)
+			w.Modify(&v1.Pod{Status: v1.PodStatus{Phase: v1.PodRunning}})
+		}()
+		return true, w, nil
+	}
+}
+
+func TestCreatePodWithOptions(t *testing.T) {
+	testCases := map[string]struct {
+		opts           PodOptions
+		notRunning     bool
+		runningDelay   time.Duration
+		expectedLabels map[string]string
+		expectErr      bool
+	}{
+		"Custom labels": {
+			opts: PodOptions{
+				Labels: map[string]string{"app": "foo", "uuid": "ignored"},
+			},
+			expectedLabels: map[string]string{"app": "foo", "pod-group": "foo-pod-group"},
+		},
+		"Scheduling constraints": {
+			opts: PodOptions{
+				NodeSelector: map[string]string{"disktype": "ssd"},
+				Tolerations:  []v1.Toleration{{Key: "dedicated", Operator: v1.TolerationOpExists}},
+			},
+			expectedLabels: map[string]string{"pod-group": "foo-pod-group"},
+		},
+		"Longer timeout": {
+			opts:           PodOptions{Timeout: time.Second},
+			runningDelay:   200 * time.Millisecond,
+			expectedLabels: map[string]string{"pod-group": "foo-pod-group"},
+		},
+		"Timeout": {
+			opts:       PodOptions{Timeout: 50 * time.Millisecond},
+			notRunning: true,
+			expectErr:  true,
+		},
+	}
+
+	for id, tc := range testCases {
+		clientset := fake.NewSimpleClientset()
+		clientset.PrependWatchReactor("pods", podWatchReactor(!tc.notRunning, tc.runningDelay))
+
+		pod, err := CreatePodWithOptions(clientset, "test-ns", "image", "foo", tc.opts)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+
+		if pod.Labels["uuid"] == "" || pod.Labels["uuid"] == "ignored" {
+			t.Errorf("%s: unexpected uuid label %q", id, pod.Labels["uuid"])
+		}
+		delete(pod.Labels, "uuid")
+		if !reflect.DeepEqual(pod.Labels, tc.expectedLabels) {
+			t.Errorf("%s: unexpected labels: want %v, got %v", id, tc.expectedLabels, pod.Labels)
+		}
+		if !reflect.DeepEqual(pod.Spec.NodeSelector, tc.opts.NodeSelector) {
+			t.Errorf("%s: unexpected node selector: want %v, got %v", id, tc.opts.NodeSelector, pod.Spec.NodeSelector)
+		}
+		if !reflect.DeepEqual(pod.Spec.Tolerations, tc.opts.Tolerations) {
+			t.Errorf("%s: unexpected tolerations: want %v, got %v", id, tc.opts.Tolerations, pod.Spec.Tolerations)
+		}
+	}
+}
+
+func TestCreatePodWithContainers(t *testing.T) {
+	containers := []v1.Container{
+		{Name: "workload", Image: "app", Command: []string{"/bin/app"}},
+		{Name: "helper", Image: "node-agent", Args: []string{"--env", "onprem"}},
+	}
+	testCases := map[string]struct {
+		containers []v1.Container
+		expectErr  bool
+	}{
+		"Two containers": {
+			containers: containers,
+		},
+		"No container": {
+			expectErr: true,
+		},
+	}
+
+	for id, tc := range testCases {
+		clientset := fake.NewSimpleClientset()
+		clientset.PrependWatchReactor("pods", podWatchReactor(true, 0))
+
+		pod, err := CreatePodWithContainers(clientset, "test-ns", "foo", tc.containers,
+			PodOptions{Labels: map[string]string{"app": "foo"}})
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(pod.Spec.Containers, tc.containers) {
+			t.Errorf("%s: unexpected containers: want %v, got %v", id, tc.containers, pod.Spec.Containers)
+		}
+		if pod.Labels["uuid"] == "" {
+			t.Errorf("%s: missing uuid label", id)
+		}
+		delete(pod.Labels, "uuid")
+		expectedLabels := map[string]string{"app": "foo", "pod-group": "foo-pod-group"}
+		if !reflect.DeepEqual(pod.Labels, expectedLabels) {
+			t.Errorf("%s: unexpected labels: want %v, got %v", id, expectedLabels, pod.Labels)
+		}
+	}
+}
+
+func TestPortForward(t *testing.T) {
+	// The API server refuses to upgrade the connection to SPDY.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	pod := func(phase v1.PodPhase) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "test-ns"},
+			Status:     v1.PodStatus{Phase: phase},
+		}
+	}
+	testCases := map[string]struct {
+		pods        []runtime.Object
+		expectedErr string
+	}{
+		"Missing pod": {
+			expectedErr: "failed to get pod test-ns/foo",
+		},
+		"Pending pod": {
+			pods:        []runtime.Object{pod(v1.PodPending)},
+			expectedErr: "pod test-ns/foo is in Pending phase, not running",
+		},
+		"Upgrade refused": {
+			pods:        []runtime.Object{pod(v1.PodRunning)},
+			expectedErr: "failed to forward port 15000 to test-ns/foo:15000",
+		},
+	}
+
+	for id, tc := range testCases {
+		clientset := fake.NewSimpleClientset(tc.pods...)
+		stop, err := PortForward(clientset, &rest.Config{Host: server.URL}, "test-ns", "foo", 15000, 15000)
+		if err == nil {
+			stop()
+			t.Errorf("%s: Succeeded. Error expected", id)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), tc.expectedErr) {
+			t.Errorf("%s: unexpected error: want %q, got %q", id, tc.expectedErr, err.Error())
+		}
+	}
+}
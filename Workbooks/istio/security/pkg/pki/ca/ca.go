@@ -18,9 +18,12 @@ import (
 	"crypto"
 	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 	// TODO(nmittler): Remove this
 	_ "github.com/golang/glog"
@@ -43,6 +46,11 @@ const (
 	// cASecret stores the key/cert of self-signed CA for persistency purpose.
 	cASecret = "istio-ca-secret"
 
+	// rootCertID is the root certificate file in a signing secret.
+	rootCertID = "root-cert.pem"
+	// certChainID is the optional certificate chain file in a signing secret.
+	certChainID = "cert-chain.pem"
+
 	// The size of a private key for a self-signed Istio CA.
 	caKeySize = 2048
 )
@@ -50,6 +58,9 @@ const (
 // CertificateAuthority contains methods to be supported by a CA.
 type CertificateAuthority interface {
 	Sign(csrPEM []byte, ttl time.Duration) ([]byte, error)
+	// SignWithIDs is like Sign, but the issued certificate carries the given
+	// identities in its SAN extension instead of those requested in the CSR.
+	SignWithIDs(csrPEM []byte, ttl time.Duration, ids []string) ([]byte, error)
 	GetRootCertificate() []byte
 }
 
@@ -65,8 +76,11 @@ type IstioCAOptions struct {
 
 // IstioCA generates keys and certificates for Istio identities.
 type IstioCA struct {
-	certTTL     time.Duration
-	maxCertTTL  time.Duration
+	certTTL    time.Duration
+	maxCertTTL time.Duration
+
+	// mutex guards the signing material, which is replaced when a self-signed root is rotated.
+	mutex       sync.RWMutex
 	signingCert *x509.Certificate
 	signingKey  crypto.PrivateKey
 
@@ -125,11 +139,61 @@ func NewSelfSignedIstioCA(caCertTTL, certTTL, maxCertTTL time.Duration, org stri
 		opts.SigningCertBytes = caSecret.Data[cACertID]
 		opts.SigningKeyBytes = caSecret.Data[cAPrivateKeyID]
 		opts.RootCertBytes = caSecret.Data[cACertID]
+		// A rotated root is stored along with the previous root, which is
+		// still trusted until it expires.
+		if roots := caSecret.Data[rootCertID]; len(roots) > 0 {
+			opts.RootCertBytes = roots
+		}
 	}
 
 	return NewIstioCA(opts)
 }
 
+// LoadSigningSecret reads the signing cert/key, root cert and optional cert chain
+// of a CA from the named secret into opts. The secret uses the same file names
+// as the mounted "cacerts" secret: ca-cert.pem, ca-key.pem, root-cert.pem and
+// cert-chain.pem.
+func LoadSigningSecret(core corev1.SecretsGetter, namespace string, name string, opts *IstioCAOptions) error {
+	secret, err := core.Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get signing secret %s/%s (error: %v)", namespace, name, err)
+	}
+
+	for _, key := range []string{cACertID, cAPrivateKeyID, rootCertID} {
+		if len(secret.Data[key]) == 0 {
+			return fmt.Errorf("signing secret %s/%s has no %s", namespace, name, key)
+		}
+	}
+	opts.SigningCertBytes = secret.Data[cACertID]
+	opts.SigningKeyBytes = secret.Data[cAPrivateKeyID]
+	opts.RootCertBytes = secret.Data[rootCertID]
+	opts.CertChainBytes = secret.Data[certChainID]
+	return nil
+}
+
+// ReadRootCert returns the root certificate of the CA stored in the named secret
+// without modifying the secret: the root-cert.pem bundle if present, the
+// ca-cert.pem of a self-signed CA otherwise.
+func ReadRootCert(core corev1.SecretsGetter, namespace string, name string) ([]byte, error) {
+	secret, err := core.Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get CA secret %s/%s (error: %v)", namespace, name, err)
+	}
+	if roots := secret.Data[rootCertID]; len(roots) > 0 {
+		return roots, nil
+	}
+	if cert := secret.Data[cACertID]; len(cert) > 0 {
+		return cert, nil
+	}
+	return nil, fmt.Errorf("CA secret %s/%s has neither %s nor %s", namespace, name, rootCertID, cACertID)
+}
+
+// ReadSelfSignedRootCert returns the root certificate of the self-signed CA
+// in the namespace. Unlike NewSelfSignedIstioCA, it never creates the secret.
+func ReadSelfSignedRootCert(core corev1.SecretsGetter, namespace string) ([]byte, error) {
+	return ReadRootCert(core, namespace, cASecret)
+}
+
 // NewIstioCA returns a new IstioCA instance.
 func NewIstioCA(opts *IstioCAOptions) (*IstioCA, error) {
 	ca := &IstioCA{
@@ -160,12 +224,28 @@ func NewIstioCA(opts *IstioCAOptions) (*IstioCA, error) {
 
 // GetRootCertificate returns the PEM-encoded root certificate.
 func (ca *IstioCA) GetRootCertificate() []byte {
+	ca.mutex.RLock()
+	defer ca.mutex.RUnlock()
 	return copyBytes(ca.rootCertBytes)
 }
 
 // Sign takes a PEM-encoded certificate signing request and returns a signed
 // certificate.
 func (ca *IstioCA) Sign(csrPEM []byte, ttl time.Duration) ([]byte, error) {
+	return ca.sign(csrPEM, ttl, nil)
+}
+
+// SignWithIDs takes a PEM-encoded certificate signing request and returns a
+// signed certificate whose SAN extension holds ids, replacing the SAN
+// extension of the request.
+func (ca *IstioCA) SignWithIDs(csrPEM []byte, ttl time.Duration, ids []string) ([]byte, error) {
+	if len(ids) == 0 {
+		return nil, errors.New("no identities to sign the certificate for")
+	}
+	return ca.sign(csrPEM, ttl, ids)
+}
+
+func (ca *IstioCA) sign(csrPEM []byte, ttl time.Duration, ids []string) ([]byte, error) {
 	csr, err := pki.ParsePemEncodedCSR(csrPEM)
 	if err != nil {
 		return nil, err
@@ -178,8 +258,15 @@ func (ca *IstioCA) Sign(csrPEM []byte, ttl time.Duration) ([]byte, error) {
 	}
 
 	tmpl := ca.generateCertificateTemplate(csr, ttl)
+	if ids != nil {
+		tmpl.ExtraExtensions = replaceSANExtension(tmpl.ExtraExtensions, ids)
+	}
 
-	bytes, err := x509.CreateCertificate(rand.Reader, tmpl, ca.signingCert, csr.PublicKey, ca.signingKey)
+	ca.mutex.RLock()
+	signingCert, signingKey, certChainBytes := ca.signingCert, ca.signingKey, ca.certChainBytes
+	ca.mutex.RUnlock()
+
+	bytes, err := x509.CreateCertificate(rand.Reader, tmpl, signingCert, csr.PublicKey, signingKey)
 	if err != nil {
 		return nil, err
 	}
@@ -191,7 +278,7 @@ func (ca *IstioCA) Sign(csrPEM []byte, ttl time.Duration) ([]byte, error) {
 	cert := pem.EncodeToMemory(block)
 
 	// Also append intermediate certs into the chain.
-	chain := append(cert, ca.certChainBytes...)
+	chain := append(cert, certChainBytes...)
 
 	return chain, nil
 }
@@ -218,6 +305,18 @@ func (ca *IstioCA) generateCertificateTemplate(request *x509.CertificateRequest,
 	}
 }
 
+// replaceSANExtension returns exts with its SAN extension, if any, replaced
+// by one holding ids.
+func replaceSANExtension(exts []pkix.Extension, ids []string) []pkix.Extension {
+	replaced := []pkix.Extension{*buildSubjectAltNameExtension(strings.Join(ids, ","))}
+	for _, ext := range exts {
+		if pki.ExtractSANExtension([]pkix.Extension{ext}) == nil {
+			replaced = append(replaced, ext)
+		}
+	}
+	return replaced
+}
+
 // verify that the cert chain, root cert and signing key/cert match.
 func (ca *IstioCA) verify() error {
 	// Create another CertPool to hold the root.
@@ -237,6 +336,44 @@ func (ca *IstioCA) verify() error {
 		return errors.New(
 			"invalid parameters: cannot verify the signing cert with the provided root chain and cert pool")
 	}
+
+	if len(ca.certChainBytes) > 0 {
+		return ca.verifyCertChain()
+	}
+	return nil
+}
+
+// verifyCertChain checks that the cert chain, which is appended to issued
+// certs, leads from the signing cert towards the root, so that clients can
+// verify issued certs with it.
+func (ca *IstioCA) verifyCertChain() error {
+	var certs []*x509.Certificate
+	for rest := ca.certChainBytes; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("invalid parameters: cannot parse cert %d in the cert chain (%v)", len(certs), err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return errors.New("invalid parameters: the cert chain contains no certs")
+	}
+
+	if !certs[0].Equal(ca.signingCert) {
+		return errors.New("invalid parameters: the cert chain does not start with the signing cert")
+	}
+	// Together with the verification of the signing cert against the cert
+	// chain and root, this ensures the chain itself leads to the root.
+	for i := 1; i < len(certs); i++ {
+		if err := certs[i-1].CheckSignatureFrom(certs[i]); err != nil {
+			return fmt.Errorf("invalid parameters: cert %d in the cert chain is not signed by cert %d (%v)", i-1, i, err)
+		}
+	}
 	return nil
 }
 
@@ -20,6 +20,7 @@ import (
 	"encoding/asn1"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -395,6 +396,282 @@ func TestSignCSRTTLError(t *testing.T) {
 	}
 }
 
+func TestSignCSRWithIDs(t *testing.T) {
+	opts := CertOptions{
+		Host:       "spiffe://cluster.local/ns/foo/sa/bar",
+		Org:        "istio.io",
+		RSAKeySize: 2048,
+	}
+	csrPEM, keyPEM, err := GenCSR(opts)
+	if err != nil {
+		t.Error(err)
+	}
+
+	ca, err := createCA()
+	if err != nil {
+		t.Error(err)
+	}
+
+	id := "spiffe://example.com/ns/foo/sa/bar"
+	certPEM, err := ca.SignWithIDs(csrPEM, 30*time.Minute, []string{id})
+	if err != nil {
+		t.Error(err)
+	}
+
+	fields := &testutil.VerifyFields{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	if err = testutil.VerifyCertificate(keyPEM, certPEM, ca.GetRootCertificate(), id, fields); err != nil {
+		t.Error(err)
+	}
+
+	cert, err := pki.ParsePemEncodedCertificate(certPEM)
+	if err != nil {
+		t.Error(err)
+	}
+	ids, err := pki.ExtractIDs(cert.Extensions)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(ids, []string{id}) {
+		t.Errorf("Unexpected identities: wanted %v but got %v", []string{id}, ids)
+	}
+
+	if _, err = ca.SignWithIDs(csrPEM, 30*time.Minute, nil); err == nil {
+		t.Error("Succeeded. Error expected")
+	}
+}
+
+func TestLoadSigningSecret(t *testing.T) {
+	now := time.Now()
+	rootCert, rootKey := GenCert(CertOptions{
+		IsCA:         true,
+		IsSelfSigned: true,
+		NotBefore:    now,
+		NotAfter:     now.Add(time.Hour),
+		Org:          "Root CA",
+		RSAKeySize:   2048,
+	})
+	namespace := "istio-system"
+
+	testCases := map[string]struct {
+		data        map[string][]byte
+		secretName  string
+		expectedErr string
+	}{
+		"Complete secret": {
+			data: map[string][]byte{
+				cACertID:       rootCert,
+				cAPrivateKeyID: rootKey,
+				rootCertID:     rootCert,
+				certChainID:    rootCert,
+			},
+			secretName: "cacerts",
+		},
+		"No cert chain": {
+			data: map[string][]byte{
+				cACertID:       rootCert,
+				cAPrivateKeyID: rootKey,
+				rootCertID:     rootCert,
+			},
+			secretName: "cacerts",
+		},
+		"Missing signing key": {
+			data: map[string][]byte{
+				cACertID:   rootCert,
+				rootCertID: rootCert,
+			},
+			secretName:  "cacerts",
+			expectedErr: "signing secret istio-system/cacerts has no ca-key.pem",
+		},
+		"Missing secret": {
+			secretName: "no-such-secret",
+			expectedErr: "failed to get signing secret istio-system/no-such-secret " +
+				"(error: secrets \"no-such-secret\" not found)",
+		},
+	}
+
+	for id, tc := range testCases {
+		client := fake.NewSimpleClientset(&v1.Secret{
+			Data: tc.data,
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cacerts",
+				Namespace: namespace,
+			},
+		})
+		opts := &IstioCAOptions{CertTTL: time.Hour, MaxCertTTL: time.Hour}
+		err := LoadSigningSecret(client.CoreV1(), namespace, tc.secretName, opts)
+		if len(tc.expectedErr) > 0 {
+			if err == nil || err.Error() != tc.expectedErr {
+				t.Errorf("%s: unexpected error: want %q, got %v", id, tc.expectedErr, err)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+
+		if !bytes.Equal(opts.SigningCertBytes, tc.data[cACertID]) ||
+			!bytes.Equal(opts.SigningKeyBytes, tc.data[cAPrivateKeyID]) ||
+			!bytes.Equal(opts.RootCertBytes, tc.data[rootCertID]) ||
+			!bytes.Equal(opts.CertChainBytes, tc.data[certChainID]) {
+			t.Errorf("%s: the options do not match the secret data", id)
+		}
+		if _, err := NewIstioCA(opts); err != nil {
+			t.Errorf("%s: failed to create a CA from the secret: %v", id, err)
+		}
+	}
+}
+
+func TestReadSelfSignedRootCert(t *testing.T) {
+	namespace := "istio-system"
+	caCert := []byte("ca-cert")
+	roots := []byte("new-root,previous-root")
+
+	testCases := map[string]struct {
+		data        map[string][]byte
+		noSecret    bool
+		expected    []byte
+		expectedErr string
+	}{
+		"Root bundle": {
+			data:     map[string][]byte{cACertID: caCert, rootCertID: roots},
+			expected: roots,
+		},
+		"CA cert only": {
+			data:     map[string][]byte{cACertID: caCert},
+			expected: caCert,
+		},
+		"No root": {
+			data:        map[string][]byte{cAPrivateKeyID: []byte("key")},
+			expectedErr: "CA secret istio-system/istio-ca-secret has neither root-cert.pem nor ca-cert.pem",
+		},
+		"Missing secret": {
+			noSecret: true,
+			expectedErr: "failed to get CA secret istio-system/istio-ca-secret " +
+				"(error: secrets \"istio-ca-secret\" not found)",
+		},
+	}
+
+	for id, tc := range testCases {
+		client := fake.NewSimpleClientset()
+		if !tc.noSecret {
+			client = fake.NewSimpleClientset(&v1.Secret{
+				Data:       tc.data,
+				ObjectMeta: metav1.ObjectMeta{Name: cASecret, Namespace: namespace},
+			})
+		}
+		root, err := ReadSelfSignedRootCert(client.CoreV1(), namespace)
+		if len(tc.expectedErr) > 0 {
+			if err == nil || err.Error() != tc.expectedErr {
+				t.Errorf("%s: unexpected error: want %q, got %v", id, tc.expectedErr, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+		} else if !bytes.Equal(root, tc.expected) {
+			t.Errorf("%s: unexpected root cert: want %q, got %q", id, tc.expected, root)
+		}
+
+		// Reading the root never writes the secret.
+		for _, action := range client.Actions() {
+			if action.GetVerb() != "get" {
+				t.Errorf("%s: unexpected %s action on the secret", id, action.GetVerb())
+			}
+		}
+	}
+}
+
+func TestCertChainValidation(t *testing.T) {
+	start := time.Now().Add(-5 * time.Minute)
+	end := start.Add(24 * time.Hour)
+
+	genCA := func(org string, signerCert *x509.Certificate, signerKey interface{}) ([]byte, []byte) {
+		return GenCert(CertOptions{
+			IsCA:         true,
+			IsSelfSigned: signerCert == nil,
+			NotAfter:     end,
+			NotBefore:    start,
+			Org:          org,
+			RSAKeySize:   2048,
+			SignerCert:   signerCert,
+			SignerPriv:   signerKey,
+		})
+	}
+	parse := func(certPEM, keyPEM []byte) (*x509.Certificate, interface{}) {
+		cert, err := pki.ParsePemEncodedCertificate(certPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := pki.ParsePemEncodedKey(keyPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, key
+	}
+
+	rootCertPEM, rootKeyPEM := genCA("Root CA", nil, nil)
+	rootCert, rootKey := parse(rootCertPEM, rootKeyPEM)
+	intermediatePEM, intermediateKeyPEM := genCA("Intermediate CA", rootCert, rootKey)
+	intermediateCert, intermediateKey := parse(intermediatePEM, intermediateKeyPEM)
+	signingPEM, signingKeyPEM := genCA("Signing CA", intermediateCert, intermediateKey)
+
+	otherRootPEM, otherRootKeyPEM := genCA("Other Root CA", nil, nil)
+	otherRootCert, otherRootKey := parse(otherRootPEM, otherRootKeyPEM)
+	otherIntermediatePEM, _ := genCA("Other Intermediate CA", otherRootCert, otherRootKey)
+
+	concat := func(pems ...[]byte) []byte {
+		return bytes.Join(pems, nil)
+	}
+
+	testCases := map[string]struct {
+		certChain   []byte
+		expectedErr string
+	}{
+		"No chain": {
+			certChain: nil,
+			// Without a chain, the signing cert cannot be verified against the root.
+			expectedErr: "invalid parameters: cannot verify the signing cert with the provided root chain and cert pool",
+		},
+		"Valid chain": {
+			certChain: concat(signingPEM, intermediatePEM),
+		},
+		"Valid chain with root": {
+			certChain: concat(signingPEM, intermediatePEM, rootCertPEM),
+		},
+		"Chain without signing cert": {
+			certChain:   intermediatePEM,
+			expectedErr: "invalid parameters: the cert chain does not start with the signing cert",
+		},
+		"Mismatched intermediate": {
+			certChain:   concat(signingPEM, otherIntermediatePEM, intermediatePEM),
+			expectedErr: "invalid parameters: cert 0 in the cert chain is not signed by cert 1",
+		},
+		"Truncated chain": {
+			certChain:   signingPEM,
+			expectedErr: "invalid parameters: cannot verify the signing cert with the provided root chain and cert pool",
+		},
+	}
+
+	for id, tc := range testCases {
+		_, err := NewIstioCA(&IstioCAOptions{
+			CertChainBytes:   tc.certChain,
+			CertTTL:          time.Hour,
+			MaxCertTTL:       time.Hour,
+			SigningCertBytes: signingPEM,
+			SigningKeyBytes:  signingKeyPEM,
+			RootCertBytes:    rootCertPEM,
+		})
+		if len(tc.expectedErr) > 0 {
+			if err == nil || !strings.HasPrefix(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: unexpected error: want %q, got %v", id, tc.expectedErr, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+		}
+	}
+}
+
 func createCA() (CertificateAuthority, error) {
 	start := time.Now().Add(-5 * time.Minute)
 	end := start.Add(24 * time.Hour)
@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"fmt"
 	"reflect"
+	"strings"
 	"time"
 	// TODO(nmittler): Remove this
 	_ "github.com/golang/glog"
@@ -62,6 +63,9 @@ type SecretController struct {
 	certTTL time.Duration
 	core    corev1.CoreV1Interface
 
+	// Cert TTLs of specific service accounts, keyed by "namespace/name".
+	certTTLOverrides map[string]time.Duration
+
 	// Controller and store for service account objects.
 	saController cache.Controller
 	saStore      cache.Store
@@ -72,13 +76,16 @@ type SecretController struct {
 }
 
 // NewSecretController returns a pointer to a newly constructed SecretController instance.
-func NewSecretController(ca ca.CertificateAuthority, certTTL time.Duration, core corev1.CoreV1Interface,
-	namespace string) *SecretController {
+// Certificates are issued with certTTL, unless certTTLOverrides has a TTL for the service
+// account keyed by "namespace/name".
+func NewSecretController(ca ca.CertificateAuthority, certTTL time.Duration, certTTLOverrides map[string]time.Duration,
+	core corev1.CoreV1Interface, namespace string) *SecretController {
 
 	c := &SecretController{
-		ca:      ca,
-		certTTL: certTTL,
-		core:    core,
+		ca:               ca,
+		certTTL:          certTTL,
+		certTTLOverrides: certTTLOverrides,
+		core:             core,
 	}
 
 	saLW := &cache.ListWatch{
@@ -226,6 +233,43 @@ func (sc *SecretController) scrtDeleted(obj interface{}) {
 	sc.upsertSecret(saName, scrt.GetNamespace())
 }
 
+// certTTLFor returns the TTL of certificates issued to a service account.
+func (sc *SecretController) certTTLFor(saName string, saNamespace string) time.Duration {
+	if ttl, ok := sc.certTTLOverrides[saNamespace+"/"+saName]; ok {
+		return ttl
+	}
+	return sc.certTTL
+}
+
+// ParseCertTTLOverrides parses "namespace/name=duration" pairs into the cert TTL
+// overrides of NewSecretController. Every TTL must be positive and at most maxTTL.
+func ParseCertTTLOverrides(pairs []string, maxTTL time.Duration) (map[string]time.Duration, error) {
+	overrides := make(map[string]time.Duration, len(pairs))
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid cert TTL override %q: expected namespace/name=duration", pair)
+		}
+		sa := strings.Split(kv[0], "/")
+		if len(sa) != 2 || sa[0] == "" || sa[1] == "" {
+			return nil, fmt.Errorf("invalid service account %q in cert TTL override %q: expected namespace/name",
+				kv[0], pair)
+		}
+		ttl, err := time.ParseDuration(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration in cert TTL override %q: %v", pair, err)
+		}
+		if ttl <= 0 || ttl > maxTTL {
+			return nil, fmt.Errorf("cert TTL %v of %s must be positive and at most %v", ttl, kv[0], maxTTL)
+		}
+		if _, exists := overrides[kv[0]]; exists {
+			return nil, fmt.Errorf("duplicate cert TTL override for %s", kv[0])
+		}
+		overrides[kv[0]] = ttl
+	}
+	return overrides, nil
+}
+
 func (sc *SecretController) generateKeyAndCert(saName string, saNamespace string) ([]byte, []byte, error) {
 	id := fmt.Sprintf("%s://cluster.local/ns/%s/sa/%s", ca.URIScheme, saNamespace, saName)
 	options := ca.CertOptions{
@@ -238,7 +282,7 @@ func (sc *SecretController) generateKeyAndCert(saName string, saNamespace string
 		return nil, nil, err
 	}
 
-	certPEM, err := sc.ca.Sign(csrPEM, sc.certTTL)
+	certPEM, err := sc.ca.Sign(csrPEM, sc.certTTLFor(saName, saNamespace))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -16,6 +16,8 @@ package controller
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -28,12 +30,20 @@ import (
 	"istio.io/istio/security/pkg/pki/ca"
 )
 
-type fakeCa struct{}
+type fakeCa struct {
+	// The TTL of the last signed certificate.
+	ttl time.Duration
+}
 
-func (ca *fakeCa) Sign([]byte, time.Duration) ([]byte, error) {
+func (ca *fakeCa) Sign(_ []byte, ttl time.Duration) ([]byte, error) {
+	ca.ttl = ttl
 	return []byte("fake cert chain"), nil
 }
 
+func (ca *fakeCa) SignWithIDs(csrPEM []byte, ttl time.Duration, _ []string) ([]byte, error) {
+	return ca.Sign(csrPEM, ttl)
+}
+
 func (ca *fakeCa) GetRootCertificate() []byte {
 	return []byte("fake root cert")
 }
@@ -118,7 +128,7 @@ func TestSecretController(t *testing.T) {
 
 	for k, tc := range testCases {
 		client := fake.NewSimpleClientset()
-		controller := NewSecretController(&fakeCa{}, time.Hour, client.CoreV1(), metav1.NamespaceAll)
+		controller := NewSecretController(&fakeCa{}, time.Hour, nil, client.CoreV1(), metav1.NamespaceAll)
 
 		if tc.existingSecret != nil {
 			err := controller.scrtStore.Add(tc.existingSecret)
@@ -145,7 +155,7 @@ func TestSecretController(t *testing.T) {
 
 func TestRecoverFromDeletedIstioSecret(t *testing.T) {
 	client := fake.NewSimpleClientset()
-	controller := NewSecretController(&fakeCa{}, time.Hour, client.CoreV1(), metav1.NamespaceAll)
+	controller := NewSecretController(&fakeCa{}, time.Hour, nil, client.CoreV1(), metav1.NamespaceAll)
 	scrt := createSecret("test", "istio.test", "test-ns")
 	controller.scrtDeleted(scrt)
 
@@ -190,7 +200,7 @@ func TestUpdateSecret(t *testing.T) {
 
 	for k, tc := range testCases {
 		client := fake.NewSimpleClientset()
-		controller := NewSecretController(&fakeCa{}, time.Hour, client.CoreV1(), metav1.NamespaceAll)
+		controller := NewSecretController(&fakeCa{}, time.Hour, nil, client.CoreV1(), metav1.NamespaceAll)
 
 		scrt := createSecret("test", "istio.test", "test-ns")
 		if rc := tc.rootCert; rc != nil {
@@ -229,3 +239,102 @@ func checkActions(actual, expected []ktesting.Action) error {
 
 	return nil
 }
+
+func TestCertTTLOverrides(t *testing.T) {
+	overrides := map[string]time.Duration{"prod/payments": 15 * time.Minute}
+	testCases := map[string]struct {
+		saName      string
+		saNamespace string
+		expectedTTL time.Duration
+	}{
+		"Overridden identity": {
+			saName:      "payments",
+			saNamespace: "prod",
+			expectedTTL: 15 * time.Minute,
+		},
+		"Default identity": {
+			saName:      "reviews",
+			saNamespace: "prod",
+			expectedTTL: time.Hour,
+		},
+		"Same name in another namespace": {
+			saName:      "payments",
+			saNamespace: "staging",
+			expectedTTL: time.Hour,
+		},
+	}
+
+	for k, tc := range testCases {
+		ca := &fakeCa{}
+		controller := NewSecretController(ca, time.Hour, overrides, fake.NewSimpleClientset().CoreV1(),
+			metav1.NamespaceAll)
+		if _, _, err := controller.generateKeyAndCert(tc.saName, tc.saNamespace); err != nil {
+			t.Errorf("Case %q: unexpected error: %v", k, err)
+			continue
+		}
+		if ca.ttl != tc.expectedTTL {
+			t.Errorf("Case %q: certificate signed with TTL %v, expected %v", k, ca.ttl, tc.expectedTTL)
+		}
+	}
+}
+
+func TestParseCertTTLOverrides(t *testing.T) {
+	testCases := map[string]struct {
+		pairs       []string
+		expected    map[string]time.Duration
+		expectedErr string
+	}{
+		"No overrides": {
+			expected: map[string]time.Duration{},
+		},
+		"Overrides": {
+			pairs:    []string{"prod/payments=15m", "prod/ledger=24h"},
+			expected: map[string]time.Duration{"prod/payments": 15 * time.Minute, "prod/ledger": 24 * time.Hour},
+		},
+		"Override at max TTL": {
+			pairs:    []string{"prod/payments=48h"},
+			expected: map[string]time.Duration{"prod/payments": 48 * time.Hour},
+		},
+		"Override above max TTL": {
+			pairs:       []string{"prod/payments=49h"},
+			expectedErr: "cert TTL 49h0m0s of prod/payments must be positive and at most 48h0m0s",
+		},
+		"Zero TTL": {
+			pairs:       []string{"prod/payments=0s"},
+			expectedErr: "cert TTL 0s of prod/payments must be positive and at most 48h0m0s",
+		},
+		"Missing duration": {
+			pairs:       []string{"prod/payments"},
+			expectedErr: `invalid cert TTL override "prod/payments": expected namespace/name=duration`,
+		},
+		"Missing namespace": {
+			pairs:       []string{"payments=15m"},
+			expectedErr: `invalid service account "payments" in cert TTL override "payments=15m": expected namespace/name`,
+		},
+		"Invalid duration": {
+			pairs:       []string{"prod/payments=soon"},
+			expectedErr: `invalid duration in cert TTL override "prod/payments=soon"`,
+		},
+		"Duplicate": {
+			pairs:       []string{"prod/payments=15m", "prod/payments=30m"},
+			expectedErr: "duplicate cert TTL override for prod/payments",
+		},
+	}
+
+	for k, tc := range testCases {
+		overrides, err := ParseCertTTLOverrides(tc.pairs, 48*time.Hour)
+		if len(tc.expectedErr) > 0 {
+			if err == nil {
+				t.Errorf("Case %q: succeeded, expected error %q", k, tc.expectedErr)
+			} else if !strings.HasPrefix(err.Error(), tc.expectedErr) {
+				t.Errorf("Case %q: incorrect error message: %q VS %q", k, err.Error(), tc.expectedErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Case %q: unexpected error: %v", k, err)
+		} else if !reflect.DeepEqual(overrides, tc.expected) {
+			t.Errorf("Case %q: got %v, expected %v", k, overrides, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ca
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/istio/security/pkg/pki"
+)
+
+// RootRotatorOptions holds the configurations for rotating the root of a self-signed Istio CA.
+type RootRotatorOptions struct {
+	// CACertTTL is the TTL of the regenerated root certificate.
+	CACertTTL time.Duration
+	// RotationFraction is the fraction of the root certificate lifetime after which it is
+	// regenerated, in (0, 1).
+	RotationFraction float64
+	// CheckInterval is how often the root certificate is checked.
+	CheckInterval time.Duration
+	// Org is the issuer organization of the regenerated root certificate.
+	Org string
+	// Namespace is where the CA secret is stored.
+	Namespace string
+}
+
+// RootRotator regenerates the self-signed root of an IstioCA before it expires, and stores
+// the new key/cert in the secret read by NewSelfSignedIstioCA.
+type RootRotator struct {
+	ca   *IstioCA
+	opts RootRotatorOptions
+	core corev1.SecretsGetter
+}
+
+// NewRootRotator returns a RootRotator for the given self-signed IstioCA.
+func NewRootRotator(ca *IstioCA, opts RootRotatorOptions, core corev1.SecretsGetter) (*RootRotator, error) {
+	if opts.RotationFraction <= 0 || opts.RotationFraction >= 1 {
+		return nil, fmt.Errorf("rotation fraction %v is not in (0, 1)", opts.RotationFraction)
+	}
+	if opts.CheckInterval <= 0 {
+		return nil, fmt.Errorf("check interval %v is not positive", opts.CheckInterval)
+	}
+	return &RootRotator{
+		ca:   ca,
+		opts: opts,
+		core: core,
+	}, nil
+}
+
+// Run starts checking the root certificate periodically until stopCh is closed.
+func (r *RootRotator) Run(stopCh chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(r.opts.CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case now := <-ticker.C:
+				if !r.shouldRotate(now) {
+					continue
+				}
+				if err := r.rotate(now); err != nil {
+					log.Errorf("Failed to rotate the self-signed root certificate (error: %v)", err)
+				}
+			}
+		}
+	}()
+}
+
+// shouldRotate returns whether the root certificate has reached the rotation fraction of its lifetime.
+func (r *RootRotator) shouldRotate(now time.Time) bool {
+	r.ca.mutex.RLock()
+	cert := r.ca.signingCert
+	r.ca.mutex.RUnlock()
+
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return !now.Before(cert.NotBefore.Add(time.Duration(float64(lifetime) * r.opts.RotationFraction)))
+}
+
+// rotate regenerates the root certificate, persists it and starts signing with it.
+func (r *RootRotator) rotate(now time.Time) error {
+	pemCert, pemKey := GenCert(CertOptions{
+		NotBefore:    now,
+		NotAfter:     now.Add(r.opts.CACertTTL),
+		Org:          r.opts.Org,
+		IsCA:         true,
+		IsSelfSigned: true,
+		RSAKeySize:   caKeySize,
+	})
+	cert, err := pki.ParsePemEncodedCertificate(pemCert)
+	if err != nil {
+		return err
+	}
+	key, err := pki.ParsePemEncodedKey(pemKey)
+	if err != nil {
+		return err
+	}
+
+	r.ca.mutex.RLock()
+	prevRoots := r.ca.rootCertBytes
+	r.ca.mutex.RUnlock()
+	// Keep trusting the previous roots until they expire so that certificates
+	// they issued remain valid during the overlap.
+	unexpiredRoots, err := pruneExpiredCerts(prevRoots, now)
+	if err != nil {
+		return err
+	}
+	rootCertBytes := append(copyBytes(pemCert), unexpiredRoots...)
+
+	// The root certificates including the previous roots are stored so that
+	// the overlap survives a restart of the CA.
+	if err := WriteSigningSecret(r.core, r.opts.Namespace, cASecret, &IstioCAOptions{
+		SigningCertBytes: pemCert,
+		SigningKeyBytes:  pemKey,
+		RootCertBytes:    rootCertBytes,
+	}); err != nil {
+		return err
+	}
+
+	r.ca.mutex.Lock()
+	defer r.ca.mutex.Unlock()
+	r.ca.signingCert = cert
+	r.ca.signingKey = key
+	r.ca.rootCertBytes = rootCertBytes
+
+	log.Infof("Rotated the self-signed root certificate, which is valid until %v", cert.NotAfter)
+	return nil
+}
@@ -0,0 +1,232 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ca
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/istio/security/pkg/pki"
+)
+
+func TestNewRootRotatorOptions(t *testing.T) {
+	testCases := map[string]struct {
+		opts        RootRotatorOptions
+		expectedErr string
+	}{
+		"Valid": {
+			opts: RootRotatorOptions{RotationFraction: 0.8, CheckInterval: time.Minute},
+		},
+		"Zero fraction": {
+			opts:        RootRotatorOptions{CheckInterval: time.Minute},
+			expectedErr: "rotation fraction 0 is not in (0, 1)",
+		},
+		"Fraction of one": {
+			opts:        RootRotatorOptions{RotationFraction: 1, CheckInterval: time.Minute},
+			expectedErr: "rotation fraction 1 is not in (0, 1)",
+		},
+		"No check interval": {
+			opts:        RootRotatorOptions{RotationFraction: 0.5},
+			expectedErr: "check interval 0s is not positive",
+		},
+	}
+
+	for id, tc := range testCases {
+		_, err := NewRootRotator(&IstioCA{}, tc.opts, fake.NewSimpleClientset().CoreV1())
+		if len(tc.expectedErr) > 0 {
+			if err == nil || err.Error() != tc.expectedErr {
+				t.Errorf("%s: unexpected error: want %q, got %v", id, tc.expectedErr, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+		}
+	}
+}
+
+func TestRootRotation(t *testing.T) {
+	// An accelerated root TTL, rotated halfway through its lifetime.
+	caCertTTL := 4 * time.Second
+	org := "test.ca.org"
+	caNamespace := "default"
+	client := fake.NewSimpleClientset()
+
+	istioCA, err := NewSelfSignedIstioCA(caCertTTL, time.Second, time.Hour, org, caNamespace, client.CoreV1())
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+	oldRoot := istioCA.GetRootCertificate()
+	oldCert := signTestCert(t, istioCA)
+
+	rotator, err := NewRootRotator(istioCA, RootRotatorOptions{
+		CACertTTL:        caCertTTL,
+		RotationFraction: 0.5,
+		CheckInterval:    50 * time.Millisecond,
+		Org:              org,
+		Namespace:        caNamespace,
+	}, client.CoreV1())
+	if err != nil {
+		t.Fatalf("Failed to create the root rotator: %v", err)
+	}
+	if rotator.shouldRotate(time.Now()) {
+		t.Fatalf("The root certificate should not be rotated right after it is generated")
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	rotator.Run(stopCh)
+
+	deadline := time.Now().Add(caCertTTL)
+	for bytes.HasPrefix(istioCA.GetRootCertificate(), oldRoot) {
+		if time.Now().After(deadline) {
+			t.Fatalf("The root certificate is not rotated within %v", caCertTTL)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	newCert := signTestCert(t, istioCA)
+
+	// The served roots contain the new root followed by the previous root.
+	roots := istioCA.GetRootCertificate()
+	if !bytes.HasSuffix(roots, oldRoot) {
+		t.Errorf("The previous root certificate is no longer trusted")
+	}
+	newRoot := roots[:len(roots)-len(oldRoot)]
+
+	secret, err := client.CoreV1().Secrets(caNamespace).Get(cASecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get the CA secret: %v", err)
+	}
+	if !bytes.Equal(secret.Data[cACertID], newRoot) {
+		t.Errorf("The CA secret does not contain the rotated root certificate")
+	}
+	if !bytes.Equal(secret.Data[rootCertID], roots) {
+		t.Errorf("The CA secret does not contain the previous root certificate")
+	}
+
+	// A restarted CA keeps trusting the previous root.
+	restartedCA, err := NewSelfSignedIstioCA(caCertTTL, time.Second, time.Hour, org, caNamespace, client.CoreV1())
+	if err != nil {
+		t.Fatalf("Failed to restart the self-signed CA: %v", err)
+	}
+	if !bytes.Equal(restartedCA.GetRootCertificate(), roots) {
+		t.Errorf("The restarted CA does not serve the root certificates of the overlap")
+	}
+
+	if err := verifyCert(newCert, newRoot); err != nil {
+		t.Errorf("A certificate issued after rotation does not chain to the new root: %v", err)
+	}
+	if err := verifyCert(newCert, oldRoot); err == nil {
+		t.Errorf("A certificate issued after rotation chains to the previous root")
+	}
+	if err := verifyCert(oldCert, roots); err != nil {
+		t.Errorf("A certificate issued before rotation is not valid during the overlap: %v", err)
+	}
+}
+
+func TestRootRotationKeepsUnexpiredRoots(t *testing.T) {
+	caNamespace := "default"
+	client := fake.NewSimpleClientset()
+	istioCA, err := NewSelfSignedIstioCA(time.Hour, time.Second, time.Hour, "test.ca.org", caNamespace, client.CoreV1())
+	if err != nil {
+		t.Fatalf("Failed to create a self-signed CA: %v", err)
+	}
+	rotator, err := NewRootRotator(istioCA, RootRotatorOptions{
+		CACertTTL:        time.Hour,
+		RotationFraction: 0.25,
+		CheckInterval:    time.Minute,
+		Org:              "test.ca.org",
+		Namespace:        caNamespace,
+	}, client.CoreV1())
+	if err != nil {
+		t.Fatalf("Failed to create the root rotator: %v", err)
+	}
+
+	// With a rotation fraction below 0.5, more than one previous root is still valid.
+	now := time.Now()
+	firstRoot := istioCA.GetRootCertificate()
+	for i, rotation := range []time.Time{now.Add(15 * time.Minute), now.Add(30 * time.Minute)} {
+		if err := rotator.rotate(rotation); err != nil {
+			t.Fatalf("Rotation %d failed: %v", i, err)
+		}
+	}
+	roots := istioCA.GetRootCertificate()
+	if n := countCerts(roots); n != 3 {
+		t.Errorf("The CA trusts %d root certificates, want the new root and both previous roots", n)
+	}
+	if !bytes.HasSuffix(roots, firstRoot) {
+		t.Errorf("The first root certificate is no longer trusted")
+	}
+	secret, err := client.CoreV1().Secrets(caNamespace).Get(cASecret, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get the CA secret: %v", err)
+	}
+	if !bytes.Equal(secret.Data[rootCertID], roots) {
+		t.Errorf("The CA secret does not contain the trusted root certificates")
+	}
+
+	// Once all previous roots expired, only the new root is trusted.
+	if err := rotator.rotate(now.Add(2 * time.Hour)); err != nil {
+		t.Fatalf("Rotation after expiry failed: %v", err)
+	}
+	if n := countCerts(istioCA.GetRootCertificate()); n != 1 {
+		t.Errorf("The CA trusts %d root certificates after the previous roots expired, want 1", n)
+	}
+}
+
+func countCerts(certsPEM []byte) int {
+	n := 0
+	for rest := certsPEM; ; n++ {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			return n
+		}
+	}
+}
+
+func signTestCert(t *testing.T, ca CertificateAuthority) []byte {
+	csrPEM, _, err := GenCSR(CertOptions{
+		Host:       "spiffe://test.com/ns/foo/sa/bar",
+		Org:        "istio.io",
+		RSAKeySize: 2048,
+	})
+	if err != nil {
+		t.Fatalf("Failed to generate a CSR: %v", err)
+	}
+	cert, err := ca.Sign(csrPEM, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to sign a CSR: %v", err)
+	}
+	return cert
+}
+
+func verifyCert(certPEM, rootsPEM []byte) error {
+	cert, err := pki.ParsePemEncodedCertificate(certPEM)
+	if err != nil {
+		return err
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(rootsPEM)
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: cert.NotBefore,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"istio.io/istio/pkg/log"
+	"istio.io/istio/security/pkg/pki"
+)
+
+// GenSigningMaterial generates a new signing key/cert valid for ttl from now, and returns it in
+// IstioCAOptions along with the matching cert chain and root cert. With a root key, the signing
+// cert is issued by the first cert of rootCertBytes, which remains the root. Without, the signing
+// cert is a new self-signed root, trusted in addition to the unexpired certs of rootCertBytes so
+// that the certs issued with the previous signing key remain valid.
+func GenSigningMaterial(rootCertBytes, rootKeyBytes []byte, org string, now time.Time,
+	ttl time.Duration) (*IstioCAOptions, error) {
+	options := CertOptions{
+		NotBefore:  now,
+		NotAfter:   now.Add(ttl),
+		Org:        org,
+		IsCA:       true,
+		RSAKeySize: caKeySize,
+	}
+
+	if len(rootKeyBytes) == 0 {
+		// Each rotation adds a root, so the expired ones are dropped to bound the bundle.
+		roots, err := pruneExpiredCerts(rootCertBytes, now)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root cert (error: %v)", err)
+		}
+		options.IsSelfSigned = true
+		pemCert, pemKey := GenCert(options)
+		return &IstioCAOptions{
+			SigningCertBytes: pemCert,
+			SigningKeyBytes:  pemKey,
+			RootCertBytes:    append(copyBytes(pemCert), roots...),
+		}, nil
+	}
+
+	rootCert, err := pki.ParsePemEncodedCertificate(rootCertBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid root cert (error: %v)", err)
+	}
+	rootKey, err := pki.ParsePemEncodedKey(rootKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid root key (error: %v)", err)
+	}
+	if !now.Before(rootCert.NotAfter) {
+		return nil, fmt.Errorf("the root cert expired at %v", rootCert.NotAfter)
+	}
+	// A signing cert outliving its root would issue certs that cannot be verified.
+	if options.NotAfter.After(rootCert.NotAfter) {
+		log.Warnf("Capping the signing cert TTL to the expiry of the root cert at %v", rootCert.NotAfter)
+		options.NotAfter = rootCert.NotAfter
+	}
+	options.SignerCert = rootCert
+	options.SignerPriv = rootKey
+
+	pemCert, pemKey := GenCert(options)
+	return &IstioCAOptions{
+		SigningCertBytes: pemCert,
+		SigningKeyBytes:  pemKey,
+		CertChainBytes:   copyBytes(pemCert),
+		RootCertBytes:    copyBytes(rootCertBytes),
+	}, nil
+}
+
+// pruneExpiredCerts returns the PEM-encoded certs of certsPEM that have not expired at now.
+func pruneExpiredCerts(certsPEM []byte, now time.Time) ([]byte, error) {
+	var out []byte
+	for rest := certsPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if now.After(cert.NotAfter) {
+			log.Infof("Dropping the root cert %q, which expired at %v", cert.Subject.CommonName, cert.NotAfter)
+			continue
+		}
+		out = append(out, pem.EncodeToMemory(block)...)
+	}
+	return out, nil
+}
+
+// WriteSigningSecret stores the signing material of opts in the named secret, creating it if
+// needed, in the format read by LoadSigningSecret.
+func WriteSigningSecret(core corev1.SecretsGetter, namespace string, name string, opts *IstioCAOptions) error {
+	secrets := core.Secrets(namespace)
+	data := map[string][]byte{
+		cACertID:       opts.SigningCertBytes,
+		cAPrivateKeyID: opts.SigningKeyBytes,
+		rootCertID:     opts.RootCertBytes,
+	}
+	if len(opts.CertChainBytes) > 0 {
+		data[certChainID] = opts.CertChainBytes
+	}
+
+	secret, err := secrets.Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = secrets.Create(&apiv1.Secret{
+			Data: data,
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+		})
+	} else if err == nil {
+		secret.Data = data
+		_, err = secrets.Update(secret)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write signing secret %s/%s (error: %v)", namespace, name, err)
+	}
+	return nil
+}
+
+// UpdateSigningMaterial verifies the signing cert/key, cert chain and root cert of opts and starts
+// issuing certificates with them. The TTLs of opts are ignored.
+func (ca *IstioCA) UpdateSigningMaterial(opts *IstioCAOptions) error {
+	next, err := NewIstioCA(opts)
+	if err != nil {
+		return err
+	}
+
+	ca.mutex.Lock()
+	defer ca.mutex.Unlock()
+	ca.signingCert = next.signingCert
+	ca.signingKey = next.signingKey
+	ca.certChainBytes = next.certChainBytes
+	ca.rootCertBytes = next.rootCertBytes
+	return nil
+}
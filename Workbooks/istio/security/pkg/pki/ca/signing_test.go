@@ -0,0 +1,284 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ca
+
+import (
+	"bytes"
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+
+	"istio.io/istio/security/pkg/pki"
+)
+
+// signedBy returns whether the PEM encoded cert chain verifies against the PEM encoded roots.
+func signedBy(t *testing.T, chain, roots []byte) bool {
+	cert, err := pki.ParsePemEncodedCertificate(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootPool := x509.NewCertPool()
+	rootPool.AppendCertsFromPEM(roots)
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM(chain)
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         rootPool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err == nil
+}
+
+func TestGenSigningMaterial(t *testing.T) {
+	now := time.Now()
+	rootCert, rootKey := GenCert(CertOptions{
+		IsCA:         true,
+		IsSelfSigned: true,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		Org:          "Root CA",
+		RSAKeySize:   2048,
+	})
+	expiredRootCert, expiredRootKey := GenCert(CertOptions{
+		IsCA:         true,
+		IsSelfSigned: true,
+		NotBefore:    now.Add(-2 * time.Hour),
+		NotAfter:     now.Add(-time.Hour),
+		Org:          "Root CA",
+		RSAKeySize:   2048,
+	})
+
+	testCases := map[string]struct {
+		rootCert         []byte
+		rootKey          []byte
+		ttl              time.Duration
+		expectedNotAfter time.Time
+		expectedErr      string
+	}{
+		"Same root": {
+			rootCert:         rootCert,
+			rootKey:          rootKey,
+			ttl:              time.Hour,
+			expectedNotAfter: now.Add(time.Hour),
+		},
+		"Same root, TTL capped": {
+			rootCert: rootCert,
+			rootKey:  rootKey,
+			ttl:      48 * time.Hour,
+		},
+		"New root": {
+			rootCert:         rootCert,
+			ttl:              time.Hour,
+			expectedNotAfter: now.Add(time.Hour),
+		},
+		"Expired root": {
+			rootCert:    expiredRootCert,
+			rootKey:     expiredRootKey,
+			ttl:         time.Hour,
+			expectedErr: "the root cert expired at",
+		},
+		"Invalid root key": {
+			rootCert:    rootCert,
+			rootKey:     []byte("invalid"),
+			ttl:         time.Hour,
+			expectedErr: "invalid root key",
+		},
+	}
+
+	for id, tc := range testCases {
+		opts, err := GenSigningMaterial(tc.rootCert, tc.rootKey, "Istio CA", now, tc.ttl)
+		if len(tc.expectedErr) > 0 {
+			if err == nil {
+				t.Errorf("%s: Succeeded. Error expected", id)
+			} else if !strings.HasPrefix(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: incorrect error message: %s VS %s", id, err.Error(), tc.expectedErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+			continue
+		}
+
+		// The new signing material chains to the root.
+		opts.CertTTL, opts.MaxCertTTL = time.Hour, time.Hour
+		if _, err := NewIstioCA(opts); err != nil {
+			t.Errorf("%s: the signing material does not verify: %v", id, err)
+		}
+
+		signingCert, err := pki.ParsePemEncodedCertificate(opts.SigningCertBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedNotAfter := tc.expectedNotAfter
+		if expectedNotAfter.IsZero() {
+			expectedNotAfter = now.Add(24 * time.Hour)
+		}
+		if !signingCert.NotAfter.Equal(expectedNotAfter.UTC().Truncate(time.Second)) {
+			t.Errorf("%s: unexpected signing cert expiry: want %v, got %v", id, expectedNotAfter, signingCert.NotAfter)
+		}
+
+		// Certs issued by the previous signing key, here the root itself, remain valid.
+		if !signedBy(t, rootCert, opts.RootCertBytes) {
+			t.Errorf("%s: the previous root is no longer trusted", id)
+		}
+		if len(tc.rootKey) > 0 && !bytes.Equal(opts.RootCertBytes, tc.rootCert) {
+			t.Errorf("%s: the root cert changed", id)
+		}
+	}
+}
+
+func TestGenSigningMaterialPrunesExpiredRoots(t *testing.T) {
+	now := time.Now()
+	rootCert, _ := GenCert(CertOptions{
+		IsCA:         true,
+		IsSelfSigned: true,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		Org:          "Root CA",
+		RSAKeySize:   2048,
+	})
+	expiredRootCert, _ := GenCert(CertOptions{
+		IsCA:         true,
+		IsSelfSigned: true,
+		NotBefore:    now.Add(-2 * time.Hour),
+		NotAfter:     now.Add(-time.Hour),
+		Org:          "Root CA",
+		RSAKeySize:   2048,
+	})
+
+	roots := append(copyBytes(expiredRootCert), rootCert...)
+	opts, err := GenSigningMaterial(roots, nil, "Istio CA", now, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := append(copyBytes(opts.SigningCertBytes), rootCert...); !bytes.Equal(opts.RootCertBytes, want) {
+		t.Errorf("the root certs are not the new root followed by the unexpired previous root")
+	}
+}
+
+func TestWriteSigningSecret(t *testing.T) {
+	now := time.Now()
+	rootCert, rootKey := GenCert(CertOptions{
+		IsCA:         true,
+		IsSelfSigned: true,
+		NotBefore:    now,
+		NotAfter:     now.Add(time.Hour),
+		Org:          "Root CA",
+		RSAKeySize:   2048,
+	})
+	namespace := "istio-system"
+	client := fake.NewSimpleClientset()
+
+	// The first write creates the secret, the second one updates it.
+	for i := 0; i < 2; i++ {
+		material, err := GenSigningMaterial(rootCert, rootKey, "Istio CA", now, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteSigningSecret(client.CoreV1(), namespace, "cacerts", material); err != nil {
+			t.Fatalf("write %d: unexpected error: %v", i, err)
+		}
+
+		opts := &IstioCAOptions{CertTTL: time.Hour, MaxCertTTL: time.Hour}
+		if err := LoadSigningSecret(client.CoreV1(), namespace, "cacerts", opts); err != nil {
+			t.Fatalf("write %d: failed to load the signing secret: %v", i, err)
+		}
+		if !bytes.Equal(opts.SigningCertBytes, material.SigningCertBytes) ||
+			!bytes.Equal(opts.SigningKeyBytes, material.SigningKeyBytes) ||
+			!bytes.Equal(opts.RootCertBytes, material.RootCertBytes) ||
+			!bytes.Equal(opts.CertChainBytes, material.CertChainBytes) {
+			t.Errorf("write %d: the secret data does not match the signing material", i)
+		}
+	}
+}
+
+func TestUpdateSigningMaterial(t *testing.T) {
+	now := time.Now()
+	rootCert, rootKey := GenCert(CertOptions{
+		IsCA:         true,
+		IsSelfSigned: true,
+		NotBefore:    now,
+		NotAfter:     now.Add(time.Hour),
+		Org:          "Root CA",
+		RSAKeySize:   2048,
+	})
+	istioCA, err := NewIstioCA(&IstioCAOptions{
+		CertTTL:          time.Hour,
+		MaxCertTTL:       time.Hour,
+		SigningCertBytes: rootCert,
+		SigningKeyBytes:  rootKey,
+		RootCertBytes:    rootCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	material, err := GenSigningMaterial(rootCert, rootKey, "Istio CA", now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Material not chaining to its root is rejected and the CA keeps signing with its current key.
+	otherRootCert, _ := GenCert(CertOptions{
+		IsCA:         true,
+		IsSelfSigned: true,
+		NotBefore:    now,
+		NotAfter:     now.Add(time.Hour),
+		Org:          "Other Root CA",
+		RSAKeySize:   2048,
+	})
+	invalid := *material
+	invalid.RootCertBytes = otherRootCert
+	if err := istioCA.UpdateSigningMaterial(&invalid); err == nil {
+		t.Error("invalid signing material: Succeeded. Error expected")
+	}
+	if !istioCA.signingCert.Equal(mustParseCert(t, rootCert)) {
+		t.Error("invalid signing material: the signing cert changed")
+	}
+
+	if err := istioCA.UpdateSigningMaterial(material); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	csr, _, err := GenCSR(CertOptions{Host: "spiffe://cluster.local/ns/default/sa/foo", RSAKeySize: 2048})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := istioCA.Sign(csr, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to sign with the new signing material: %v", err)
+	}
+	issued, err := pki.ParsePemEncodedCertificate(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := issued.CheckSignatureFrom(mustParseCert(t, material.SigningCertBytes)); err != nil {
+		t.Errorf("the cert is not issued with the new signing key: %v", err)
+	}
+	if !signedBy(t, chain, istioCA.GetRootCertificate()) {
+		t.Error("the cert issued with the new signing key does not chain to the root")
+	}
+}
+
+func mustParseCert(t *testing.T, certPEM []byte) *x509.Certificate {
+	cert, err := pki.ParsePemEncodedCertificate(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
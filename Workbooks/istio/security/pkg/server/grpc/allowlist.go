@@ -0,0 +1,55 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// allowedIDsFilePrefix marks a value of LoadAllowedIdentities as a file path.
+const allowedIDsFilePrefix = "@"
+
+// LoadAllowedIdentities returns the identities listed by values. Each value
+// is either an identity, e.g. spiffe://cluster.local/ns/default/sa/foo, or
+// "@" followed by the path of a file listing one identity per line. Empty
+// lines and lines starting with "#" in the files are ignored.
+func LoadAllowedIdentities(values []string) ([]string, error) {
+	var ids []string
+	for _, value := range values {
+		if !strings.HasPrefix(value, allowedIDsFilePrefix) {
+			if value == "" {
+				return nil, fmt.Errorf("empty identity")
+			}
+			ids = append(ids, value)
+			continue
+		}
+
+		path := strings.TrimPrefix(value, allowedIDsFilePrefix)
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read allowed identities (error: %v)", err)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			ids = append(ids, line)
+		}
+	}
+	return ids, nil
+}
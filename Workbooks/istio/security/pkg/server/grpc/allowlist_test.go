@@ -0,0 +1,78 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadAllowedIdentities(t *testing.T) {
+	file, err := ioutil.TempFile("", "allowed-identities")
+	if err != nil {
+		t.Fatalf("failed to create temp file: %v", err)
+	}
+	defer func() { _ = os.Remove(file.Name()) }()
+	content := "# payments\nspiffe://cluster.local/ns/prod/sa/payments\n\n  spiffe://cluster.local/ns/prod/sa/orders  \n"
+	if _, err = file.WriteString(content); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+	_ = file.Close()
+
+	testCases := map[string]struct {
+		values      []string
+		expected    []string
+		expectedErr string
+	}{
+		"No identity": {},
+		"Identities": {
+			values:   []string{"spiffe://cluster.local/ns/default/sa/foo", "spiffe://cluster.local/ns/default/sa/bar"},
+			expected: []string{"spiffe://cluster.local/ns/default/sa/foo", "spiffe://cluster.local/ns/default/sa/bar"},
+		},
+		"Identities and file": {
+			values: []string{"spiffe://cluster.local/ns/default/sa/foo", "@" + file.Name()},
+			expected: []string{"spiffe://cluster.local/ns/default/sa/foo", "spiffe://cluster.local/ns/prod/sa/payments",
+				"spiffe://cluster.local/ns/prod/sa/orders"},
+		},
+		"Missing file": {
+			values:      []string{"@/does/not/exist"},
+			expectedErr: "cannot read allowed identities (error: ",
+		},
+		"Empty identity": {
+			values:      []string{"spiffe://cluster.local/ns/default/sa/foo", ""},
+			expectedErr: "empty identity",
+		},
+	}
+
+	for id, tc := range testCases {
+		ids, err := LoadAllowedIdentities(tc.values)
+		if len(tc.expectedErr) > 0 {
+			if err == nil {
+				t.Errorf("%s: Succeeded. Error expected", id)
+			} else if !strings.HasPrefix(err.Error(), tc.expectedErr) {
+				t.Errorf("%s: incorrect error message: %s VS %s", id, err.Error(), tc.expectedErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+		} else if !reflect.DeepEqual(ids, tc.expected) {
+			t.Errorf("%s: got identities %v, want %v", id, ids, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"istio.io/istio/security/pkg/pki"
+)
+
+// auditRecord describes one issued certificate.
+type auditRecord struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Requester    []string  `json:"requester"`
+	SerialNumber string    `json:"serialNumber"`
+	SANs         []string  `json:"sans"`
+	TTL          string    `json:"ttl"`
+	// PrevHash is the hex-encoded SHA-256 of the previous line, or empty
+	// for the first record of a log.
+	PrevHash string `json:"prevHash"`
+}
+
+// AuditLogger records every certificate issued by the server as one JSON line.
+// Each line holds the hash of the line before it, so that editing or removing
+// a line breaks the chain of all the lines following it.
+type AuditLogger struct {
+	mutex    sync.Mutex
+	w        io.Writer
+	prevHash string
+}
+
+// NewAuditLogger creates an AuditLogger writing to w. The first record is
+// chained to lastLine, the last line already written to w if any.
+func NewAuditLogger(w io.Writer, lastLine []byte) *AuditLogger {
+	a := &AuditLogger{w: w}
+	if line := bytes.TrimSpace(lastLine); len(line) > 0 {
+		a.prevHash = auditLineHash(line)
+	}
+	return a
+}
+
+// auditLineHash returns the hex-encoded SHA-256 of an audit line, without its newline.
+func auditLineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// record writes an audit line for the PEM-encoded certificate issued to the requester.
+func (a *AuditLogger) record(requester *caller, certPEM []byte) error {
+	cert, err := pki.ParsePemEncodedCertificate(certPEM)
+	if err != nil {
+		return err
+	}
+	sans, err := pki.ExtractIDs(cert.Extensions)
+	if err != nil {
+		return fmt.Errorf("failed to extract SAN entries (%v)", err)
+	}
+
+	r := auditRecord{
+		Timestamp:    time.Now().UTC(),
+		Requester:    requester.identities,
+		SerialNumber: cert.SerialNumber.String(),
+		SANs:         sans,
+		TTL:          cert.NotAfter.Sub(cert.NotBefore).String(),
+	}
+
+	// The mutex orders the records of concurrent requests along the chain,
+	// and keeps their lines from interleaving.
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	r.PrevHash = a.prevHash
+	line, err := json.Marshal(&r)
+	if err != nil {
+		return err
+	}
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	a.prevHash = auditLineHash(line)
+	return nil
+}
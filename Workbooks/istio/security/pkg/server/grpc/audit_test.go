@@ -0,0 +1,123 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"istio.io/istio/security/pkg/pki"
+	pb "istio.io/istio/security/proto"
+)
+
+func TestAuditLog(t *testing.T) {
+	istioCA, _ := newSelfSignedCA(t)
+	var out bytes.Buffer
+	server := &Server{
+		ca:             istioCA,
+		authorizer:     &mockAuthorizer{},
+		authenticators: []authenticator{&mockAuthenticator{identities: []string{"spiffe://test.com/ns/foo/sa/bar"}}},
+		auditLogger:    NewAuditLogger(&out, nil),
+	}
+
+	var serials []string
+	for _, ttl := range []int64{30, 60} {
+		response, err := server.HandleCSR(context.Background(), &pb.Request{CsrPem: []byte(csr), RequestedTtlMinutes: ttl})
+		if err != nil {
+			t.Fatalf("failed to sign CSR: %v", err)
+		}
+		cert, err := pki.ParsePemEncodedCertificate(response.SignedCertChain)
+		if err != nil {
+			t.Fatalf("failed to parse the issued certificate: %v", err)
+		}
+		serials = append(serials, cert.SerialNumber.String())
+	}
+
+	lines, records := readAuditLog(t, &out)
+	if len(records) != 2 {
+		t.Fatalf("unexpected number of audit lines: want 2, got %d", len(records))
+	}
+	// Each line is chained to the line before it.
+	if records[0].PrevHash != "" {
+		t.Errorf("record 0: unexpected previous hash %q", records[0].PrevHash)
+	}
+	if want := auditLineHash(lines[0]); records[1].PrevHash != want {
+		t.Errorf("record 1: unexpected previous hash: want %s, got %s", want, records[1].PrevHash)
+	}
+
+	for i, ttl := range []string{"30m0s", "1h0m0s"} {
+		r := records[i]
+		if !reflect.DeepEqual(r.Requester, []string{"spiffe://test.com/ns/foo/sa/bar"}) {
+			t.Errorf("record %d: unexpected requester %v", i, r.Requester)
+		}
+		if r.SerialNumber != serials[i] {
+			t.Errorf("record %d: unexpected serial number: want %s, got %s", i, serials[i], r.SerialNumber)
+		}
+		if !reflect.DeepEqual(r.SANs, []string{"spiffe://test.com/namespace/ns/serviceaccount/sa"}) {
+			t.Errorf("record %d: unexpected SANs %v", i, r.SANs)
+		}
+		if r.TTL != ttl {
+			t.Errorf("record %d: unexpected TTL: want %s, got %s", i, ttl, r.TTL)
+		}
+		if time.Since(r.Timestamp) > time.Minute {
+			t.Errorf("record %d: unexpected timestamp %v", i, r.Timestamp)
+		}
+	}
+}
+
+func TestAuditLogResumesChain(t *testing.T) {
+	istioCA, _ := newSelfSignedCA(t)
+	lastLine := []byte(`{"serialNumber":"1","prevHash":""}`)
+	var out bytes.Buffer
+	server := &Server{
+		ca:             istioCA,
+		authorizer:     &mockAuthorizer{},
+		authenticators: []authenticator{&mockAuthenticator{identities: []string{"spiffe://test.com/ns/foo/sa/bar"}}},
+		auditLogger:    NewAuditLogger(&out, append(lastLine, '\n')),
+	}
+	if _, err := server.HandleCSR(context.Background(), &pb.Request{CsrPem: []byte(csr), RequestedTtlMinutes: 30}); err != nil {
+		t.Fatalf("failed to sign CSR: %v", err)
+	}
+
+	_, records := readAuditLog(t, &out)
+	if len(records) != 1 {
+		t.Fatalf("unexpected number of audit lines: want 1, got %d", len(records))
+	}
+	if want := auditLineHash(lastLine); records[0].PrevHash != want {
+		t.Errorf("the first record is not chained to the existing log: want %s, got %s", want, records[0].PrevHash)
+	}
+}
+
+// readAuditLog returns the lines of an audit log and the records they hold.
+func readAuditLog(t *testing.T, out *bytes.Buffer) ([][]byte, []auditRecord) {
+	var lines [][]byte
+	var records []auditRecord
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		var r auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("invalid audit line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, append([]byte{}, scanner.Bytes()...))
+		records = append(records, r)
+	}
+	return lines, records
+}
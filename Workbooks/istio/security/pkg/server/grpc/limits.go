@@ -0,0 +1,79 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"net"
+	"sync"
+
+	"istio.io/istio/pkg/log"
+)
+
+// Limits bound the load the server accepts, so that it sheds excess
+// requests instead of exhausting its resources, e.g. when all pods of a
+// cluster restart at once. Zero values mean unlimited.
+type Limits struct {
+	// MaxConcurrentStreams is the maximum number of CSRs handled at once.
+	// Further requests fail with codes.ResourceExhausted.
+	MaxConcurrentStreams uint32
+
+	// MaxConnections is the maximum number of open client connections.
+	// Further connections are closed as soon as they are accepted.
+	MaxConnections int
+}
+
+// connLimitListener closes the connections accepted beyond its limit of
+// open connections, so that clients back off and retry instead of
+// queueing on an overloaded server.
+type connLimitListener struct {
+	net.Listener
+	open chan struct{}
+}
+
+func newConnLimitListener(l net.Listener, maxConnections int) net.Listener {
+	return &connLimitListener{
+		Listener: l,
+		open:     make(chan struct{}, maxConnections),
+	}
+}
+
+func (l *connLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case l.open <- struct{}{}:
+			return &limitedConn{Conn: conn, release: func() { <-l.open }}, nil
+		default:
+			log.Warnf("closing connection from %v: the limit of %d connections is reached", conn.RemoteAddr(), cap(l.open))
+			_ = conn.Close()
+		}
+	}
+}
+
+// limitedConn releases its slot of a connLimitListener when closed.
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "istio.io/istio/security/proto"
+)
+
+// blockingCA signs certificates once released, reporting every signing
+// in progress on started.
+type blockingCA struct {
+	mockCA
+	started chan struct{}
+	release chan struct{}
+}
+
+func (ca *blockingCA) Sign(csrPEM []byte, ttl time.Duration) ([]byte, error) {
+	ca.started <- struct{}{}
+	<-ca.release
+	return ca.mockCA.Sign(csrPEM, ttl)
+}
+
+func (ca *blockingCA) SignWithIDs(csrPEM []byte, ttl time.Duration, ids []string) ([]byte, error) {
+	return ca.Sign(csrPEM, ttl)
+}
+
+func TestHandleCSRConcurrencyLimit(t *testing.T) {
+	const limit = 2
+	blocking := &blockingCA{
+		mockCA:  mockCA{cert: "generated cert"},
+		started: make(chan struct{}, limit),
+		release: make(chan struct{}),
+	}
+	server := New(blocking, time.Hour, "hostname", 8080, ServerOptions{Limits: Limits{MaxConcurrentStreams: limit}})
+	server.authenticators = []authenticator{&mockAuthenticator{}}
+	server.authorizer = &mockAuthorizer{}
+	request := &pb.Request{CsrPem: []byte(csr)}
+
+	errs := make(chan error, limit)
+	for i := 0; i < limit; i++ {
+		go func() {
+			_, err := server.HandleCSR(context.Background(), request)
+			errs <- err
+		}()
+	}
+	for i := 0; i < limit; i++ {
+		<-blocking.started
+	}
+
+	// All slots are taken, so further requests are shed.
+	for i := 0; i < 3; i++ {
+		_, err := server.HandleCSR(context.Background(), request)
+		s, _ := status.FromError(err)
+		if code := s.Code(); code != codes.ResourceExhausted {
+			t.Errorf("request %d beyond the limit: expecting code (%d) but got (%d)", i, codes.ResourceExhausted, code)
+		}
+	}
+
+	close(blocking.release)
+	for i := 0; i < limit; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("request within the limit: unexpected error: %v", err)
+		}
+	}
+
+	// The released slots accept requests again.
+	if _, err := server.HandleCSR(context.Background(), request); err != nil {
+		t.Errorf("request after release: unexpected error: %v", err)
+	}
+}
+
+func TestConnLimitListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := newConnLimitListener(inner, 1)
+	defer func() { _ = listener.Close() }()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				close(accepted)
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	first, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = first.Close() }()
+	served := <-accepted
+
+	// The connection beyond the limit is closed by the server.
+	second, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = second.Close() }()
+	_ = second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("connection beyond the limit: expecting EOF but got %v", err)
+	}
+
+	// Closing the served connection frees its slot.
+	_ = served.Close()
+	third, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = third.Close() }()
+	select {
+	case conn := <-accepted:
+		_ = conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Error("connection after release: not accepted")
+	}
+}
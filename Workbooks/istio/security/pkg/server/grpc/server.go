@@ -19,6 +19,7 @@ import (
 	"crypto/x509"
 	"fmt"
 	"net"
+	"os"
 	"time"
 	// TODO(nmittler): Remove this
 	_ "github.com/golang/glog"
@@ -37,6 +38,16 @@ import (
 
 const certExpirationBuffer = time.Minute
 
+const (
+	// NetworkTCP serves on a TCP port of all interfaces.
+	NetworkTCP = "tcp"
+	// NetworkUnix serves on a Unix domain socket, with the hostname used as the socket path.
+	NetworkUnix = "unix"
+
+	// unixSocketCertHost is the host of the server certificate when serving on a Unix domain socket.
+	unixSocketCertHost = "localhost"
+)
+
 // Server implements pb.IstioCAService and provides the service on the
 // specified port.
 type Server struct {
@@ -45,8 +56,20 @@ type Server struct {
 	serverCertTTL  time.Duration
 	ca             ca.CertificateAuthority
 	certificate    *tls.Certificate
+	network        string
 	hostname       string
 	port           int
+	auditLogger    *AuditLogger
+	trustDomain    string
+	tlsPolicy      TLSPolicy
+
+	// allowedIDs are the identities that may be requested, all if empty.
+	allowedIDs map[string]bool
+
+	limits Limits
+	// inflight holds a token for each CSR being handled if the number of
+	// concurrent CSRs is limited.
+	inflight chan struct{}
 }
 
 // HandleCSR handles an incoming certificate signing request (CSR). It does
@@ -54,6 +77,16 @@ type Server struct {
 // and returns the resulting certificate. If not approved, reason for refusal
 // to sign is returned as part of the response object.
 func (s *Server) HandleCSR(ctx context.Context, request *pb.Request) (*pb.Response, error) {
+	if s.inflight != nil {
+		select {
+		case s.inflight <- struct{}{}:
+			defer func() { <-s.inflight }()
+		default:
+			log.Warnf("rejecting CSR: the limit of %d concurrent requests is reached", cap(s.inflight))
+			return nil, status.Errorf(codes.ResourceExhausted, "too many concurrent requests, retry later")
+		}
+	}
+
 	caller := s.authenticate(ctx)
 	if caller == nil {
 		log.Warn("request authentication failure")
@@ -72,18 +105,39 @@ func (s *Server) HandleCSR(ctx context.Context, request *pb.Request) (*pb.Respon
 		return nil, status.Errorf(codes.InvalidArgument, "CSR identity extraction error (%v)", err)
 	}
 
+	for _, id := range requestedIDs {
+		if len(s.allowedIDs) > 0 && !s.allowedIDs[id] {
+			log.Warnf("requested identity %q is not allowed", id)
+			return nil, status.Errorf(codes.PermissionDenied, "requested identity %q is not allowed", id)
+		}
+	}
+
 	err = s.authorizer.authorize(caller, requestedIDs)
 	if err != nil {
 		log.Warnf("request is not authorized (%v)", err)
 		return nil, status.Errorf(codes.PermissionDenied, "request is not authorized (%v)", err)
 	}
 
-	cert, err := s.ca.Sign(request.CsrPem, time.Duration(request.RequestedTtlMinutes)*time.Minute)
+	ttl := time.Duration(request.RequestedTtlMinutes) * time.Minute
+	var cert []byte
+	if s.trustDomain != "" {
+		cert, err = s.ca.SignWithIDs(request.CsrPem, ttl, idsInTrustDomain(requestedIDs, s.trustDomain))
+	} else {
+		cert, err = s.ca.Sign(request.CsrPem, ttl)
+	}
 	if err != nil {
 		log.Errorf("CSR signing error (%v)", err)
 		return nil, status.Errorf(codes.Internal, "CSR signing error (%v)", err)
 	}
 
+	if s.auditLogger != nil {
+		if err := s.auditLogger.record(caller, cert); err != nil {
+			// Certificates must not be issued without an audit record.
+			log.Errorf("audit logging error (%v)", err)
+			return nil, status.Errorf(codes.Internal, "audit logging error (%v)", err)
+		}
+	}
+
 	response := &pb.Response{
 		IsApproved:      true,
 		SignedCertChain: cert,
@@ -93,21 +147,28 @@ func (s *Server) HandleCSR(ctx context.Context, request *pb.Request) (*pb.Respon
 	return response, nil
 }
 
-// Run starts a GRPC server on the specified port.
+// Run starts a GRPC server on the specified port, or Unix domain socket.
 func (s *Server) Run() error {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", s.port))
+	listener, err := s.listen()
 	if err != nil {
-		return fmt.Errorf("cannot listen on port %d (error: %v)", s.port, err)
+		return err
+	}
+
+	if s.limits.MaxConnections > 0 {
+		listener = newConnLimitListener(listener, s.limits.MaxConnections)
 	}
 
-	serverOption := s.createTLSServerOption()
+	serverOptions := []grpc.ServerOption{s.createTLSServerOption()}
+	if s.limits.MaxConcurrentStreams > 0 {
+		serverOptions = append(serverOptions, grpc.MaxConcurrentStreams(s.limits.MaxConcurrentStreams))
+	}
 
-	grpcServer := grpc.NewServer(serverOption)
+	grpcServer := grpc.NewServer(serverOptions...)
 	pb.RegisterIstioCAServiceServer(grpcServer, s)
 
 	// grpcServer.Serve() is a blocking call, so run it in a goroutine.
 	go func() {
-		log.Infof("Starting GRPC server on port %d", s.port)
+		log.Infof("Starting GRPC server on %s", listener.Addr())
 
 		err := grpcServer.Serve(listener)
 
@@ -118,36 +179,125 @@ func (s *Server) Run() error {
 	return nil
 }
 
-// New creates a new instance of `IstioCAServiceServer`.
-func New(ca ca.CertificateAuthority, ttl time.Duration, hostname string, port int) *Server {
+func (s *Server) listen() (net.Listener, error) {
+	if s.network == NetworkUnix {
+		// A socket file left by a previous run would make the listen fail.
+		if err := os.Remove(s.hostname); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot remove stale socket %s (error: %v)", s.hostname, err)
+		}
+		listener, err := net.Listen(NetworkUnix, s.hostname)
+		if err != nil {
+			return nil, fmt.Errorf("cannot listen on socket %s (error: %v)", s.hostname, err)
+		}
+		return listener, nil
+	}
+
+	listener, err := net.Listen(NetworkTCP, fmt.Sprintf(":%d", s.port))
+	if err != nil {
+		return nil, fmt.Errorf("cannot listen on port %d (error: %v)", s.port, err)
+	}
+	return listener, nil
+}
+
+// ServerOptions holds the optional configurations of a Server. The zero
+// value serves on a TCP port without restrictions.
+type ServerOptions struct {
+	// Network is either NetworkTCP, serving on the given port, or
+	// NetworkUnix, serving on the socket at the path given as hostname.
+	// Defaults to NetworkTCP.
+	Network string
+
+	// AuditLogger records issued certificates unless it is nil.
+	AuditLogger *AuditLogger
+
+	// TrustDomain, if not empty, is the trust domain the SPIFFE identities
+	// of issued workload certificates are moved into.
+	TrustDomain string
+
+	// TLSPolicy restricts client connections.
+	TLSPolicy TLSPolicy
+
+	// AllowedIDs are the identities certificates may be issued for as
+	// requested in the CSR, all if empty.
+	AllowedIDs []string
+
+	// Limits bound the load accepted by the server.
+	Limits Limits
+}
+
+// New creates a new instance of `IstioCAServiceServer`, serving on the given
+// hostname and port as configured by the options.
+func New(ca ca.CertificateAuthority, ttl time.Duration, hostname string, port int, opts ServerOptions) *Server {
+	network := opts.Network
+	if network == "" {
+		network = NetworkTCP
+	}
+
 	// Notice that the order of authenticators matters, since at runtime
 	// authenticators are actived sequentially and the first successful attempt
 	// is used as the authentication result.
 	authenticators := []authenticator{&clientCertAuthenticator{}}
 	aud := fmt.Sprintf("grpc://%s:%d", hostname, port)
+	if network == NetworkUnix {
+		aud = fmt.Sprintf("unix://%s", hostname)
+	}
 	if jwtAuthenticator, err := newIDTokenAuthenticator(aud); err != nil {
 		log.Errorf("failed to create JWT authenticator (error %v)", err)
 	} else {
 		authenticators = append(authenticators, jwtAuthenticator)
 	}
 
+	allowed := make(map[string]bool, len(opts.AllowedIDs))
+	for _, id := range opts.AllowedIDs {
+		allowed[id] = true
+	}
+
+	var inflight chan struct{}
+	if opts.Limits.MaxConcurrentStreams > 0 {
+		inflight = make(chan struct{}, opts.Limits.MaxConcurrentStreams)
+	}
+
 	return &Server{
 		authenticators: authenticators,
 		authorizer:     &registryAuthorizor{registry.GetIdentityRegistry()},
 		serverCertTTL:  ttl,
 		ca:             ca,
+		network:        network,
 		hostname:       hostname,
 		port:           port,
+		auditLogger:    opts.AuditLogger,
+		trustDomain:    opts.TrustDomain,
+		tlsPolicy:      opts.TLSPolicy,
+		allowedIDs:     allowed,
+		limits:         opts.Limits,
+		inflight:       inflight,
 	}
 }
 
 func (s *Server) createTLSServerOption() grpc.ServerOption {
+	config := s.tlsConfig()
+	// The root certificates change when the CA root is rotated or reloaded, so
+	// the client certificates are verified against the current ones.
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return s.tlsConfig(), nil
+	}
+	return grpc.Creds(credentials.NewTLS(config))
+}
+
+// tlsConfig returns the TLS configuration of the server, trusting the client
+// certificates issued by the current root certificates of the CA.
+func (s *Server) tlsConfig() *tls.Config {
 	cp := x509.NewCertPool()
 	cp.AppendCertsFromPEM(s.ca.GetRootCertificate())
 
-	config := &tls.Config{
-		ClientCAs:  cp,
-		ClientAuth: tls.VerifyClientCertIfGiven,
+	return &tls.Config{
+		ClientCAs:                cp,
+		ClientAuth:               tls.VerifyClientCertIfGiven,
+		MinVersion:               s.tlsPolicy.MinVersion,
+		CipherSuites:             s.tlsPolicy.CipherSuites,
+		PreferServerCipherSuites: true,
+		// credentials.NewTLS only adds HTTP/2 to the base config, not to those returned per client.
+		NextProtos: []string{"h2"},
 		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
 			if s.certificate == nil || shouldRefresh(s.certificate) {
 				// Apply new certificate if there isn't one yet, or the one has become invalid.
@@ -160,12 +310,15 @@ func (s *Server) createTLSServerOption() grpc.ServerOption {
 			return s.certificate, nil
 		},
 	}
-	return grpc.Creds(credentials.NewTLS(config))
 }
 
 func (s *Server) applyServerCertificate() (*tls.Certificate, error) {
+	host := s.hostname
+	if s.network == NetworkUnix {
+		host = unixSocketCertHost
+	}
 	opts := ca.CertOptions{
-		Host:       s.hostname,
+		Host:       host,
 		RSAKeySize: 2048,
 	}
 
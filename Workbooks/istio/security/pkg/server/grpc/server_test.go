@@ -19,11 +19,19 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 
 	"google.golang.org/grpc/status"
 	"istio.io/istio/security/pkg/pki/ca"
@@ -47,6 +55,9 @@ type mockCA struct {
 	cert   string
 	root   string
 	errMsg string
+
+	// The identities passed to the last SignWithIDs call.
+	ids []string
 }
 
 func (ca *mockCA) Sign(csrPEM []byte, ttl time.Duration) ([]byte, error) {
@@ -56,6 +67,11 @@ func (ca *mockCA) Sign(csrPEM []byte, ttl time.Duration) ([]byte, error) {
 	return []byte(ca.cert), nil
 }
 
+func (ca *mockCA) SignWithIDs(csrPEM []byte, ttl time.Duration, ids []string) ([]byte, error) {
+	ca.ids = ids
+	return ca.Sign(csrPEM, ttl)
+}
+
 func (ca *mockCA) GetRootCertificate() []byte {
 	return []byte(ca.root)
 }
@@ -152,6 +168,117 @@ func TestSign(t *testing.T) {
 	}
 }
 
+func TestSignInTrustDomain(t *testing.T) {
+	mock := &mockCA{cert: "generated cert"}
+	server := &Server{
+		ca:             mock,
+		authorizer:     &mockAuthorizer{},
+		authenticators: []authenticator{&mockAuthenticator{}},
+		trustDomain:    "example.com",
+	}
+	request := &pb.Request{CsrPem: []byte(csr)}
+
+	if _, err := server.HandleCSR(context.Background(), request); err != nil {
+		t.Fatalf("HandleCSR() failed: %v", err)
+	}
+	want := []string{"spiffe://example.com/namespace/ns/serviceaccount/sa"}
+	if !reflect.DeepEqual(mock.ids, want) {
+		t.Errorf("signed identities: want %v, got %v", want, mock.ids)
+	}
+}
+
+func TestSignAllowedIdentities(t *testing.T) {
+	testCases := map[string]struct {
+		allowedIDs []string
+		code       codes.Code
+	}{
+		"All identities allowed": {
+			code: codes.OK,
+		},
+		"Allowed identity": {
+			allowedIDs: []string{"spiffe://test.com/namespace/ns/serviceaccount/other",
+				"spiffe://test.com/namespace/ns/serviceaccount/sa"},
+			code: codes.OK,
+		},
+		"Denied identity": {
+			allowedIDs: []string{"spiffe://test.com/namespace/ns/serviceaccount/other"},
+			code:       codes.PermissionDenied,
+		},
+	}
+
+	for id, c := range testCases {
+		server := New(&mockCA{cert: "generated cert"}, time.Hour, "hostname", 8080,
+			ServerOptions{AllowedIDs: c.allowedIDs})
+		server.authorizer = &mockAuthorizer{}
+		server.authenticators = []authenticator{&mockAuthenticator{}}
+		request := &pb.Request{CsrPem: []byte(csr)}
+
+		_, err := server.HandleCSR(context.Background(), request)
+		s, _ := status.FromError(err)
+		if s.Code() != c.code {
+			t.Errorf("Case %s: expecting code to be (%d) but got (%d)", id, c.code, s.Code())
+		}
+		if c.code == codes.PermissionDenied {
+			if want := "requested identity \"spiffe://test.com/namespace/ns/serviceaccount/sa\" is not allowed"; s.Message() != want {
+				t.Errorf("Case %s: incorrect error message: %s VS %s", id, s.Message(), want)
+			}
+		}
+	}
+}
+
+func TestIdsInTrustDomain(t *testing.T) {
+	testCases := map[string]struct {
+		ids  []string
+		want []string
+	}{
+		"Service account": {
+			ids:  []string{"spiffe://cluster.local/ns/foo/sa/bar"},
+			want: []string{"spiffe://example.com/ns/foo/sa/bar"},
+		},
+		"No path": {
+			ids:  []string{"spiffe://cluster.local"},
+			want: []string{"spiffe://example.com"},
+		},
+		"Mixed identities": {
+			ids:  []string{"foo.default.svc", "spiffe://cluster.local/ns/foo/sa/bar"},
+			want: []string{"foo.default.svc", "spiffe://example.com/ns/foo/sa/bar"},
+		},
+	}
+
+	for id, tc := range testCases {
+		if got := idsInTrustDomain(tc.ids, "example.com"); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: want %v, got %v", id, tc.want, got)
+		}
+	}
+}
+
+func TestValidateTrustDomain(t *testing.T) {
+	testCases := map[string]struct {
+		trustDomain string
+		valid       bool
+	}{
+		"Single label":    {trustDomain: "cluster", valid: true},
+		"Multiple labels": {trustDomain: "prod.example-1.com", valid: true},
+		"Empty":           {trustDomain: ""},
+		"Upper case":      {trustDomain: "Example.com"},
+		"Empty label":     {trustDomain: "example..com"},
+		"Leading hyphen":  {trustDomain: "-example.com"},
+		"Scheme":          {trustDomain: "spiffe://example.com"},
+		"Path":            {trustDomain: "example.com/ns"},
+		"Long label":      {trustDomain: strings.Repeat("a", 64) + ".com"},
+		"Too long":        {trustDomain: strings.Repeat("a.", 127) + "com"},
+	}
+
+	for id, tc := range testCases {
+		err := ValidateTrustDomain(tc.trustDomain)
+		if tc.valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+		} else if !tc.valid && err == nil {
+			t.Errorf("%s: Succeeded. Error expected", id)
+		}
+	}
+}
+
 func TestShouldRefresh(t *testing.T) {
 	now := time.Now()
 	testCases := map[string]struct {
@@ -216,7 +343,7 @@ func TestRun(t *testing.T) {
 	}
 
 	for id, tc := range testCases {
-		server := New(tc.ca, time.Hour, tc.hostname, tc.port)
+		server := New(tc.ca, time.Hour, tc.hostname, tc.port, ServerOptions{})
 		err := server.Run()
 		if len(tc.expectedErr) > 0 {
 			if err == nil {
@@ -249,3 +376,211 @@ func TestRun(t *testing.T) {
 		}
 	}
 }
+
+func TestRunOnUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "istio-ca-grpc")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	socket := filepath.Join(dir, "ca.sock")
+
+	istioCA, rootCert := newSelfSignedCA(t)
+
+	// A stale socket file must not prevent the server from starting.
+	if err := ioutil.WriteFile(socket, nil, 0600); err != nil {
+		t.Fatalf("failed to create stale socket file: %v", err)
+	}
+
+	server := New(istioCA, time.Hour, socket, 0, ServerOptions{Network: NetworkUnix})
+	if err := server.Run(); err != nil {
+		t.Fatalf("failed to run server: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(rootCert)
+	creds := credentials.NewTLS(&tls.Config{RootCAs: pool, ServerName: unixSocketCertHost})
+	conn, err := grpc.Dial(socket,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithBlock(),
+		grpc.WithTimeout(5*time.Second),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout(NetworkUnix, addr, timeout)
+		}))
+	if err != nil {
+		t.Fatalf("failed to dial %s: %v", socket, err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	// The client presents no credentials, so a round trip ends with an authentication failure.
+	_, err = pb.NewIstioCAServiceClient(conn).HandleCSR(context.Background(), &pb.Request{CsrPem: []byte(csr)})
+	if s, _ := status.FromError(err); s.Code() != codes.Unauthenticated {
+		t.Errorf("unexpected response: want code %v, got %v", codes.Unauthenticated, err)
+	}
+}
+
+func TestRunWithTLSPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "istio-ca-grpc")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	socket := filepath.Join(dir, "ca.sock")
+
+	istioCA, rootCert := newSelfSignedCA(t)
+	policy, err := ParseTLSPolicy(DefaultTLSMinVersion, DefaultTLSCipherSuites)
+	if err != nil {
+		t.Fatalf("failed to parse the default TLS policy: %v", err)
+	}
+	server := New(istioCA, time.Hour, socket, 0, ServerOptions{Network: NetworkUnix, TLSPolicy: policy})
+	if err := server.Run(); err != nil {
+		t.Fatalf("failed to run server: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(rootCert)
+	testCases := map[string]struct {
+		minVersion   uint16
+		maxVersion   uint16
+		cipherSuites []uint16
+		refused      bool
+	}{
+		"TLS 1.1": {
+			minVersion: tls.VersionTLS10,
+			maxVersion: tls.VersionTLS11,
+			refused:    true,
+		},
+		"TLS 1.2": {
+			minVersion: tls.VersionTLS12,
+			maxVersion: tls.VersionTLS12,
+		},
+		"Disallowed cipher suite": {
+			minVersion:   tls.VersionTLS12,
+			maxVersion:   tls.VersionTLS12,
+			cipherSuites: []uint16{tls.TLS_RSA_WITH_AES_128_GCM_SHA256},
+			refused:      true,
+		},
+	}
+
+	for id, tc := range testCases {
+		conn, err := net.DialTimeout(NetworkUnix, socket, 5*time.Second)
+		if err != nil {
+			t.Fatalf("%s: failed to dial %s: %v", id, socket, err)
+		}
+		client := tls.Client(conn, &tls.Config{
+			RootCAs:      pool,
+			ServerName:   unixSocketCertHost,
+			MinVersion:   tc.minVersion,
+			MaxVersion:   tc.maxVersion,
+			CipherSuites: tc.cipherSuites,
+		})
+		err = client.Handshake()
+		_ = client.Close()
+		if tc.refused && err == nil {
+			t.Errorf("%s: Succeeded. Error expected", id)
+		} else if !tc.refused && err != nil {
+			t.Errorf("%s: unexpected handshake error: %v", id, err)
+		}
+	}
+}
+
+// newSelfSignedCA creates a CA signing with a self-signed root, and returns it with the PEM-encoded root.
+func newSelfSignedCA(t *testing.T) (*ca.IstioCA, []byte) {
+	now := time.Now()
+	rootCert, rootKey := ca.GenCert(ca.CertOptions{
+		IsCA:         true,
+		IsSelfSigned: true,
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(time.Hour),
+		Org:          "Root CA",
+		RSAKeySize:   2048,
+	})
+	istioCA, err := ca.NewIstioCA(&ca.IstioCAOptions{
+		CertTTL:          time.Hour,
+		MaxCertTTL:       time.Hour,
+		SigningCertBytes: rootCert,
+		SigningKeyBytes:  rootKey,
+		RootCertBytes:    rootCert,
+	})
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+	return istioCA, rootCert
+}
+
+// rotatingCA serves the roots of a CA whose root is rotated, signing with the current root.
+type rotatingCA struct {
+	ca.CertificateAuthority
+	roots []byte
+}
+
+func (ca *rotatingCA) GetRootCertificate() []byte {
+	return ca.roots
+}
+
+func TestRunAfterRootRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "istio-ca-grpc")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	socket := filepath.Join(dir, "ca.sock")
+
+	oldCA, oldRoot := newSelfSignedCA(t)
+	newCA, newRoot := newSelfSignedCA(t)
+	rotating := &rotatingCA{CertificateAuthority: oldCA, roots: oldRoot}
+
+	server := New(rotating, time.Hour, socket, 0, ServerOptions{Network: NetworkUnix})
+	server.authorizer = &mockAuthorizer{}
+	if err := server.Run(); err != nil {
+		t.Fatalf("failed to run server: %v", err)
+	}
+
+	// The root is rotated after the server started.
+	rotating.CertificateAuthority = newCA
+	rotating.roots = append(append([]byte{}, newRoot...), oldRoot...)
+
+	// The node agent renews with a certificate issued by the new root.
+	csrPEM, keyPEM, err := ca.GenCSR(ca.CertOptions{
+		Host:       "spiffe://cluster.local/ns/default/sa/default",
+		RSAKeySize: 2048,
+	})
+	if err != nil {
+		t.Fatalf("failed to generate CSR: %v", err)
+	}
+	certPEM, err := newCA.Sign(csrPEM, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to sign CSR: %v", err)
+	}
+	clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("failed to load client certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(rotating.roots)
+	creds := credentials.NewTLS(&tls.Config{
+		RootCAs:      pool,
+		ServerName:   unixSocketCertHost,
+		Certificates: []tls.Certificate{clientCert},
+	})
+	conn, err := grpc.Dial(socket,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithBlock(),
+		grpc.WithTimeout(5*time.Second),
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout(NetworkUnix, addr, timeout)
+		}))
+	if err != nil {
+		t.Fatalf("failed to dial %s: %v", socket, err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	resp, err := pb.NewIstioCAServiceClient(conn).HandleCSR(context.Background(), &pb.Request{CsrPem: csrPEM})
+	if err != nil {
+		t.Fatalf("CSR after root rotation failed: %v", err)
+	}
+	if !resp.IsApproved {
+		t.Errorf("CSR after root rotation is not approved")
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+var (
+	// DefaultTLSMinVersion is the default minimum TLS version of the server.
+	DefaultTLSMinVersion = "1.2"
+
+	// DefaultTLSCipherSuites are the default TLS 1.2 cipher suites of the
+	// server, all providing forward secrecy and authenticated encryption.
+	DefaultTLSCipherSuites = []string{
+		"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+		"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+		"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+		"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305",
+		"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305",
+	}
+
+	tlsVersions = map[string]uint16{
+		"1.2": tls.VersionTLS12,
+	}
+
+	// tlsCipherSuites are the cipher suites that may be configured, by
+	// their crypto/tls names. RC4 and 3DES suites are not supported.
+	tlsCipherSuites = map[string]uint16{
+		"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+		"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+		"TLS_RSA_WITH_AES_128_CBC_SHA256":         tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+		"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+		"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+		"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+		"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+		"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+		"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+		"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	}
+)
+
+// TLSPolicy restricts the TLS connections accepted by the server. The zero
+// value applies the crypto/tls defaults.
+type TLSPolicy struct {
+	// MinVersion is the minimum TLS version, e.g. tls.VersionTLS12.
+	MinVersion uint16
+
+	// CipherSuites are the cipher suites of TLS 1.2 connections, in order
+	// of preference.
+	CipherSuites []uint16
+}
+
+// ParseTLSPolicy returns the policy with the minimum TLS version "1.2", and
+// the cipher suites named as in crypto/tls, e.g.
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+func ParseTLSPolicy(minVersion string, cipherSuites []string) (TLSPolicy, error) {
+	if minVersion == "1.3" {
+		// The CA is built with Go 1.9, whose crypto/tls does not implement
+		// TLS 1.3 (added in Go 1.12), so requiring it would fail every handshake.
+		return TLSPolicy{}, fmt.Errorf("TLS version \"1.3\" is not supported by the Go 1.9 crypto/tls, want \"1.2\"")
+	}
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return TLSPolicy{}, fmt.Errorf("unsupported TLS version %q, want \"1.2\"", minVersion)
+	}
+	if len(cipherSuites) == 0 {
+		return TLSPolicy{}, fmt.Errorf("no TLS cipher suite specified")
+	}
+	policy := TLSPolicy{MinVersion: version}
+	for _, name := range cipherSuites {
+		suite, ok := tlsCipherSuites[name]
+		if !ok {
+			return TLSPolicy{}, fmt.Errorf("unsupported TLS cipher suite %q", name)
+		}
+		policy.CipherSuites = append(policy.CipherSuites, suite)
+	}
+	return policy, nil
+}
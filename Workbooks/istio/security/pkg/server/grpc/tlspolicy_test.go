@@ -0,0 +1,87 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+)
+
+func TestParseTLSPolicy(t *testing.T) {
+	testCases := map[string]struct {
+		minVersion   string
+		cipherSuites []string
+		expected     TLSPolicy
+		expectedErr  string
+	}{
+		"Defaults": {
+			minVersion:   DefaultTLSMinVersion,
+			cipherSuites: DefaultTLSCipherSuites,
+			expected: TLSPolicy{
+				MinVersion: tls.VersionTLS12,
+				CipherSuites: []uint16{
+					tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+					tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+					tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+					tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+					tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+					tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+				},
+			},
+		},
+		"TLS 1.3": {
+			minVersion:   "1.3",
+			cipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+			expectedErr:  "TLS version \"1.3\" is not supported by the Go 1.9 crypto/tls, want \"1.2\"",
+		},
+		"Unsupported version": {
+			minVersion:   "1.1",
+			cipherSuites: DefaultTLSCipherSuites,
+			expectedErr:  "unsupported TLS version \"1.1\", want \"1.2\"",
+		},
+		"Unknown cipher suite": {
+			minVersion:   "1.2",
+			cipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_FOO"},
+			expectedErr:  "unsupported TLS cipher suite \"TLS_FOO\"",
+		},
+		"RC4 cipher suite": {
+			minVersion:   "1.2",
+			cipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"},
+			expectedErr:  "unsupported TLS cipher suite \"TLS_RSA_WITH_RC4_128_SHA\"",
+		},
+		"No cipher suite": {
+			minVersion:  "1.2",
+			expectedErr: "no TLS cipher suite specified",
+		},
+	}
+
+	for id, tc := range testCases {
+		policy, err := ParseTLSPolicy(tc.minVersion, tc.cipherSuites)
+		if len(tc.expectedErr) > 0 {
+			if err == nil {
+				t.Errorf("%s: Succeeded. Error expected", id)
+			} else if err.Error() != tc.expectedErr {
+				t.Errorf("%s: incorrect error message: %s VS %s", id, err.Error(), tc.expectedErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+		} else if !reflect.DeepEqual(policy, tc.expected) {
+			t.Errorf("%s: got policy %+v, want %+v", id, policy, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"istio.io/istio/security/pkg/pki/ca"
+)
+
+const maxTrustDomainLength = 253
+
+var (
+	spiffePrefix = ca.URIScheme + "://"
+
+	trustDomainLabel = regexp.MustCompile("^[a-z0-9]([-a-z0-9]*[a-z0-9])?$")
+)
+
+// ValidateTrustDomain checks that the trust domain is a DNS-like name, i.e.
+// dot-separated lower case labels of at most 63 characters each.
+func ValidateTrustDomain(trustDomain string) error {
+	if len(trustDomain) == 0 {
+		return fmt.Errorf("trust domain is empty")
+	}
+	if len(trustDomain) > maxTrustDomainLength {
+		return fmt.Errorf("trust domain %q is longer than %d characters", trustDomain, maxTrustDomainLength)
+	}
+	for _, label := range strings.Split(trustDomain, ".") {
+		if len(label) > 63 || !trustDomainLabel.MatchString(label) {
+			return fmt.Errorf("trust domain %q has an invalid label %q", trustDomain, label)
+		}
+	}
+	return nil
+}
+
+// idsInTrustDomain returns ids with the trust domain of each SPIFFE URI
+// replaced, e.g. spiffe://cluster.local/ns/foo/sa/bar becomes
+// spiffe://example.com/ns/foo/sa/bar for the trust domain example.com.
+// Other identities are returned as is.
+func idsInTrustDomain(ids []string, trustDomain string) []string {
+	rewritten := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if strings.HasPrefix(id, spiffePrefix) {
+			path := ""
+			if i := strings.Index(id[len(spiffePrefix):], "/"); i >= 0 {
+				path = id[len(spiffePrefix)+i:]
+			}
+			id = spiffePrefix + trustDomain + path
+		}
+		rewritten = append(rewritten, id)
+	}
+	return rewritten
+}
@@ -38,14 +38,18 @@ type Config struct {
 
 	// ServiceIdentityPrivateKeyFile is valid in FILE mode. It specifies the file path for service identity private key.
 	ServiceIdentityPrivateKeyFile string
+
+	// RootCertFile is valid in FILE mode. It specifies the file path for the root certificate.
+	RootCertFile string
 }
 
 // NewSecretFileServerConfig creates a Config for propogating key/cert to workload through file.
-func NewSecretFileServerConfig(certFile string, keyFile string) Config {
+func NewSecretFileServerConfig(certFile string, keyFile string, rootCertFile string) Config {
 	return Config{
 		Mode:                          SecretFile,
 		FileUtil:                      util.FileUtilImpl{},
 		ServiceIdentityCertFile:       certFile,
 		ServiceIdentityPrivateKeyFile: keyFile,
+		RootCertFile:                  rootCertFile,
 	}
 }
@@ -33,3 +33,8 @@ func (sf *SecretFileServer) SetServiceIdentityPrivateKey(content []byte) error {
 func (sf *SecretFileServer) SetServiceIdentityCert(content []byte) error {
 	return sf.cfg.FileUtil.Write(sf.cfg.ServiceIdentityCertFile, content, certFilePermission)
 }
+
+// SetRootCert sets the root certificate into the file system.
+func (sf *SecretFileServer) SetRootCert(content []byte) error {
+	return sf.cfg.FileUtil.Write(sf.cfg.RootCertFile, content, certFilePermission)
+}
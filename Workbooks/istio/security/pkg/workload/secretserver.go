@@ -24,6 +24,8 @@ type SecretServer interface {
 	SetServiceIdentityPrivateKey([]byte) error
 	// SetServiceIdentityCert sets the service identity cert to the channel accessible to the workload.
 	SetServiceIdentityCert([]byte) error
+	// SetRootCert sets the root cert to the channel accessible to the workload.
+	SetRootCert([]byte) error
 }
 
 // NewSecretServer instantiates a SecretServer according to the configuration.